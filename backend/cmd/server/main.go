@@ -6,15 +6,19 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"time"
 
 	"github.com/EROQIN/relite-reader/backend/internal/annotations"
 	"github.com/EROQIN/relite-reader/backend/internal/auth"
 	"github.com/EROQIN/relite-reader/backend/internal/bookmarks"
 	"github.com/EROQIN/relite-reader/backend/internal/books"
+	"github.com/EROQIN/relite-reader/backend/internal/covers"
 	apphttp "github.com/EROQIN/relite-reader/backend/internal/http"
+	"github.com/EROQIN/relite-reader/backend/internal/library"
 	"github.com/EROQIN/relite-reader/backend/internal/preferences"
 	"github.com/EROQIN/relite-reader/backend/internal/progress"
+	"github.com/EROQIN/relite-reader/backend/internal/sidecar"
 	"github.com/EROQIN/relite-reader/backend/internal/tasks"
 	"github.com/EROQIN/relite-reader/backend/internal/users"
 	"github.com/EROQIN/relite-reader/backend/internal/webdav"
@@ -126,26 +130,113 @@ func main() {
 		}
 		webStore = pgWeb
 	}
-	webClient := webdav.NewHTTPClient(http.DefaultClient)
-	queue := tasks.NewQueue(tasksStore, tasks.DefaultHandler, 200)
-	webSvc := webdav.NewService(webStore, webClient, key, bookStore, queue)
-	interval := 20 * time.Minute
-	if raw := os.Getenv("RELITE_WEB_DAV_SYNC_INTERVAL"); raw != "" {
+	coversDir := filepath.Join(os.TempDir(), "relite-covers")
+	if dataDir := os.Getenv("RELITE_DATA_DIR"); dataDir != "" {
+		coversDir = filepath.Join(dataDir, "covers")
+	}
+	coverCache := covers.NewCache(coversDir)
+	var clientOptions webdav.ClientOptions
+	if raw := os.Getenv("RELITE_WEB_DAV_WORKERS"); raw != "" {
+		workers, err := strconv.Atoi(raw)
+		if err != nil || workers <= 0 {
+			log.Fatal("invalid RELITE_WEB_DAV_WORKERS")
+		}
+		clientOptions.Workers = workers
+	}
+	if raw := os.Getenv("RELITE_WEB_DAV_RATE"); raw != "" {
+		rate, err := strconv.ParseFloat(raw, 64)
+		if err != nil || rate < 0 {
+			log.Fatal("invalid RELITE_WEB_DAV_RATE")
+		}
+		clientOptions.RequestsPerSecond = rate
+	}
+	if raw := os.Getenv("RELITE_WEB_DAV_CONNECT_TIMEOUT"); raw != "" {
 		duration, err := time.ParseDuration(raw)
+		if err != nil || duration <= 0 {
+			log.Fatal("invalid RELITE_WEB_DAV_CONNECT_TIMEOUT")
+		}
+		clientOptions.ConnectTimeout = duration
+	}
+	if raw := os.Getenv("RELITE_WEB_DAV_READ_TIMEOUT"); raw != "" {
+		duration, err := time.ParseDuration(raw)
+		if err != nil || duration <= 0 {
+			log.Fatal("invalid RELITE_WEB_DAV_READ_TIMEOUT")
+		}
+		clientOptions.ReadTimeout = duration
+	}
+	webClient := webdav.NewHTTPClient(nil, clientOptions)
+	var contentCache *webdav.ContentCache
+	if dataDir := os.Getenv("RELITE_DATA_DIR"); dataDir != "" {
+		cacheOptions := webdav.CacheOptions{MaxSize: 1024 << 20}
+		if raw := os.Getenv("RELITE_CONTENT_CACHE_MB"); raw != "" {
+			size, err := strconv.ParseInt(raw, 10, 64)
+			if err != nil || size < 0 {
+				log.Fatal("invalid RELITE_CONTENT_CACHE_MB")
+			}
+			cacheOptions.MaxSize = size << 20
+		}
+		if raw := os.Getenv("RELITE_CONTENT_PREFETCH"); raw != "" {
+			duration, err := time.ParseDuration(raw)
+			if err != nil || duration < 0 {
+				log.Fatal("invalid RELITE_CONTENT_PREFETCH")
+			}
+			cacheOptions.Prefetch = duration
+		}
+		if cacheOptions.MaxSize > 0 {
+			contentCache, err = webdav.NewContentCache(filepath.Join(dataDir, "content"), cacheOptions)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+	taskWorkers := 4
+	if raw := os.Getenv("RELITE_TASK_WORKERS"); raw != "" {
+		taskWorkers, err = strconv.Atoi(raw)
+		if err != nil || taskWorkers <= 0 {
+			log.Fatal("invalid RELITE_TASK_WORKERS")
+		}
+	}
+	registry := tasks.NewRegistry()
+	if raw := os.Getenv("RELITE_TASK_TIMEOUTS"); raw != "" {
+		timeouts, err := tasks.ParseTimeouts(raw)
+		if err != nil {
+			log.Fatal("invalid RELITE_TASK_TIMEOUTS")
+		}
+		for taskType, timeout := range timeouts {
+			registry.SetTimeout(taskType, timeout)
+		}
+	}
+	queue := tasks.NewQueue(tasksStore, registry, tasks.QueueOptions{
+		Workers: taskWorkers,
+		Owner:   os.Getenv("RELITE_TASK_OWNER"),
+	})
+	webSvc := webdav.NewService(webStore, webClient, key, bookStore, queue, contentCache)
+	library.NewProcessor(bookStore, webSvc.Background(), queue, coverCache).Register(registry)
+	sidecar.NewExporter(webSvc, bookStore, annotationsStore, bookmarksStore, progressStore).Register(registry)
+	fallback, _ := webdav.ParseSchedule("20m")
+	if raw := os.Getenv("RELITE_WEB_DAV_SYNC_INTERVAL"); raw != "" {
+		fallback, err = webdav.ParseSchedule(raw)
 		if err != nil {
 			log.Fatal("invalid RELITE_WEB_DAV_SYNC_INTERVAL")
 		}
-		interval = duration
 	}
-	ticker := time.NewTicker(interval)
+	syncParallel := 2
+	if raw := os.Getenv("RELITE_WEB_DAV_SYNC_PARALLEL"); raw != "" {
+		syncParallel, err = strconv.Atoi(raw)
+		if err != nil || syncParallel <= 0 {
+			log.Fatal("invalid RELITE_WEB_DAV_SYNC_PARALLEL")
+		}
+	}
+	webSvc.Register(registry, syncParallel)
+	ticker := time.NewTicker(time.Minute)
 	defer ticker.Stop()
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
-	go webdav.NewScheduler(webSvc, ticker.C).Start(ctx)
+	go webdav.NewScheduler(webSvc, ticker.C, fallback).Start(ctx)
 	queue.Start(ctx)
 	srv := &http.Server{
 		Addr:    ":8080",
-		Handler: apphttp.NewRouterWithAuthAndWebDAV(authSvc, jwtSecret, webSvc, bookStore, annotationsStore, bookmarksStore, prefsStore, progressStore, tasksStore, queue),
+		Handler: apphttp.NewRouterWithAuthAndWebDAV(authSvc, jwtSecret, webSvc, bookStore, annotationsStore, bookmarksStore, prefsStore, progressStore, tasksStore, queue, coverCache),
 	}
 	log.Fatal(srv.ListenAndServe())
 }
@@ -3,10 +3,13 @@ package annotations
 import "time"
 
 type Annotation struct {
-	ID        string    `json:"id"`
-	UserID    string    `json:"user_id"`
-	BookID    string    `json:"book_id"`
-	Location  float64   `json:"location"`
+	ID       string  `json:"id"`
+	UserID   string  `json:"user_id"`
+	BookID   string  `json:"book_id"`
+	Location float64 `json:"location"`
+	// BlockID anchors the annotation to a block of the reader document
+	// model; Location stays as the fallback for other formats.
+	BlockID   string    `json:"block_id,omitempty"`
 	Quote     string    `json:"quote"`
 	Note      string    `json:"note"`
 	Color     string    `json:"color"`
@@ -16,7 +16,7 @@ func NewMemoryStore() *MemoryStore {
 	return &MemoryStore{items: make(map[string]map[string][]Annotation)}
 }
 
-func (s *MemoryStore) Create(userID, bookID string, location float64, quote, note, color string) (Annotation, error) {
+func (s *MemoryStore) Create(userID, bookID string, location float64, quote, note, color, blockID string) (Annotation, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	if s.items[userID] == nil {
@@ -28,6 +28,7 @@ func (s *MemoryStore) Create(userID, bookID string, location float64, quote, not
 		UserID:    userID,
 		BookID:    bookID,
 		Location:  location,
+		BlockID:   blockID,
 		Quote:     quote,
 		Note:      note,
 		Color:     color,
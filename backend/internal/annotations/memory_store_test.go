@@ -4,7 +4,7 @@ import "testing"
 
 func TestMemoryStoreCRUD(t *testing.T) {
 	store := NewMemoryStore()
-	created, err := store.Create("user-1", "book-1", 0.42, "quote", "note", "#ffcc00")
+	created, err := store.Create("user-1", "book-1", 0.42, "quote", "note", "#ffcc00", "b12")
 	if err != nil {
 		t.Fatalf("create failed: %v", err)
 	}
@@ -18,6 +18,9 @@ func TestMemoryStoreCRUD(t *testing.T) {
 	if items[0].ID != created.ID {
 		t.Fatalf("expected id %s, got %s", created.ID, items[0].ID)
 	}
+	if items[0].BlockID != "b12" {
+		t.Fatalf("expected block id b12, got %q", items[0].BlockID)
+	}
 	if err := store.Delete("user-1", "book-1", created.ID); err != nil {
 		t.Fatalf("delete failed: %v", err)
 	}
@@ -31,20 +31,21 @@ CREATE TABLE IF NOT EXISTS annotations (
   created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
 );
 CREATE INDEX IF NOT EXISTS idx_annotations_user_book ON annotations (user_id, book_id);
+ALTER TABLE annotations ADD COLUMN IF NOT EXISTS block_id TEXT NOT NULL DEFAULT '';
 `)
 	return err
 }
 
-func (s *PostgresStore) Create(userID, bookID string, location float64, quote, note, color string) (Annotation, error) {
+func (s *PostgresStore) Create(userID, bookID string, location float64, quote, note, color, blockID string) (Annotation, error) {
 	ctx := context.Background()
 	id := newAnnotationID()
 	var item Annotation
 	err := s.pool.QueryRow(ctx, `
-INSERT INTO annotations (id, user_id, book_id, location, quote, note, color)
-VALUES ($1, $2, $3, $4, $5, $6, $7)
-RETURNING id, user_id, book_id, location, quote, note, color, created_at;`,
-		id, userID, bookID, location, quote, note, color,
-	).Scan(&item.ID, &item.UserID, &item.BookID, &item.Location, &item.Quote, &item.Note, &item.Color, &item.CreatedAt)
+INSERT INTO annotations (id, user_id, book_id, location, block_id, quote, note, color)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+RETURNING id, user_id, book_id, location, block_id, quote, note, color, created_at;`,
+		id, userID, bookID, location, blockID, quote, note, color,
+	).Scan(&item.ID, &item.UserID, &item.BookID, &item.Location, &item.BlockID, &item.Quote, &item.Note, &item.Color, &item.CreatedAt)
 	if err != nil {
 		return Annotation{}, err
 	}
@@ -54,7 +55,7 @@ RETURNING id, user_id, book_id, location, quote, note, color, created_at;`,
 func (s *PostgresStore) ListByBook(userID, bookID string) ([]Annotation, error) {
 	ctx := context.Background()
 	rows, err := s.pool.Query(ctx, `
-SELECT id, user_id, book_id, location, quote, note, color, created_at
+SELECT id, user_id, book_id, location, block_id, quote, note, color, created_at
 FROM annotations
 WHERE user_id = $1 AND book_id = $2
 ORDER BY created_at DESC;`,
@@ -67,7 +68,7 @@ ORDER BY created_at DESC;`,
 	var out []Annotation
 	for rows.Next() {
 		var item Annotation
-		if err := rows.Scan(&item.ID, &item.UserID, &item.BookID, &item.Location, &item.Quote, &item.Note, &item.Color, &item.CreatedAt); err != nil {
+		if err := rows.Scan(&item.ID, &item.UserID, &item.BookID, &item.Location, &item.BlockID, &item.Quote, &item.Note, &item.Color, &item.CreatedAt); err != nil {
 			return nil, err
 		}
 		out = append(out, item)
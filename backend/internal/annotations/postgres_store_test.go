@@ -20,7 +20,7 @@ func TestPostgresStoreCRUD(t *testing.T) {
 	t.Cleanup(func() {
 		_, _ = pool.Exec(context.Background(), `DELETE FROM annotations WHERE user_id = $1`, userID)
 	})
-	created, err := store.Create(userID, bookID, 0.5, "quote", "note", "#ffcc00")
+	created, err := store.Create(userID, bookID, 0.5, "quote", "note", "#ffcc00", "b3")
 	if err != nil {
 		t.Fatalf("create: %v", err)
 	}
@@ -34,6 +34,9 @@ func TestPostgresStoreCRUD(t *testing.T) {
 	if list[0].ID != created.ID {
 		t.Fatalf("expected id %s, got %s", created.ID, list[0].ID)
 	}
+	if list[0].BlockID != "b3" {
+		t.Fatalf("expected block id b3, got %q", list[0].BlockID)
+	}
 	if err := store.Delete(userID, bookID, created.ID); err != nil {
 		t.Fatalf("delete: %v", err)
 	}
@@ -6,7 +6,7 @@ var ErrNotFound = errors.New("annotation not found")
 
 // Store persists annotations.
 type Store interface {
-	Create(userID, bookID string, location float64, quote, note, color string) (Annotation, error)
+	Create(userID, bookID string, location float64, quote, note, color, blockID string) (Annotation, error)
 	ListByBook(userID, bookID string) ([]Annotation, error)
 	Delete(userID, bookID, id string) error
 }
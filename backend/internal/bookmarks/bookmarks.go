@@ -3,10 +3,12 @@ package bookmarks
 import "time"
 
 type Bookmark struct {
-	ID        string    `json:"id"`
-	UserID    string    `json:"user_id"`
-	BookID    string    `json:"book_id"`
-	Label     string    `json:"label"`
-	Location  float64   `json:"location"`
+	ID       string  `json:"id"`
+	UserID   string  `json:"user_id"`
+	BookID   string  `json:"book_id"`
+	Label    string  `json:"label"`
+	Location float64 `json:"location"`
+	// BlockID anchors the bookmark to a block of the reader document model.
+	BlockID   string    `json:"block_id,omitempty"`
 	CreatedAt time.Time `json:"created_at"`
 }
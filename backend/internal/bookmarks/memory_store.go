@@ -16,7 +16,7 @@ func NewMemoryStore() *MemoryStore {
 	return &MemoryStore{items: make(map[string]map[string][]Bookmark)}
 }
 
-func (s *MemoryStore) Create(userID, bookID, label string, location float64) (Bookmark, error) {
+func (s *MemoryStore) Create(userID, bookID, label string, location float64, blockID string) (Bookmark, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	if s.items[userID] == nil {
@@ -29,6 +29,7 @@ func (s *MemoryStore) Create(userID, bookID, label string, location float64) (Bo
 		BookID:    bookID,
 		Label:     label,
 		Location:  location,
+		BlockID:   blockID,
 		CreatedAt: time.Now().UTC(),
 	}
 	s.items[userID][bookID] = append(s.items[userID][bookID], bookmark)
@@ -4,7 +4,7 @@ import "testing"
 
 func TestMemoryStoreCRUD(t *testing.T) {
 	store := NewMemoryStore()
-	created, err := store.Create("user-1", "book-1", "Intro", 0.2)
+	created, err := store.Create("user-1", "book-1", "Intro", 0.2, "b7")
 	if err != nil {
 		t.Fatalf("create: %v", err)
 	}
@@ -12,6 +12,9 @@ func TestMemoryStoreCRUD(t *testing.T) {
 	if len(list) != 1 {
 		t.Fatalf("expected 1, got %d", len(list))
 	}
+	if list[0].BlockID != "b7" {
+		t.Fatalf("expected block id b7, got %q", list[0].BlockID)
+	}
 	if err := store.Delete("user-1", "book-1", created.ID); err != nil {
 		t.Fatalf("delete: %v", err)
 	}
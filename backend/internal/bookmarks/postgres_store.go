@@ -29,20 +29,21 @@ CREATE TABLE IF NOT EXISTS bookmarks (
   created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
 );
 CREATE INDEX IF NOT EXISTS idx_bookmarks_user_book ON bookmarks (user_id, book_id);
+ALTER TABLE bookmarks ADD COLUMN IF NOT EXISTS block_id TEXT NOT NULL DEFAULT '';
 `)
 	return err
 }
 
-func (s *PostgresStore) Create(userID, bookID, label string, location float64) (Bookmark, error) {
+func (s *PostgresStore) Create(userID, bookID, label string, location float64, blockID string) (Bookmark, error) {
 	ctx := context.Background()
 	id := newBookmarkID()
 	var bookmark Bookmark
 	err := s.pool.QueryRow(ctx,
-		`INSERT INTO bookmarks (id, user_id, book_id, label, location)
-         VALUES ($1, $2, $3, $4, $5)
-         RETURNING id, user_id, book_id, label, location, created_at`,
-		id, userID, bookID, label, location,
-	).Scan(&bookmark.ID, &bookmark.UserID, &bookmark.BookID, &bookmark.Label, &bookmark.Location, &bookmark.CreatedAt)
+		`INSERT INTO bookmarks (id, user_id, book_id, label, location, block_id)
+         VALUES ($1, $2, $3, $4, $5, $6)
+         RETURNING id, user_id, book_id, label, location, block_id, created_at`,
+		id, userID, bookID, label, location, blockID,
+	).Scan(&bookmark.ID, &bookmark.UserID, &bookmark.BookID, &bookmark.Label, &bookmark.Location, &bookmark.BlockID, &bookmark.CreatedAt)
 	if err != nil {
 		return Bookmark{}, err
 	}
@@ -52,7 +53,7 @@ func (s *PostgresStore) Create(userID, bookID, label string, location float64) (
 func (s *PostgresStore) ListByBook(userID, bookID string) ([]Bookmark, error) {
 	ctx := context.Background()
 	rows, err := s.pool.Query(ctx,
-		`SELECT id, user_id, book_id, label, location, created_at FROM bookmarks
+		`SELECT id, user_id, book_id, label, location, block_id, created_at FROM bookmarks
          WHERE user_id = $1 AND book_id = $2 ORDER BY created_at DESC`,
 		userID, bookID,
 	)
@@ -63,7 +64,7 @@ func (s *PostgresStore) ListByBook(userID, bookID string) ([]Bookmark, error) {
 	var out []Bookmark
 	for rows.Next() {
 		var item Bookmark
-		if err := rows.Scan(&item.ID, &item.UserID, &item.BookID, &item.Label, &item.Location, &item.CreatedAt); err != nil {
+		if err := rows.Scan(&item.ID, &item.UserID, &item.BookID, &item.Label, &item.Location, &item.BlockID, &item.CreatedAt); err != nil {
 			return nil, err
 		}
 		out = append(out, item)
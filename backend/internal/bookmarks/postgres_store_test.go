@@ -20,7 +20,7 @@ func TestPostgresStoreCRUD(t *testing.T) {
 	t.Cleanup(func() {
 		_, _ = pool.Exec(context.Background(), `DELETE FROM bookmarks WHERE user_id = $1`, userID)
 	})
-	created, err := store.Create(userID, bookID, "Chapter", 0.2)
+	created, err := store.Create(userID, bookID, "Chapter", 0.2, "b2")
 	if err != nil {
 		t.Fatalf("create: %v", err)
 	}
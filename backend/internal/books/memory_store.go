@@ -79,3 +79,50 @@ func (s *MemoryStore) MarkMissing(userID string, missing []string) error {
 	}
 	return nil
 }
+
+func (s *MemoryStore) UpdateMetadata(userID string, book Book) (Book, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for path, existing := range s.items[userID] {
+		if existing.ID != book.ID {
+			continue
+		}
+		existing.Title = book.Title
+		existing.Author = book.Author
+		existing.Language = book.Language
+		existing.Identifier = book.Identifier
+		existing.Series = book.Series
+		existing.SeriesIndex = book.SeriesIndex
+		existing.Subject = book.Subject
+		existing.PageCount = book.PageCount
+		existing.ContentHash = book.ContentHash
+		existing.Format = book.Format
+		existing.UpdatedAt = time.Now()
+		s.items[userID][path] = existing
+		return existing, nil
+	}
+	return Book{}, ErrNotFound
+}
+
+func (s *MemoryStore) Move(userID, id, sourcePath string) (Book, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for path, book := range s.items[userID] {
+		if book.ID != id {
+			continue
+		}
+		if path == sourcePath {
+			return book, nil
+		}
+		if _, ok := s.items[userID][sourcePath]; ok {
+			return Book{}, ErrExists
+		}
+		delete(s.items[userID], path)
+		book.SourcePath = sourcePath
+		book.Missing = false
+		book.UpdatedAt = time.Now()
+		s.items[userID][sourcePath] = book
+		return book, nil
+	}
+	return Book{}, ErrNotFound
+}
@@ -27,3 +27,34 @@ func TestMemoryStoreUpsertAndMarkMissing(t *testing.T) {
 		t.Fatalf("expected missing to be true")
 	}
 }
+
+func TestMemoryStoreMoveKeepsID(t *testing.T) {
+	store := NewMemoryStore()
+	book, _ := store.Upsert("user-1", Book{SourcePath: "/a.epub", Title: "A", Format: "epub"})
+	_, _ = store.Upsert("user-1", Book{SourcePath: "/c.epub", Title: "C", Format: "epub"})
+	_ = store.MarkMissing("user-1", []string{"/a.epub"})
+	moved, err := store.Move("user-1", book.ID, "/new/a.epub")
+	if err != nil {
+		t.Fatalf("move: %v", err)
+	}
+	if moved.ID != book.ID || moved.SourcePath != "/new/a.epub" || moved.Missing {
+		t.Fatalf("unexpected moved book %+v", moved)
+	}
+	if _, err := store.GetBySourcePath("user-1", "/a.epub"); err != ErrNotFound {
+		t.Fatalf("expected old path gone, got %v", err)
+	}
+	if _, err := store.Move("user-1", book.ID, "/c.epub"); err != ErrExists {
+		t.Fatalf("expected taken path rejected, got %v", err)
+	}
+	book.Title = "Extracted"
+	updated, err := store.UpdateMetadata("user-1", book)
+	if err != nil {
+		t.Fatalf("update metadata: %v", err)
+	}
+	if updated.Title != "Extracted" || updated.SourcePath != "/new/a.epub" {
+		t.Fatalf("expected metadata written to the moved book, got %+v", updated)
+	}
+	if list, _ := store.ListByUser("user-1"); len(list) != 2 {
+		t.Fatalf("expected no extra book, got %+v", list)
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
@@ -34,6 +35,13 @@ CREATE TABLE IF NOT EXISTS books (
   UNIQUE (user_id, source_path)
 );
 ALTER TABLE books ADD COLUMN IF NOT EXISTS connection_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE books ADD COLUMN IF NOT EXISTS language TEXT NOT NULL DEFAULT '';
+ALTER TABLE books ADD COLUMN IF NOT EXISTS identifier TEXT NOT NULL DEFAULT '';
+ALTER TABLE books ADD COLUMN IF NOT EXISTS series TEXT NOT NULL DEFAULT '';
+ALTER TABLE books ADD COLUMN IF NOT EXISTS series_index DOUBLE PRECISION NOT NULL DEFAULT 0;
+ALTER TABLE books ADD COLUMN IF NOT EXISTS subject TEXT NOT NULL DEFAULT '';
+ALTER TABLE books ADD COLUMN IF NOT EXISTS page_count INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE books ADD COLUMN IF NOT EXISTS content_hash TEXT NOT NULL DEFAULT '';
 CREATE INDEX IF NOT EXISTS idx_books_user_id ON books (user_id);
 `)
 	return err
@@ -47,20 +55,28 @@ func (s *PostgresStore) Upsert(userID string, book Book) (Book, error) {
 	book.UserID = userID
 	book.Missing = false
 	book.UpdatedAt = time.Now().UTC()
-	err := s.pool.QueryRow(ctx, `
-INSERT INTO books (id, user_id, title, author, format, source_path, connection_id, missing, updated_at)
-VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	row := s.pool.QueryRow(ctx, `
+INSERT INTO books (id, user_id, title, author, language, identifier, series, series_index, subject, page_count, content_hash, format, source_path, connection_id, missing, updated_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)
 ON CONFLICT (user_id, source_path)
 DO UPDATE SET
   title = EXCLUDED.title,
   author = EXCLUDED.author,
+  language = EXCLUDED.language,
+  identifier = EXCLUDED.identifier,
+  series = EXCLUDED.series,
+  series_index = EXCLUDED.series_index,
+  subject = EXCLUDED.subject,
+  page_count = EXCLUDED.page_count,
+  content_hash = EXCLUDED.content_hash,
   format = EXCLUDED.format,
   connection_id = EXCLUDED.connection_id,
   missing = EXCLUDED.missing,
   updated_at = EXCLUDED.updated_at
-RETURNING id, user_id, title, author, format, source_path, connection_id, missing, updated_at;`,
-		book.ID, book.UserID, book.Title, book.Author, book.Format, book.SourcePath, book.ConnectionID, book.Missing, book.UpdatedAt,
-	).Scan(&book.ID, &book.UserID, &book.Title, &book.Author, &book.Format, &book.SourcePath, &book.ConnectionID, &book.Missing, &book.UpdatedAt)
+RETURNING `+bookColumns+`;`,
+		book.ID, book.UserID, book.Title, book.Author, book.Language, book.Identifier, book.Series, book.SeriesIndex, book.Subject, book.PageCount, book.ContentHash, book.Format, book.SourcePath, book.ConnectionID, book.Missing, book.UpdatedAt,
+	)
+	book, err := scanBook(row)
 	if err != nil {
 		return Book{}, err
 	}
@@ -70,7 +86,7 @@ RETURNING id, user_id, title, author, format, source_path, connection_id, missin
 func (s *PostgresStore) ListByUser(userID string) ([]Book, error) {
 	ctx := context.Background()
 	rows, err := s.pool.Query(ctx, `
-SELECT id, user_id, title, author, format, source_path, connection_id, missing, updated_at
+SELECT `+bookColumns+`
 FROM books
 WHERE user_id = $1
 ORDER BY updated_at DESC;`,
@@ -82,8 +98,8 @@ ORDER BY updated_at DESC;`,
 	defer rows.Close()
 	var out []Book
 	for rows.Next() {
-		var book Book
-		if err := rows.Scan(&book.ID, &book.UserID, &book.Title, &book.Author, &book.Format, &book.SourcePath, &book.ConnectionID, &book.Missing, &book.UpdatedAt); err != nil {
+		book, err := scanBook(rows)
+		if err != nil {
 			return nil, err
 		}
 		out = append(out, book)
@@ -96,13 +112,12 @@ ORDER BY updated_at DESC;`,
 
 func (s *PostgresStore) GetBySourcePath(userID, sourcePath string) (Book, error) {
 	ctx := context.Background()
-	var book Book
-	err := s.pool.QueryRow(ctx, `
-SELECT id, user_id, title, author, format, source_path, connection_id, missing, updated_at
+	book, err := scanBook(s.pool.QueryRow(ctx, `
+SELECT `+bookColumns+`
 FROM books
 WHERE user_id = $1 AND source_path = $2;`,
 		userID, sourcePath,
-	).Scan(&book.ID, &book.UserID, &book.Title, &book.Author, &book.Format, &book.SourcePath, &book.ConnectionID, &book.Missing, &book.UpdatedAt)
+	))
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return Book{}, ErrNotFound
@@ -114,13 +129,12 @@ WHERE user_id = $1 AND source_path = $2;`,
 
 func (s *PostgresStore) GetByID(userID, id string) (Book, error) {
 	ctx := context.Background()
-	var book Book
-	err := s.pool.QueryRow(ctx, `
-SELECT id, user_id, title, author, format, source_path, connection_id, missing, updated_at
+	book, err := scanBook(s.pool.QueryRow(ctx, `
+SELECT `+bookColumns+`
 FROM books
 WHERE user_id = $1 AND id = $2;`,
 		userID, id,
-	).Scan(&book.ID, &book.UserID, &book.Title, &book.Author, &book.Format, &book.SourcePath, &book.ConnectionID, &book.Missing, &book.UpdatedAt)
+	))
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return Book{}, ErrNotFound
@@ -149,6 +163,66 @@ WHERE user_id = $2 AND source_path = $3;`,
 	return nil
 }
 
+func (s *PostgresStore) UpdateMetadata(userID string, book Book) (Book, error) {
+	ctx := context.Background()
+	updated, err := scanBook(s.pool.QueryRow(ctx, `
+UPDATE books
+SET title = $1, author = $2, language = $3, identifier = $4, series = $5, series_index = $6,
+  subject = $7, page_count = $8, content_hash = $9, format = $10, updated_at = $11
+WHERE user_id = $12 AND id = $13
+RETURNING `+bookColumns+`;`,
+		book.Title, book.Author, book.Language, book.Identifier, book.Series, book.SeriesIndex,
+		book.Subject, book.PageCount, book.ContentHash, book.Format, time.Now().UTC(), userID, book.ID,
+	))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return Book{}, ErrNotFound
+		}
+		return Book{}, err
+	}
+	return updated, nil
+}
+
+func (s *PostgresStore) Move(userID, id, sourcePath string) (Book, error) {
+	ctx := context.Background()
+	book, err := scanBook(s.pool.QueryRow(ctx, `
+UPDATE books
+SET source_path = $1, missing = FALSE, updated_at = $2
+WHERE user_id = $3 AND id = $4
+RETURNING `+bookColumns+`;`,
+		sourcePath, time.Now().UTC(), userID, id,
+	))
+	if err != nil {
+		if isDuplicate(err) {
+			return Book{}, ErrExists
+		}
+		if errors.Is(err, pgx.ErrNoRows) {
+			return Book{}, ErrNotFound
+		}
+		return Book{}, err
+	}
+	return book, nil
+}
+
+const bookColumns = `id, user_id, title, author, language, identifier, series, series_index, subject, page_count, content_hash, format, source_path, connection_id, missing, updated_at`
+
+func scanBook(row pgx.Row) (Book, error) {
+	var book Book
+	err := row.Scan(
+		&book.ID, &book.UserID, &book.Title, &book.Author, &book.Language, &book.Identifier, &book.Series, &book.SeriesIndex,
+		&book.Subject, &book.PageCount, &book.ContentHash, &book.Format, &book.SourcePath, &book.ConnectionID, &book.Missing, &book.UpdatedAt,
+	)
+	return book, err
+}
+
+func isDuplicate(err error) bool {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code == "23505"
+	}
+	return false
+}
+
 func newBookID() string {
 	buf := make([]byte, 8)
 	_, _ = rand.Read(buf)
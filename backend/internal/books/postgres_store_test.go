@@ -44,4 +44,27 @@ func TestPostgresStoreUpsertList(t *testing.T) {
 	if !updated.Missing {
 		t.Fatalf("expected missing true")
 	}
+	other, err := store.Upsert(userID, Book{Title: "Other", Format: "epub", SourcePath: "/books/other.epub"})
+	if err != nil {
+		t.Fatalf("upsert: %v", err)
+	}
+	moved, err := store.Move(userID, created.ID, "/books/moved/sample.epub")
+	if err != nil {
+		t.Fatalf("move: %v", err)
+	}
+	if moved.ID != created.ID || moved.SourcePath != "/books/moved/sample.epub" || moved.Missing {
+		t.Fatalf("unexpected moved book %+v", moved)
+	}
+	if _, err := store.Move(userID, created.ID, other.SourcePath); err != ErrExists {
+		t.Fatalf("expected taken path rejected, got %v", err)
+	}
+	stale := created
+	stale.Title = "Extracted"
+	updated, err = store.UpdateMetadata(userID, stale)
+	if err != nil {
+		t.Fatalf("update metadata: %v", err)
+	}
+	if updated.Title != "Extracted" || updated.SourcePath != moved.SourcePath {
+		t.Fatalf("expected metadata written to the moved book, got %+v", updated)
+	}
 }
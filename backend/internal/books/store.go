@@ -10,14 +10,26 @@ type Book struct {
 	UserID       string
 	Title        string
 	Author       string
+	Language     string
+	Identifier   string
+	Series       string
+	SeriesIndex  float64
+	Subject      string
+	PageCount    int
 	Format       string
 	SourcePath   string
 	ConnectionID string
 	Missing      bool
 	UpdatedAt    time.Time
+	// ContentHash is the hex SHA-256 of the content, recorded by the
+	// format task. Sync uses it to recognise moved files.
+	ContentHash string
 }
 
-var ErrNotFound = errors.New("book not found")
+var (
+	ErrNotFound = errors.New("book not found")
+	ErrExists   = errors.New("book already exists")
+)
 
 type Store interface {
 	Upsert(userID string, book Book) (Book, error)
@@ -25,4 +37,12 @@ type Store interface {
 	GetByID(userID, id string) (Book, error)
 	GetBySourcePath(userID, sourcePath string) (Book, error)
 	MarkMissing(userID string, missing []string) error
+	// Move points a book at a new source path, keeping its ID and the
+	// data attached to it, and clears its missing flag.
+	Move(userID, id, sourcePath string) (Book, error)
+	// UpdateMetadata writes the format, content hash and extracted
+	// metadata of book to the book with its ID. The source path,
+	// connection and missing flag are left alone, so a move made in the
+	// meantime sticks.
+	UpdateMetadata(userID string, book Book) (Book, error)
 }
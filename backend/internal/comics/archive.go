@@ -0,0 +1,183 @@
+package comics
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io"
+	"sort"
+
+	"github.com/EROQIN/relite-reader/backend/internal/formats"
+)
+
+// maxPageSize bounds compressed pages that must be inflated into memory.
+const maxPageSize = 64 << 20
+
+var (
+	ErrUnsupported = errors.New("unsupported comic archive")
+	ErrNoPages     = errors.New("comic archive has no pages")
+	ErrPageRange   = errors.New("page out of range")
+)
+
+type Page struct {
+	Name string
+	Size int64
+	// offset is the data offset of uncompressed TAR members.
+	offset int64
+	zip    *zip.File
+}
+
+// Archive gives random access to the image pages of a comic book archive.
+type Archive struct {
+	r     io.ReaderAt
+	size  int64
+	pages []Page
+	files map[string]Page
+}
+
+// Open reads the directory of a ZIP (cbz) or TAR (cbt) archive. Pages are
+// ordered with a natural sort so "page10.jpg" follows "page9.jpg". RAR
+// (cbr), 7z (cb7) and ACE (cba) archives report ErrUnsupported.
+func Open(format string, r io.ReaderAt, size int64) (*Archive, error) {
+	archive := &Archive{r: r, size: size, files: make(map[string]Page)}
+	var err error
+	switch format {
+	case "cbz":
+		err = archive.readZip()
+	case "cbt":
+		err = archive.readTar()
+	default:
+		return nil, ErrUnsupported
+	}
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(archive.pages, func(i, j int) bool {
+		return naturalLess(archive.pages[i].Name, archive.pages[j].Name)
+	})
+	return archive, nil
+}
+
+func (a *Archive) readZip() error {
+	reader, err := zip.NewReader(a.r, a.size)
+	if err != nil {
+		return err
+	}
+	for _, file := range reader.File {
+		if file.FileInfo().IsDir() {
+			continue
+		}
+		page := Page{Name: file.Name, Size: int64(file.UncompressedSize64), zip: file}
+		a.add(page)
+	}
+	return nil
+}
+
+func (a *Archive) readTar() error {
+	counter := &countingReader{r: io.NewSectionReader(a.r, 0, a.size)}
+	reader := tar.NewReader(counter)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		a.add(Page{Name: header.Name, Size: header.Size, offset: counter.n})
+	}
+}
+
+func (a *Archive) add(page Page) {
+	a.files[page.Name] = page
+	if formats.IsImage(page.Name) && !isHidden(page.Name) {
+		a.pages = append(a.pages, page)
+	}
+}
+
+// Pages lists the image entries in reading order.
+func (a *Archive) Pages() []Page {
+	return a.pages
+}
+
+// OpenPage returns a reader for the page at index (0-based).
+func (a *Archive) OpenPage(index int) (io.ReadCloser, Page, error) {
+	if index < 0 || index >= len(a.pages) {
+		return nil, Page{}, ErrPageRange
+	}
+	page := a.pages[index]
+	rc, err := a.open(page)
+	return rc, page, err
+}
+
+// OpenFile returns a reader for any member of the archive by name.
+func (a *Archive) OpenFile(name string) (io.ReadCloser, error) {
+	page, ok := a.files[name]
+	if !ok {
+		return nil, ErrNoPages
+	}
+	return a.open(page)
+}
+
+// PageReader returns a seekable reader for the page at index, suitable
+// for serving byte ranges. Stored ZIP entries and TAR members are read in
+// place; deflated entries are inflated into memory.
+func (a *Archive) PageReader(index int) (io.ReadSeeker, Page, error) {
+	if index < 0 || index >= len(a.pages) {
+		return nil, Page{}, ErrPageRange
+	}
+	page := a.pages[index]
+	if page.zip == nil {
+		return io.NewSectionReader(a.r, page.offset, page.Size), page, nil
+	}
+	if page.zip.Method == zip.Store {
+		if offset, err := page.zip.DataOffset(); err == nil {
+			return io.NewSectionReader(a.r, offset, page.Size), page, nil
+		}
+	}
+	rc, err := page.zip.Open()
+	if err != nil {
+		return nil, Page{}, err
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(io.LimitReader(rc, maxPageSize))
+	if err != nil {
+		return nil, Page{}, err
+	}
+	return bytes.NewReader(data), page, nil
+}
+
+func (a *Archive) open(page Page) (io.ReadCloser, error) {
+	if page.zip != nil {
+		return page.zip.Open()
+	}
+	return io.NopCloser(io.NewSectionReader(a.r, page.offset, page.Size)), nil
+}
+
+// FirstPage returns the bytes of the first page, used as the cover.
+func (a *Archive) FirstPage(limit int64) ([]byte, error) {
+	if len(a.pages) == 0 {
+		return nil, ErrNoPages
+	}
+	rc, _, err := a.OpenPage(0)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(io.LimitReader(rc, limit))
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,150 @@
+package comics
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"io"
+	"testing"
+)
+
+func buildZip(t *testing.T, names []string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	writer := zip.NewWriter(&buf)
+	for _, name := range names {
+		w, err := writer.Create(name)
+		if err != nil {
+			t.Fatalf("create: %v", err)
+		}
+		_, _ = w.Write([]byte("data:" + name))
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func buildTar(t *testing.T, names []string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	writer := tar.NewWriter(&buf)
+	for _, name := range names {
+		content := []byte("data:" + name)
+		if err := writer.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatalf("header: %v", err)
+		}
+		_, _ = writer.Write(content)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestOpenZipSortsPagesNaturally(t *testing.T) {
+	data := buildZip(t, []string{"page10.jpg", "page9.jpg", "page1.png", "__MACOSX/._page1.png", "notes.txt"})
+	archive, err := Open("cbz", bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	pages := archive.Pages()
+	want := []string{"page1.png", "page9.jpg", "page10.jpg"}
+	if len(pages) != len(want) {
+		t.Fatalf("expected %d pages, got %d", len(want), len(pages))
+	}
+	for i, name := range want {
+		if pages[i].Name != name {
+			t.Fatalf("page %d: expected %s, got %s", i, name, pages[i].Name)
+		}
+	}
+}
+
+func TestOpenTarReadsPages(t *testing.T) {
+	data := buildTar(t, []string{"b/002.jpg", "b/001.jpg"})
+	archive, err := Open("cbt", bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	rc, page, err := archive.OpenPage(1)
+	if err != nil {
+		t.Fatalf("open page: %v", err)
+	}
+	defer rc.Close()
+	content, _ := io.ReadAll(rc)
+	if page.Name != "b/002.jpg" || string(content) != "data:b/002.jpg" {
+		t.Fatalf("unexpected page %s: %q", page.Name, content)
+	}
+	first, err := archive.FirstPage(1 << 20)
+	if err != nil || string(first) != "data:b/001.jpg" {
+		t.Fatalf("unexpected first page %q (%v)", first, err)
+	}
+}
+
+func TestPageReaderSeeksStoredAndDeflatedPages(t *testing.T) {
+	var buf bytes.Buffer
+	writer := zip.NewWriter(&buf)
+	stored, _ := writer.CreateHeader(&zip.FileHeader{Name: "01.jpg", Method: zip.Store})
+	_, _ = stored.Write([]byte("stored-page"))
+	deflated, _ := writer.Create("02.jpg")
+	_, _ = deflated.Write([]byte("deflated-page"))
+	_ = writer.Close()
+	data := buf.Bytes()
+	archive, err := Open("cbz", bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	for i, want := range []string{"page", "d-page"} {
+		reader, _, err := archive.PageReader(i)
+		if err != nil {
+			t.Fatalf("page %d: %v", i, err)
+		}
+		if _, err := reader.Seek(-int64(len(want)), io.SeekEnd); err != nil {
+			t.Fatalf("seek: %v", err)
+		}
+		got, _ := io.ReadAll(reader)
+		if string(got) != want {
+			t.Fatalf("page %d: got %q", i, got)
+		}
+	}
+}
+
+func TestComicInfo(t *testing.T) {
+	var buf bytes.Buffer
+	writer := zip.NewWriter(&buf)
+	info, _ := writer.Create("ComicInfo.xml")
+	_, _ = info.Write([]byte(`<?xml version="1.0"?>
+<ComicInfo xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">
+  <Title>The Long Halloween</Title>
+  <Series>Batman</Series>
+  <Number>3.5</Number>
+  <Writer>Jeph Loeb</Writer>
+  <Manga>YesAndRightToLeft</Manga>
+</ComicInfo>`))
+	page, _ := writer.Create("001.png")
+	_, _ = page.Write([]byte("png"))
+	_ = writer.Close()
+	data := buf.Bytes()
+	archive, err := Open("cbz", bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	parsed, ok := archive.Info()
+	if !ok || parsed.Series != "Batman" || parsed.Writer != "Jeph Loeb" || parsed.Title != "The Long Halloween" {
+		t.Fatalf("unexpected info %+v", parsed)
+	}
+	if number, ok := parsed.IssueNumber(); !ok || number != 3.5 || !parsed.RightToLeft() {
+		t.Fatalf("unexpected issue %v %v", number, ok)
+	}
+	if len(archive.Pages()) != 1 {
+		t.Fatalf("expected ComicInfo.xml to be excluded from pages")
+	}
+}
+
+func TestOpenRejectsUnsupportedArchives(t *testing.T) {
+	for _, format := range []string{"cbr", "cb7", "cba"} {
+		if _, err := Open(format, bytes.NewReader(nil), 0); err != ErrUnsupported {
+			t.Fatalf("%s: expected ErrUnsupported, got %v", format, err)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package comics
+
+import (
+	"encoding/xml"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Info holds the fields of a ComicRack ComicInfo.xml that the library
+// cares about.
+type Info struct {
+	Title     string `xml:"Title"`
+	Series    string `xml:"Series"`
+	Number    string `xml:"Number"`
+	Volume    string `xml:"Volume"`
+	Writer    string `xml:"Writer"`
+	Penciller string `xml:"Penciller"`
+	Publisher string `xml:"Publisher"`
+	Summary   string `xml:"Summary"`
+	Year      int    `xml:"Year"`
+	Language  string `xml:"LanguageISO"`
+	// Manga is "Yes" or "YesAndRightToLeft" for right-to-left books.
+	Manga string `xml:"Manga"`
+}
+
+// maxComicInfoSize bounds the metadata document.
+const maxComicInfoSize = 1 << 20
+
+// Info reads ComicInfo.xml from the archive root (or, failing that, any
+// folder). ok is false when the archive has no usable metadata.
+func (a *Archive) Info() (Info, bool) {
+	name := ""
+	for candidate := range a.files {
+		if !strings.EqualFold(path.Base(candidate), "ComicInfo.xml") {
+			continue
+		}
+		if name == "" || strings.Count(candidate, "/") < strings.Count(name, "/") {
+			name = candidate
+		}
+	}
+	if name == "" {
+		return Info{}, false
+	}
+	rc, err := a.OpenFile(name)
+	if err != nil {
+		return Info{}, false
+	}
+	defer rc.Close()
+	var info Info
+	decoder := xml.NewDecoder(io.LimitReader(rc, maxComicInfoSize))
+	decoder.Strict = false
+	if err := decoder.Decode(&info); err != nil {
+		return Info{}, false
+	}
+	for _, field := range []*string{&info.Title, &info.Series, &info.Number, &info.Volume, &info.Writer, &info.Penciller, &info.Publisher, &info.Summary, &info.Language, &info.Manga} {
+		*field = strings.TrimSpace(*field)
+	}
+	return info, true
+}
+
+// IssueNumber parses Number as a float so it can be stored as a series
+// index. Non-numeric issues such as "Annual 1" report ok=false.
+func (i Info) IssueNumber() (float64, bool) {
+	value, err := strconv.ParseFloat(i.Number, 64)
+	if err != nil {
+		return 0, false
+	}
+	return value, true
+}
+
+// RightToLeft reports whether pages should be read right to left.
+func (i Info) RightToLeft() bool {
+	return i.Manga == "YesAndRightToLeft"
+}
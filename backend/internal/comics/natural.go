@@ -0,0 +1,57 @@
+package comics
+
+import (
+	"path"
+	"strings"
+)
+
+// naturalLess compares names so that embedded numbers sort by value.
+func naturalLess(a, b string) bool {
+	a = strings.ToLower(a)
+	b = strings.ToLower(b)
+	for a != "" && b != "" {
+		if isDigit(a[0]) && isDigit(b[0]) {
+			na, restA := leadingNumber(a)
+			nb, restB := leadingNumber(b)
+			trimmedA := strings.TrimLeft(na, "0")
+			trimmedB := strings.TrimLeft(nb, "0")
+			if len(trimmedA) != len(trimmedB) {
+				return len(trimmedA) < len(trimmedB)
+			}
+			if trimmedA != trimmedB {
+				return trimmedA < trimmedB
+			}
+			if len(na) != len(nb) {
+				return len(na) < len(nb)
+			}
+			a, b = restA, restB
+			continue
+		}
+		if a[0] != b[0] {
+			return a[0] < b[0]
+		}
+		a, b = a[1:], b[1:]
+	}
+	return len(a) < len(b)
+}
+
+func leadingNumber(s string) (string, string) {
+	i := 0
+	for i < len(s) && isDigit(s[i]) {
+		i++
+	}
+	return s[:i], s[i:]
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// isHidden skips macOS resource forks and dot files that often ship inside
+// comic archives.
+func isHidden(name string) bool {
+	if strings.HasPrefix(name, "__MACOSX/") {
+		return true
+	}
+	return strings.HasPrefix(path.Base(name), ".")
+}
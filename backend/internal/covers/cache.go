@@ -0,0 +1,109 @@
+package covers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var ErrNotCached = errors.New("cover not cached")
+
+// Cache stores generated thumbnails on disk under
+// <dir>/<user>/<book>/<size>.jpg.
+type Cache struct {
+	dir string
+}
+
+type Entry struct {
+	Path    string
+	ETag    string
+	ModTime time.Time
+}
+
+func NewCache(dir string) *Cache {
+	return &Cache{dir: dir}
+}
+
+// Store renders every thumbnail size from the source image and replaces
+// the cached files atomically.
+func (c *Cache) Store(userID, bookID string, source []byte) error {
+	dir := c.bookDir(userID, bookID)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	for name, width := range Sizes {
+		thumb, err := Thumbnail(source, width)
+		if err != nil {
+			return err
+		}
+		path := filepath.Join(dir, name+".jpg")
+		tmp := path + ".tmp"
+		if err := os.WriteFile(tmp, thumb, 0o644); err != nil {
+			return err
+		}
+		if err := os.Rename(tmp, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Lookup returns the cached thumbnail for size along with a strong ETag
+// derived from its content.
+func (c *Cache) Lookup(userID, bookID, size string) (Entry, error) {
+	if _, ok := Sizes[size]; !ok {
+		return Entry{}, ErrNotCached
+	}
+	path := filepath.Join(c.bookDir(userID, bookID), size+".jpg")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return Entry{}, ErrNotCached
+		}
+		return Entry{}, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return Entry{}, err
+	}
+	sum := sha256.Sum256(data)
+	return Entry{
+		Path:    path,
+		ETag:    `"` + hex.EncodeToString(sum[:8]) + `"`,
+		ModTime: info.ModTime(),
+	}, nil
+}
+
+// Has reports whether a thumbnail exists for the book.
+func (c *Cache) Has(userID, bookID string) bool {
+	_, err := os.Stat(filepath.Join(c.bookDir(userID, bookID), DefaultSize+".jpg"))
+	return err == nil
+}
+
+// Remove deletes all thumbnails for a book.
+func (c *Cache) Remove(userID, bookID string) error {
+	return os.RemoveAll(c.bookDir(userID, bookID))
+}
+
+func (c *Cache) bookDir(userID, bookID string) string {
+	return filepath.Join(c.dir, safeSegment(userID), safeSegment(bookID))
+}
+
+// safeSegment keeps identifiers from escaping the cache directory.
+func safeSegment(value string) string {
+	cleaned := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+			return r
+		}
+		return '_'
+	}, value)
+	if cleaned == "" {
+		return "_"
+	}
+	return cleaned
+}
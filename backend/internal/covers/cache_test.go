@@ -0,0 +1,68 @@
+package covers
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func testPNG(t *testing.T, w, h int) []byte {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.NRGBA{R: uint8(x), G: uint8(y), B: 200, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestThumbnailScalesToWidth(t *testing.T) {
+	thumb, err := Thumbnail(testPNG(t, 800, 1200), 160)
+	if err != nil {
+		t.Fatalf("thumbnail: %v", err)
+	}
+	img, err := jpeg.Decode(bytes.NewReader(thumb))
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if img.Bounds().Dx() != 160 || img.Bounds().Dy() != 240 {
+		t.Fatalf("unexpected size %v", img.Bounds())
+	}
+}
+
+func TestCacheStoreAndLookup(t *testing.T) {
+	cache := NewCache(t.TempDir())
+	if _, err := cache.Lookup("user-1", "b-1", "small"); err != ErrNotCached {
+		t.Fatalf("expected not cached, got %v", err)
+	}
+	if err := cache.Store("user-1", "b-1", testPNG(t, 100, 150)); err != nil {
+		t.Fatalf("store: %v", err)
+	}
+	entry, err := cache.Lookup("user-1", "b-1", "large")
+	if err != nil {
+		t.Fatalf("lookup: %v", err)
+	}
+	if entry.ETag == "" || !cache.Has("user-1", "b-1") {
+		t.Fatalf("expected cached entry")
+	}
+	if _, err := cache.Lookup("user-1", "b-1", "huge"); err != ErrNotCached {
+		t.Fatalf("expected unknown size to miss")
+	}
+	if err := cache.Remove("user-1", "b-1"); err != nil || cache.Has("user-1", "b-1") {
+		t.Fatalf("expected removal")
+	}
+}
+
+func TestSafeSegmentBlocksTraversal(t *testing.T) {
+	if got := safeSegment("../../etc"); got != "______etc" {
+		t.Fatalf("unexpected segment %q", got)
+	}
+}
@@ -0,0 +1,72 @@
+package covers
+
+import (
+	"errors"
+	"io"
+
+	"github.com/EROQIN/relite-reader/backend/internal/comics"
+	"github.com/EROQIN/relite-reader/backend/internal/epub"
+	"github.com/EROQIN/relite-reader/backend/internal/fb2"
+	"github.com/EROQIN/relite-reader/backend/internal/mobi"
+)
+
+var ErrNoCover = errors.New("no cover image")
+
+// maxSourceSize bounds the cover image we are willing to decode.
+const maxSourceSize = 32 << 20
+
+// Supported reports whether covers can be extracted from a format.
+func Supported(format string) bool {
+	switch format {
+	case "epub", "cbz", "cbt", "fb2", "mobi", "azw", "azw3", "azw4":
+		return true
+	}
+	return false
+}
+
+// Extract returns the raw cover image embedded in a book.
+func Extract(format string, r io.ReaderAt, size int64) ([]byte, error) {
+	switch format {
+	case "epub":
+		book, err := epub.Open(r, size)
+		if err != nil {
+			return nil, err
+		}
+		data, err := book.Cover()
+		if errors.Is(err, epub.ErrNoCover) {
+			return nil, ErrNoCover
+		}
+		return data, err
+	case "cbz", "cbt":
+		archive, err := comics.Open(format, r, size)
+		if err != nil {
+			return nil, err
+		}
+		data, err := archive.FirstPage(maxSourceSize)
+		if errors.Is(err, comics.ErrNoPages) {
+			return nil, ErrNoCover
+		}
+		return data, err
+	case "fb2":
+		doc, err := fb2.Parse(io.NewSectionReader(r, 0, size))
+		if err != nil {
+			return nil, err
+		}
+		cover, err := doc.Cover()
+		if err != nil {
+			return nil, ErrNoCover
+		}
+		return cover.Data, nil
+	case "mobi", "azw", "azw3", "azw4":
+		book, err := mobi.Open(r, size)
+		if err != nil {
+			return nil, err
+		}
+		data, err := book.Cover()
+		if errors.Is(err, mobi.ErrNoCover) {
+			return nil, ErrNoCover
+		}
+		return data, err
+	}
+	return nil, ErrNoCover
+}
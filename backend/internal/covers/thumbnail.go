@@ -0,0 +1,54 @@
+package covers
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+
+	// Register decoders for the image formats found in ebooks and comics.
+	_ "image/gif"
+	_ "image/png"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// Sizes maps thumbnail names to their target width in pixels.
+var Sizes = map[string]int{
+	"small":  160,
+	"medium": 320,
+	"large":  640,
+}
+
+const DefaultSize = "medium"
+
+// Thumbnail decodes an image and scales it down to width, keeping the
+// aspect ratio. Images narrower than width are re-encoded unscaled.
+func Thumbnail(source []byte, width int) ([]byte, error) {
+	src, _, err := image.Decode(bytes.NewReader(source))
+	if err != nil {
+		return nil, err
+	}
+	bounds := src.Bounds()
+	if bounds.Dx() <= 0 || bounds.Dy() <= 0 {
+		return nil, image.ErrFormat
+	}
+	targetW := bounds.Dx()
+	targetH := bounds.Dy()
+	if targetW > width {
+		targetH = bounds.Dy() * width / bounds.Dx()
+		targetW = width
+		if targetH < 1 {
+			targetH = 1
+		}
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, targetW, targetH))
+	// Fill with white so transparent PNG covers do not turn black in JPEG.
+	draw.Draw(dst, dst.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, bounds, draw.Over, nil)
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 85}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
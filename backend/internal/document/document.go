@@ -0,0 +1,320 @@
+package document
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Document is the format-neutral representation that Markdown, HTML and
+// FB2 books are converted into. Sections and blocks carry IDs that stay
+// the same as long as the source file does, so reading positions can
+// point at a block instead of a fraction of the book.
+type Document struct {
+	Title     string     `json:"title,omitempty"`
+	Authors   []string   `json:"authors,omitempty"`
+	Language  string     `json:"language,omitempty"`
+	Sections  []Section  `json:"sections"`
+	Footnotes []Footnote `json:"footnotes,omitempty"`
+}
+
+type Section struct {
+	ID     string  `json:"id"`
+	Title  string  `json:"title,omitempty"`
+	Blocks []Block `json:"blocks"`
+}
+
+type BlockKind string
+
+const (
+	Paragraph BlockKind = "paragraph"
+	Heading   BlockKind = "heading"
+	Image     BlockKind = "image"
+	Quote     BlockKind = "quote"
+	Code      BlockKind = "code"
+	ListItem  BlockKind = "list_item"
+	Rule      BlockKind = "rule"
+	Table     BlockKind = "table"
+)
+
+type Block struct {
+	ID   string    `json:"id"`
+	Kind BlockKind `json:"kind"`
+	// Level is the heading level or, for list items, the nesting depth.
+	Level   int      `json:"level,omitempty"`
+	Ordered bool     `json:"ordered,omitempty"`
+	Text    string   `json:"text,omitempty"`
+	Inlines []Inline `json:"inlines,omitempty"`
+	Src     string   `json:"src,omitempty"`
+	Alt     string   `json:"alt,omitempty"`
+	// Rows holds the cells of a table block.
+	Rows []TableRow `json:"rows,omitempty"`
+}
+
+type TableRow struct {
+	// Header marks a row repeated at the top of the table, such as the
+	// column titles.
+	Header bool        `json:"header,omitempty"`
+	Cells  []TableCell `json:"cells"`
+}
+
+type TableCell struct {
+	Inlines []Inline `json:"inlines,omitempty"`
+}
+
+type InlineKind string
+
+const (
+	Text        InlineKind = "text"
+	Emphasis    InlineKind = "emphasis"
+	Strong      InlineKind = "strong"
+	Strike      InlineKind = "strike"
+	CodeSpan    InlineKind = "code"
+	Link        InlineKind = "link"
+	InlineImage InlineKind = "image"
+	FootnoteRef InlineKind = "footnote_ref"
+	Break       InlineKind = "break"
+	Sub         InlineKind = "sub"
+	Sup         InlineKind = "sup"
+)
+
+type Inline struct {
+	Kind InlineKind `json:"kind"`
+	Text string     `json:"text,omitempty"`
+	// Href is the link target, or the footnote ID for footnote references.
+	Href     string   `json:"href,omitempty"`
+	Src      string   `json:"src,omitempty"`
+	Alt      string   `json:"alt,omitempty"`
+	Children []Inline `json:"children,omitempty"`
+}
+
+type Footnote struct {
+	ID     string  `json:"id"`
+	Label  string  `json:"label,omitempty"`
+	Blocks []Block `json:"blocks"`
+}
+
+// Block returns the block with the given ID.
+func (d *Document) Block(id string) (Block, bool) {
+	for _, section := range d.Sections {
+		for _, block := range section.Blocks {
+			if block.ID == id {
+				return block, true
+			}
+		}
+	}
+	return Block{}, false
+}
+
+// MapImages rewrites every image source through resolve and drops the
+// images it rejects.
+func (d *Document) MapImages(resolve func(src string) (string, bool)) {
+	mapBlocks := func(blocks []Block) []Block {
+		out := blocks[:0]
+		for _, block := range blocks {
+			if block.Kind == Image {
+				src, ok := resolve(block.Src)
+				if !ok {
+					continue
+				}
+				block.Src = src
+			}
+			block.Inlines = mapInlines(block.Inlines, resolve)
+			for _, row := range block.Rows {
+				for i := range row.Cells {
+					row.Cells[i].Inlines = mapInlines(row.Cells[i].Inlines, resolve)
+				}
+			}
+			out = append(out, block)
+		}
+		return out
+	}
+	for i := range d.Sections {
+		d.Sections[i].Blocks = mapBlocks(d.Sections[i].Blocks)
+	}
+	for i := range d.Footnotes {
+		d.Footnotes[i].Blocks = mapBlocks(d.Footnotes[i].Blocks)
+	}
+}
+
+func mapInlines(inlines []Inline, resolve func(string) (string, bool)) []Inline {
+	out := inlines[:0]
+	for _, inline := range inlines {
+		if inline.Kind == InlineImage {
+			src, ok := resolve(inline.Src)
+			if !ok {
+				if inline.Alt != "" {
+					out = append(out, Inline{Kind: Text, Text: inline.Alt})
+				}
+				continue
+			}
+			inline.Src = src
+		}
+		inline.Children = mapInlines(inline.Children, resolve)
+		out = append(out, inline)
+	}
+	return out
+}
+
+// linkTo wraps children in a link to href. Unsafe targets such as
+// javascript: URLs are dropped and only the children are kept, so neither
+// the JSON model nor the rendered HTML carries them.
+func linkTo(href string, children []Inline) []Inline {
+	href, ok := safeLink(href)
+	if !ok {
+		return children
+	}
+	return []Inline{{Kind: Link, Href: href, Children: children}}
+}
+
+// builder assigns sequential IDs while a converter walks the source.
+type builder struct {
+	doc       *Document
+	section   *Section
+	blocks    int
+	footnotes map[string]int
+	// target receives blocks; it points into a footnote while one is
+	// being converted.
+	target *[]Block
+	inNote bool
+}
+
+func newBuilder() *builder {
+	return &builder{doc: &Document{}, footnotes: make(map[string]int)}
+}
+
+// startSection begins a new section unless the current one is still
+// empty, in which case it only picks up a missing title.
+func (b *builder) startSection(title string) {
+	if b.section != nil && len(b.section.Blocks) == 0 {
+		if b.section.Title == "" {
+			b.section.Title = title
+		}
+		return
+	}
+	b.doc.Sections = append(b.doc.Sections, Section{
+		ID:     "s" + strconv.Itoa(len(b.doc.Sections)+1),
+		Title:  title,
+		Blocks: []Block{},
+	})
+	b.section = &b.doc.Sections[len(b.doc.Sections)-1]
+	b.target = &b.section.Blocks
+}
+
+func (b *builder) add(block Block) {
+	if block.Kind == Paragraph || block.Kind == Quote || block.Kind == Heading || block.Kind == ListItem {
+		block.Inlines = trimInlines(block.Inlines)
+		if len(block.Inlines) == 0 {
+			return
+		}
+	}
+	if block.Kind == Table && len(block.Rows) == 0 {
+		return
+	}
+	if block.Kind == Heading && block.Level <= 2 && !b.inNote {
+		b.startSection(strings.TrimSpace(plainText(block.Inlines)))
+	}
+	if b.target == nil {
+		b.startSection("")
+	}
+	b.blocks++
+	block.ID = "b" + strconv.Itoa(b.blocks)
+	*b.target = append(*b.target, block)
+}
+
+// footnote switches output to the footnote with the given source id and
+// returns a func that switches back.
+func (b *builder) footnote(sourceID, label string) func() {
+	id := FootnoteID(sourceID)
+	index, ok := b.footnotes[id]
+	if !ok {
+		b.doc.Footnotes = append(b.doc.Footnotes, Footnote{ID: id, Label: label, Blocks: []Block{}})
+		index = len(b.doc.Footnotes) - 1
+		b.footnotes[id] = index
+	}
+	b.target = &b.doc.Footnotes[index].Blocks
+	b.inNote = true
+	return func() {
+		b.inNote = false
+		b.target = nil
+		if b.section != nil {
+			b.target = &b.section.Blocks
+		}
+	}
+}
+
+func (b *builder) finish() *Document {
+	if b.doc.Sections == nil {
+		b.doc.Sections = []Section{}
+	}
+	return b.doc
+}
+
+// FootnoteID turns a source identifier into the ID used in the model.
+func FootnoteID(sourceID string) string {
+	sourceID = strings.TrimPrefix(strings.TrimSpace(sourceID), "#")
+	return "fn-" + strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			return r
+		}
+		return '_'
+	}, sourceID)
+}
+
+// trimInlines drops leading and trailing whitespace from a run of inlines.
+func trimInlines(inlines []Inline) []Inline {
+	for len(inlines) > 0 && inlines[0].Kind == Text {
+		inlines[0].Text = strings.TrimLeft(inlines[0].Text, " \t\n")
+		if inlines[0].Text != "" {
+			break
+		}
+		inlines = inlines[1:]
+	}
+	for len(inlines) > 0 && (inlines[len(inlines)-1].Kind == Text || inlines[len(inlines)-1].Kind == Break) {
+		last := &inlines[len(inlines)-1]
+		if last.Kind == Text {
+			last.Text = strings.TrimRight(last.Text, " \t\n")
+			if last.Text != "" {
+				break
+			}
+		}
+		inlines = inlines[:len(inlines)-1]
+	}
+	return inlines
+}
+
+// joinLines trims the paragraphs of a table cell and puts line breaks
+// between those that are not empty.
+func joinLines(lines [][]Inline) []Inline {
+	var out []Inline
+	for _, line := range lines {
+		line = trimInlines(line)
+		if len(line) == 0 {
+			continue
+		}
+		if len(out) > 0 {
+			out = append(out, Inline{Kind: Break})
+		}
+		out = append(out, line...)
+	}
+	return out
+}
+
+// plainText flattens inlines into their visible text.
+func plainText(inlines []Inline) string {
+	var buf strings.Builder
+	for _, inline := range inlines {
+		switch inline.Kind {
+		case Text, CodeSpan:
+			buf.WriteString(inline.Text)
+		case Break:
+			buf.WriteByte(' ')
+		case InlineImage:
+			buf.WriteString(inline.Alt)
+		case FootnoteRef:
+		default:
+			buf.WriteString(plainText(inline.Children))
+		}
+	}
+	return buf.String()
+}
@@ -0,0 +1,399 @@
+package document
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/EROQIN/relite-reader/backend/internal/fb2"
+)
+
+func TestFromMarkdown(t *testing.T) {
+	doc := FromMarkdown(strings.Join([]string{
+		"# The Book",
+		"",
+		"Intro with *emphasis*, **strong**, `code` and a [link](https://example.com \"t\").",
+		"",
+		"Chapter One",
+		"-----------",
+		"",
+		"A note here.[^1] Line one  ",
+		"line two.",
+		"",
+		"> quoted",
+		"> text",
+		"",
+		"- first",
+		"  - nested",
+		"1. ordered",
+		"",
+		"```go",
+		"fmt.Println(\"*not emphasis*\")",
+		"```",
+		"",
+		"![Map](map.png)",
+		"",
+		"***",
+		"",
+		"[^1]: The footnote text.",
+	}, "\n"))
+	if doc.Title != "The Book" {
+		t.Fatalf("unexpected title %q", doc.Title)
+	}
+	if len(doc.Sections) != 2 || doc.Sections[1].Title != "Chapter One" || doc.Sections[1].ID != "s2" {
+		t.Fatalf("unexpected sections %+v", doc.Sections)
+	}
+	intro := doc.Sections[0].Blocks[1]
+	kinds := []InlineKind{}
+	for _, inline := range intro.Inlines {
+		kinds = append(kinds, inline.Kind)
+	}
+	want := []InlineKind{Text, Emphasis, Text, Strong, Text, CodeSpan, Text, Link, Text}
+	if len(kinds) != len(want) {
+		t.Fatalf("unexpected inlines %+v", intro.Inlines)
+	}
+	for i := range want {
+		if kinds[i] != want[i] {
+			t.Fatalf("inline %d: expected %s, got %s", i, want[i], kinds[i])
+		}
+	}
+	if intro.Inlines[7].Href != "https://example.com" {
+		t.Fatalf("unexpected link %+v", intro.Inlines[7])
+	}
+	blocks := doc.Sections[1].Blocks
+	var got []string
+	for _, block := range blocks {
+		got = append(got, string(block.Kind))
+	}
+	if strings.Join(got, ",") != "heading,paragraph,quote,list_item,list_item,list_item,code,image,rule" {
+		t.Fatalf("unexpected blocks %v", got)
+	}
+	note := blocks[1].Inlines
+	if note[1].Kind != FootnoteRef || note[1].Href != "fn-1" || note[3].Kind != Break {
+		t.Fatalf("unexpected note paragraph %+v", note)
+	}
+	if blocks[4].Level != 2 || blocks[5].Ordered != true || blocks[3].Ordered {
+		t.Fatalf("unexpected list items %+v", blocks[3:6])
+	}
+	if blocks[6].Text != "fmt.Println(\"*not emphasis*\")" || blocks[7].Src != "map.png" {
+		t.Fatalf("unexpected code or image %+v %+v", blocks[6], blocks[7])
+	}
+	if len(doc.Footnotes) != 1 || doc.Footnotes[0].ID != "fn-1" || plainText(doc.Footnotes[0].Blocks[0].Inlines) != "The footnote text." {
+		t.Fatalf("unexpected footnotes %+v", doc.Footnotes)
+	}
+}
+
+func TestMarkdownBlockIDsAreStable(t *testing.T) {
+	source := "# A\n\nOne.\n\n## B\n\nTwo.\n"
+	first, _ := json.Marshal(FromMarkdown(source))
+	second, _ := json.Marshal(FromMarkdown(source))
+	if string(first) != string(second) {
+		t.Fatalf("conversion is not deterministic")
+	}
+	doc := FromMarkdown(source)
+	if block, ok := doc.Block("b4"); !ok || plainText(block.Inlines) != "Two." {
+		t.Fatalf("unexpected block b4 %+v", block)
+	}
+}
+
+func TestFromHTML(t *testing.T) {
+	page := []byte(`<!DOCTYPE html><html lang="fr"><head><meta charset="windows-1252"><title>Caf` + "\xe9" + `</title>
+<script>alert(1)</script></head><body>
+<h1>Part  One</h1>
+<div>Loose <i>text</i><br>after break</div>
+<p>See<a epub:type="noteref" href="#n1">1</a> and <a href="javascript:alert(1)">this</a>.</p>
+<blockquote><p>Quoted</p></blockquote>
+<ul><li>One<ul><li>Two</li></ul></li></ul>
+<p><img src="data:image/png;base64,AAAA" alt="dot"></p>
+<aside epub:type="footnote" id="n1"><p>Note body.</p></aside>
+</body></html>`)
+	doc, err := FromHTML(page)
+	if err != nil {
+		t.Fatalf("convert: %v", err)
+	}
+	if doc.Title != "Café" || doc.Language != "fr" {
+		t.Fatalf("unexpected metadata %q %q", doc.Title, doc.Language)
+	}
+	if len(doc.Sections) != 1 || doc.Sections[0].Title != "Part One" {
+		t.Fatalf("unexpected sections %+v", doc.Sections)
+	}
+	var got []string
+	for _, block := range doc.Sections[0].Blocks {
+		got = append(got, string(block.Kind))
+	}
+	if strings.Join(got, ",") != "heading,paragraph,paragraph,quote,list_item,list_item,image" {
+		t.Fatalf("unexpected blocks %v", got)
+	}
+	blocks := doc.Sections[0].Blocks
+	if blocks[1].Inlines[1].Kind != Emphasis || blocks[1].Inlines[2].Kind != Break {
+		t.Fatalf("unexpected inlines %+v", blocks[1].Inlines)
+	}
+	if ref := blocks[2].Inlines[1]; ref.Kind != FootnoteRef || ref.Href != "fn-n1" {
+		t.Fatalf("unexpected note ref %+v", ref)
+	}
+	if blocks[5].Level != 2 {
+		t.Fatalf("expected nested list item, got %+v", blocks[5])
+	}
+	if len(doc.Footnotes) != 1 || plainText(doc.Footnotes[0].Blocks[0].Inlines) != "Note body." {
+		t.Fatalf("unexpected footnotes %+v", doc.Footnotes)
+	}
+}
+
+func TestFromFB2(t *testing.T) {
+	source := `<?xml version="1.0" encoding="utf-8"?>
+<FictionBook xmlns="http://www.gribuser.ru/xml/fictionbook/2.0" xmlns:l="http://www.w3.org/1999/xlink">
+<description><title-info><book-title>Sample</book-title><lang>ru</lang></title-info></description>
+<body>
+<title><p>Sample</p></title>
+<section id="c1"><title><p>Chapter 1</p><p>The Start</p></title>
+<epigraph><p>Wise words</p><text-author>Someone</text-author></epigraph>
+<p>Hello <emphasis>world</emphasis><a l:href="#n1" type="note">[1]</a>.</p>
+<image l:href="#pic.png"/>
+<section><title><p>Part A</p></title><p>Inner</p></section>
+<poem><stanza><v>Line one</v><v>Line two</v></stanza></poem>
+</section>
+</body>
+<body name="notes"><section id="n1"><title><p>1</p></title><p>Footnote text</p></section></body>
+</FictionBook>`
+	book, err := fb2.Parse(strings.NewReader(source))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	doc := FromFB2(book)
+	if doc.Title != "Sample" || doc.Language != "ru" {
+		t.Fatalf("unexpected metadata %+v", doc)
+	}
+	if len(doc.Sections) != 3 || doc.Sections[1].Title != "Chapter 1 The Start" || doc.Sections[2].Title != "Part A" {
+		t.Fatalf("unexpected sections %+v", doc.Sections)
+	}
+	chapter := doc.Sections[1].Blocks
+	var got []string
+	for _, block := range chapter {
+		got = append(got, string(block.Kind))
+	}
+	if strings.Join(got, ",") != "heading,quote,quote,paragraph,image" {
+		t.Fatalf("unexpected blocks %v", got)
+	}
+	if chapter[4].Src != "#pic.png" {
+		t.Fatalf("unexpected image %+v", chapter[4])
+	}
+	if ref := chapter[3].Inlines[2]; ref.Kind != FootnoteRef || ref.Href != "fn-n1" || ref.Text != "[1]" {
+		t.Fatalf("unexpected note ref %+v", chapter[3].Inlines)
+	}
+	stanza := doc.Sections[2].Blocks[2]
+	if stanza.Kind != Quote || len(stanza.Inlines) != 3 || stanza.Inlines[1].Kind != Break {
+		t.Fatalf("unexpected stanza %+v", stanza)
+	}
+	if len(doc.Footnotes) != 1 || doc.Footnotes[0].Label != "1" {
+		t.Fatalf("unexpected footnotes %+v", doc.Footnotes)
+	}
+}
+
+func TestRenderHTML(t *testing.T) {
+	doc := FromMarkdown("# T\n\n[bad](javascript:alert(1)) [ok](https://x.test) <b>raw</b>\n\n- a\n  - b\n- c\n\n![x](https://tracker.test/p.png)\n![y](data:image/png;base64,AA)\n")
+	doc.MapImages(func(src string) (string, bool) {
+		return src, strings.HasPrefix(src, "data:")
+	})
+	out := string(RenderHTML(doc))
+	for _, want := range []string{
+		`<h1 id="b1">T</h1>`,
+		`bad <a href="https://x.test">ok</a> &lt;b&gt;raw&lt;/b&gt;`,
+		`<ul><li id="b3">a<ul><li id="b4">b</li></ul></li><li id="b5">c</li></ul>`,
+		`<img src="data:image/png;base64,AA" alt="y"/>`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("expected %q in %s", want, out)
+		}
+	}
+	if strings.Contains(out, "javascript") || strings.Contains(out, "tracker.test") {
+		t.Fatalf("unsafe output %s", out)
+	}
+}
+
+func TestRenderHTMLTable(t *testing.T) {
+	doc := &Document{Sections: []Section{{ID: "s1", Blocks: []Block{{
+		ID:   "b1",
+		Kind: Table,
+		Rows: []TableRow{
+			{Header: true, Cells: []TableCell{{Inlines: []Inline{{Kind: Text, Text: "Name"}}}, {Inlines: []Inline{{Kind: Text, Text: "<Qty>"}}}}},
+			{Cells: []TableCell{{Inlines: []Inline{{Kind: Text, Text: "Tea"}, {Kind: Break}, {Kind: Text, Text: "green"}}}, {}}},
+		},
+	}}}}}
+	want := `<table id="b1"><thead><tr><th>Name</th><th>&lt;Qty&gt;</th></tr></thead><tbody><tr><td>Tea<br/>green</td><td></td></tr></tbody></table>`
+	if out := string(RenderHTML(doc)); !strings.Contains(out, want) {
+		t.Fatalf("expected %q in %s", want, out)
+	}
+}
+
+func buildZip(t *testing.T, files map[string]string) *zip.Reader {
+	t.Helper()
+	var buf bytes.Buffer
+	writer := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := writer.Create(name)
+		if err != nil {
+			t.Fatalf("zip: %v", err)
+		}
+		_, _ = w.Write([]byte(content))
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("zip: %v", err)
+	}
+	archive, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("zip: %v", err)
+	}
+	return archive
+}
+
+func blockKinds(blocks []Block) string {
+	var kinds []string
+	for _, block := range blocks {
+		kinds = append(kinds, string(block.Kind))
+	}
+	return strings.Join(kinds, ",")
+}
+
+// tableCells lists a table's rows separated by ";" and cells by "|", with
+// header rows starred and line breaks shown as "/".
+func tableCells(block Block) string {
+	var rows []string
+	for _, row := range block.Rows {
+		var cells []string
+		for _, cell := range row.Cells {
+			var text strings.Builder
+			for _, inline := range cell.Inlines {
+				if inline.Kind == Break {
+					text.WriteString("/")
+					continue
+				}
+				text.WriteString(plainText([]Inline{inline}))
+			}
+			cells = append(cells, text.String())
+		}
+		line := strings.Join(cells, "|")
+		if row.Header {
+			line = "*" + line
+		}
+		rows = append(rows, line)
+	}
+	return strings.Join(rows, ";")
+}
+
+func TestFromDOCX(t *testing.T) {
+	const w = `xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"`
+	archive := buildZip(t, map[string]string{
+		"word/document.xml": `<w:document ` + w + ` xmlns:a="http://schemas.openxmlformats.org/drawingml/2006/main" xmlns:wp="http://schemas.openxmlformats.org/drawingml/2006/wordprocessingDrawing"><w:body>
+<w:p><w:pPr><w:pStyle w:val="Heading1"/></w:pPr><w:r><w:t>Design</w:t></w:r></w:p>
+<w:p><w:r><w:t xml:space="preserve">Plain </w:t></w:r><w:r><w:rPr><w:b/><w:i/></w:rPr><w:t>bold italic</w:t></w:r><w:r><w:rPr><w:b w:val="0"/></w:rPr><w:t xml:space="preserve"> off</w:t></w:r><w:r><w:footnoteReference w:id="2"/></w:r>
+<w:hyperlink r:id="rLink"><w:r><w:t>site</w:t></w:r></w:hyperlink></w:p>
+<w:p><w:pPr><w:numPr><w:ilvl w:val="0"/><w:numId w:val="1"/></w:numPr></w:pPr><w:r><w:t>bullet</w:t></w:r></w:p>
+<w:p><w:pPr><w:numPr><w:ilvl w:val="1"/><w:numId w:val="1"/></w:numPr></w:pPr><w:r><w:t>step</w:t></w:r></w:p>
+<w:tbl><w:tr><w:trPr><w:tblHeader/></w:trPr><w:tc><w:p><w:r><w:t>A</w:t></w:r></w:p></w:tc><w:tc><w:p><w:r><w:t>B</w:t></w:r></w:p></w:tc></w:tr><w:tr><w:tc><w:p><w:r><w:t>1</w:t></w:r></w:p><w:p><w:r><w:t>one</w:t></w:r></w:p></w:tc><w:tc><w:p/></w:tc></w:tr></w:tbl>
+<w:p><w:r><w:drawing><wp:inline><wp:docPr id="1" name="Picture" descr="Diagram"/><a:graphic><a:graphicData><a:blip r:embed="rImg"/></a:graphicData></a:graphic></wp:inline></w:drawing></w:r></w:p>
+<w:p><w:pPr><w:pStyle w:val="Quote"/></w:pPr><w:r><w:t>Cited</w:t></w:r></w:p>
+</w:body></w:document>`,
+		"word/_rels/document.xml.rels": `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rLink" Type="hyperlink" Target="https://example.com" TargetMode="External"/>
+<Relationship Id="rImg" Type="image" Target="media/image1.png"/>
+</Relationships>`,
+		"word/styles.xml": `<w:styles ` + w + `>
+<w:style w:type="paragraph" w:styleId="Heading1"><w:name w:val="heading 1"/></w:style>
+<w:style w:type="paragraph" w:styleId="Quote"><w:name w:val="Quote"/></w:style>
+</w:styles>`,
+		"word/numbering.xml": `<w:numbering ` + w + `>
+<w:abstractNum w:abstractNumId="0"><w:lvl w:ilvl="0"><w:numFmt w:val="bullet"/></w:lvl><w:lvl w:ilvl="1"><w:numFmt w:val="decimal"/></w:lvl></w:abstractNum>
+<w:num w:numId="1"><w:abstractNumId w:val="0"/></w:num>
+</w:numbering>`,
+		"word/footnotes.xml": `<w:footnotes ` + w + `>
+<w:footnote w:type="separator" w:id="0"><w:p/></w:footnote>
+<w:footnote w:id="2"><w:p><w:r><w:footnoteRef/></w:r><w:r><w:t xml:space="preserve"> Footnote body</w:t></w:r></w:p></w:footnote>
+</w:footnotes>`,
+		"docProps/core.xml": `<cp:coreProperties xmlns:cp="http://schemas.openxmlformats.org/package/2006/metadata/core-properties" xmlns:dc="http://purl.org/dc/elements/1.1/"><dc:title>Spec</dc:title><dc:creator>Ada</dc:creator></cp:coreProperties>`,
+	})
+	doc, err := FromDOCX(archive)
+	if err != nil {
+		t.Fatalf("convert: %v", err)
+	}
+	if doc.Title != "Spec" || len(doc.Authors) != 1 || doc.Authors[0] != "Ada" {
+		t.Fatalf("unexpected metadata %+v", doc)
+	}
+	blocks := doc.Sections[0].Blocks
+	if got := blockKinds(blocks); got != "heading,paragraph,list_item,list_item,table,image,quote" {
+		t.Fatalf("unexpected blocks %s", got)
+	}
+	para := blocks[1].Inlines
+	if para[1].Kind != Strong || para[1].Children[0].Kind != Emphasis || para[2].Kind != Text {
+		t.Fatalf("unexpected formatting %+v", para)
+	}
+	if para[3].Kind != FootnoteRef || para[3].Href != "fn-footnote-2" || para[3].Text != "1" {
+		t.Fatalf("unexpected footnote ref %+v", para[3])
+	}
+	if para[4].Kind != Link || para[4].Href != "https://example.com" {
+		t.Fatalf("unexpected link %+v", para[4])
+	}
+	if blocks[2].Ordered || !blocks[3].Ordered || blocks[3].Level != 2 {
+		t.Fatalf("unexpected list items %+v", blocks[2:4])
+	}
+	if got := tableCells(blocks[4]); got != "*A|B;1/one|" {
+		t.Fatalf("unexpected table %s", got)
+	}
+	if blocks[5].Src != "word/media/image1.png" || blocks[5].Alt != "Diagram" {
+		t.Fatalf("unexpected image %+v", blocks[5])
+	}
+	if len(doc.Footnotes) != 1 || plainText(doc.Footnotes[0].Blocks[0].Inlines) != "Footnote body" {
+		t.Fatalf("unexpected footnotes %+v", doc.Footnotes)
+	}
+}
+
+func TestFromODT(t *testing.T) {
+	const ns = `xmlns:office="urn:oasis:names:tc:opendocument:xmlns:office:1.0" xmlns:text="urn:oasis:names:tc:opendocument:xmlns:text:1.0" xmlns:style="urn:oasis:names:tc:opendocument:xmlns:style:1.0" xmlns:fo="urn:oasis:names:tc:opendocument:xmlns:xsl-fo-compatible:1.0" xmlns:table="urn:oasis:names:tc:opendocument:xmlns:table:1.0" xmlns:draw="urn:oasis:names:tc:opendocument:xmlns:drawing:1.0" xmlns:xlink="http://www.w3.org/1999/xlink" xmlns:svg="urn:oasis:names:tc:opendocument:xmlns:svg-compatible:1.0"`
+	archive := buildZip(t, map[string]string{
+		"content.xml": `<office:document-content ` + ns + `>
+<office:automatic-styles>
+<style:style style:name="T1" style:family="text"><style:text-properties fo:font-weight="bold"/></style:style>
+<text:list-style style:name="L1"><text:list-level-style-number text:level="1"/><text:list-level-style-bullet text:level="2"/></text:list-style>
+</office:automatic-styles>
+<office:body><office:text>
+<text:h text:outline-level="1">Overview</text:h>
+<text:p>Some <text:span text:style-name="T1">bold</text:span>  text<text:note text:id="ftn1" text:note-class="footnote"><text:note-citation>1</text:note-citation><text:note-body><text:p>ODT note</text:p></text:note-body></text:note>.</text:p>
+<text:list text:style-name="L1"><text:list-item><text:p>First</text:p><text:list><text:list-item><text:p>Sub</text:p></text:list-item></text:list></text:list-item></text:list>
+<table:table><table:table-header-rows><table:table-row><table:table-cell><text:p>X</text:p></table:table-cell><table:table-cell><text:p>Y</text:p></table:table-cell></table:table-row></table:table-header-rows><table:table-row><table:table-cell><text:p>2</text:p></table:table-cell><table:table-cell><text:p><text:span text:style-name="T1">two</text:span></text:p></table:table-cell></table:table-row></table:table>
+<text:p><draw:frame><draw:image xlink:href="Pictures/chart.png"/><svg:desc>Chart</svg:desc></draw:frame></text:p>
+<text:p text:style-name="Quotations">Said</text:p>
+</office:text></office:body></office:document-content>`,
+		"styles.xml": `<office:document-styles ` + ns + `><office:styles>
+<style:style style:name="Quotations" style:display-name="Quotations" style:family="paragraph"/>
+</office:styles></office:document-styles>`,
+		"meta.xml": `<office:document-meta xmlns:office="urn:oasis:names:tc:opendocument:xmlns:office:1.0" xmlns:meta="urn:oasis:names:tc:opendocument:xmlns:meta:1.0" xmlns:dc="http://purl.org/dc/elements/1.1/"><office:meta><dc:title>Notes</dc:title><meta:initial-creator>Lin</meta:initial-creator><dc:language>en</dc:language></office:meta></office:document-meta>`,
+	})
+	doc, err := FromODT(archive)
+	if err != nil {
+		t.Fatalf("convert: %v", err)
+	}
+	if doc.Title != "Notes" || doc.Language != "en" || len(doc.Authors) != 1 || doc.Authors[0] != "Lin" {
+		t.Fatalf("unexpected metadata %+v", doc)
+	}
+	blocks := doc.Sections[0].Blocks
+	if got := blockKinds(blocks); got != "heading,paragraph,list_item,list_item,table,image,quote" {
+		t.Fatalf("unexpected blocks %s", got)
+	}
+	para := blocks[1].Inlines
+	if para[1].Kind != Strong || para[3].Kind != FootnoteRef || para[3].Href != "fn-ftn1" {
+		t.Fatalf("unexpected paragraph %+v", para)
+	}
+	if !blocks[2].Ordered || blocks[3].Ordered || blocks[3].Level != 2 {
+		t.Fatalf("unexpected list items %+v", blocks[2:4])
+	}
+	if got := tableCells(blocks[4]); got != "*X|Y;2|two" || blocks[4].Rows[1].Cells[1].Inlines[0].Kind != Strong {
+		t.Fatalf("unexpected table %s %+v", got, blocks[4])
+	}
+	if blocks[5].Src != "Pictures/chart.png" || blocks[5].Alt != "Chart" {
+		t.Fatalf("unexpected image %+v", blocks[5])
+	}
+	if len(doc.Footnotes) != 1 || doc.Footnotes[0].Label != "1" || plainText(doc.Footnotes[0].Blocks[0].Inlines) != "ODT note" {
+		t.Fatalf("unexpected footnotes %+v", doc.Footnotes)
+	}
+}
@@ -0,0 +1,513 @@
+package document
+
+import (
+	"archive/zip"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// FromDOCX converts a Word document. Headings come from the paragraph
+// styles (built-in "heading N" names or an outline level), lists from
+// numbering.xml, and footnotes and endnotes are numbered in the order they
+// are referenced. Image sources are package paths such as
+// "word/media/image1.png".
+func FromDOCX(archive *zip.Reader) (*Document, error) {
+	body, err := readPart(archive, "word/document.xml")
+	if err != nil {
+		return nil, err
+	}
+	if body == nil || body.find("body") == nil {
+		return nil, ErrInvalid
+	}
+	c := &docxConverter{
+		b:       newBuilder(),
+		archive: archive,
+		styles:  make(map[string]docxStyle),
+		lists:   make(map[string]map[string]bool),
+	}
+	if err := c.loadStyles(); err != nil {
+		return nil, err
+	}
+	if err := c.loadNumbering(); err != nil {
+		return nil, err
+	}
+	if c.rels, err = readRels(archive, "word/document.xml"); err != nil {
+		return nil, err
+	}
+	if err := readCoreProperties(archive, c.b.doc); err != nil {
+		return nil, err
+	}
+	c.blocks(body.find("body"))
+	if err := c.notes(); err != nil {
+		return nil, err
+	}
+	doc := c.b.finish()
+	if doc.Title == "" {
+		doc.Title = firstHeading(doc)
+	}
+	return doc, nil
+}
+
+type docxStyle struct {
+	name    string
+	basedOn string
+	outline int
+	run     runProps
+}
+
+type runProps struct {
+	bold, italic, strike, sup, sub bool
+}
+
+type docxNoteRef struct {
+	kind string
+	id   string
+}
+
+type docxConverter struct {
+	b       *builder
+	archive *zip.Reader
+	styles  map[string]docxStyle
+	// lists maps numId to the ilvl values that use a bullet.
+	lists map[string]map[string]bool
+	rels  map[string]relationship
+	// noteRefs lists footnote and endnote references in document order.
+	noteRefs []docxNoteRef
+	labels   map[string]string
+}
+
+func (c *docxConverter) loadStyles() error {
+	root, err := readPart(c.archive, "word/styles.xml")
+	if err != nil || root == nil {
+		return err
+	}
+	for _, node := range root.Children {
+		if node.Name != "style" {
+			continue
+		}
+		style := docxStyle{outline: -1}
+		for _, part := range node.Children {
+			switch part.Name {
+			case "name":
+				style.name = strings.ToLower(part.attr("val"))
+			case "basedOn":
+				style.basedOn = part.attr("val")
+			case "pPr":
+				if level := part.child("outlineLvl"); level != nil {
+					if n, err := strconv.Atoi(level.attr("val")); err == nil {
+						style.outline = n
+					}
+				}
+			case "rPr":
+				style.run = readRunProps(part, runProps{})
+			}
+		}
+		c.styles[node.attr("styleId")] = style
+	}
+	return nil
+}
+
+func (c *docxConverter) loadNumbering() error {
+	root, err := readPart(c.archive, "word/numbering.xml")
+	if err != nil || root == nil {
+		return err
+	}
+	bullets := make(map[string]map[string]bool)
+	for _, node := range root.Children {
+		if node.Name != "abstractNum" {
+			continue
+		}
+		levels := make(map[string]bool)
+		for _, level := range node.Children {
+			if level.Name == "lvl" {
+				format := level.child("numFmt")
+				levels[level.attr("ilvl")] = format != nil && format.attr("val") == "bullet"
+			}
+		}
+		bullets[node.attr("abstractNumId")] = levels
+	}
+	for _, node := range root.Children {
+		if node.Name != "num" {
+			continue
+		}
+		if abstract := node.child("abstractNumId"); abstract != nil {
+			c.lists[node.attr("numId")] = bullets[abstract.attr("val")]
+		}
+	}
+	return nil
+}
+
+// headingLevel resolves a paragraph style to a heading level, or 0.
+func (c *docxConverter) headingLevel(styleID string) int {
+	for i := 0; i < 8 && styleID != ""; i++ {
+		style, ok := c.styles[styleID]
+		if !ok {
+			break
+		}
+		if style.name == "title" {
+			return 1
+		}
+		if level, ok := strings.CutPrefix(style.name, "heading "); ok {
+			if n, err := strconv.Atoi(level); err == nil && n >= 1 {
+				return min(n, 6)
+			}
+		}
+		if style.outline >= 0 && style.outline < 9 {
+			return min(style.outline+1, 6)
+		}
+		styleID = style.basedOn
+	}
+	return 0
+}
+
+func (c *docxConverter) styleName(styleID string) string {
+	return c.styles[styleID].name
+}
+
+func (c *docxConverter) blocks(container *xmlNode) {
+	for _, node := range container.Children {
+		switch node.Name {
+		case "p":
+			c.paragraph(node)
+		case "tbl":
+			c.table(node)
+		case "sdt":
+			if content := node.child("sdtContent"); content != nil {
+				c.blocks(content)
+			}
+		case "customXml", "ins", "smartTag":
+			c.blocks(node)
+		}
+	}
+}
+
+func (c *docxConverter) paragraph(node *xmlNode) {
+	block := Block{Kind: Paragraph}
+	if props := node.child("pPr"); props != nil {
+		styleID := ""
+		if style := props.child("pStyle"); style != nil {
+			styleID = style.attr("val")
+		}
+		name := c.styleName(styleID)
+		switch level := c.headingLevel(styleID); {
+		case level > 0:
+			block.Kind, block.Level = Heading, level
+		case strings.Contains(name, "quote"):
+			block.Kind = Quote
+		case name == "html preformatted" || strings.Contains(name, "code"):
+			block.Kind = Code
+		}
+		if level := props.child("outlineLvl"); level != nil && block.Kind == Paragraph {
+			if n, err := strconv.Atoi(level.attr("val")); err == nil && n < 9 {
+				block.Kind, block.Level = Heading, min(n+1, 6)
+			}
+		}
+		if numbering := props.child("numPr"); numbering != nil && block.Kind == Paragraph {
+			numID, ilvl := "", "0"
+			if id := numbering.child("numId"); id != nil {
+				numID = id.attr("val")
+			}
+			if level := numbering.child("ilvl"); level != nil {
+				ilvl = level.attr("val")
+			}
+			if levels, ok := c.lists[numID]; ok && numID != "0" {
+				depth, _ := strconv.Atoi(ilvl)
+				block.Kind, block.Level, block.Ordered = ListItem, depth+1, !levels[ilvl]
+			}
+		}
+	}
+	block.Inlines = c.inlines(node)
+	if block.Kind == Code {
+		block.Text, block.Inlines = strings.TrimRight(plainText(block.Inlines), " "), nil
+		if block.Text == "" {
+			return
+		}
+	}
+	c.b.add(imageOnly(block))
+}
+
+// table keeps the rows and cells of a w:tbl. Rows marked tblHeader repeat
+// on every page in Word, so they are the header rows.
+func (c *docxConverter) table(node *xmlNode) {
+	table := Block{Kind: Table}
+	for _, row := range node.Children {
+		if row.Name != "tr" {
+			continue
+		}
+		var out TableRow
+		if props := row.child("trPr"); props != nil {
+			if header := props.child("tblHeader"); header != nil {
+				out.Header = header.attr("val") != "0" && header.attr("val") != "false"
+			}
+		}
+		for _, cell := range row.Children {
+			if cell.Name != "tc" {
+				continue
+			}
+			var lines [][]Inline
+			for _, p := range paragraphs(cell) {
+				lines = append(lines, c.inlines(p))
+			}
+			out.Cells = append(out.Cells, TableCell{Inlines: joinLines(lines)})
+		}
+		if len(out.Cells) > 0 {
+			table.Rows = append(table.Rows, out)
+		}
+	}
+	c.b.add(table)
+}
+
+func paragraphs(node *xmlNode) []*xmlNode {
+	var out []*xmlNode
+	for _, child := range node.Children {
+		switch child.Name {
+		case "p":
+			out = append(out, child)
+		case "sdt", "sdtContent", "customXml", "tbl", "tr", "tc":
+			out = append(out, paragraphs(child)...)
+		}
+	}
+	return out
+}
+
+func (c *docxConverter) inlines(node *xmlNode) []Inline {
+	var out []Inline
+	for _, child := range node.Children {
+		switch child.Name {
+		case "r":
+			out = append(out, c.run(child)...)
+		case "hyperlink":
+			children := c.inlines(child)
+			target := ""
+			if rel, ok := c.rels[child.attr("id")]; ok && rel.external {
+				target = rel.target
+			}
+			out = append(out, linkTo(target, children)...)
+		case "ins", "smartTag", "fldSimple", "customXml", "sdt", "sdtContent":
+			out = append(out, c.inlines(child)...)
+		}
+	}
+	return out
+}
+
+func (c *docxConverter) run(node *xmlNode) []Inline {
+	props := runProps{}
+	if rPr := node.child("rPr"); rPr != nil {
+		if style := rPr.child("rStyle"); style != nil {
+			props = c.styles[style.attr("val")].run
+		}
+		props = readRunProps(rPr, props)
+	}
+	var out []Inline
+	var text strings.Builder
+	flush := func() {
+		if text.Len() > 0 {
+			out = append(out, Inline{Kind: Text, Text: text.String()})
+			text.Reset()
+		}
+	}
+	for _, child := range node.Children {
+		switch child.Name {
+		case "t":
+			text.WriteString(child.text())
+		case "tab":
+			text.WriteByte(' ')
+		case "noBreakHyphen":
+			text.WriteByte('-')
+		case "br", "cr":
+			if child.attr("type") == "page" || child.attr("type") == "column" {
+				continue
+			}
+			flush()
+			out = append(out, Inline{Kind: Break})
+		case "drawing", "pict", "object":
+			flush()
+			if image, ok := c.image(child); ok {
+				out = append(out, image)
+			}
+		case "footnoteReference", "endnoteReference":
+			flush()
+			kind := strings.TrimSuffix(child.Name, "Reference")
+			out = append(out, Inline{Kind: FootnoteRef, Href: FootnoteID(kind + "-" + child.attr("id")), Text: c.noteLabel(kind, child.attr("id"))})
+		}
+	}
+	flush()
+	return wrapRun(out, props)
+}
+
+// image resolves a DrawingML blip or a VML imagedata reference.
+func (c *docxConverter) image(node *xmlNode) (Inline, bool) {
+	id := ""
+	if blip := node.find("blip"); blip != nil {
+		id = blip.attr("embed")
+	} else if data := node.find("imagedata"); data != nil {
+		id = data.attr("id")
+	}
+	rel, ok := c.rels[id]
+	if !ok || rel.external {
+		return Inline{}, false
+	}
+	alt := ""
+	if props := node.find("docPr"); props != nil {
+		alt = firstNonEmpty(props.attr("descr"), props.attr("title"))
+	}
+	return Inline{Kind: InlineImage, Src: rel.target, Alt: alt}, true
+}
+
+func (c *docxConverter) noteLabel(kind, id string) string {
+	key := kind + "-" + id
+	if c.labels == nil {
+		c.labels = make(map[string]string)
+	}
+	if label, ok := c.labels[key]; ok {
+		return label
+	}
+	c.noteRefs = append(c.noteRefs, docxNoteRef{kind: kind, id: id})
+	label := strconv.Itoa(len(c.labels) + 1)
+	c.labels[key] = label
+	return label
+}
+
+// notes converts the referenced footnotes and endnotes.
+func (c *docxConverter) notes() error {
+	if len(c.noteRefs) == 0 {
+		return nil
+	}
+	parts := make(map[string]map[string]*xmlNode)
+	for _, kind := range []string{"footnote", "endnote"} {
+		root, err := readPart(c.archive, "word/"+kind+"s.xml")
+		if err != nil {
+			return err
+		}
+		parts[kind] = make(map[string]*xmlNode)
+		if root == nil {
+			continue
+		}
+		for _, note := range root.Children {
+			if note.Name == kind {
+				parts[kind][note.attr("id")] = note
+			}
+		}
+	}
+	documentRels := c.rels
+	defer func() { c.rels = documentRels }()
+	// Notes may reference further notes; the slice grows while we walk it.
+	for i := 0; i < len(c.noteRefs); i++ {
+		ref := c.noteRefs[i]
+		note, ok := parts[ref.kind][ref.id]
+		if !ok {
+			continue
+		}
+		rels, err := readRels(c.archive, "word/"+ref.kind+"s.xml")
+		if err != nil {
+			return err
+		}
+		c.rels = rels
+		done := c.b.footnote(ref.kind+"-"+ref.id, c.labels[ref.kind+"-"+ref.id])
+		c.blocks(note)
+		done()
+	}
+	return nil
+}
+
+func readRunProps(rPr *xmlNode, props runProps) runProps {
+	on := func(node *xmlNode) bool {
+		switch node.attr("val") {
+		case "0", "false", "off", "none":
+			return false
+		}
+		return true
+	}
+	for _, child := range rPr.Children {
+		switch child.Name {
+		case "b":
+			props.bold = on(child)
+		case "i":
+			props.italic = on(child)
+		case "strike", "dstrike":
+			props.strike = on(child)
+		case "vertAlign":
+			props.sup = child.attr("val") == "superscript"
+			props.sub = child.attr("val") == "subscript"
+		}
+	}
+	return props
+}
+
+// wrapRun nests a run's inlines in its formatting, innermost first.
+func wrapRun(inlines []Inline, props runProps) []Inline {
+	if len(inlines) == 0 {
+		return nil
+	}
+	layers := []struct {
+		on   bool
+		kind InlineKind
+	}{
+		{props.sub, Sub}, {props.sup, Sup}, {props.strike, Strike}, {props.italic, Emphasis}, {props.bold, Strong},
+	}
+	for _, layer := range layers {
+		if layer.on {
+			inlines = []Inline{{Kind: layer.kind, Children: inlines}}
+		}
+	}
+	return inlines
+}
+
+type relationship struct {
+	target   string
+	external bool
+}
+
+// readRels loads the relationships of a package part, resolving internal
+// targets to package paths.
+func readRels(archive *zip.Reader, part string) (map[string]relationship, error) {
+	dir, file := path.Split(part)
+	root, err := readPart(archive, dir+"_rels/"+file+".rels")
+	if err != nil {
+		return nil, err
+	}
+	rels := make(map[string]relationship)
+	if root == nil {
+		return rels, nil
+	}
+	for _, node := range root.Children {
+		if node.Name != "Relationship" {
+			continue
+		}
+		rel := relationship{target: node.attr("Target"), external: node.attr("TargetMode") == "External"}
+		if !rel.external {
+			if strings.HasPrefix(rel.target, "/") {
+				rel.target = strings.TrimPrefix(rel.target, "/")
+			} else {
+				rel.target = path.Join(dir, rel.target)
+			}
+		}
+		rels[node.attr("Id")] = rel
+	}
+	return rels, nil
+}
+
+// readCoreProperties fills title, authors and language from
+// docProps/core.xml.
+func readCoreProperties(archive *zip.Reader, doc *Document) error {
+	root, err := readPart(archive, "docProps/core.xml")
+	if err != nil || root == nil {
+		return err
+	}
+	for _, node := range root.Children {
+		value := strings.TrimSpace(node.text())
+		if value == "" {
+			continue
+		}
+		switch node.Name {
+		case "title":
+			doc.Title = value
+		case "creator":
+			doc.Authors = append(doc.Authors, value)
+		case "language":
+			doc.Language = value
+		}
+	}
+	return nil
+}
@@ -0,0 +1,211 @@
+package document
+
+import (
+	"strings"
+
+	"github.com/EROQIN/relite-reader/backend/internal/fb2"
+)
+
+// FromFB2 converts a parsed FictionBook. The first body is the main text;
+// bodies named "notes" or "comments" provide the footnotes. Images keep
+// their "#binary-id" references for the caller to map.
+func FromFB2(book *fb2.Document) *Document {
+	c := &fb2Converter{b: newBuilder()}
+	c.b.doc.Title = book.Title
+	c.b.doc.Authors = book.Authors
+	c.b.doc.Language = book.Language
+	main := true
+	for _, body := range book.Bodies {
+		switch body.Attr("name") {
+		case "notes", "comments", "footnotes":
+			c.notes(body)
+			continue
+		}
+		if !main {
+			// Extra unnamed bodies are appendices of the main text.
+			c.b.startSection(titleText(body))
+		}
+		main = false
+		c.section(body, 0)
+	}
+	return c.b.finish()
+}
+
+type fb2Converter struct {
+	b *builder
+}
+
+// section converts a body or section. Top-level sections and their direct
+// children each start a section in the model; deeper ones only add a
+// heading.
+func (c *fb2Converter) section(node *fb2.Node, depth int) {
+	if depth > 0 && depth <= 2 {
+		c.b.startSection(titleText(node))
+	}
+	for _, child := range node.Children {
+		switch child.Name {
+		case "title":
+			c.b.add(Block{Kind: Heading, Level: min(max(depth, 1), 6), Inlines: c.lines(child)})
+		case "section":
+			c.section(child, depth+1)
+		default:
+			c.block(child, false)
+		}
+	}
+}
+
+// notes turns each section of a notes body into a footnote.
+func (c *fb2Converter) notes(body *fb2.Node) {
+	for _, child := range body.Children {
+		if child.Name != "section" {
+			continue
+		}
+		id := child.Attr("id")
+		if id == "" {
+			continue
+		}
+		done := c.b.footnote(id, strings.TrimSpace(titleText(child)))
+		for _, part := range child.Children {
+			if part.Name != "title" {
+				c.block(part, false)
+			}
+		}
+		done()
+	}
+}
+
+func (c *fb2Converter) block(node *fb2.Node, quote bool) {
+	kind := Paragraph
+	if quote {
+		kind = Quote
+	}
+	switch node.Name {
+	case "p", "text-author":
+		c.b.add(Block{Kind: kind, Inlines: c.inlines(node.Children)})
+	case "subtitle":
+		c.b.add(Block{Kind: Heading, Level: 3, Inlines: c.inlines(node.Children)})
+	case "image":
+		c.b.add(Block{Kind: Image, Src: node.Attr("href"), Alt: node.Attr("alt")})
+	case "empty-line":
+	case "epigraph", "cite", "annotation":
+		for _, child := range node.Children {
+			c.block(child, true)
+		}
+	case "poem":
+		for _, child := range node.Children {
+			switch child.Name {
+			case "title":
+				c.b.add(Block{Kind: Heading, Level: 3, Inlines: c.lines(child)})
+			case "stanza":
+				c.b.add(Block{Kind: Quote, Inlines: c.lines(child)})
+			default:
+				c.block(child, true)
+			}
+		}
+	case "table":
+		for _, row := range node.Children {
+			var cells []Inline
+			for _, cell := range row.Children {
+				if cell.Name == "" {
+					continue
+				}
+				if len(cells) > 0 {
+					cells = append(cells, Inline{Kind: Text, Text: " | "})
+				}
+				cells = append(cells, trimInlines(c.inlines(cell.Children))...)
+			}
+			c.b.add(Block{Kind: kind, Inlines: cells})
+		}
+	case "section":
+		for _, child := range node.Children {
+			c.block(child, quote)
+		}
+	}
+}
+
+// lines joins the paragraphs or verses of a title or stanza with line
+// breaks.
+func (c *fb2Converter) lines(node *fb2.Node) []Inline {
+	var out []Inline
+	for _, child := range node.Children {
+		if child.Name != "p" && child.Name != "v" {
+			continue
+		}
+		line := trimInlines(c.inlines(child.Children))
+		if len(line) == 0 {
+			continue
+		}
+		if len(out) > 0 {
+			out = append(out, Inline{Kind: Break})
+		}
+		out = append(out, line...)
+	}
+	return out
+}
+
+func (c *fb2Converter) inlines(nodes []*fb2.Node) []Inline {
+	var out []Inline
+	for _, node := range nodes {
+		if node.Name == "" {
+			out = append(out, Inline{Kind: Text, Text: collapseSpace(node.Text)})
+			continue
+		}
+		wrap := func(kind InlineKind) {
+			if children := c.inlines(node.Children); len(children) > 0 {
+				out = append(out, Inline{Kind: kind, Children: children})
+			}
+		}
+		switch node.Name {
+		case "emphasis":
+			wrap(Emphasis)
+		case "strong":
+			wrap(Strong)
+		case "strikethrough":
+			wrap(Strike)
+		case "sub":
+			wrap(Sub)
+		case "sup":
+			wrap(Sup)
+		case "code":
+			out = append(out, Inline{Kind: CodeSpan, Text: nodeText(node)})
+		case "image":
+			out = append(out, Inline{Kind: InlineImage, Src: node.Attr("href"), Alt: node.Attr("alt")})
+		case "a":
+			href := node.Attr("href")
+			if node.Attr("type") == "note" {
+				out = append(out, Inline{Kind: FootnoteRef, Href: FootnoteID(href), Text: strings.TrimSpace(collapseSpace(nodeText(node)))})
+				continue
+			}
+			out = append(out, linkTo(href, c.inlines(node.Children))...)
+		default:
+			out = append(out, c.inlines(node.Children)...)
+		}
+	}
+	return out
+}
+
+func titleText(node *fb2.Node) string {
+	for _, child := range node.Children {
+		if child.Name == "title" {
+			var parts []string
+			for _, p := range child.Children {
+				if text := strings.TrimSpace(collapseSpace(nodeText(p))); text != "" {
+					parts = append(parts, text)
+				}
+			}
+			return strings.Join(parts, " ")
+		}
+	}
+	return ""
+}
+
+func nodeText(node *fb2.Node) string {
+	if node.Name == "" {
+		return node.Text
+	}
+	var buf strings.Builder
+	for _, child := range node.Children {
+		buf.WriteString(nodeText(child))
+	}
+	return buf.String()
+}
@@ -0,0 +1,377 @@
+package document
+
+import (
+	"bytes"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/charset"
+
+	"github.com/EROQIN/relite-reader/backend/internal/xhtml"
+)
+
+// FromHTML converts an HTML or XHTML page. Legacy encodings are detected
+// from the BOM and <meta charset> the way a browser would.
+func FromHTML(data []byte) (*Document, error) {
+	if !bytes.HasPrefix(bytes.TrimLeft(data, "\ufeff \t\r\n"), []byte("<?xml")) {
+		enc, _, _ := charset.DetermineEncoding(data, "text/html")
+		if decoded, err := enc.NewDecoder().Bytes(data); err == nil {
+			data = decoded
+		}
+	}
+	root, err := xhtml.Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	c := &htmlConverter{b: newBuilder()}
+	if node := findElement(root, "html"); node != nil {
+		c.b.doc.Language = strings.TrimSpace(firstNonEmpty(htmlAttr(node, "lang"), htmlAttr(node, "xml:lang")))
+	}
+	if node := findElement(root, "title"); node != nil {
+		c.b.doc.Title = collapseSpace(textOf(node))
+	}
+	body := findElement(root, "body")
+	if body == nil {
+		body = root
+	}
+	c.blocks(body, blockContext{})
+	doc := c.b.finish()
+	if doc.Title == "" {
+		doc.Title = firstHeading(doc)
+	}
+	return doc, nil
+}
+
+type htmlConverter struct {
+	b *builder
+}
+
+type blockContext struct {
+	quote   bool
+	list    int
+	ordered bool
+	// notes is set inside an endnotes container, whose lists hold notes.
+	notes bool
+}
+
+// kind is the block kind for a run of inline content.
+func (ctx blockContext) kind() BlockKind {
+	switch {
+	case ctx.list > 0:
+		return ListItem
+	case ctx.quote:
+		return Quote
+	}
+	return Paragraph
+}
+
+var skippedElements = map[string]bool{
+	"head": true, "script": true, "style": true, "noscript": true, "template": true,
+	"iframe": true, "object": true, "embed": true, "form": true, "button": true,
+	"input": true, "select": true, "textarea": true, "nav": true,
+}
+
+var blockElements = map[string]bool{
+	"address": true, "article": true, "aside": true, "blockquote": true, "body": true,
+	"dd": true, "details": true, "div": true, "dl": true, "dt": true, "figcaption": true,
+	"figure": true, "footer": true, "h1": true, "h2": true, "h3": true, "h4": true,
+	"h5": true, "h6": true, "header": true, "hr": true, "li": true, "main": true,
+	"ol": true, "p": true, "pre": true, "section": true, "summary": true, "table": true,
+	"tbody": true, "td": true, "tfoot": true, "th": true, "thead": true, "tr": true,
+	"ul": true,
+}
+
+// blocks walks a container, gathering runs of inline content into blocks
+// and recursing into nested block elements.
+func (c *htmlConverter) blocks(node *html.Node, ctx blockContext) {
+	var pending []Inline
+	flush := func() {
+		if len(pending) > 0 {
+			c.b.add(Block{Kind: ctx.kind(), Level: ctx.list, Ordered: ctx.ordered, Inlines: pending})
+			pending = nil
+		}
+	}
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type == html.ElementNode && skippedElements[child.Data] {
+			continue
+		}
+		if child.Type != html.ElementNode || !c.isBlock(child) {
+			pending = append(pending, c.inlines(child)...)
+			continue
+		}
+		flush()
+		c.block(child, ctx)
+	}
+	flush()
+}
+
+// isBlock reports whether an element breaks the inline flow. A lone image
+// counts as a block so it becomes a figure of its own.
+func (c *htmlConverter) isBlock(node *html.Node) bool {
+	return blockElements[node.Data] || (node.Data == "img" && onlyChild(node))
+}
+
+func (c *htmlConverter) block(node *html.Node, ctx blockContext) {
+	if isNoteList(node) {
+		ctx.notes = true
+	}
+	if (node.Data == "ol" || node.Data == "ul") && ctx.notes {
+		// An endnotes list: every item with an id is a note.
+		for item := node.FirstChild; item != nil; item = item.NextSibling {
+			if item.Type == html.ElementNode && htmlAttr(item, "id") != "" {
+				done := c.b.footnote(htmlAttr(item, "id"), "")
+				c.blocks(item, blockContext{})
+				done()
+			}
+		}
+		return
+	}
+	if id := htmlAttr(node, "id"); id != "" && isNote(node) {
+		done := c.b.footnote(id, noteLabel(node))
+		c.blocks(node, blockContext{})
+		done()
+		return
+	}
+	switch node.Data {
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		c.b.add(Block{Kind: Heading, Level: int(node.Data[1] - '0'), Inlines: c.children(node)})
+	case "p", "dt", "dd", "figcaption", "summary", "th", "td":
+		if ctx.list > 0 || ctx.quote {
+			c.blocks(node, ctx)
+			return
+		}
+		c.blocks(node, blockContext{})
+	case "pre":
+		c.b.add(Block{Kind: Code, Text: strings.Trim(textOf(node), "\n")})
+	case "hr":
+		c.b.add(Block{Kind: Rule})
+	case "img":
+		c.b.add(Block{Kind: Image, Src: htmlAttr(node, "src"), Alt: htmlAttr(node, "alt")})
+	case "blockquote":
+		ctx.quote = true
+		c.blocks(node, ctx)
+	case "ul", "ol":
+		inner := blockContext{quote: ctx.quote, list: ctx.list + 1, ordered: node.Data == "ol"}
+		for item := node.FirstChild; item != nil; item = item.NextSibling {
+			if item.Type == html.ElementNode && item.Data == "li" {
+				c.blocks(item, inner)
+			}
+		}
+	case "tr":
+		var cells []Inline
+		for cell := node.FirstChild; cell != nil; cell = cell.NextSibling {
+			if cell.Type != html.ElementNode {
+				continue
+			}
+			if len(cells) > 0 {
+				cells = append(cells, Inline{Kind: Text, Text: " | "})
+			}
+			cells = append(cells, trimInlines(c.children(cell))...)
+		}
+		c.b.add(Block{Kind: ctx.kind(), Level: ctx.list, Ordered: ctx.ordered, Inlines: cells})
+	default:
+		c.blocks(node, ctx)
+	}
+}
+
+func (c *htmlConverter) children(node *html.Node) []Inline {
+	var out []Inline
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		out = append(out, c.inlines(child)...)
+	}
+	return out
+}
+
+func (c *htmlConverter) inlines(node *html.Node) []Inline {
+	switch node.Type {
+	case html.TextNode:
+		return []Inline{{Kind: Text, Text: collapseSpace(node.Data)}}
+	case html.ElementNode:
+	default:
+		return nil
+	}
+	if skippedElements[node.Data] {
+		return nil
+	}
+	wrap := func(kind InlineKind) []Inline {
+		children := c.children(node)
+		if len(children) == 0 {
+			return nil
+		}
+		return []Inline{{Kind: kind, Children: children}}
+	}
+	switch node.Data {
+	case "em", "i", "cite", "dfn", "var":
+		return wrap(Emphasis)
+	case "strong", "b":
+		return wrap(Strong)
+	case "s", "del", "strike":
+		return wrap(Strike)
+	case "sub":
+		return wrap(Sub)
+	case "sup":
+		return wrap(Sup)
+	case "code", "kbd", "samp", "tt":
+		return []Inline{{Kind: CodeSpan, Text: textOf(node)}}
+	case "br":
+		return []Inline{{Kind: Break}}
+	case "img":
+		return []Inline{{Kind: InlineImage, Src: htmlAttr(node, "src"), Alt: htmlAttr(node, "alt")}}
+	case "a":
+		href := htmlAttr(node, "href")
+		if isNoteRef(node) && strings.HasPrefix(href, "#") {
+			return []Inline{{Kind: FootnoteRef, Href: FootnoteID(href), Text: collapseSpace(strings.TrimSpace(textOf(node)))}}
+		}
+		return linkTo(href, c.children(node))
+	}
+	if c.isBlock(node) {
+		// Block elements nested inside inline ones are flattened.
+		return append(c.children(node), Inline{Kind: Break})
+	}
+	return c.children(node)
+}
+
+// semantics returns the epub:type and role values of an element.
+func semantics(node *html.Node) []string {
+	return strings.Fields(htmlAttr(node, "epub:type") + " " + htmlAttr(node, "role"))
+}
+
+func isNote(node *html.Node) bool {
+	for _, value := range semantics(node) {
+		switch value {
+		case "footnote", "endnote", "rearnote", "note", "doc-footnote", "doc-endnote":
+			return true
+		}
+	}
+	return false
+}
+
+func isNoteList(node *html.Node) bool {
+	for _, value := range semantics(node) {
+		switch value {
+		case "footnotes", "endnotes", "rearnotes", "doc-endnotes":
+			return true
+		}
+	}
+	return false
+}
+
+func isNoteRef(node *html.Node) bool {
+	for _, value := range semantics(node) {
+		if value == "noteref" || value == "doc-noteref" {
+			return true
+		}
+	}
+	return false
+}
+
+// noteLabel takes the label from a leading heading in a note, if any.
+func noteLabel(node *html.Node) string {
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type != html.ElementNode {
+			continue
+		}
+		if len(child.Data) == 2 && child.Data[0] == 'h' {
+			return collapseSpace(strings.TrimSpace(textOf(child)))
+		}
+		return ""
+	}
+	return ""
+}
+
+func onlyChild(node *html.Node) bool {
+	parent := node.Parent
+	if parent == nil {
+		return false
+	}
+	for sibling := parent.FirstChild; sibling != nil; sibling = sibling.NextSibling {
+		if sibling == node {
+			continue
+		}
+		if sibling.Type == html.ElementNode || (sibling.Type == html.TextNode && strings.TrimSpace(sibling.Data) != "") {
+			return false
+		}
+	}
+	return true
+}
+
+// htmlAttr matches an attribute by its qualified name, so "epub:type"
+// finds both the namespaced XHTML form and the literal HTML5 one.
+func htmlAttr(node *html.Node, key string) string {
+	space, local := "", key
+	if i := strings.IndexByte(key, ':'); i >= 0 {
+		space, local = key[:i], key[i+1:]
+	}
+	for _, a := range node.Attr {
+		if (a.Namespace == space && a.Key == local) || (a.Namespace == "" && a.Key == key) {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func findElement(node *html.Node, name string) *html.Node {
+	if node.Type == html.ElementNode && node.Data == name {
+		return node
+	}
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		if found := findElement(child, name); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+func textOf(node *html.Node) string {
+	if node.Type == html.TextNode {
+		return node.Data
+	}
+	var buf strings.Builder
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		buf.WriteString(textOf(child))
+	}
+	return buf.String()
+}
+
+// collapseSpace folds runs of whitespace into single spaces, keeping a
+// leading or trailing space so adjacent inlines stay separated.
+func collapseSpace(s string) string {
+	var buf strings.Builder
+	space := false
+	for _, r := range s {
+		switch r {
+		case ' ', '\t', '\n', '\r', '\f':
+			space = true
+			continue
+		}
+		if space {
+			buf.WriteByte(' ')
+			space = false
+		}
+		buf.WriteRune(r)
+	}
+	if space {
+		buf.WriteByte(' ')
+	}
+	return buf.String()
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+func firstHeading(doc *Document) string {
+	for _, section := range doc.Sections {
+		for _, block := range section.Blocks {
+			if block.Kind == Heading {
+				return strings.TrimSpace(plainText(block.Inlines))
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,429 @@
+package document
+
+import (
+	"regexp"
+	"strings"
+)
+
+// FromMarkdown converts the commonly used subset of CommonMark: ATX and
+// setext headings, fenced and indented code, block quotes, lists, rules,
+// images, links, emphasis and footnotes in the [^label] style. Raw HTML is
+// kept as text.
+func FromMarkdown(text string) *Document {
+	text = strings.TrimPrefix(text, "\ufeff")
+	text = strings.ReplaceAll(strings.ReplaceAll(text, "\r\n", "\n"), "\r", "\n")
+	p := &markdownParser{b: newBuilder()}
+	p.parse(strings.Split(text, "\n"), false)
+	doc := p.b.finish()
+	for _, section := range doc.Sections {
+		for _, block := range section.Blocks {
+			if block.Kind == Heading && block.Level == 1 {
+				doc.Title = strings.TrimSpace(plainText(block.Inlines))
+				return doc
+			}
+		}
+	}
+	doc.Title = firstHeading(doc)
+	return doc
+}
+
+type markdownParser struct {
+	b *builder
+}
+
+var (
+	atxHeading   = regexp.MustCompile(`^ {0,3}(#{1,6})(?:[ \t]+(.*?))?(?:[ \t]+#+)?[ \t]*$`)
+	setextLine   = regexp.MustCompile(`^ {0,3}(=+|-+)[ \t]*$`)
+	ruleLine     = regexp.MustCompile(`^ {0,3}(?:(?:\*[ \t]*){3,}|(?:-[ \t]*){3,}|(?:_[ \t]*){3,})$`)
+	fenceLine    = regexp.MustCompile("^( {0,3})(`{3,}|~{3,})")
+	listItem     = regexp.MustCompile(`^([ \t]*)([-*+]|\d{1,9}[.)])[ \t]+(.*)$`)
+	footnoteLine = regexp.MustCompile(`^ {0,3}\[\^([^\]\s]+)\]:[ \t]*(.*)$`)
+	quoteLine    = regexp.MustCompile(`^ {0,3}>[ \t]?(.*)$`)
+)
+
+// parse converts a run of lines. Inside a block quote every text block
+// becomes a quote.
+func (p *markdownParser) parse(lines []string, quote bool) {
+	var paragraph []string
+	flush := func() {
+		if len(paragraph) == 0 {
+			return
+		}
+		kind := Paragraph
+		if quote {
+			kind = Quote
+		}
+		p.b.add(Block{Kind: kind, Inlines: parseInlines(strings.Join(paragraph, "\n"))})
+		paragraph = nil
+	}
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		if strings.TrimSpace(line) == "" {
+			flush()
+			continue
+		}
+		if m := fenceLine.FindStringSubmatch(line); m != nil {
+			flush()
+			fence := m[2]
+			var code []string
+			for i++; i < len(lines); i++ {
+				if strings.HasPrefix(strings.TrimLeft(lines[i], " "), fence) && strings.Trim(lines[i], " "+fence[:1]) == "" {
+					break
+				}
+				code = append(code, strings.TrimPrefix(lines[i], m[1]))
+			}
+			p.b.add(Block{Kind: Code, Text: strings.Join(code, "\n")})
+			continue
+		}
+		if len(paragraph) > 0 {
+			if m := setextLine.FindStringSubmatch(line); m != nil {
+				level := 1
+				if m[1][0] == '-' {
+					level = 2
+				}
+				p.b.add(Block{Kind: Heading, Level: level, Inlines: parseInlines(strings.Join(paragraph, "\n"))})
+				paragraph = nil
+				continue
+			}
+		}
+		if m := atxHeading.FindStringSubmatch(line); m != nil {
+			flush()
+			p.b.add(Block{Kind: Heading, Level: len(m[1]), Inlines: parseInlines(m[2])})
+			continue
+		}
+		if ruleLine.MatchString(line) {
+			flush()
+			p.b.add(Block{Kind: Rule})
+			continue
+		}
+		if quoteLine.MatchString(line) {
+			flush()
+			var inner []string
+			for ; i < len(lines); i++ {
+				m := quoteLine.FindStringSubmatch(lines[i])
+				if m == nil {
+					// Lazy continuation lines belong to the quote.
+					if strings.TrimSpace(lines[i]) == "" {
+						break
+					}
+					inner = append(inner, lines[i])
+					continue
+				}
+				inner = append(inner, m[1])
+			}
+			p.parse(inner, true)
+			continue
+		}
+		if m := footnoteLine.FindStringSubmatch(line); m != nil {
+			flush()
+			body := []string{m[2]}
+			for i+1 < len(lines) && (isIndented(lines[i+1]) || strings.TrimSpace(lines[i+1]) == "" && i+2 < len(lines) && isIndented(lines[i+2])) {
+				i++
+				body = append(body, strings.TrimSpace(lines[i]))
+			}
+			done := p.b.footnote(m[1], m[1])
+			p.parse(body, false)
+			done()
+			continue
+		}
+		if m := listItem.FindStringSubmatch(line); m != nil && (len(paragraph) == 0 || m[1] == "") {
+			flush()
+			item := []string{m[3]}
+			for i+1 < len(lines) {
+				next := lines[i+1]
+				if strings.TrimSpace(next) == "" || listItem.MatchString(next) || fenceLine.MatchString(next) ||
+					atxHeading.MatchString(next) || ruleLine.MatchString(next) || quoteLine.MatchString(next) {
+					break
+				}
+				item = append(item, strings.TrimSpace(next))
+				i++
+			}
+			p.b.add(Block{
+				Kind:    ListItem,
+				Level:   indentWidth(m[1])/2 + 1,
+				Ordered: m[2][0] >= '0' && m[2][0] <= '9',
+				Inlines: parseInlines(strings.Join(item, "\n")),
+			})
+			continue
+		}
+		if len(paragraph) == 0 && isIndented(line) && !quote {
+			var code []string
+			for ; i < len(lines) && (isIndented(lines[i]) || strings.TrimSpace(lines[i]) == ""); i++ {
+				code = append(code, trimIndent(lines[i]))
+			}
+			i--
+			p.b.add(Block{Kind: Code, Text: strings.TrimRight(strings.Join(code, "\n"), "\n")})
+			continue
+		}
+		if len(paragraph) == 0 {
+			if src, alt, ok := standaloneImage(line); ok {
+				p.b.add(Block{Kind: Image, Src: src, Alt: alt})
+				continue
+			}
+		}
+		paragraph = append(paragraph, line)
+	}
+	flush()
+}
+
+func indentWidth(s string) int {
+	width := 0
+	for _, r := range s {
+		if r == '\t' {
+			width += 4 - width%4
+			continue
+		}
+		width++
+	}
+	return width
+}
+
+func isIndented(line string) bool {
+	return strings.HasPrefix(line, "    ") || strings.HasPrefix(line, "\t")
+}
+
+func trimIndent(line string) string {
+	if strings.HasPrefix(line, "\t") {
+		return line[1:]
+	}
+	return strings.TrimPrefix(line, "    ")
+}
+
+var imageLine = regexp.MustCompile(`^ {0,3}!\[([^\]]*)\]\(\s*<?([^)\s>]+)>?(?:\s+"[^"]*")?\s*\)[ \t]*$`)
+
+func standaloneImage(line string) (src, alt string, ok bool) {
+	m := imageLine.FindStringSubmatch(line)
+	if m == nil {
+		return "", "", false
+	}
+	return m[2], m[1], true
+}
+
+// parseInlines converts a paragraph's text into inline runs.
+func parseInlines(text string) []Inline {
+	var out []Inline
+	var buf strings.Builder
+	flush := func() {
+		if buf.Len() > 0 {
+			out = append(out, Inline{Kind: Text, Text: buf.String()})
+			buf.Reset()
+		}
+	}
+	emit := func(inlines ...Inline) {
+		flush()
+		out = append(out, inlines...)
+	}
+	for i := 0; i < len(text); {
+		c := text[i]
+		switch {
+		case c == '\\' && i+1 < len(text):
+			next := text[i+1]
+			if next == '\n' {
+				emit(Inline{Kind: Break})
+				i += 2
+				continue
+			}
+			if strings.IndexByte("\\`*_{}[]()#+-.!~<>|\"'", next) >= 0 {
+				buf.WriteByte(next)
+				i += 2
+				continue
+			}
+		case c == '\n':
+			// Two trailing spaces make a hard break.
+			if strings.HasSuffix(buf.String(), "  ") {
+				trimmed := strings.TrimRight(buf.String(), " ")
+				buf.Reset()
+				buf.WriteString(trimmed)
+				emit(Inline{Kind: Break})
+			} else {
+				buf.WriteByte(' ')
+			}
+			i++
+			continue
+		case c == '`':
+			run := countRun(text[i:], '`')
+			if end := strings.Index(text[i+run:], strings.Repeat("`", run)); end >= 0 {
+				code := text[i+run : i+run+end]
+				if len(code) > 2 && code[0] == ' ' && code[len(code)-1] == ' ' {
+					code = code[1 : len(code)-1]
+				}
+				emit(Inline{Kind: CodeSpan, Text: strings.ReplaceAll(code, "\n", " ")})
+				i += run + end + run
+				continue
+			}
+			buf.WriteString(text[i : i+run])
+			i += run
+			continue
+		case c == '!' && strings.HasPrefix(text[i+1:], "["):
+			if label, dest, n, ok := linkAt(text[i+1:]); ok {
+				emit(Inline{Kind: InlineImage, Src: dest, Alt: label})
+				i += 1 + n
+				continue
+			}
+		case c == '[':
+			if strings.HasPrefix(text[i:], "[^") {
+				if end := strings.IndexByte(text[i:], ']'); end > 2 && !strings.ContainsAny(text[i+2:i+end], " \t\n") {
+					label := text[i+2 : i+end]
+					emit(Inline{Kind: FootnoteRef, Href: FootnoteID(label), Text: label})
+					i += end + 1
+					continue
+				}
+			}
+			if label, dest, n, ok := linkAt(text[i:]); ok {
+				emit(linkTo(dest, parseInlines(label))...)
+				i += n
+				continue
+			}
+		case c == '<':
+			if end := strings.IndexByte(text[i:], '>'); end > 0 {
+				target := text[i+1 : i+end]
+				if isAutolink(target) {
+					href := target
+					if strings.Contains(target, "@") && !strings.Contains(target, ":") {
+						href = "mailto:" + target
+					}
+					emit(linkTo(href, []Inline{{Kind: Text, Text: target}})...)
+					i += end + 1
+					continue
+				}
+			}
+		case c == '*' || c == '_' || c == '~':
+			if inline, n, ok := delimited(text[i:]); ok {
+				emit(inline)
+				i += n
+				continue
+			}
+			run := countRun(text[i:], c)
+			buf.WriteString(text[i : i+run])
+			i += run
+			continue
+		}
+		buf.WriteByte(c)
+		i++
+	}
+	flush()
+	return out
+}
+
+func countRun(s string, c byte) int {
+	n := 0
+	for n < len(s) && s[n] == c {
+		n++
+	}
+	return n
+}
+
+// delimited parses an emphasis, strong or strikethrough span at the start
+// of s and reports how many bytes it consumed.
+func delimited(s string) (Inline, int, bool) {
+	c := s[0]
+	run := countRun(s, c)
+	var kind InlineKind
+	var width int
+	switch {
+	case c == '~' && run >= 2:
+		kind, width = Strike, 2
+	case c == '~':
+		return Inline{}, 0, false
+	case run >= 2:
+		kind, width = Strong, 2
+	default:
+		kind, width = Emphasis, 1
+	}
+	delim := s[:width]
+	rest := s[width:]
+	// An opening delimiter must be followed by a non-space character.
+	if rest == "" || rest[0] == ' ' || rest[0] == '\n' {
+		return Inline{}, 0, false
+	}
+	for from := 0; from < len(rest); {
+		end := strings.Index(rest[from:], delim)
+		if end < 0 {
+			break
+		}
+		end += from
+		closes := end > 0 && rest[end-1] != ' ' && rest[end-1] != '\n'
+		// Intraword underscores do not close emphasis.
+		if c == '_' && end+width < len(rest) && isWordByte(rest[end+width]) {
+			closes = false
+		}
+		// Skip a strong delimiter when looking for an emphasis close.
+		if width == 1 && end+1 < len(rest) && rest[end+1] == c {
+			closes = false
+			end++
+		}
+		if closes {
+			return Inline{Kind: kind, Children: parseInlines(rest[:end])}, width + end + width, true
+		}
+		from = end + 1
+	}
+	return Inline{}, 0, false
+}
+
+func isWordByte(b byte) bool {
+	return b == '_' || b >= '0' && b <= '9' || b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' || b >= 0x80
+}
+
+// linkAt parses "[label](destination "title")" at the start of s.
+func linkAt(s string) (label, dest string, n int, ok bool) {
+	depth := 0
+	end := -1
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '[':
+			depth++
+		case ']':
+			depth--
+			if depth == 0 {
+				end = i
+			}
+		}
+		if end >= 0 {
+			break
+		}
+	}
+	if end < 0 || end+1 >= len(s) || s[end+1] != '(' {
+		return "", "", 0, false
+	}
+	// Destinations may contain balanced parentheses.
+	close, parens := -1, 0
+	for i := end + 2; i < len(s) && close < 0; i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '(':
+			parens++
+		case ')':
+			if parens == 0 {
+				close = i - end - 1
+			}
+			parens--
+		}
+	}
+	if close < 0 {
+		return "", "", 0, false
+	}
+	inner := strings.TrimSpace(s[end+2 : end+1+close])
+	if strings.HasPrefix(inner, "<") {
+		if gt := strings.IndexByte(inner, '>'); gt > 0 {
+			inner = inner[1:gt]
+		}
+	} else if fields := strings.Fields(inner); len(fields) > 0 {
+		inner = fields[0]
+	}
+	return s[1:end], inner, end + 1 + close + 1, true
+}
+
+func isAutolink(target string) bool {
+	if strings.ContainsAny(target, " \t\n<") {
+		return false
+	}
+	lower := strings.ToLower(target)
+	if strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://") || strings.HasPrefix(lower, "mailto:") {
+		return true
+	}
+	at := strings.IndexByte(target, '@')
+	return at > 0 && strings.Contains(target[at:], ".")
+}
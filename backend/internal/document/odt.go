@@ -0,0 +1,345 @@
+package document
+
+import (
+	"archive/zip"
+	"strconv"
+	"strings"
+)
+
+// FromODT converts an OpenDocument text file. Formatting comes from the
+// automatic and common styles, list kinds from the list styles, and notes
+// are collected in citation order. Image sources are package paths such
+// as "Pictures/1000.png".
+func FromODT(archive *zip.Reader) (*Document, error) {
+	content, err := readPart(archive, "content.xml")
+	if err != nil {
+		return nil, err
+	}
+	if content == nil {
+		return nil, ErrInvalid
+	}
+	text := content.find("text")
+	if text == nil {
+		return nil, ErrInvalid
+	}
+	c := &odtConverter{
+		b:          newBuilder(),
+		styles:     make(map[string]odtStyle),
+		listStyles: make(map[string]map[int]bool),
+	}
+	styles, err := readPart(archive, "styles.xml")
+	if err != nil {
+		return nil, err
+	}
+	// Common styles first so automatic styles of the same name win.
+	for _, root := range []*xmlNode{styles, content} {
+		if root != nil {
+			c.loadStyles(root)
+		}
+	}
+	meta, err := readPart(archive, "meta.xml")
+	if err != nil {
+		return nil, err
+	}
+	if meta != nil {
+		c.readMeta(meta)
+	}
+	c.blocks(text, odtContext{})
+	for i := 0; i < len(c.notes); i++ {
+		note := c.notes[i]
+		done := c.b.footnote(note.id, note.label)
+		if body := note.node.child("note-body"); body != nil {
+			c.blocks(body, odtContext{})
+		}
+		done()
+	}
+	doc := c.b.finish()
+	if doc.Title == "" {
+		doc.Title = firstHeading(doc)
+	}
+	return doc, nil
+}
+
+type odtStyle struct {
+	name   string
+	parent string
+	run    runProps
+	// listStyle is the list style a paragraph style attaches to.
+	listStyle string
+}
+
+type odtNote struct {
+	id    string
+	label string
+	node  *xmlNode
+}
+
+type odtConverter struct {
+	b      *builder
+	styles map[string]odtStyle
+	// listStyles maps a list style to the levels that are numbered.
+	listStyles map[string]map[int]bool
+	notes      []odtNote
+}
+
+type odtContext struct {
+	list      int
+	listStyle string
+}
+
+func (c *odtConverter) loadStyles(root *xmlNode) {
+	var walk func(*xmlNode)
+	walk = func(node *xmlNode) {
+		for _, child := range node.Children {
+			switch child.Name {
+			case "style":
+				style := odtStyle{
+					name:      strings.ToLower(firstNonEmpty(child.attr("display-name"), child.attr("name"))),
+					parent:    child.attr("parent-style-name"),
+					listStyle: child.attr("list-style-name"),
+				}
+				if props := child.child("text-properties"); props != nil {
+					style.run = runProps{
+						bold:   props.attr("font-weight") == "bold" || props.attr("font-weight") == "700",
+						italic: props.attr("font-style") == "italic",
+						strike: props.attr("text-line-through-style") != "" && props.attr("text-line-through-style") != "none",
+						sup:    strings.HasPrefix(props.attr("text-position"), "super"),
+						sub:    strings.HasPrefix(props.attr("text-position"), "sub"),
+					}
+				}
+				c.styles[child.attr("name")] = style
+			case "list-style":
+				levels := make(map[int]bool)
+				for _, level := range child.Children {
+					if n, err := strconv.Atoi(level.attr("level")); err == nil {
+						levels[n] = level.Name == "list-level-style-number"
+					}
+				}
+				c.listStyles[child.attr("name")] = levels
+			case "styles", "automatic-styles", "master-styles":
+				walk(child)
+			}
+		}
+	}
+	walk(root)
+}
+
+// styleName follows parent styles until one has a recognisable name.
+func (c *odtConverter) styleName(name string) string {
+	for i := 0; i < 8 && name != ""; i++ {
+		style, ok := c.styles[name]
+		if !ok {
+			break
+		}
+		switch {
+		case style.name == "title", strings.HasPrefix(style.name, "heading"),
+			strings.Contains(style.name, "quot"), strings.Contains(style.name, "preformatted"):
+			return style.name
+		}
+		name = style.parent
+	}
+	return ""
+}
+
+func (c *odtConverter) readMeta(root *xmlNode) {
+	meta := root.find("meta")
+	if meta == nil {
+		return
+	}
+	creator := ""
+	for _, node := range meta.Children {
+		value := strings.TrimSpace(node.text())
+		switch node.Name {
+		case "title":
+			c.b.doc.Title = value
+		case "initial-creator":
+			if creator == "" {
+				creator = value
+			}
+		case "creator":
+			creator = firstNonEmpty(creator, value)
+		case "language":
+			c.b.doc.Language = value
+		}
+	}
+	if creator != "" {
+		c.b.doc.Authors = []string{creator}
+	}
+}
+
+func (c *odtConverter) blocks(container *xmlNode, ctx odtContext) {
+	for _, node := range container.Children {
+		switch node.Name {
+		case "h":
+			level, _ := strconv.Atoi(node.attr("outline-level"))
+			c.b.add(Block{Kind: Heading, Level: min(max(level, 1), 6), Inlines: c.inlines(node)})
+		case "p":
+			c.paragraph(node, ctx)
+		case "list":
+			inner := odtContext{list: ctx.list + 1, listStyle: firstNonEmpty(node.attr("style-name"), ctx.listStyle)}
+			for _, item := range node.Children {
+				if item.Name == "list-item" || item.Name == "list-header" {
+					c.blocks(item, inner)
+				}
+			}
+		case "table":
+			c.table(node)
+		case "section":
+			c.blocks(node, ctx)
+		case "frame":
+			if image, ok := c.frame(node); ok {
+				c.b.add(Block{Kind: Image, Src: image.Src, Alt: image.Alt})
+			}
+		}
+	}
+}
+
+func (c *odtConverter) paragraph(node *xmlNode, ctx odtContext) {
+	block := Block{Kind: Paragraph, Inlines: c.inlines(node)}
+	name := c.styleName(node.attr("style-name"))
+	switch {
+	case ctx.list > 0:
+		listStyle := ctx.listStyle
+		if listStyle == "" {
+			listStyle = c.styles[node.attr("style-name")].listStyle
+		}
+		block.Kind, block.Level, block.Ordered = ListItem, ctx.list, c.listStyles[listStyle][ctx.list]
+	case name == "title":
+		block.Kind, block.Level = Heading, 1
+	case strings.HasPrefix(name, "heading"):
+		level, _ := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(name, "heading")))
+		block.Kind, block.Level = Heading, min(max(level, 1), 6)
+	case strings.Contains(name, "quot"):
+		block.Kind = Quote
+	case strings.Contains(name, "preformatted"):
+		block.Kind, block.Text, block.Inlines = Code, plainText(block.Inlines), nil
+		if strings.TrimSpace(block.Text) == "" {
+			return
+		}
+	}
+	c.b.add(imageOnly(block))
+}
+
+// table keeps the rows and cells of a table:table; rows inside
+// table:table-header-rows are the header rows.
+func (c *odtConverter) table(node *xmlNode) {
+	table := Block{Kind: Table}
+	c.tableRows(node, &table, false)
+	c.b.add(table)
+}
+
+func (c *odtConverter) tableRows(node *xmlNode, table *Block, header bool) {
+	for _, row := range node.Children {
+		switch row.Name {
+		case "table-header-rows":
+			c.tableRows(row, table, true)
+			continue
+		case "table-rows", "table-row-group":
+			c.tableRows(row, table, header)
+			continue
+		case "table-row":
+		default:
+			continue
+		}
+		out := TableRow{Header: header}
+		for _, cell := range row.Children {
+			if cell.Name != "table-cell" {
+				continue
+			}
+			var lines [][]Inline
+			for _, p := range cell.Children {
+				if p.Name == "p" || p.Name == "h" {
+					lines = append(lines, c.inlines(p))
+				}
+			}
+			out.Cells = append(out.Cells, TableCell{Inlines: joinLines(lines)})
+		}
+		if len(out.Cells) > 0 {
+			table.Rows = append(table.Rows, out)
+		}
+	}
+}
+
+func (c *odtConverter) inlines(node *xmlNode) []Inline {
+	var out []Inline
+	for _, child := range node.Children {
+		switch child.Name {
+		case "":
+			out = append(out, Inline{Kind: Text, Text: collapseSpace(child.Text)})
+		case "s":
+			count, err := strconv.Atoi(child.attr("c"))
+			if err != nil || count < 1 {
+				count = 1
+			}
+			out = append(out, Inline{Kind: Text, Text: strings.Repeat(" ", min(count, 64))})
+		case "tab":
+			out = append(out, Inline{Kind: Text, Text: " "})
+		case "line-break":
+			out = append(out, Inline{Kind: Break})
+		case "span":
+			out = append(out, wrapRun(c.inlines(child), c.runProps(child.attr("style-name")))...)
+		case "a":
+			href := child.attr("href")
+			children := c.inlines(child)
+			if strings.HasPrefix(href, "#") {
+				out = append(out, children...)
+				continue
+			}
+			out = append(out, linkTo(href, children)...)
+		case "note":
+			label := ""
+			if citation := child.child("note-citation"); citation != nil {
+				label = strings.TrimSpace(citation.text())
+			}
+			if label == "" {
+				label = strconv.Itoa(len(c.notes) + 1)
+			}
+			id := firstNonEmpty(child.attr("id"), "note"+strconv.Itoa(len(c.notes)+1))
+			c.notes = append(c.notes, odtNote{id: id, label: label, node: child})
+			out = append(out, Inline{Kind: FootnoteRef, Href: FootnoteID(id), Text: label})
+		case "frame":
+			if image, ok := c.frame(child); ok {
+				out = append(out, image)
+			}
+		case "meta", "ruby", "ruby-base", "inner-text", "hidden-text":
+			out = append(out, c.inlines(child)...)
+		}
+	}
+	return out
+}
+
+// runProps merges the text properties of a style and its parents.
+func (c *odtConverter) runProps(name string) runProps {
+	var props runProps
+	for i := 0; i < 8 && name != ""; i++ {
+		style, ok := c.styles[name]
+		if !ok {
+			break
+		}
+		props.bold = props.bold || style.run.bold
+		props.italic = props.italic || style.run.italic
+		props.strike = props.strike || style.run.strike
+		props.sup = props.sup || style.run.sup
+		props.sub = props.sub || style.run.sub
+		name = style.parent
+	}
+	return props
+}
+
+func (c *odtConverter) frame(node *xmlNode) (Inline, bool) {
+	image := node.child("image")
+	if image == nil {
+		return Inline{}, false
+	}
+	src := image.attr("href")
+	if src == "" || strings.Contains(src, "://") {
+		return Inline{}, false
+	}
+	alt := ""
+	for _, name := range []string{"desc", "title"} {
+		if child := node.child(name); child != nil && alt == "" {
+			alt = strings.TrimSpace(child.text())
+		}
+	}
+	return Inline{Kind: InlineImage, Src: strings.TrimPrefix(src, "./"), Alt: alt}, true
+}
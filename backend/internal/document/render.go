@@ -0,0 +1,220 @@
+package document
+
+import (
+	"bytes"
+	"html"
+	"strconv"
+	"strings"
+)
+
+// RenderHTML writes the document as a standalone XHTML page. Every
+// section and block carries its ID as an id attribute. Only http, https,
+// mailto and in-page links survive; images must be data: URIs or paths on
+// this server, which is what MapImages is for.
+func RenderHTML(doc *Document) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(`<?xml version="1.0" encoding="utf-8"?>` + "\n")
+	buf.WriteString(`<!DOCTYPE html>` + "\n")
+	buf.WriteString(`<html xmlns="http://www.w3.org/1999/xhtml"`)
+	if doc.Language != "" {
+		buf.WriteString(` lang="` + html.EscapeString(doc.Language) + `"`)
+	}
+	buf.WriteString(`><head><meta charset="utf-8"/><title>` + html.EscapeString(doc.Title) + `</title></head><body>`)
+	for _, section := range doc.Sections {
+		buf.WriteString(`<section id="` + html.EscapeString(section.ID) + `">`)
+		renderBlocks(&buf, section.Blocks)
+		buf.WriteString(`</section>`)
+	}
+	if len(doc.Footnotes) > 0 {
+		buf.WriteString(`<section id="footnotes" role="doc-endnotes">`)
+		for _, note := range doc.Footnotes {
+			buf.WriteString(`<aside id="` + html.EscapeString(note.ID) + `" role="doc-footnote">`)
+			renderBlocks(&buf, note.Blocks)
+			buf.WriteString(`</aside>`)
+		}
+		buf.WriteString(`</section>`)
+	}
+	buf.WriteString(`</body></html>`)
+	return buf.Bytes()
+}
+
+// listLevel is an open list while rendering a run of list items.
+type listLevel struct {
+	tag    string
+	itemOn bool
+}
+
+func renderBlocks(buf *bytes.Buffer, blocks []Block) {
+	var lists []listLevel
+	closeLists := func(depth int) {
+		for len(lists) > depth {
+			top := lists[len(lists)-1]
+			if top.itemOn {
+				buf.WriteString(`</li>`)
+			}
+			buf.WriteString(`</` + top.tag + `>`)
+			lists = lists[:len(lists)-1]
+		}
+	}
+	for _, block := range blocks {
+		id := ` id="` + html.EscapeString(block.ID) + `"`
+		if block.Kind != ListItem {
+			closeLists(0)
+		}
+		switch block.Kind {
+		case Heading:
+			tag := "h" + strconv.Itoa(min(max(block.Level, 1), 6))
+			buf.WriteString(`<` + tag + id + `>`)
+			renderInlines(buf, block.Inlines)
+			buf.WriteString(`</` + tag + `>`)
+		case Quote:
+			buf.WriteString(`<blockquote` + id + `><p>`)
+			renderInlines(buf, block.Inlines)
+			buf.WriteString(`</p></blockquote>`)
+		case Code:
+			buf.WriteString(`<pre` + id + `><code>` + html.EscapeString(block.Text) + `</code></pre>`)
+		case Rule:
+			buf.WriteString(`<hr` + id + `/>`)
+		case Image:
+			if src, ok := safeImage(block.Src); ok {
+				buf.WriteString(`<figure` + id + `><img src="` + html.EscapeString(src) + `" alt="` + html.EscapeString(block.Alt) + `"/></figure>`)
+			}
+		case Table:
+			buf.WriteString(`<table` + id + `>`)
+			renderRows(buf, block.Rows)
+			buf.WriteString(`</table>`)
+		case ListItem:
+			level := max(block.Level, 1)
+			closeLists(level)
+			if len(lists) == level {
+				if top := &lists[level-1]; top.itemOn {
+					buf.WriteString(`</li>`)
+					top.itemOn = false
+				}
+			}
+			for len(lists) < level {
+				if n := len(lists); n > 0 && !lists[n-1].itemOn {
+					buf.WriteString(`<li>`)
+					lists[n-1].itemOn = true
+				}
+				tag := "ul"
+				if block.Ordered {
+					tag = "ol"
+				}
+				buf.WriteString(`<` + tag + `>`)
+				lists = append(lists, listLevel{tag: tag})
+			}
+			buf.WriteString(`<li` + id + `>`)
+			renderInlines(buf, block.Inlines)
+			lists[level-1].itemOn = true
+		default:
+			buf.WriteString(`<p` + id + `>`)
+			renderInlines(buf, block.Inlines)
+			buf.WriteString(`</p>`)
+		}
+	}
+	closeLists(0)
+}
+
+// renderRows puts the header rows at the top of a table in its thead and
+// the rest in its tbody; header cells are th wherever they are.
+func renderRows(buf *bytes.Buffer, rows []TableRow) {
+	head := 0
+	for head < len(rows) && rows[head].Header {
+		head++
+	}
+	group := func(tag string, rows []TableRow) {
+		if len(rows) == 0 {
+			return
+		}
+		buf.WriteString(`<` + tag + `>`)
+		for _, row := range rows {
+			cell := "td"
+			if row.Header {
+				cell = "th"
+			}
+			buf.WriteString(`<tr>`)
+			for _, c := range row.Cells {
+				buf.WriteString(`<` + cell + `>`)
+				renderInlines(buf, c.Inlines)
+				buf.WriteString(`</` + cell + `>`)
+			}
+			buf.WriteString(`</tr>`)
+		}
+		buf.WriteString(`</` + tag + `>`)
+	}
+	group("thead", rows[:head])
+	group("tbody", rows[head:])
+}
+
+var inlineTags = map[InlineKind]string{
+	Emphasis: "em",
+	Strong:   "strong",
+	Strike:   "del",
+	Sub:      "sub",
+	Sup:      "sup",
+}
+
+func renderInlines(buf *bytes.Buffer, inlines []Inline) {
+	for _, inline := range inlines {
+		switch inline.Kind {
+		case Text:
+			buf.WriteString(html.EscapeString(inline.Text))
+		case CodeSpan:
+			buf.WriteString(`<code>` + html.EscapeString(inline.Text) + `</code>`)
+		case Break:
+			buf.WriteString(`<br/>`)
+		case InlineImage:
+			if src, ok := safeImage(inline.Src); ok {
+				buf.WriteString(`<img src="` + html.EscapeString(src) + `" alt="` + html.EscapeString(inline.Alt) + `"/>`)
+			} else {
+				buf.WriteString(html.EscapeString(inline.Alt))
+			}
+		case FootnoteRef:
+			label := inline.Text
+			if label == "" {
+				label = "*"
+			}
+			buf.WriteString(`<sup><a href="#` + html.EscapeString(inline.Href) + `" role="doc-noteref">` + html.EscapeString(label) + `</a></sup>`)
+		case Link:
+			if href, ok := safeLink(inline.Href); ok {
+				buf.WriteString(`<a href="` + html.EscapeString(href) + `">`)
+				renderInlines(buf, inline.Children)
+				buf.WriteString(`</a>`)
+			} else {
+				renderInlines(buf, inline.Children)
+			}
+		default:
+			if tag, ok := inlineTags[inline.Kind]; ok {
+				buf.WriteString(`<` + tag + `>`)
+				renderInlines(buf, inline.Children)
+				buf.WriteString(`</` + tag + `>`)
+				continue
+			}
+			renderInlines(buf, inline.Children)
+		}
+	}
+}
+
+func safeLink(href string) (string, bool) {
+	href = strings.TrimSpace(href)
+	lower := strings.ToLower(href)
+	switch {
+	case strings.HasPrefix(lower, "http://"), strings.HasPrefix(lower, "https://"), strings.HasPrefix(lower, "mailto:"):
+		return href, true
+	case strings.HasPrefix(href, "#") && len(href) > 1:
+		return href, true
+	}
+	return "", false
+}
+
+func safeImage(src string) (string, bool) {
+	lower := strings.ToLower(strings.TrimSpace(src))
+	switch {
+	case strings.HasPrefix(lower, "data:image/") && !strings.HasPrefix(lower, "data:image/svg"):
+		return src, true
+	case strings.HasPrefix(src, "/") && !strings.HasPrefix(src, "//"):
+		return src, true
+	}
+	return "", false
+}
@@ -0,0 +1,136 @@
+package document
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"errors"
+	"io"
+	"strings"
+)
+
+var ErrInvalid = errors.New("invalid document")
+
+// maxPartSize bounds a single XML part read from an office package.
+const maxPartSize = 32 << 20
+
+// maxTreeDepth guards against hostile nesting in office XML.
+const maxTreeDepth = 256
+
+// xmlNode is a namespace-agnostic element tree; office formats are easier
+// to walk as trees than with streaming decoders. Text nodes have an empty
+// Name.
+type xmlNode struct {
+	Name     string
+	Attrs    []xml.Attr
+	Text     string
+	Children []*xmlNode
+}
+
+func parseTree(r io.Reader) (*xmlNode, error) {
+	decoder := xml.NewDecoder(io.LimitReader(r, maxPartSize))
+	decoder.Strict = false
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			return readTree(decoder, start, 0)
+		}
+	}
+}
+
+func readTree(decoder *xml.Decoder, start xml.StartElement, depth int) (*xmlNode, error) {
+	if depth > maxTreeDepth {
+		return nil, ErrInvalid
+	}
+	node := &xmlNode{Name: start.Name.Local, Attrs: start.Attr}
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			child, err := readTree(decoder, t, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			node.Children = append(node.Children, child)
+		case xml.CharData:
+			node.Children = append(node.Children, &xmlNode{Text: string(t)})
+		case xml.EndElement:
+			return node, nil
+		}
+	}
+}
+
+// attr returns an attribute by local name.
+func (n *xmlNode) attr(name string) string {
+	for _, a := range n.Attrs {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+func (n *xmlNode) child(name string) *xmlNode {
+	for _, c := range n.Children {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// find returns the first descendant with the given name.
+func (n *xmlNode) find(name string) *xmlNode {
+	for _, c := range n.Children {
+		if c.Name == name {
+			return c
+		}
+		if found := c.find(name); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+func (n *xmlNode) text() string {
+	if n.Name == "" {
+		return n.Text
+	}
+	var buf strings.Builder
+	for _, c := range n.Children {
+		buf.WriteString(c.text())
+	}
+	return buf.String()
+}
+
+// readPart parses an XML member of a zip package. A missing member is
+// reported as a nil tree without error.
+func readPart(archive *zip.Reader, name string) (*xmlNode, error) {
+	for _, file := range archive.File {
+		if file.Name != name {
+			continue
+		}
+		rc, err := file.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		return parseTree(rc)
+	}
+	return nil, nil
+}
+
+// imageOnly turns a paragraph that holds nothing but an image into an
+// image block.
+func imageOnly(block Block) Block {
+	inlines := trimInlines(block.Inlines)
+	if block.Kind == Paragraph && len(inlines) == 1 && inlines[0].Kind == InlineImage {
+		return Block{Kind: Image, Src: inlines[0].Src, Alt: inlines[0].Alt}
+	}
+	return block
+}
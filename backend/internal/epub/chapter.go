@@ -0,0 +1,109 @@
+package epub
+
+import (
+	"errors"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/EROQIN/relite-reader/backend/internal/xhtml"
+)
+
+var ErrChapterRange = errors.New("chapter out of range")
+
+// URLMapper builds reader URLs for references inside a chapter.
+type URLMapper struct {
+	// Chapter returns the URL of another spine document.
+	Chapter func(index int, fragment string) string
+	// Resource returns the URL of an archive file such as an image or
+	// stylesheet.
+	Resource func(href string) string
+}
+
+// RenderChapter returns the sanitized XHTML of the spine document at
+// index with links and resources rewritten through urls.
+func (b *Book) RenderChapter(index int, urls URLMapper) ([]byte, error) {
+	chapters := b.Chapters()
+	if index < 0 || index >= len(chapters) {
+		return nil, ErrChapterRange
+	}
+	chapter := chapters[index]
+	data, err := b.ReadFile(chapter.Href)
+	if err != nil {
+		return nil, err
+	}
+	base := path.Dir(chapter.Href)
+	return xhtml.Sanitize(data, func(kind xhtml.Kind, ref string) (string, bool) {
+		ref = strings.TrimSpace(ref)
+		if ref == "" {
+			return "", false
+		}
+		if parsed, err := url.Parse(ref); err == nil && parsed.Scheme != "" {
+			// Remote resources would leak reading activity; only plain
+			// outbound links are kept.
+			if kind == xhtml.Link && (parsed.Scheme == "http" || parsed.Scheme == "https" || parsed.Scheme == "mailto") {
+				return ref, true
+			}
+			return "", false
+		}
+		if strings.HasPrefix(ref, "#") {
+			return ref, kind == xhtml.Link
+		}
+		target := resolvePath(base, ref)
+		if strings.HasPrefix(target, "../") || target == ".." {
+			return "", false
+		}
+		if kind == xhtml.Link {
+			fragment := ""
+			if idx := strings.IndexByte(ref, '#'); idx >= 0 {
+				fragment = ref[idx+1:]
+			}
+			if target == chapter.Href && fragment != "" {
+				return "#" + fragment, true
+			}
+			if n := b.ChapterIndex(target); n >= 0 {
+				return urls.Chapter(n, fragment), true
+			}
+		}
+		if _, ok := b.files[target]; !ok {
+			return "", false
+		}
+		return urls.Resource(target), true
+	})
+}
+
+// Resource returns an archive file listed in the manifest together with
+// its media type. Stylesheets have their url() references rewritten.
+// Content documents are only available through RenderChapter.
+func (b *Book) Resource(href string, urls URLMapper) ([]byte, string, error) {
+	var item ManifestItem
+	found := false
+	for _, candidate := range b.Manifest {
+		if candidate.Href == href {
+			item, found = candidate, true
+			break
+		}
+	}
+	if !found || item.MediaType == "application/xhtml+xml" || item.MediaType == "text/html" {
+		return nil, "", ErrInvalid
+	}
+	data, err := b.readLimited(href, maxResourceSize)
+	if err != nil {
+		return nil, "", err
+	}
+	if item.MediaType == "text/css" {
+		base := path.Dir(href)
+		css := xhtml.RewriteCSS(string(data), func(ref string) (string, bool) {
+			if parsed, err := url.Parse(ref); err != nil || parsed.Scheme != "" {
+				return "", false
+			}
+			target := resolvePath(base, ref)
+			if _, ok := b.files[target]; !ok {
+				return "", false
+			}
+			return urls.Resource(target), true
+		})
+		data = []byte(css)
+	}
+	return data, item.MediaType, nil
+}
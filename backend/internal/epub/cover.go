@@ -0,0 +1,58 @@
+package epub
+
+import (
+	"errors"
+	"strings"
+)
+
+var ErrNoCover = errors.New("epub has no cover image")
+
+// CoverItem locates the cover image using, in order, the EPUB 3
+// cover-image property, the EPUB 2 <meta name="cover"> reference and
+// finally any image whose id or file name mentions "cover".
+func (b *Book) CoverItem() (ManifestItem, error) {
+	for _, item := range b.Manifest {
+		if hasProperty(item.Properties, "cover-image") {
+			return item, nil
+		}
+	}
+	if b.CoverID != "" {
+		if item, ok := b.Item(b.CoverID); ok && isImage(item) {
+			return item, nil
+		}
+		// Some writers put the href instead of the id in the meta.
+		for _, item := range b.Manifest {
+			if strings.HasSuffix(item.Href, b.CoverID) && isImage(item) {
+				return item, nil
+			}
+		}
+	}
+	for _, item := range b.Manifest {
+		if isImage(item) && (strings.Contains(strings.ToLower(item.ID), "cover") || strings.Contains(strings.ToLower(item.Href), "cover")) {
+			return item, nil
+		}
+	}
+	return ManifestItem{}, ErrNoCover
+}
+
+// Cover returns the raw bytes of the cover image.
+func (b *Book) Cover() ([]byte, error) {
+	item, err := b.CoverItem()
+	if err != nil {
+		return nil, err
+	}
+	return b.ReadFile(item.Href)
+}
+
+func hasProperty(properties, want string) bool {
+	for _, property := range strings.Fields(properties) {
+		if property == want {
+			return true
+		}
+	}
+	return false
+}
+
+func isImage(item ManifestItem) bool {
+	return strings.HasPrefix(item.MediaType, "image/")
+}
@@ -0,0 +1,214 @@
+package epub
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/url"
+	"path"
+	"strings"
+)
+
+var ErrInvalid = errors.New("invalid epub")
+
+// maxXMLSize bounds the OPF and navigation documents we are willing to parse.
+const maxXMLSize = 8 << 20
+
+// maxResourceSize bounds images, fonts and other files served from the archive.
+const maxResourceSize = 32 << 20
+
+type Book struct {
+	archive  *zip.Reader
+	files    map[string]*zip.File
+	OPFPath  string
+	Metadata Metadata
+	Manifest []ManifestItem
+	Spine    []SpineItem
+	// CoverID is the manifest id referenced by an EPUB 2 <meta name="cover">.
+	CoverID string
+	// TocID is the manifest id of the NCX referenced by the spine.
+	TocID string
+}
+
+type ManifestItem struct {
+	ID         string
+	Href       string
+	MediaType  string
+	Properties string
+}
+
+type SpineItem struct {
+	IDRef  string
+	Linear bool
+}
+
+// Open parses the container and package document of an EPUB archive.
+func Open(r io.ReaderAt, size int64) (*Book, error) {
+	archive, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+	book := &Book{archive: archive, files: make(map[string]*zip.File, len(archive.File))}
+	for _, file := range archive.File {
+		book.files[file.Name] = file
+	}
+	opfPath, err := book.rootfile()
+	if err != nil {
+		return nil, err
+	}
+	book.OPFPath = opfPath
+	if err := book.parsePackage(); err != nil {
+		return nil, err
+	}
+	return book, nil
+}
+
+type container struct {
+	Rootfiles []struct {
+		FullPath  string `xml:"full-path,attr"`
+		MediaType string `xml:"media-type,attr"`
+	} `xml:"rootfiles>rootfile"`
+}
+
+func (b *Book) rootfile() (string, error) {
+	data, err := b.ReadFile("META-INF/container.xml")
+	if err != nil {
+		return "", ErrInvalid
+	}
+	var parsed container
+	if err := xml.Unmarshal(data, &parsed); err != nil {
+		return "", err
+	}
+	for _, rootfile := range parsed.Rootfiles {
+		if rootfile.MediaType == "" || rootfile.MediaType == "application/oebps-package+xml" {
+			if rootfile.FullPath != "" {
+				return rootfile.FullPath, nil
+			}
+		}
+	}
+	return "", ErrInvalid
+}
+
+type packageDoc struct {
+	Metadata struct {
+		Titles      []dcElement   `xml:"title"`
+		Creators    []dcElement   `xml:"creator"`
+		Languages   []dcElement   `xml:"language"`
+		Identifiers []dcElement   `xml:"identifier"`
+		Publishers  []dcElement   `xml:"publisher"`
+		Subjects    []dcElement   `xml:"subject"`
+		Description []dcElement   `xml:"description"`
+		Metas       []metaElement `xml:"meta"`
+	} `xml:"metadata"`
+	UniqueIdentifier string `xml:"unique-identifier,attr"`
+	Manifest         struct {
+		Items []struct {
+			ID         string `xml:"id,attr"`
+			Href       string `xml:"href,attr"`
+			MediaType  string `xml:"media-type,attr"`
+			Properties string `xml:"properties,attr"`
+		} `xml:"item"`
+	} `xml:"manifest"`
+	Spine struct {
+		Toc      string `xml:"toc,attr"`
+		Itemrefs []struct {
+			IDRef  string `xml:"idref,attr"`
+			Linear string `xml:"linear,attr"`
+		} `xml:"itemref"`
+	} `xml:"spine"`
+}
+
+type dcElement struct {
+	ID    string `xml:"id,attr"`
+	Role  string `xml:"role,attr"`
+	Value string `xml:",chardata"`
+}
+
+type metaElement struct {
+	Name     string `xml:"name,attr"`
+	Content  string `xml:"content,attr"`
+	Property string `xml:"property,attr"`
+	Refines  string `xml:"refines,attr"`
+	ID       string `xml:"id,attr"`
+	Value    string `xml:",chardata"`
+}
+
+func (b *Book) parsePackage() error {
+	data, err := b.ReadFile(b.OPFPath)
+	if err != nil {
+		return ErrInvalid
+	}
+	var doc packageDoc
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+	base := path.Dir(b.OPFPath)
+	for _, item := range doc.Manifest.Items {
+		b.Manifest = append(b.Manifest, ManifestItem{
+			ID:         item.ID,
+			Href:       resolvePath(base, item.Href),
+			MediaType:  item.MediaType,
+			Properties: item.Properties,
+		})
+	}
+	for _, ref := range doc.Spine.Itemrefs {
+		b.Spine = append(b.Spine, SpineItem{IDRef: ref.IDRef, Linear: ref.Linear != "no"})
+	}
+	b.TocID = doc.Spine.Toc
+	for _, meta := range doc.Metadata.Metas {
+		if meta.Name == "cover" {
+			b.CoverID = meta.Content
+		}
+	}
+	b.Metadata = parseMetadata(doc)
+	return nil
+}
+
+// Item returns the manifest entry with the given id.
+func (b *Book) Item(id string) (ManifestItem, bool) {
+	for _, item := range b.Manifest {
+		if item.ID == id {
+			return item, true
+		}
+	}
+	return ManifestItem{}, false
+}
+
+// ReadFile returns the contents of a file inside the archive.
+func (b *Book) ReadFile(name string) ([]byte, error) {
+	return b.readLimited(name, maxXMLSize)
+}
+
+func (b *Book) readLimited(name string, limit int64) ([]byte, error) {
+	file, ok := b.files[name]
+	if !ok {
+		return nil, ErrInvalid
+	}
+	rc, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(io.LimitReader(rc, limit))
+}
+
+// resolvePath joins an href relative to the directory of the referencing
+// document, dropping fragments and URL escapes.
+func resolvePath(base, href string) string {
+	if idx := strings.IndexByte(href, '#'); idx >= 0 {
+		href = href[:idx]
+	}
+	href = unescape(href)
+	if base == "." || base == "" {
+		return path.Clean(href)
+	}
+	return path.Clean(path.Join(base, href))
+}
+
+func unescape(value string) string {
+	if decoded, err := url.PathUnescape(value); err == nil {
+		return decoded
+	}
+	return value
+}
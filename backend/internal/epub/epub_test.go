@@ -0,0 +1,175 @@
+package epub
+
+import (
+	"archive/zip"
+	"bytes"
+	"testing"
+)
+
+const testContainer = `<?xml version="1.0"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles>
+    <rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/>
+  </rootfiles>
+</container>`
+
+const testOPF = `<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="uid">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:opf="http://www.idpf.org/2007/opf">
+    <dc:identifier id="isbn">urn:isbn:9780261102217</dc:identifier>
+    <dc:identifier id="uid">urn:uuid:1b4e28ba-2fa1-11d2-883f-0016d3cca427</dc:identifier>
+    <dc:title id="t1">The Hobbit</dc:title>
+    <dc:title id="t2">There and Back Again</dc:title>
+    <meta refines="#t1" property="title-type">main</meta>
+    <dc:creator opf:role="aut">J. R. R. Tolkien</dc:creator>
+    <dc:creator id="ill">Alan Lee</dc:creator>
+    <meta refines="#ill" property="role" scheme="marc:relators">ill</meta>
+    <dc:language>en</dc:language>
+    <meta name="calibre:series" content="Middle-earth"/>
+    <meta name="calibre:series_index" content="1.0"/>
+    <meta name="cover" content="cover-img"/>
+  </metadata>
+  <manifest>
+    <item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>
+    <item id="ncx" href="toc.ncx" media-type="application/x-dtbncx+xml"/>
+    <item id="cover-img" href="images/cover.jpg" media-type="image/jpeg"/>
+    <item id="ch1" href="text/chapter%201.xhtml" media-type="application/xhtml+xml"/>
+    <item id="ch2" href="text/chapter2.xhtml" media-type="application/xhtml+xml"/>
+  </manifest>
+  <spine toc="ncx">
+    <itemref idref="ch1"/>
+    <itemref idref="ch2" linear="no"/>
+  </spine>
+</package>`
+
+func buildEPUB(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	writer := zip.NewWriter(&buf)
+	mimetype, _ := writer.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store})
+	_, _ = mimetype.Write([]byte("application/epub+zip"))
+	for name, content := range files {
+		w, err := writer.Create(name)
+		if err != nil {
+			t.Fatalf("create %s: %v", name, err)
+		}
+		_, _ = w.Write([]byte(content))
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func openTestBook(t *testing.T, files map[string]string) *Book {
+	t.Helper()
+	data := buildEPUB(t, files)
+	book, err := Open(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	return book
+}
+
+func TestOpenParsesMetadata(t *testing.T) {
+	book := openTestBook(t, map[string]string{
+		"META-INF/container.xml": testContainer,
+		"OEBPS/content.opf":      testOPF,
+	})
+	md := book.Metadata
+	if md.Title != "The Hobbit" {
+		t.Fatalf("unexpected title %q", md.Title)
+	}
+	if md.Author() != "J. R. R. Tolkien" {
+		t.Fatalf("unexpected author %q", md.Author())
+	}
+	if md.Language != "en" {
+		t.Fatalf("unexpected language %q", md.Language)
+	}
+	if md.Identifier != "urn:uuid:1b4e28ba-2fa1-11d2-883f-0016d3cca427" {
+		t.Fatalf("unexpected identifier %q", md.Identifier)
+	}
+	if md.Series != "Middle-earth" || md.SeriesIndex != 1 {
+		t.Fatalf("unexpected series %q #%v", md.Series, md.SeriesIndex)
+	}
+}
+
+func TestOpenParsesManifestAndSpine(t *testing.T) {
+	book := openTestBook(t, map[string]string{
+		"META-INF/container.xml": testContainer,
+		"OEBPS/content.opf":      testOPF,
+	})
+	item, ok := book.Item("ch1")
+	if !ok || item.Href != "OEBPS/text/chapter 1.xhtml" {
+		t.Fatalf("unexpected manifest href %q", item.Href)
+	}
+	if len(book.Spine) != 2 || book.Spine[1].Linear {
+		t.Fatalf("unexpected spine %+v", book.Spine)
+	}
+	if book.CoverID != "cover-img" || book.TocID != "ncx" {
+		t.Fatalf("unexpected cover/toc ids %q %q", book.CoverID, book.TocID)
+	}
+}
+
+func TestOpenParsesEPUB3Collection(t *testing.T) {
+	opf := `<package xmlns="http://www.idpf.org/2007/opf" version="3.0">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:title>Dune Messiah</dc:title>
+    <dc:creator>Frank Herbert</dc:creator>
+    <meta property="belongs-to-collection" id="c01">Dune</meta>
+    <meta refines="#c01" property="collection-type">series</meta>
+    <meta refines="#c01" property="group-position">2</meta>
+  </metadata>
+  <manifest/>
+  <spine/>
+</package>`
+	book := openTestBook(t, map[string]string{
+		"META-INF/container.xml": testContainer,
+		"OEBPS/content.opf":      opf,
+	})
+	if book.Metadata.Series != "Dune" || book.Metadata.SeriesIndex != 2 {
+		t.Fatalf("unexpected series %q #%v", book.Metadata.Series, book.Metadata.SeriesIndex)
+	}
+}
+
+func TestOpenRejectsMissingContainer(t *testing.T) {
+	data := buildEPUB(t, map[string]string{"OEBPS/content.opf": testOPF})
+	if _, err := Open(bytes.NewReader(data), int64(len(data))); err == nil {
+		t.Fatalf("expected error")
+	}
+}
+
+func TestCoverFromMetaReference(t *testing.T) {
+	book := openTestBook(t, map[string]string{
+		"META-INF/container.xml":    testContainer,
+		"OEBPS/content.opf":         testOPF,
+		"OEBPS/images/cover.jpg":    "jpeg-bytes",
+		"OEBPS/text/chapter2.xhtml": "<html/>",
+	})
+	data, err := book.Cover()
+	if err != nil {
+		t.Fatalf("cover: %v", err)
+	}
+	if string(data) != "jpeg-bytes" {
+		t.Fatalf("unexpected cover data %q", data)
+	}
+}
+
+func TestCoverFromEPUB3Property(t *testing.T) {
+	opf := `<package xmlns="http://www.idpf.org/2007/opf" version="3.0">
+  <metadata/>
+  <manifest>
+    <item id="img1" href="art.png" media-type="image/png" properties="cover-image"/>
+  </manifest>
+  <spine/>
+</package>`
+	book := openTestBook(t, map[string]string{
+		"META-INF/container.xml": testContainer,
+		"OEBPS/content.opf":      opf,
+		"OEBPS/art.png":          "png-bytes",
+	})
+	item, err := book.CoverItem()
+	if err != nil || item.Href != "OEBPS/art.png" {
+		t.Fatalf("unexpected cover item %+v (%v)", item, err)
+	}
+}
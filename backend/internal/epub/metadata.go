@@ -0,0 +1,111 @@
+package epub
+
+import (
+	"strconv"
+	"strings"
+)
+
+type Metadata struct {
+	Title       string
+	Authors     []string
+	Language    string
+	Identifier  string
+	Publisher   string
+	Description string
+	Subjects    []string
+	Series      string
+	SeriesIndex float64
+}
+
+// Author joins all creators with the "aut" role (or every creator when no
+// roles are declared) into a display string.
+func (m Metadata) Author() string {
+	return strings.Join(m.Authors, ", ")
+}
+
+func parseMetadata(doc packageDoc) Metadata {
+	md := doc.Metadata
+	refines := make(map[string]map[string]string)
+	for _, meta := range md.Metas {
+		if meta.Refines == "" || meta.Property == "" {
+			continue
+		}
+		id := strings.TrimPrefix(meta.Refines, "#")
+		if refines[id] == nil {
+			refines[id] = make(map[string]string)
+		}
+		refines[id][meta.Property] = strings.TrimSpace(meta.Value)
+	}
+	out := Metadata{
+		Title:       firstValue(md.Titles),
+		Language:    firstValue(md.Languages),
+		Publisher:   firstValue(md.Publishers),
+		Description: firstValue(md.Description),
+	}
+	for _, title := range md.Titles {
+		// EPUB 3 may list subtitles and collection titles; prefer the main one.
+		if refines[title.ID]["title-type"] == "main" {
+			out.Title = strings.TrimSpace(title.Value)
+			break
+		}
+	}
+	for _, creator := range md.Creators {
+		name := strings.TrimSpace(creator.Value)
+		if name == "" {
+			continue
+		}
+		role := creator.Role
+		if role == "" {
+			role = refines[creator.ID]["role"]
+		}
+		if role != "" && role != "aut" {
+			continue
+		}
+		out.Authors = append(out.Authors, name)
+	}
+	for _, subject := range md.Subjects {
+		if value := strings.TrimSpace(subject.Value); value != "" {
+			out.Subjects = append(out.Subjects, value)
+		}
+	}
+	out.Identifier = firstValue(md.Identifiers)
+	for _, identifier := range md.Identifiers {
+		if identifier.ID != "" && identifier.ID == doc.UniqueIdentifier {
+			out.Identifier = strings.TrimSpace(identifier.Value)
+			break
+		}
+	}
+	for _, meta := range md.Metas {
+		switch {
+		case meta.Name == "calibre:series":
+			out.Series = strings.TrimSpace(meta.Content)
+		case meta.Name == "calibre:series_index":
+			out.SeriesIndex = parseIndex(meta.Content)
+		case meta.Property == "belongs-to-collection" && out.Series == "":
+			collection := refines[meta.ID]
+			if kind := collection["collection-type"]; kind != "" && kind != "series" {
+				continue
+			}
+			out.Series = strings.TrimSpace(meta.Value)
+			out.SeriesIndex = parseIndex(collection["group-position"])
+		}
+	}
+	return out
+}
+
+func firstValue(elements []dcElement) string {
+	for _, element := range elements {
+		if value := strings.TrimSpace(element.Value); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+func parseIndex(raw string) float64 {
+	value, err := strconv.ParseFloat(strings.TrimSpace(raw), 64)
+	if err != nil {
+		return 0
+	}
+	return value
+}
@@ -0,0 +1,252 @@
+package epub
+
+import (
+	"encoding/xml"
+	"path"
+	"strings"
+
+	"github.com/EROQIN/relite-reader/backend/internal/xhtml"
+	"golang.org/x/net/html"
+)
+
+type Chapter struct {
+	Index     int
+	ID        string
+	Href      string
+	MediaType string
+	Linear    bool
+}
+
+// TocEntry is one navigation point. Chapter is the spine index the entry
+// points into, or -1 when the target is not part of the spine.
+type TocEntry struct {
+	Title    string
+	Href     string
+	Fragment string
+	Chapter  int
+	Children []TocEntry
+}
+
+// Chapters returns the spine in reading order, skipping itemrefs that do
+// not resolve to a manifest item.
+func (b *Book) Chapters() []Chapter {
+	var out []Chapter
+	for _, ref := range b.Spine {
+		item, ok := b.Item(ref.IDRef)
+		if !ok {
+			continue
+		}
+		out = append(out, Chapter{
+			Index:     len(out),
+			ID:        item.ID,
+			Href:      item.Href,
+			MediaType: item.MediaType,
+			Linear:    ref.Linear,
+		})
+	}
+	return out
+}
+
+// ChapterIndex returns the spine index of the document at href.
+func (b *Book) ChapterIndex(href string) int {
+	for _, chapter := range b.Chapters() {
+		if chapter.Href == href {
+			return chapter.Index
+		}
+	}
+	return -1
+}
+
+// TOC reads the EPUB 3 navigation document, falling back to the EPUB 2
+// NCX. Books with neither get one entry per spine item.
+func (b *Book) TOC() []TocEntry {
+	if entries := b.navTOC(); len(entries) > 0 {
+		return entries
+	}
+	if entries := b.ncxTOC(); len(entries) > 0 {
+		return entries
+	}
+	var out []TocEntry
+	for _, chapter := range b.Chapters() {
+		if !chapter.Linear {
+			continue
+		}
+		title := strings.TrimSuffix(path.Base(chapter.Href), path.Ext(chapter.Href))
+		out = append(out, TocEntry{Title: title, Href: chapter.Href, Chapter: chapter.Index})
+	}
+	return out
+}
+
+func (b *Book) entry(base, title, href string) TocEntry {
+	fragment := ""
+	if idx := strings.IndexByte(href, '#'); idx >= 0 {
+		fragment = href[idx+1:]
+	}
+	target := resolvePath(base, href)
+	return TocEntry{
+		Title:    strings.Join(strings.Fields(title), " "),
+		Href:     target,
+		Fragment: fragment,
+		Chapter:  b.ChapterIndex(target),
+	}
+}
+
+func (b *Book) navTOC() []TocEntry {
+	var navItem ManifestItem
+	found := false
+	for _, item := range b.Manifest {
+		if hasProperty(item.Properties, "nav") {
+			navItem, found = item, true
+			break
+		}
+	}
+	if !found {
+		return nil
+	}
+	data, err := b.ReadFile(navItem.Href)
+	if err != nil {
+		return nil
+	}
+	root, err := xhtml.Parse(data)
+	if err != nil {
+		return nil
+	}
+	var toc *html.Node
+	walkNodes(root, func(node *html.Node) bool {
+		if node.Type != html.ElementNode || node.Data != "nav" {
+			return true
+		}
+		if toc == nil || strings.Contains(navType(node), "toc") {
+			toc = node
+		}
+		return toc == nil || !strings.Contains(navType(toc), "toc")
+	})
+	if toc == nil {
+		return nil
+	}
+	list := firstChildElement(toc, "ol")
+	if list == nil {
+		return nil
+	}
+	return b.navList(path.Dir(navItem.Href), list)
+}
+
+func (b *Book) navList(base string, list *html.Node) []TocEntry {
+	var out []TocEntry
+	for li := list.FirstChild; li != nil; li = li.NextSibling {
+		if li.Type != html.ElementNode || li.Data != "li" {
+			continue
+		}
+		var entry TocEntry
+		label := firstChildElement(li, "a")
+		if label == nil {
+			label = firstChildElement(li, "span")
+		}
+		if label == nil {
+			continue
+		}
+		href := ""
+		for _, attr := range label.Attr {
+			if attr.Key == "href" && attr.Namespace == "" {
+				href = attr.Val
+			}
+		}
+		if href != "" {
+			entry = b.entry(base, nodeText(label), href)
+		} else {
+			entry = TocEntry{Title: strings.Join(strings.Fields(nodeText(label)), " "), Chapter: -1}
+		}
+		if nested := firstChildElement(li, "ol"); nested != nil {
+			entry.Children = b.navList(base, nested)
+		}
+		out = append(out, entry)
+	}
+	return out
+}
+
+type ncxDoc struct {
+	Points []ncxPoint `xml:"navMap>navPoint"`
+}
+
+type ncxPoint struct {
+	Label   string `xml:"navLabel>text"`
+	Content struct {
+		Src string `xml:"src,attr"`
+	} `xml:"content"`
+	Points []ncxPoint `xml:"navPoint"`
+}
+
+func (b *Book) ncxTOC() []TocEntry {
+	item, ok := b.Item(b.TocID)
+	if !ok {
+		for _, candidate := range b.Manifest {
+			if candidate.MediaType == "application/x-dtbncx+xml" {
+				item, ok = candidate, true
+				break
+			}
+		}
+	}
+	if !ok {
+		return nil
+	}
+	data, err := b.ReadFile(item.Href)
+	if err != nil {
+		return nil
+	}
+	var doc ncxDoc
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		return nil
+	}
+	return b.ncxPoints(path.Dir(item.Href), doc.Points)
+}
+
+func (b *Book) ncxPoints(base string, points []ncxPoint) []TocEntry {
+	var out []TocEntry
+	for _, point := range points {
+		entry := b.entry(base, point.Label, point.Content.Src)
+		entry.Children = b.ncxPoints(base, point.Points)
+		out = append(out, entry)
+	}
+	return out
+}
+
+func navType(node *html.Node) string {
+	for _, attr := range node.Attr {
+		if attr.Key == "type" && attr.Namespace == "epub" || attr.Key == "epub:type" {
+			return attr.Val
+		}
+	}
+	return ""
+}
+
+func walkNodes(node *html.Node, visit func(*html.Node) bool) bool {
+	if !visit(node) {
+		return false
+	}
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		if !walkNodes(child, visit) {
+			return false
+		}
+	}
+	return true
+}
+
+func firstChildElement(node *html.Node, name string) *html.Node {
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type == html.ElementNode && child.Data == name {
+			return child
+		}
+	}
+	return nil
+}
+
+func nodeText(node *html.Node) string {
+	var buf strings.Builder
+	walkNodes(node, func(n *html.Node) bool {
+		if n.Type == html.TextNode {
+			buf.WriteString(n.Data)
+		}
+		return true
+	})
+	return buf.String()
+}
@@ -0,0 +1,109 @@
+package epub
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+const testNav = `<?xml version="1.0" encoding="UTF-8"?>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops">
+<body>
+  <nav epub:type="landmarks"><ol><li><a href="text/chapter2.xhtml">Notes</a></li></ol></nav>
+  <nav epub:type="toc"><ol>
+    <li><a href="text/chapter%201.xhtml">An Unexpected
+      Party</a>
+      <ol><li><a href="text/chapter%201.xhtml#s2">Roast Mutton</a></li></ol>
+    </li>
+    <li><span>Appendix</span></li>
+  </ol></nav>
+</body></html>`
+
+const testNCX = `<?xml version="1.0" encoding="UTF-8"?>
+<ncx xmlns="http://www.daisy.org/z3986/2005/ncx/" version="2005-1">
+  <navMap>
+    <navPoint id="p1"><navLabel><text>Chapter One</text></navLabel><content src="text/chapter%201.xhtml"/>
+      <navPoint id="p2"><navLabel><text>Part Two</text></navLabel><content src="text/chapter2.xhtml#top"/></navPoint>
+    </navPoint>
+  </navMap>
+</ncx>`
+
+func TestChaptersFollowSpine(t *testing.T) {
+	book := openTestBook(t, map[string]string{
+		"META-INF/container.xml": testContainer,
+		"OEBPS/content.opf":      testOPF,
+	})
+	chapters := book.Chapters()
+	if len(chapters) != 2 || chapters[0].Href != "OEBPS/text/chapter 1.xhtml" || chapters[1].Linear {
+		t.Fatalf("unexpected chapters %+v", chapters)
+	}
+}
+
+func TestTOCPrefersNavDocument(t *testing.T) {
+	book := openTestBook(t, map[string]string{
+		"META-INF/container.xml": testContainer,
+		"OEBPS/content.opf":      testOPF,
+		"OEBPS/nav.xhtml":        testNav,
+		"OEBPS/toc.ncx":          testNCX,
+	})
+	toc := book.TOC()
+	if len(toc) != 2 || toc[0].Title != "An Unexpected Party" || toc[0].Chapter != 0 {
+		t.Fatalf("unexpected toc %+v", toc)
+	}
+	if len(toc[0].Children) != 1 || toc[0].Children[0].Fragment != "s2" {
+		t.Fatalf("unexpected nested entries %+v", toc[0].Children)
+	}
+	if toc[1].Title != "Appendix" || toc[1].Chapter != -1 {
+		t.Fatalf("unexpected heading entry %+v", toc[1])
+	}
+}
+
+func TestTOCFallsBackToNCX(t *testing.T) {
+	book := openTestBook(t, map[string]string{
+		"META-INF/container.xml": testContainer,
+		"OEBPS/content.opf":      testOPF,
+		"OEBPS/toc.ncx":          testNCX,
+	})
+	toc := book.TOC()
+	if len(toc) != 1 || toc[0].Title != "Chapter One" || len(toc[0].Children) != 1 {
+		t.Fatalf("unexpected toc %+v", toc)
+	}
+	if child := toc[0].Children[0]; child.Chapter != 1 || child.Fragment != "top" {
+		t.Fatalf("unexpected child %+v", child)
+	}
+}
+
+func TestRenderChapterRewritesReferences(t *testing.T) {
+	chapter := `<?xml version="1.0" encoding="UTF-8"?>
+<html xmlns="http://www.w3.org/1999/xhtml"><head><link rel="stylesheet" href="../styles/book.css"/></head>
+<body><p><img src="../images/cover.jpg" alt=""/><a href="chapter2.xhtml#top">next</a>
+<a href="#s2">below</a><img src="../../secret.png"/><script>alert(1)</script></p></body></html>`
+	book := openTestBook(t, map[string]string{
+		"META-INF/container.xml":     testContainer,
+		"OEBPS/content.opf":          testOPF,
+		"OEBPS/text/chapter 1.xhtml": chapter,
+		"OEBPS/text/chapter2.xhtml":  "<html/>",
+		"OEBPS/images/cover.jpg":     "jpeg",
+		"OEBPS/styles/book.css":      "p { margin: 0 }",
+	})
+	urls := URLMapper{
+		Chapter:  func(index int, fragment string) string { return "/c/" + strconv.Itoa(index) + "#" + fragment },
+		Resource: func(href string) string { return "/r/" + href },
+	}
+	out, err := book.RenderChapter(0, urls)
+	if err != nil {
+		t.Fatalf("render: %v", err)
+	}
+	text := string(out)
+	for _, want := range []string{`href="/r/OEBPS/styles/book.css"`, `src="/r/OEBPS/images/cover.jpg"`, `href="/c/1#top"`, `href="#s2"`} {
+		if !strings.Contains(text, want) {
+			t.Fatalf("expected %q in %s", want, text)
+		}
+	}
+	if strings.Contains(text, "secret") || strings.Contains(text, "script") {
+		t.Fatalf("unexpected content in %s", text)
+	}
+	if _, err := book.RenderChapter(5, urls); err != ErrChapterRange {
+		t.Fatalf("expected range error, got %v", err)
+	}
+}
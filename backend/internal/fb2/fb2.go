@@ -0,0 +1,256 @@
+package fb2
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+var (
+	ErrInvalid = errors.New("invalid fb2")
+	ErrNoCover = errors.New("fb2 has no cover image")
+)
+
+type Document struct {
+	Title       string
+	Authors     []string
+	Language    string
+	Series      string
+	SeriesIndex float64
+	Annotation  string
+	// CoverID is the binary id referenced from <coverpage>.
+	CoverID  string
+	Binaries map[string]Binary
+	// Bodies holds the main text followed by any notes or comments
+	// bodies, as generic element trees.
+	Bodies []*Node
+}
+
+// Node is an element or, when Name is empty, a run of text.
+type Node struct {
+	Name     string
+	Attrs    map[string]string
+	Text     string
+	Children []*Node
+}
+
+// Attr returns an attribute by local name, so l:href and xlink:href both
+// answer to "href".
+func (n *Node) Attr(name string) string {
+	return n.Attrs[name]
+}
+
+type Binary struct {
+	ContentType string
+	Data        []byte
+}
+
+type person struct {
+	FirstName  string `xml:"first-name"`
+	MiddleName string `xml:"middle-name"`
+	LastName   string `xml:"last-name"`
+	Nickname   string `xml:"nickname"`
+}
+
+func (p person) String() string {
+	var parts []string
+	for _, part := range []string{p.FirstName, p.MiddleName, p.LastName} {
+		if value := strings.TrimSpace(part); value != "" {
+			parts = append(parts, value)
+		}
+	}
+	if len(parts) == 0 {
+		return strings.TrimSpace(p.Nickname)
+	}
+	return strings.Join(parts, " ")
+}
+
+type imageRef struct {
+	Attrs []xml.Attr `xml:",any,attr"`
+}
+
+func (i imageRef) href() string {
+	for _, attr := range i.Attrs {
+		if attr.Name.Local == "href" {
+			return strings.TrimPrefix(attr.Value, "#")
+		}
+	}
+	return ""
+}
+
+type titleInfo struct {
+	BookTitle  string   `xml:"book-title"`
+	Authors    []person `xml:"author"`
+	Lang       string   `xml:"lang"`
+	Annotation struct {
+		Inner string `xml:",innerxml"`
+	} `xml:"annotation"`
+	Sequences []struct {
+		Name   string `xml:"name,attr"`
+		Number string `xml:"number,attr"`
+	} `xml:"sequence"`
+	Coverpage struct {
+		Images []imageRef `xml:"image"`
+	} `xml:"coverpage"`
+}
+
+// NewDecoder returns an XML decoder that understands the legacy charsets
+// (windows-1251, koi8-r, ...) common in FB2 files.
+func NewDecoder(r io.Reader) *xml.Decoder {
+	decoder := xml.NewDecoder(r)
+	decoder.Strict = false
+	decoder.CharsetReader = func(label string, input io.Reader) (io.Reader, error) {
+		enc, err := htmlindex.Get(label)
+		if err != nil {
+			return nil, err
+		}
+		return enc.NewDecoder().Reader(input), nil
+	}
+	return decoder
+}
+
+// Parse reads the description, bodies and embedded binaries of an FB2
+// document.
+func Parse(r io.Reader) (*Document, error) {
+	decoder := NewDecoder(r)
+	doc := &Document{Binaries: make(map[string]Binary)}
+	sawRoot := false
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		switch start.Name.Local {
+		case "FictionBook":
+			sawRoot = true
+		case "title-info":
+			var info titleInfo
+			if err := decoder.DecodeElement(&info, &start); err != nil {
+				return nil, err
+			}
+			doc.applyTitleInfo(info)
+		case "body":
+			body, err := readNode(decoder, start, 0)
+			if err != nil {
+				return nil, err
+			}
+			doc.Bodies = append(doc.Bodies, body)
+		case "binary":
+			if err := doc.readBinary(decoder, start); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if !sawRoot {
+		return nil, ErrInvalid
+	}
+	return doc, nil
+}
+
+// maxNodeDepth guards against hostile nesting in bodies.
+const maxNodeDepth = 128
+
+func readNode(decoder *xml.Decoder, start xml.StartElement, depth int) (*Node, error) {
+	if depth > maxNodeDepth {
+		return nil, ErrInvalid
+	}
+	node := &Node{Name: start.Name.Local}
+	for _, attr := range start.Attr {
+		if node.Attrs == nil {
+			node.Attrs = make(map[string]string, len(start.Attr))
+		}
+		node.Attrs[attr.Name.Local] = attr.Value
+	}
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			child, err := readNode(decoder, t, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			node.Children = append(node.Children, child)
+		case xml.CharData:
+			node.Children = append(node.Children, &Node{Text: string(t)})
+		case xml.EndElement:
+			return node, nil
+		}
+	}
+}
+
+func (d *Document) applyTitleInfo(info titleInfo) {
+	d.Title = strings.TrimSpace(info.BookTitle)
+	d.Language = strings.TrimSpace(info.Lang)
+	d.Annotation = strings.TrimSpace(info.Annotation.Inner)
+	for _, author := range info.Authors {
+		if name := author.String(); name != "" {
+			d.Authors = append(d.Authors, name)
+		}
+	}
+	if len(info.Sequences) > 0 {
+		d.Series = strings.TrimSpace(info.Sequences[0].Name)
+		d.SeriesIndex, _ = strconv.ParseFloat(strings.TrimSpace(info.Sequences[0].Number), 64)
+	}
+	for _, image := range info.Coverpage.Images {
+		if href := image.href(); href != "" {
+			d.CoverID = href
+			break
+		}
+	}
+}
+
+func (d *Document) readBinary(decoder *xml.Decoder, start xml.StartElement) error {
+	var raw struct {
+		ID          string `xml:"id,attr"`
+		ContentType string `xml:"content-type,attr"`
+		Data        string `xml:",chardata"`
+	}
+	if err := decoder.DecodeElement(&raw, &start); err != nil {
+		return err
+	}
+	cleaned := strings.Map(func(r rune) rune {
+		if r == ' ' || r == '\n' || r == '\r' || r == '\t' {
+			return -1
+		}
+		return r
+	}, raw.Data)
+	data, err := base64.StdEncoding.DecodeString(cleaned)
+	if err != nil {
+		// Skip damaged images rather than failing the whole book.
+		return nil
+	}
+	d.Binaries[raw.ID] = Binary{ContentType: raw.ContentType, Data: data}
+	return nil
+}
+
+// Author joins all authors into a display string.
+func (d *Document) Author() string {
+	return strings.Join(d.Authors, ", ")
+}
+
+// Cover returns the image referenced from the title-info coverpage.
+func (d *Document) Cover() (Binary, error) {
+	if d.CoverID == "" {
+		return Binary{}, ErrNoCover
+	}
+	binary, ok := d.Binaries[d.CoverID]
+	if !ok {
+		return Binary{}, ErrNoCover
+	}
+	return binary, nil
+}
@@ -0,0 +1,58 @@
+package fb2
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+const sample = `<?xml version="1.0" encoding="windows-1251"?>
+<FictionBook xmlns="http://www.gribuser.ru/xml/fictionbook/2.0" xmlns:l="http://www.w3.org/1999/xlink">
+  <description>
+    <title-info>
+      <author><first-name>Лев</first-name><last-name>Толстой</last-name></author>
+      <book-title>Война и мир</book-title>
+      <lang>ru</lang>
+      <sequence name="Эпопея" number="1"/>
+      <coverpage><image l:href="#cover.jpg"/></coverpage>
+    </title-info>
+  </description>
+  <body><section><p>Eh bien, mon prince.</p></section></body>
+  <binary id="cover.jpg" content-type="image/jpeg">aGVs
+bG8=</binary>
+</FictionBook>`
+
+func TestParseReadsDescriptionAndCover(t *testing.T) {
+	encoded, err := charmap.Windows1251.NewEncoder().String(sample)
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	doc, err := Parse(strings.NewReader(encoded))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if doc.Title != "Война и мир" || doc.Author() != "Лев Толстой" || doc.Language != "ru" {
+		t.Fatalf("unexpected description %+v", doc)
+	}
+	if doc.Series != "Эпопея" || doc.SeriesIndex != 1 {
+		t.Fatalf("unexpected series %q #%v", doc.Series, doc.SeriesIndex)
+	}
+	if len(doc.Bodies) != 1 || doc.Bodies[0].Children[0].Name != "section" {
+		t.Fatalf("unexpected bodies %+v", doc.Bodies)
+	}
+	cover, err := doc.Cover()
+	if err != nil {
+		t.Fatalf("cover: %v", err)
+	}
+	if !bytes.Equal(cover.Data, []byte("hello")) || cover.ContentType != "image/jpeg" {
+		t.Fatalf("unexpected cover %+v", cover)
+	}
+}
+
+func TestParseRejectsOtherXML(t *testing.T) {
+	if _, err := Parse(strings.NewReader(`<html><body/></html>`)); err == nil {
+		t.Fatalf("expected error")
+	}
+}
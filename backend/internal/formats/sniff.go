@@ -0,0 +1,329 @@
+package formats
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"path"
+	"strings"
+)
+
+const sniffLen = 4096
+
+type Detection struct {
+	Format   string
+	MimeType string
+}
+
+var mimeTypes = map[string]string{
+	"epub":     "application/epub+zip",
+	"pdf":      "application/pdf",
+	"txt":      "text/plain; charset=utf-8",
+	"mobi":     "application/x-mobipocket-ebook",
+	"cbz":      "application/vnd.comicbook+zip",
+	"cbr":      "application/vnd.comicbook-rar",
+	"cb7":      "application/x-cb7",
+	"cbt":      "application/x-cbt",
+	"cba":      "application/x-cba",
+	"azw":      "application/vnd.amazon.ebook",
+	"azw3":     "application/vnd.amazon.mobi8-ebook",
+	"azw4":     "application/vnd.amazon.ebook",
+	"kfx":      "application/octet-stream",
+	"fb2":      "application/x-fictionbook+xml",
+	"rtf":      "application/rtf",
+	"docx":     "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+	"odt":      "application/vnd.oasis.opendocument.text",
+	"md":       "text/markdown; charset=utf-8",
+	"markdown": "text/markdown; charset=utf-8",
+	"html":     "text/html; charset=utf-8",
+	"htm":      "text/html; charset=utf-8",
+	"djvu":     "image/vnd.djvu",
+	"xps":      "application/vnd.ms-xpsdocument",
+	"lit":      "application/x-ms-reader",
+	"pdb":      "application/vnd.palm",
+}
+
+// aliases groups formats that share a container signature, so a sniffed
+// format does not override a more specific extension from the same family.
+var aliases = map[string]string{
+	"azw":      "mobi",
+	"azw3":     "mobi",
+	"azw4":     "mobi",
+	"htm":      "html",
+	"markdown": "md",
+}
+
+// containers maps archive-based formats to the container they are sniffed
+// from.
+var containers = map[string]string{
+	"epub": "zip",
+	"cbz":  "zip",
+	"docx": "zip",
+	"odt":  "zip",
+	"xps":  "zip",
+	"cbt":  "tar",
+}
+
+// MimeType returns the content type for a supported format, or
+// application/octet-stream when the format is unknown.
+func MimeType(format string) string {
+	if mime, ok := mimeTypes[format]; ok {
+		return mime
+	}
+	return "application/octet-stream"
+}
+
+// Sniff inspects the leading bytes of r (and the archive directory for
+// ZIP and TAR containers) to determine the real format of a book.
+func Sniff(r io.ReaderAt, size int64) (Detection, bool) {
+	format, _ := sniff(r, size)
+	if format == "" {
+		return Detection{}, false
+	}
+	return Detection{Format: format, MimeType: MimeType(format)}, true
+}
+
+func sniff(r io.ReaderAt, size int64) (format, container string) {
+	head := make([]byte, sniffLen)
+	n, err := r.ReadAt(head, 0)
+	if err != nil && err != io.EOF {
+		return "", ""
+	}
+	head = head[:n]
+	format = sniffHead(head)
+	switch format {
+	case "zip":
+		return sniffZip(r, size, head), "zip"
+	case "tar":
+		return sniffTar(r, size), "tar"
+	}
+	return format, ""
+}
+
+// DetectContent combines extension and content detection. The sniffed
+// format wins unless it belongs to the same family as the extension; an
+// archive whose contents do not match its container extension (a plain
+// ZIP named .epub) is rejected.
+func DetectContent(pathname string, r io.ReaderAt, size int64) (Detection, bool) {
+	byExt, extOK := Detect(pathname)
+	sniffed, container := sniff(r, size)
+	if sniffed == "" {
+		if !extOK || (container != "" && containers[byExt] == container) {
+			return Detection{}, false
+		}
+		return Detection{Format: byExt, MimeType: MimeType(byExt)}, true
+	}
+	if extOK && family(byExt) == family(sniffed) {
+		return Detection{Format: byExt, MimeType: MimeType(byExt)}, true
+	}
+	return Detection{Format: sniffed, MimeType: MimeType(sniffed)}, true
+}
+
+func family(format string) string {
+	if alias, ok := aliases[format]; ok {
+		return alias
+	}
+	return format
+}
+
+func sniffHead(head []byte) string {
+	switch {
+	case bytes.HasPrefix(head, []byte("PK\x03\x04")):
+		return "zip"
+	case bytes.HasPrefix(head, []byte("AT&TFORM")):
+		if len(head) >= 16 {
+			kind := string(head[12:16])
+			if kind == "DJVU" || kind == "DJVM" {
+				return "djvu"
+			}
+		}
+		return ""
+	case bytes.HasPrefix(head, []byte("Rar!\x1a\x07")):
+		return "cbr"
+	case bytes.HasPrefix(head, []byte("7z\xbc\xaf\x27\x1c")):
+		return "cb7"
+	case len(head) >= 14 && string(head[7:14]) == "**ACE**":
+		return "cba"
+	case bytes.HasPrefix(head, []byte("{\\rtf")):
+		return "rtf"
+	case bytes.HasPrefix(head, []byte("ITOLITLS")):
+		return "lit"
+	}
+	if idx := bytes.Index(head, []byte("%PDF-")); idx >= 0 && idx < 1024 {
+		return "pdf"
+	}
+	if len(head) >= 68 {
+		switch string(head[60:68]) {
+		case "BOOKMOBI":
+			return "mobi"
+		case "TEXtREAd":
+			return "pdb"
+		}
+	}
+	if len(head) >= 262 && string(head[257:262]) == "ustar" {
+		return "tar"
+	}
+	return sniffMarkup(head)
+}
+
+func sniffMarkup(head []byte) string {
+	trimmed := bytes.TrimPrefix(head, []byte("\xef\xbb\xbf"))
+	trimmed = bytes.TrimLeft(trimmed, " \t\r\n")
+	if len(trimmed) == 0 || trimmed[0] != '<' {
+		return ""
+	}
+	lower := bytes.ToLower(trimmed)
+	if bytes.HasPrefix(lower, []byte("<!doctype html")) || bytes.HasPrefix(lower, []byte("<html")) {
+		return "html"
+	}
+	decoder := xml.NewDecoder(bytes.NewReader(trimmed))
+	decoder.Strict = false
+	decoder.CharsetReader = func(_ string, input io.Reader) (io.Reader, error) { return input, nil }
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return ""
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			switch strings.ToLower(start.Name.Local) {
+			case "fictionbook":
+				return "fb2"
+			case "html":
+				return "html"
+			}
+			return ""
+		}
+	}
+}
+
+func sniffZip(r io.ReaderAt, size int64, head []byte) string {
+	archive, err := zip.NewReader(io.NewSectionReader(r, 0, size), size)
+	if err != nil {
+		return sniffZipHeader(head)
+	}
+	names := make(map[string]*zip.File, len(archive.File))
+	images := 0
+	files := 0
+	for _, file := range archive.File {
+		names[file.Name] = file
+		if strings.HasSuffix(file.Name, "/") {
+			continue
+		}
+		files++
+		if IsImage(file.Name) {
+			images++
+		}
+	}
+	if file, ok := names["mimetype"]; ok {
+		if format := formatForMimetype(readZipEntry(file, 128)); format != "" {
+			return format
+		}
+	}
+	switch {
+	case names["META-INF/container.xml"] != nil:
+		return "epub"
+	case names["word/document.xml"] != nil:
+		return "docx"
+	case names["FixedDocumentSequence.fdseq"] != nil:
+		return "xps"
+	case images > 0 && images*2 >= files:
+		return "cbz"
+	}
+	return ""
+}
+
+// sniffZipHeader handles truncated ZIP data where the central directory
+// is not available, relying on the stored mimetype entry that EPUB and
+// ODT place first in the archive.
+func sniffZipHeader(head []byte) string {
+	if len(head) < 30 {
+		return ""
+	}
+	nameLen := int(head[26]) | int(head[27])<<8
+	extraLen := int(head[28]) | int(head[29])<<8
+	start := 30 + nameLen + extraLen
+	if len(head) < 30+nameLen || string(head[30:30+nameLen]) != "mimetype" || start > len(head) {
+		return ""
+	}
+	end := start + 128
+	if end > len(head) {
+		end = len(head)
+	}
+	return formatForMimetype(string(head[start:end]))
+}
+
+func formatForMimetype(raw string) string {
+	value := strings.TrimSpace(raw)
+	switch {
+	case strings.HasPrefix(value, "application/epub+zip"):
+		return "epub"
+	case strings.HasPrefix(value, "application/vnd.oasis.opendocument.text"):
+		return "odt"
+	case strings.HasPrefix(value, "application/vnd.comicbook+zip"), strings.HasPrefix(value, "application/x-cbz"):
+		return "cbz"
+	}
+	return ""
+}
+
+func readZipEntry(file *zip.File, limit int64) string {
+	rc, err := file.Open()
+	if err != nil {
+		return ""
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(io.LimitReader(rc, limit))
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+func sniffTar(r io.ReaderAt, size int64) string {
+	reader := tar.NewReader(io.NewSectionReader(r, 0, size))
+	images := 0
+	files := 0
+	for {
+		header, err := reader.Next()
+		if err != nil {
+			break
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		files++
+		if IsImage(header.Name) {
+			images++
+		}
+	}
+	if images > 0 && images*2 >= files {
+		return "cbt"
+	}
+	return ""
+}
+
+// IsImage reports whether name has an image extension commonly found in
+// comic archives and ebook containers.
+func IsImage(name string) bool {
+	_, ok := imageTypes[strings.ToLower(path.Ext(name))]
+	return ok
+}
+
+var imageTypes = map[string]string{
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".png":  "image/png",
+	".gif":  "image/gif",
+	".webp": "image/webp",
+	".bmp":  "image/bmp",
+	".avif": "image/avif",
+}
+
+// ImageType returns the MIME type of an image file name.
+func ImageType(name string) string {
+	if value, ok := imageTypes[strings.ToLower(path.Ext(name))]; ok {
+		return value
+	}
+	return "application/octet-stream"
+}
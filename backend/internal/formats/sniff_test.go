@@ -0,0 +1,105 @@
+package formats
+
+import (
+	"archive/zip"
+	"bytes"
+	"testing"
+)
+
+func buildZip(t *testing.T, entries map[string]string, order []string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	writer := zip.NewWriter(&buf)
+	for _, name := range order {
+		method := zip.Deflate
+		if name == "mimetype" {
+			method = zip.Store
+		}
+		w, err := writer.CreateHeader(&zip.FileHeader{Name: name, Method: method})
+		if err != nil {
+			t.Fatalf("create entry: %v", err)
+		}
+		if _, err := w.Write([]byte(entries[name])); err != nil {
+			t.Fatalf("write entry: %v", err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("close zip: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestSniffSignatures(t *testing.T) {
+	mobi := make([]byte, 80)
+	copy(mobi[60:], "BOOKMOBI")
+	djvu := []byte("AT&TFORM\x00\x00\x10\x00DJVUINFO")
+	cases := map[string][]byte{
+		"pdf":  []byte("%PDF-1.7\n%\xe2\xe3\xcf\xd3\n"),
+		"djvu": djvu,
+		"cbr":  []byte("Rar!\x1a\x07\x01\x00rest"),
+		"cb7":  []byte("7z\xbc\xaf\x27\x1c\x00\x04"),
+		"mobi": mobi,
+		"fb2":  []byte(`<?xml version="1.0" encoding="windows-1251"?><FictionBook xmlns="http://www.gribuser.ru/xml/fictionbook/2.0">`),
+		"html": []byte("<!DOCTYPE html><html><body></body></html>"),
+	}
+	for want, data := range cases {
+		got, ok := Sniff(bytes.NewReader(data), int64(len(data)))
+		if !ok || got.Format != want {
+			t.Fatalf("expected %s, got %q (ok=%v)", want, got.Format, ok)
+		}
+		if got.MimeType != MimeType(want) {
+			t.Fatalf("expected mime %s, got %s", MimeType(want), got.MimeType)
+		}
+	}
+}
+
+func TestSniffZipContainers(t *testing.T) {
+	epub := buildZip(t, map[string]string{
+		"mimetype":               "application/epub+zip",
+		"META-INF/container.xml": "<container/>",
+	}, []string{"mimetype", "META-INF/container.xml"})
+	docx := buildZip(t, map[string]string{
+		"[Content_Types].xml": "<Types/>",
+		"word/document.xml":   "<w:document/>",
+	}, []string{"[Content_Types].xml", "word/document.xml"})
+	cbz := buildZip(t, map[string]string{
+		"001.jpg": "a", "002.jpg": "b", "ComicInfo.xml": "<ComicInfo/>",
+	}, []string{"001.jpg", "002.jpg", "ComicInfo.xml"})
+	plain := buildZip(t, map[string]string{"notes.txt": "hello"}, []string{"notes.txt"})
+
+	for want, data := range map[string][]byte{"epub": epub, "docx": docx, "cbz": cbz} {
+		got, ok := Sniff(bytes.NewReader(data), int64(len(data)))
+		if !ok || got.Format != want {
+			t.Fatalf("expected %s, got %q", want, got.Format)
+		}
+	}
+	if _, ok := Sniff(bytes.NewReader(plain), int64(len(plain))); ok {
+		t.Fatalf("expected plain zip to be rejected")
+	}
+	truncated := epub[:60]
+	if got, ok := Sniff(bytes.NewReader(truncated), int64(len(truncated))); !ok || got.Format != "epub" {
+		t.Fatalf("expected epub from truncated header, got %q", got.Format)
+	}
+}
+
+func TestDetectContentPrefersSniffedFormat(t *testing.T) {
+	pdf := []byte("%PDF-1.4\n")
+	got, ok := DetectContent("/library/report.bin", bytes.NewReader(pdf), int64(len(pdf)))
+	if !ok || got.Format != "pdf" {
+		t.Fatalf("expected pdf, got %q", got.Format)
+	}
+	plain := buildZip(t, map[string]string{"notes.txt": "hello"}, []string{"notes.txt"})
+	if _, ok := DetectContent("/library/fake.epub", bytes.NewReader(plain), int64(len(plain))); ok {
+		t.Fatalf("expected plain zip named .epub to be rejected")
+	}
+	text := []byte("Chapter 1\nIt was a dark and stormy night.")
+	if got, ok := DetectContent("/library/novel.txt", bytes.NewReader(text), int64(len(text))); !ok || got.Format != "txt" {
+		t.Fatalf("expected extension fallback, got %q", got.Format)
+	}
+	mobi := make([]byte, 80)
+	copy(mobi[60:], "BOOKMOBI")
+	got, _ = DetectContent("/library/book.azw3", bytes.NewReader(mobi), int64(len(mobi)))
+	if got.Format != "azw3" {
+		t.Fatalf("expected azw3 to be kept, got %q", got.Format)
+	}
+}
@@ -15,6 +15,7 @@ type AnnotationsHandler struct {
 
 type annotationPayload struct {
 	Location float64 `json:"location"`
+	BlockID  string  `json:"block_id"`
 	Quote    string  `json:"quote"`
 	Note     string  `json:"note"`
 	Color    string  `json:"color"`
@@ -81,7 +82,7 @@ func (h *AnnotationsHandler) handleCreate(w http.ResponseWriter, r *http.Request
 		return
 	}
 	location := normalizeLocation(payload.Location)
-	item, err := h.store.Create(userID, bookID, location, payload.Quote, payload.Note, payload.Color)
+	item, err := h.store.Create(userID, bookID, location, payload.Quote, payload.Note, payload.Color, strings.TrimSpace(payload.BlockID))
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
@@ -16,6 +16,7 @@ type BookmarksHandler struct {
 type bookmarkPayload struct {
 	Label    string  `json:"label"`
 	Location float64 `json:"location"`
+	BlockID  string  `json:"block_id"`
 }
 
 func NewBookmarksHandler(secret []byte, store bookmarks.Store) *BookmarksHandler {
@@ -71,7 +72,7 @@ func (h *BookmarksHandler) handleCreate(w http.ResponseWriter, r *http.Request,
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	item, err := h.store.Create(userID, bookID, payload.Label, payload.Location)
+	item, err := h.store.Create(userID, bookID, payload.Label, payload.Location, strings.TrimSpace(payload.BlockID))
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
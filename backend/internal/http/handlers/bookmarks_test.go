@@ -33,7 +33,7 @@ func TestBookmarksHandlerCreateAndList(t *testing.T) {
 
 	store := bookmarks.NewMemoryStore()
 	h := handlers.NewBookmarksHandler(secret, store)
-	payload, _ := json.Marshal(map[string]any{"label": "Intro", "location": 0.12})
+	payload, _ := json.Marshal(map[string]any{"label": "Intro", "location": 0.12, "block_id": "b5"})
 	createReq := httptest.NewRequest(http.MethodPost, "/api/bookmarks/book-1", bytes.NewReader(payload))
 	createReq.Header.Set("Authorization", "Bearer "+token)
 	createResp := httptest.NewRecorder()
@@ -49,4 +49,13 @@ func TestBookmarksHandlerCreateAndList(t *testing.T) {
 	if listResp.Code != http.StatusOK {
 		t.Fatalf("expected 200, got %d", listResp.Code)
 	}
+	var list []struct {
+		BlockID string `json:"block_id"`
+	}
+	if err := json.NewDecoder(listResp.Body).Decode(&list); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(list) != 1 || list[0].BlockID != "b5" {
+		t.Fatalf("unexpected bookmarks %+v", list)
+	}
 }
@@ -1,12 +1,19 @@
 package handlers
 
 import (
+	"errors"
 	"io"
 	"net/http"
+	"os"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/EROQIN/relite-reader/backend/internal/books"
+	"github.com/EROQIN/relite-reader/backend/internal/covers"
+	"github.com/EROQIN/relite-reader/backend/internal/formats"
+	"github.com/EROQIN/relite-reader/backend/internal/library"
+	"github.com/EROQIN/relite-reader/backend/internal/plaintext"
 	"github.com/EROQIN/relite-reader/backend/internal/webdav"
 )
 
@@ -14,21 +21,34 @@ type BooksHandler struct {
 	secret []byte
 	store  books.Store
 	webSvc *webdav.Service
+	covers *covers.Cache
+	spools *library.SpoolCache
 }
 
 type booksResponse struct {
 	ID           string    `json:"id"`
 	Title        string    `json:"title"`
 	Author       string    `json:"author"`
+	Language     string    `json:"language"`
+	Identifier   string    `json:"identifier"`
+	Series       string    `json:"series"`
+	SeriesIndex  float64   `json:"series_index"`
+	Subject      string    `json:"subject"`
+	PageCount    int       `json:"page_count"`
 	Format       string    `json:"format"`
 	SourcePath   string    `json:"source_path"`
 	ConnectionID string    `json:"connection_id"`
 	Missing      bool      `json:"missing"`
+	CoverURL     string    `json:"cover_url,omitempty"`
 	UpdatedAt    time.Time `json:"updated_at"`
 }
 
-func NewBooksHandler(secret []byte, store books.Store, webSvc *webdav.Service) *BooksHandler {
-	return &BooksHandler{secret: secret, store: store, webSvc: webSvc}
+func NewBooksHandler(secret []byte, store books.Store, webSvc *webdav.Service, coverCache *covers.Cache) *BooksHandler {
+	h := &BooksHandler{secret: secret, store: store, webSvc: webSvc, covers: coverCache}
+	if webSvc != nil {
+		h.spools = library.NewSpoolCache(webSvc, 4)
+	}
+	return h
 }
 
 func (h *BooksHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -41,8 +61,55 @@ func (h *BooksHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		h.handleList(w, r, userID)
 		return
 	}
+	if r.Method == http.MethodPost && r.URL.Path == "/api/books/upload" {
+		h.handleUpload(w, r, userID)
+		return
+	}
+	if (r.Method == http.MethodPut || r.Method == http.MethodDelete) && strings.HasSuffix(r.URL.Path, "/pin") {
+		bookID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/books/"), "/pin")
+		if bookID == "" || strings.Contains(bookID, "/") {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		h.handlePin(w, r, userID, bookID)
+		return
+	}
 	if r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/api/books/") {
-		h.handleContent(w, r, userID)
+		parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/api/books/"), "/", 3)
+		if len(parts) < 2 || parts[0] == "" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		switch parts[1] {
+		case "cover":
+			h.handleCover(w, r, userID)
+		case "toc":
+			h.handleTOC(w, r, userID, parts[0])
+		case "document":
+			if len(parts) != 2 {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			h.handleDocument(w, r, userID, parts[0])
+		case "pages":
+			if len(parts) == 2 {
+				h.handlePages(w, r, userID, parts[0])
+				return
+			}
+			h.handlePage(w, r, userID, parts[0], parts[2])
+		case "chapters", "resources":
+			if len(parts) != 3 || parts[2] == "" {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			if parts[1] == "chapters" {
+				h.handleChapter(w, r, userID, parts[0], parts[2])
+			} else {
+				h.handleResource(w, r, userID, parts[0], parts[2])
+			}
+		default:
+			h.handleContent(w, r, userID)
+		}
 		return
 	}
 	w.WriteHeader(http.StatusNotFound)
@@ -56,20 +123,38 @@ func (h *BooksHandler) handleList(w http.ResponseWriter, r *http.Request, userID
 	}
 	resp := make([]booksResponse, 0, len(list))
 	for _, book := range list {
-		resp = append(resp, booksResponse{
-			ID:           book.ID,
-			Title:        book.Title,
-			Author:       book.Author,
-			Format:       book.Format,
-			SourcePath:   book.SourcePath,
-			ConnectionID: book.ConnectionID,
-			Missing:      book.Missing,
-			UpdatedAt:    book.UpdatedAt,
-		})
+		resp = append(resp, h.bookResponse(userID, book))
 	}
 	writeJSON(w, http.StatusOK, resp)
 }
 
+func (h *BooksHandler) bookResponse(userID string, book books.Book) booksResponse {
+	coverURL := ""
+	if h.covers != nil && h.covers.Has(userID, book.ID) {
+		coverURL = "/api/books/" + book.ID + "/cover"
+	}
+	return booksResponse{
+		ID:           book.ID,
+		Title:        book.Title,
+		Author:       book.Author,
+		Language:     book.Language,
+		Identifier:   book.Identifier,
+		Series:       book.Series,
+		SeriesIndex:  book.SeriesIndex,
+		Subject:      book.Subject,
+		PageCount:    book.PageCount,
+		Format:       book.Format,
+		SourcePath:   book.SourcePath,
+		ConnectionID: book.ConnectionID,
+		Missing:      book.Missing,
+		CoverURL:     coverURL,
+		UpdatedAt:    book.UpdatedAt,
+	}
+}
+
+// handleContent streams the book from WebDAV. Range, If-Range and the
+// conditional headers are passed through so PDF viewers can fetch pages
+// on demand and revalidate with 304s.
 func (h *BooksHandler) handleContent(w http.ResponseWriter, r *http.Request, userID string) {
 	if h.webSvc == nil {
 		w.WriteHeader(http.StatusServiceUnavailable)
@@ -81,14 +166,209 @@ func (h *BooksHandler) handleContent(w http.ResponseWriter, r *http.Request, use
 		w.WriteHeader(http.StatusNotFound)
 		return
 	}
-	reader, contentType, err := h.webSvc.OpenContent(userID, parts[0])
+	book, err := h.store.GetByID(userID, parts[0])
 	if err != nil {
 		w.WriteHeader(http.StatusNotFound)
 		return
 	}
-	defer reader.Close()
-	if contentType != "" {
+	req := webdav.ContentRequest{
+		Range:           r.Header.Get("Range"),
+		IfRange:         r.Header.Get("If-Range"),
+		IfNoneMatch:     r.Header.Get("If-None-Match"),
+		IfModifiedSince: r.Header.Get("If-Modified-Since"),
+	}
+	if book.Format == "txt" {
+		// Transcoded text has neither the length nor the bytes of the
+		// source, so only whole-file revalidation by date is supported.
+		req = webdav.ContentRequest{IfModifiedSince: req.IfModifiedSince}
+	}
+	content, err := h.webSvc.FetchContent(r.Context(), userID, parts[0], req)
+	if err != nil {
+		if errors.Is(err, books.ErrNotFound) || errors.Is(err, webdav.ErrNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+	if !content.LastModified.IsZero() {
+		w.Header().Set("Last-Modified", content.LastModified.UTC().Format(http.TimeFormat))
+	}
+	if book.Format != "txt" && content.ETag != "" {
+		w.Header().Set("ETag", content.ETag)
+	}
+	if content.Seeker != nil {
+		// A cached copy answers Range and conditional headers here.
+		if book.Format != "txt" {
+			defer content.Body.Close()
+			w.Header().Set("Content-Type", contentType(content, book.Format))
+			http.ServeContent(w, r, "", content.LastModified, content.Seeker)
+			return
+		}
+		if notModifiedSince(content.LastModified, req.IfModifiedSince) {
+			content.Body.Close()
+			content.Status = http.StatusNotModified
+		}
+	}
+	switch content.Status {
+	case http.StatusNotModified:
+		w.WriteHeader(http.StatusNotModified)
+		return
+	case http.StatusRequestedRangeNotSatisfiable:
+		if content.ContentRange != "" {
+			w.Header().Set("Content-Range", content.ContentRange)
+		}
+		w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+	defer content.Body.Close()
+	if book.Format == "txt" {
+		// Legacy encodings are transcoded so the reader always gets UTF-8.
+		text, charset, err := plaintext.NewReader(content.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("X-Source-Charset", charset)
+		_, _ = io.Copy(w, text)
+		return
+	}
+	if contentType := contentType(content, book.Format); contentType != "" {
 		w.Header().Set("Content-Type", contentType)
 	}
-	_, _ = io.Copy(w, reader)
+	if content.AcceptRanges {
+		w.Header().Set("Accept-Ranges", "bytes")
+	}
+	if content.Status == http.StatusPartialContent && content.ContentRange != "" {
+		w.Header().Set("Content-Range", content.ContentRange)
+	}
+	if content.Length >= 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(content.Length, 10))
+	}
+	w.WriteHeader(content.Status)
+	_, _ = io.Copy(w, content.Body)
+}
+
+// handlePin keeps a book in the content cache (PUT) or lets it be evicted
+// again (DELETE), so it stays readable while the share is offline.
+func (h *BooksHandler) handlePin(w http.ResponseWriter, r *http.Request, userID, bookID string) {
+	if h.webSvc == nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	err := h.webSvc.PinBook(r.Context(), userID, bookID, r.Method == http.MethodPut)
+	switch {
+	case err == nil:
+		w.WriteHeader(http.StatusNoContent)
+	case errors.Is(err, webdav.ErrCacheDisabled):
+		w.WriteHeader(http.StatusNotImplemented)
+	case errors.Is(err, books.ErrNotFound), errors.Is(err, webdav.ErrNotFound):
+		w.WriteHeader(http.StatusNotFound)
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// contentType prefers the type reported by the server unless it is the
+// generic octet-stream.
+func contentType(content webdav.Content, format string) string {
+	if content.ContentType == "" || strings.HasPrefix(content.ContentType, "application/octet-stream") {
+		return formats.MimeType(format)
+	}
+	return content.ContentType
+}
+
+func notModifiedSince(modified time.Time, header string) bool {
+	if modified.IsZero() || header == "" {
+		return false
+	}
+	since, err := http.ParseTime(header)
+	return err == nil && !modified.Truncate(time.Second).After(since)
+}
+
+func (h *BooksHandler) handleCover(w http.ResponseWriter, r *http.Request, userID string) {
+	path := strings.TrimPrefix(r.URL.Path, "/api/books/")
+	parts := strings.Split(path, "/")
+	if len(parts) != 2 || parts[1] != "cover" || parts[0] == "" || h.covers == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if _, err := h.store.GetByID(userID, parts[0]); err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	size := r.URL.Query().Get("size")
+	if size == "" {
+		size = covers.DefaultSize
+	}
+	if _, ok := covers.Sizes[size]; !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	entry, err := h.covers.Lookup(userID, parts[0], size)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	file, err := os.Open(entry.Path)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	defer file.Close()
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+	w.Header().Set("ETag", entry.ETag)
+	http.ServeContent(w, r, "", entry.ModTime, file)
+}
+
+func (h *BooksHandler) handleTOC(w http.ResponseWriter, r *http.Request, userID, bookID string) {
+	if book, err := h.store.GetByID(userID, bookID); err == nil && book.Format == "txt" {
+		h.handleTextTOC(w, r, userID, bookID)
+		return
+	}
+	h.handleBookTOC(w, r, userID, bookID)
+}
+
+func (h *BooksHandler) handleChapter(w http.ResponseWriter, r *http.Request, userID, bookID, rawIndex string) {
+	index, err := strconv.Atoi(rawIndex)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if book, err := h.store.GetByID(userID, bookID); err == nil && book.Format == "txt" {
+		h.handleTextChapter(w, r, userID, bookID, index)
+		return
+	}
+	h.handleBookChapter(w, r, userID, bookID, index)
+}
+
+// openBook fetches a book through the spool cache after checking that it
+// has one of the given formats. It writes the error response itself and
+// returns ok=false when the book cannot be read.
+func (h *BooksHandler) openBook(w http.ResponseWriter, r *http.Request, userID, bookID string, allowed ...string) (books.Book, *library.Spool, func(), bool) {
+	if h.spools == nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return books.Book{}, nil, nil, false
+	}
+	book, err := h.store.GetByID(userID, bookID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return books.Book{}, nil, nil, false
+	}
+	supported := false
+	for _, format := range allowed {
+		supported = supported || book.Format == format
+	}
+	if !supported {
+		http.Error(w, "unsupported format: "+book.Format, http.StatusUnsupportedMediaType)
+		return books.Book{}, nil, nil, false
+	}
+	spool, release, err := h.spools.Acquire(r.Context(), userID, bookID, book.UpdatedAt.String())
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return books.Book{}, nil, nil, false
+	}
+	return book, spool, release, true
 }
@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/EROQIN/relite-reader/backend/internal/books"
+	"github.com/EROQIN/relite-reader/backend/internal/comics"
+	"github.com/EROQIN/relite-reader/backend/internal/formats"
+)
+
+type comicPageResponse struct {
+	Index int    `json:"index"`
+	Name  string `json:"name"`
+	Size  int64  `json:"size"`
+	URL   string `json:"url"`
+}
+
+type comicInfoResponse struct {
+	Title       string `json:"title,omitempty"`
+	Series      string `json:"series,omitempty"`
+	Number      string `json:"number,omitempty"`
+	Volume      string `json:"volume,omitempty"`
+	Writer      string `json:"writer,omitempty"`
+	Publisher   string `json:"publisher,omitempty"`
+	Summary     string `json:"summary,omitempty"`
+	Year        int    `json:"year,omitempty"`
+	RightToLeft bool   `json:"right_to_left"`
+}
+
+type comicPagesResponse struct {
+	Pages []comicPageResponse `json:"pages"`
+	Info  *comicInfoResponse  `json:"info,omitempty"`
+}
+
+var comicFormats = []string{"cbz", "cbt", "cbr", "cb7", "cba"}
+
+func (h *BooksHandler) openComic(w http.ResponseWriter, r *http.Request, userID, bookID string) (books.Book, *comics.Archive, func(), bool) {
+	book, spool, release, ok := h.openBook(w, r, userID, bookID, comicFormats...)
+	if !ok {
+		return books.Book{}, nil, nil, false
+	}
+	archive, err := comics.Open(book.Format, spool, spool.Size)
+	if err != nil {
+		release()
+		if err == comics.ErrUnsupported {
+			http.Error(w, book.Format+" archives are not supported yet", http.StatusUnsupportedMediaType)
+			return books.Book{}, nil, nil, false
+		}
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		return books.Book{}, nil, nil, false
+	}
+	return book, archive, release, true
+}
+
+func (h *BooksHandler) handlePages(w http.ResponseWriter, r *http.Request, userID, bookID string) {
+	_, archive, release, ok := h.openComic(w, r, userID, bookID)
+	if !ok {
+		return
+	}
+	defer release()
+	base := "/api/books/" + url.PathEscape(bookID) + "/pages/"
+	resp := comicPagesResponse{Pages: []comicPageResponse{}}
+	for i, page := range archive.Pages() {
+		resp.Pages = append(resp.Pages, comicPageResponse{
+			Index: i,
+			Name:  page.Name,
+			Size:  page.Size,
+			URL:   base + strconv.Itoa(i),
+		})
+	}
+	if info, ok := archive.Info(); ok {
+		resp.Info = &comicInfoResponse{
+			Title:       info.Title,
+			Series:      info.Series,
+			Number:      info.Number,
+			Volume:      info.Volume,
+			Writer:      info.Writer,
+			Publisher:   info.Publisher,
+			Summary:     info.Summary,
+			Year:        info.Year,
+			RightToLeft: info.RightToLeft(),
+		}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (h *BooksHandler) handlePage(w http.ResponseWriter, r *http.Request, userID, bookID, rawIndex string) {
+	index, err := strconv.Atoi(rawIndex)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	book, archive, release, ok := h.openComic(w, r, userID, bookID)
+	if !ok {
+		return
+	}
+	defer release()
+	reader, page, err := archive.PageReader(index)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", formats.ImageType(page.Name))
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	w.Header().Set("ETag", fmt.Sprintf(`"%s-%d-%d"`, book.ID, book.UpdatedAt.UnixNano(), index))
+	http.ServeContent(w, r, "", book.UpdatedAt, reader)
+}
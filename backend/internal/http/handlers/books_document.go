@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/EROQIN/relite-reader/backend/internal/document"
+	"github.com/EROQIN/relite-reader/backend/internal/fb2"
+	"github.com/EROQIN/relite-reader/backend/internal/formats"
+	"github.com/EROQIN/relite-reader/backend/internal/plaintext"
+)
+
+// maxDocumentSize bounds the Markdown, HTML and FB2 books that are
+// converted in memory, and the media served from office packages.
+const maxDocumentSize = 64 << 20
+
+var documentFormats = []string{"md", "markdown", "html", "htm", "fb2", "docx", "odt"}
+
+// openDocument converts a book into the reader document model. Images are
+// mapped to resource URLs for FB2 binaries and office package media;
+// other books keep only inline data: images.
+func (h *BooksHandler) openDocument(w http.ResponseWriter, r *http.Request, userID, bookID string) (*document.Document, bool) {
+	book, spool, release, ok := h.openBook(w, r, userID, bookID, documentFormats...)
+	if !ok {
+		return nil, false
+	}
+	defer release()
+	var doc *document.Document
+	var err error
+	// resource reports whether an image reference names an embedded file.
+	resource := func(string) bool { return false }
+	switch book.Format {
+	case "docx", "odt":
+		var archive *zip.Reader
+		if archive, err = zip.NewReader(spool, spool.Size); err != nil {
+			break
+		}
+		if book.Format == "docx" {
+			doc, err = document.FromDOCX(archive)
+		} else {
+			doc, err = document.FromODT(archive)
+		}
+		resource = func(name string) bool { return packageImage(archive, name) != nil }
+	default:
+		var data []byte
+		data, err = io.ReadAll(io.NewSectionReader(spool, 0, min(spool.Size, maxDocumentSize)))
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return nil, false
+		}
+		switch book.Format {
+		case "md", "markdown":
+			text, _ := plaintext.Decode(data)
+			doc = document.FromMarkdown(text)
+		case "html", "htm":
+			doc, err = document.FromHTML(data)
+		case "fb2":
+			var parsed *fb2.Document
+			if parsed, err = fb2.Parse(bytes.NewReader(data)); err == nil {
+				doc = document.FromFB2(parsed)
+				resource = func(ref string) bool {
+					id, ok := strings.CutPrefix(ref, "#")
+					_, found := parsed.Binaries[id]
+					return ok && found
+				}
+			}
+		}
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		return nil, false
+	}
+	if doc.Title == "" {
+		doc.Title = book.Title
+	}
+	urls := epubURLs(bookID)
+	doc.MapImages(func(src string) (string, bool) {
+		if resource(src) {
+			return urls.Resource(strings.TrimPrefix(src, "#")), true
+		}
+		return src, strings.HasPrefix(strings.ToLower(src), "data:image/")
+	})
+	return doc, true
+}
+
+// packageImage finds an image member of an office package.
+func packageImage(archive *zip.Reader, name string) *zip.File {
+	if formats.ImageType(name) == "application/octet-stream" {
+		return nil
+	}
+	for _, file := range archive.File {
+		if file.Name == name {
+			return file
+		}
+	}
+	return nil
+}
+
+func (h *BooksHandler) handleDocument(w http.ResponseWriter, r *http.Request, userID, bookID string) {
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "html" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	doc, ok := h.openDocument(w, r, userID, bookID)
+	if !ok {
+		return
+	}
+	if format != "html" {
+		writeJSON(w, http.StatusOK, doc)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xhtml+xml; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; img-src 'self' data:; style-src 'self' 'unsafe-inline'")
+	_, _ = w.Write(document.RenderHTML(doc))
+}
+
+// handleFB2Resource serves an embedded FB2 binary, usually an image.
+func (h *BooksHandler) handleFB2Resource(w http.ResponseWriter, r *http.Request, userID, bookID, id string) {
+	_, spool, release, ok := h.openBook(w, r, userID, bookID, "fb2")
+	if !ok {
+		return
+	}
+	defer release()
+	parsed, err := fb2.Parse(io.NewSectionReader(spool, 0, min(spool.Size, maxDocumentSize)))
+	if err != nil {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		return
+	}
+	binary, found := parsed.Binaries[id]
+	if !found {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	writeResource(w, binary.Data, binary.ContentType)
+}
+
+// handlePackageResource serves an image embedded in a DOCX or ODT file.
+func (h *BooksHandler) handlePackageResource(w http.ResponseWriter, r *http.Request, userID, bookID, name string) {
+	_, spool, release, ok := h.openBook(w, r, userID, bookID, "docx", "odt")
+	if !ok {
+		return
+	}
+	defer release()
+	archive, err := zip.NewReader(spool, spool.Size)
+	if err != nil {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		return
+	}
+	file := packageImage(archive, name)
+	if file == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	rc, err := file.Open()
+	if err != nil {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		return
+	}
+	defer rc.Close()
+	w.Header().Set("Content-Type", formats.ImageType(name))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	_, _ = io.Copy(w, io.LimitReader(rc, maxDocumentSize))
+}
@@ -0,0 +1,202 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/EROQIN/relite-reader/backend/internal/epub"
+	"github.com/EROQIN/relite-reader/backend/internal/mobi"
+)
+
+type chapterResponse struct {
+	Index  int    `json:"index"`
+	ID     string `json:"id"`
+	Href   string `json:"href"`
+	Linear bool   `json:"linear"`
+	URL    string `json:"url"`
+}
+
+type tocEntryResponse struct {
+	Title    string             `json:"title"`
+	Chapter  int                `json:"chapter"`
+	Fragment string             `json:"fragment,omitempty"`
+	URL      string             `json:"url,omitempty"`
+	Children []tocEntryResponse `json:"children,omitempty"`
+}
+
+type tocResponse struct {
+	Title    string             `json:"title"`
+	Chapters []chapterResponse  `json:"chapters"`
+	Toc      []tocEntryResponse `json:"toc"`
+}
+
+func (h *BooksHandler) openEPUB(w http.ResponseWriter, r *http.Request, userID, bookID string) (*epub.Book, func(), bool) {
+	_, spool, release, ok := h.openBook(w, r, userID, bookID, "epub")
+	if !ok {
+		return nil, nil, false
+	}
+	parsed, err := epub.Open(spool, spool.Size)
+	if err != nil {
+		release()
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		return nil, nil, false
+	}
+	return parsed, release, true
+}
+
+// chapterBook is a reflowable book served through the chapter API.
+type chapterBook interface {
+	Chapters() []epub.Chapter
+	TOC() []epub.TocEntry
+	RenderChapter(index int, urls epub.URLMapper) ([]byte, error)
+	Resource(href string, urls epub.URLMapper) ([]byte, string, error)
+}
+
+// openChapters opens an EPUB or Kindle book and returns it with its title.
+// Kindle text is decoded up front so broken books fail here.
+func (h *BooksHandler) openChapters(w http.ResponseWriter, r *http.Request, userID, bookID string) (chapterBook, string, func(), bool) {
+	book, spool, release, ok := h.openBook(w, r, userID, bookID, append([]string{"epub"}, mobiFormats...)...)
+	if !ok {
+		return nil, "", nil, false
+	}
+	if book.Format == "epub" {
+		parsed, err := epub.Open(spool, spool.Size)
+		if err != nil {
+			release()
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			return nil, "", nil, false
+		}
+		return parsed, parsed.Metadata.Title, release, true
+	}
+	parsed, err := mobi.Open(spool, spool.Size)
+	if err == nil {
+		err = parsed.Load()
+	}
+	if err != nil {
+		release()
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		return nil, "", nil, false
+	}
+	return parsed, parsed.Metadata.Title, release, true
+}
+
+func epubURLs(bookID string) epub.URLMapper {
+	base := "/api/books/" + url.PathEscape(bookID)
+	return epub.URLMapper{
+		Chapter: func(index int, fragment string) string {
+			target := base + "/chapters/" + strconv.Itoa(index)
+			if fragment != "" {
+				target += "#" + fragment
+			}
+			return target
+		},
+		Resource: func(href string) string {
+			segments := strings.Split(href, "/")
+			for i, segment := range segments {
+				segments[i] = url.PathEscape(segment)
+			}
+			return base + "/resources/" + strings.Join(segments, "/")
+		},
+	}
+}
+
+func (h *BooksHandler) handleBookTOC(w http.ResponseWriter, r *http.Request, userID, bookID string) {
+	book, title, release, ok := h.openChapters(w, r, userID, bookID)
+	if !ok {
+		return
+	}
+	defer release()
+	urls := epubURLs(bookID)
+	resp := tocResponse{Title: title, Chapters: []chapterResponse{}}
+	for _, chapter := range book.Chapters() {
+		resp.Chapters = append(resp.Chapters, chapterResponse{
+			Index:  chapter.Index,
+			ID:     chapter.ID,
+			Href:   chapter.Href,
+			Linear: chapter.Linear,
+			URL:    urls.Chapter(chapter.Index, ""),
+		})
+	}
+	resp.Toc = tocEntries(book.TOC(), urls)
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func tocEntries(entries []epub.TocEntry, urls epub.URLMapper) []tocEntryResponse {
+	out := make([]tocEntryResponse, 0, len(entries))
+	for _, entry := range entries {
+		item := tocEntryResponse{
+			Title:    entry.Title,
+			Chapter:  entry.Chapter,
+			Fragment: entry.Fragment,
+		}
+		if entry.Chapter >= 0 {
+			item.URL = urls.Chapter(entry.Chapter, entry.Fragment)
+		}
+		if len(entry.Children) > 0 {
+			item.Children = tocEntries(entry.Children, urls)
+		}
+		out = append(out, item)
+	}
+	return out
+}
+
+func (h *BooksHandler) handleBookChapter(w http.ResponseWriter, r *http.Request, userID, bookID string, index int) {
+	book, _, release, ok := h.openChapters(w, r, userID, bookID)
+	if !ok {
+		return
+	}
+	defer release()
+	body, err := book.RenderChapter(index, epubURLs(bookID))
+	if err != nil {
+		if err == epub.ErrChapterRange {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xhtml+xml; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; img-src 'self' data:; style-src 'self' 'unsafe-inline'; font-src 'self' data:")
+	_, _ = w.Write(body)
+}
+
+func (h *BooksHandler) handleResource(w http.ResponseWriter, r *http.Request, userID, bookID, href string) {
+	if book, err := h.store.GetByID(userID, bookID); err == nil {
+		switch book.Format {
+		case "fb2":
+			h.handleFB2Resource(w, r, userID, bookID, href)
+			return
+		case "docx", "odt":
+			h.handlePackageResource(w, r, userID, bookID, href)
+			return
+		case "mobi", "azw", "azw3", "azw4":
+			h.handleMOBIResource(w, r, userID, bookID, href)
+			return
+		}
+	}
+	book, release, ok := h.openEPUB(w, r, userID, bookID)
+	if !ok {
+		return
+	}
+	defer release()
+	data, mediaType, err := book.Resource(href, epubURLs(bookID))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	writeResource(w, data, mediaType)
+}
+
+// writeResource serves a file embedded in a book.
+func writeResource(w http.ResponseWriter, data []byte, mediaType string) {
+	if mediaType == "" {
+		mediaType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", mediaType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	// SVG images may carry script; keep them inert when opened directly.
+	w.Header().Set("Content-Security-Policy", "sandbox; default-src 'none'; img-src 'self' data:; style-src 'unsafe-inline'")
+	_, _ = w.Write(data)
+}
@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/EROQIN/relite-reader/backend/internal/mobi"
+)
+
+// mobiFormats are the Kindle formats read by the mobi package.
+var mobiFormats = []string{"mobi", "azw", "azw3", "azw4"}
+
+// handleMOBIResource serves an image record or KF8 stylesheet without
+// decoding the book text.
+func (h *BooksHandler) handleMOBIResource(w http.ResponseWriter, r *http.Request, userID, bookID, href string) {
+	_, spool, release, ok := h.openBook(w, r, userID, bookID, mobiFormats...)
+	if !ok {
+		return
+	}
+	defer release()
+	parsed, err := mobi.Open(spool, spool.Size)
+	if err != nil {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		return
+	}
+	data, mediaType, err := parsed.Resource(href, epubURLs(bookID))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	writeResource(w, data, mediaType)
+}
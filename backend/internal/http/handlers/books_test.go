@@ -1,15 +1,30 @@
 package handlers_test
 
 import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/binary"
 	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/EROQIN/relite-reader/backend/internal/auth"
 	"github.com/EROQIN/relite-reader/backend/internal/books"
+	"github.com/EROQIN/relite-reader/backend/internal/covers"
 	"github.com/EROQIN/relite-reader/backend/internal/http/handlers"
 	"github.com/EROQIN/relite-reader/backend/internal/users"
+	"github.com/EROQIN/relite-reader/backend/internal/webdav"
+	"golang.org/x/text/encoding/simplifiedchinese"
 )
 
 type booksResponse struct {
@@ -23,7 +38,7 @@ type booksResponse struct {
 
 func TestBooksHandlerRequiresAuth(t *testing.T) {
 	store := books.NewMemoryStore()
-	h := handlers.NewBooksHandler([]byte("jwt"), store, nil)
+	h := handlers.NewBooksHandler([]byte("jwt"), store, nil, nil)
 	req := httptest.NewRequest(http.MethodGet, "/api/books", nil)
 	resp := httptest.NewRecorder()
 	h.ServeHTTP(resp, req)
@@ -44,7 +59,7 @@ func TestBooksHandlerListsBooks(t *testing.T) {
 	_, _ = store.Upsert(user.ID, books.Book{SourcePath: "/b.pdf", Title: "B", Format: "pdf"})
 	_ = store.MarkMissing(user.ID, []string{"/b.pdf"})
 
-	h := handlers.NewBooksHandler(secret, store, nil)
+	h := handlers.NewBooksHandler(secret, store, nil, nil)
 	req := httptest.NewRequest(http.MethodGet, "/api/books", nil)
 	req.Header.Set("Authorization", "Bearer "+token)
 	resp := httptest.NewRecorder()
@@ -60,3 +75,707 @@ func TestBooksHandlerListsBooks(t *testing.T) {
 		t.Fatalf("expected 2 books, got %d", len(payload))
 	}
 }
+
+func TestBooksHandlerServesCover(t *testing.T) {
+	userStore := users.NewMemoryStore()
+	authSvc := auth.NewService(userStore)
+	user, _ := authSvc.Register("reader@example.com", "secret")
+	secret := []byte("jwt")
+	token, _ := auth.NewToken(secret, user.ID)
+
+	store := books.NewMemoryStore()
+	book, _ := store.Upsert(user.ID, books.Book{SourcePath: "/a.epub", Title: "A", Format: "epub"})
+	cache := covers.NewCache(t.TempDir())
+	var img bytes.Buffer
+	_ = png.Encode(&img, image.NewRGBA(image.Rect(0, 0, 600, 900)))
+	if err := cache.Store(user.ID, book.ID, img.Bytes()); err != nil {
+		t.Fatalf("store cover: %v", err)
+	}
+
+	h := handlers.NewBooksHandler(secret, store, nil, cache)
+	req := httptest.NewRequest(http.MethodGet, "/api/books/"+book.ID+"/cover?size=small", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp := httptest.NewRecorder()
+	h.ServeHTTP(resp, req)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.Code)
+	}
+	etag := resp.Header().Get("ETag")
+	if resp.Header().Get("Content-Type") != "image/jpeg" || etag == "" || resp.Header().Get("Cache-Control") == "" {
+		t.Fatalf("unexpected headers %v", resp.Header())
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/books/"+book.ID+"/cover?size=small", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("If-None-Match", etag)
+	resp = httptest.NewRecorder()
+	h.ServeHTTP(resp, req)
+	if resp.Code != http.StatusNotModified {
+		t.Fatalf("expected 304, got %d", resp.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/books/"+book.ID+"/cover?size=huge", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp = httptest.NewRecorder()
+	h.ServeHTTP(resp, req)
+	if resp.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", resp.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/books", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp = httptest.NewRecorder()
+	h.ServeHTTP(resp, req)
+	var payload []struct {
+		CoverURL string `json:"cover_url"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(payload) != 1 || payload[0].CoverURL != "/api/books/"+book.ID+"/cover" {
+		t.Fatalf("unexpected cover url %+v", payload)
+	}
+}
+
+type contentClient struct{ data []byte }
+
+func (c contentClient) List(_ context.Context, _, _, _ string, _ func(string) bool) ([]webdav.Entry, error) {
+	return nil, nil
+}
+
+func (c contentClient) Fetch(_ context.Context, _, _, _, _ string, _ webdav.ContentRequest) (webdav.Content, error) {
+	return webdav.Content{
+		Status:      http.StatusOK,
+		Body:        io.NopCloser(bytes.NewReader(c.data)),
+		ContentType: "application/epub+zip",
+		Length:      int64(len(c.data)),
+	}, nil
+}
+
+func (contentClient) Put(_ context.Context, _, _, _, _ string, _ io.ReadSeeker, _ int64, _ string) (string, error) {
+	return "", nil
+}
+
+func (contentClient) MakeCollection(_ context.Context, _, _, _, _ string) error {
+	return nil
+}
+
+func (contentClient) Stat(_ context.Context, _, _, _, filePath string) (webdav.Entry, error) {
+	return webdav.Entry{Path: filePath}, nil
+}
+
+func buildReaderEPUB(t *testing.T) []byte {
+	t.Helper()
+	files := map[string]string{
+		"META-INF/container.xml": `<container><rootfiles><rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/></rootfiles></container>`,
+		"OEBPS/content.opf": `<package xmlns="http://www.idpf.org/2007/opf" version="2.0">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/"><dc:title>Reader Test</dc:title></metadata>
+  <manifest>
+    <item id="ncx" href="toc.ncx" media-type="application/x-dtbncx+xml"/>
+    <item id="css" href="style.css" media-type="text/css"/>
+    <item id="img" href="images/map.png" media-type="image/png"/>
+    <item id="c1" href="one.xhtml" media-type="application/xhtml+xml"/>
+  </manifest>
+  <spine toc="ncx"><itemref idref="c1"/></spine>
+</package>`,
+		"OEBPS/toc.ncx":        `<ncx><navMap><navPoint><navLabel><text>One</text></navLabel><content src="one.xhtml#start"/></navPoint></navMap></ncx>`,
+		"OEBPS/style.css":      `body { background: url(images/map.png) }`,
+		"OEBPS/images/map.png": "png",
+		"OEBPS/one.xhtml":      `<html xmlns="http://www.w3.org/1999/xhtml"><head><link rel="stylesheet" href="style.css"/></head><body><p id="start" onclick="x()">Hello<img src="images/map.png"/></p></body></html>`,
+	}
+	var buf bytes.Buffer
+	writer := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, _ := writer.Create(name)
+		_, _ = w.Write([]byte(content))
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestBooksHandlerServesEPUBChapters(t *testing.T) {
+	userStore := users.NewMemoryStore()
+	authSvc := auth.NewService(userStore)
+	user, _ := authSvc.Register("reader@example.com", "secret")
+	secret := []byte("jwt")
+	token, _ := auth.NewToken(secret, user.ID)
+
+	store := books.NewMemoryStore()
+	key, _ := webdav.ParseKey("00112233445566778899aabbccddeeff00112233445566778899aabbccddeeff")
+	webSvc := webdav.NewService(webdav.NewMemoryStore(), contentClient{data: buildReaderEPUB(t)}, key, store, nil, nil)
+	conn, err := webSvc.Create(context.Background(), user.ID, "https://dav.example.com", "reader", "pw", webdav.Filter{}, "", "")
+	if err != nil {
+		t.Fatalf("create connection: %v", err)
+	}
+	book, _ := store.Upsert(user.ID, books.Book{SourcePath: "/a.epub", Title: "a", Format: "epub", ConnectionID: conn.ID})
+	h := handlers.NewBooksHandler(secret, store, webSvc, nil)
+	get := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp := httptest.NewRecorder()
+		h.ServeHTTP(resp, req)
+		return resp
+	}
+	base := "/api/books/" + book.ID
+
+	resp := get(base + "/toc")
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.Code)
+	}
+	var toc struct {
+		Title    string `json:"title"`
+		Chapters []struct {
+			URL string `json:"url"`
+		} `json:"chapters"`
+		Toc []struct {
+			Title string `json:"title"`
+			URL   string `json:"url"`
+		} `json:"toc"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&toc); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if toc.Title != "Reader Test" || len(toc.Chapters) != 1 || len(toc.Toc) != 1 || toc.Toc[0].URL != base+"/chapters/0#start" {
+		t.Fatalf("unexpected toc %+v", toc)
+	}
+
+	resp = get(base + "/chapters/0")
+	body := resp.Body.String()
+	if resp.Code != http.StatusOK || !strings.HasPrefix(resp.Header().Get("Content-Type"), "application/xhtml+xml") {
+		t.Fatalf("unexpected chapter response %d %v", resp.Code, resp.Header())
+	}
+	if strings.Contains(body, "onclick") || !strings.Contains(body, `src="`+base+`/resources/OEBPS/images/map.png"`) {
+		t.Fatalf("unexpected chapter body %s", body)
+	}
+
+	resp = get(base + "/resources/OEBPS/style.css")
+	if resp.Code != http.StatusOK || !strings.Contains(resp.Body.String(), base+"/resources/OEBPS/images/map.png") {
+		t.Fatalf("unexpected stylesheet %d %s", resp.Code, resp.Body.String())
+	}
+	if resp := get(base + "/resources/OEBPS/one.xhtml"); resp.Code != http.StatusNotFound {
+		t.Fatalf("expected content documents to be hidden, got %d", resp.Code)
+	}
+	if resp := get(base + "/chapters/3"); resp.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for missing chapter, got %d", resp.Code)
+	}
+}
+
+func TestBooksHandlerServesComicPages(t *testing.T) {
+	userStore := users.NewMemoryStore()
+	authSvc := auth.NewService(userStore)
+	user, _ := authSvc.Register("reader@example.com", "secret")
+	secret := []byte("jwt")
+	token, _ := auth.NewToken(secret, user.ID)
+
+	var archive bytes.Buffer
+	writer := zip.NewWriter(&archive)
+	for _, name := range []string{"page10.jpg", "page9.jpg", "ComicInfo.xml"} {
+		w, _ := writer.Create(name)
+		if name == "ComicInfo.xml" {
+			_, _ = w.Write([]byte(`<ComicInfo><Series>Saga</Series><Number>12</Number><Writer>Brian K. Vaughan</Writer></ComicInfo>`))
+			continue
+		}
+		_, _ = w.Write([]byte("jpeg:" + name))
+	}
+	_ = writer.Close()
+
+	store := books.NewMemoryStore()
+	key, _ := webdav.ParseKey("00112233445566778899aabbccddeeff00112233445566778899aabbccddeeff")
+	webSvc := webdav.NewService(webdav.NewMemoryStore(), contentClient{data: archive.Bytes()}, key, store, nil, nil)
+	conn, _ := webSvc.Create(context.Background(), user.ID, "https://dav.example.com", "reader", "pw", webdav.Filter{}, "", "")
+	book, _ := store.Upsert(user.ID, books.Book{SourcePath: "/saga-12.cbz", Title: "saga-12", Format: "cbz", ConnectionID: conn.ID})
+	sevenZip, _ := store.Upsert(user.ID, books.Book{SourcePath: "/a.cb7", Title: "a", Format: "cb7", ConnectionID: conn.ID})
+	h := handlers.NewBooksHandler(secret, store, webSvc, nil)
+	get := func(path, rangeHeader string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		if rangeHeader != "" {
+			req.Header.Set("Range", rangeHeader)
+		}
+		resp := httptest.NewRecorder()
+		h.ServeHTTP(resp, req)
+		return resp
+	}
+
+	resp := get("/api/books/"+book.ID+"/pages", "")
+	var listing struct {
+		Pages []struct {
+			Name string `json:"name"`
+			URL  string `json:"url"`
+		} `json:"pages"`
+		Info struct {
+			Series string `json:"series"`
+			Number string `json:"number"`
+			Writer string `json:"writer"`
+		} `json:"info"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&listing); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(listing.Pages) != 2 || listing.Pages[0].Name != "page9.jpg" {
+		t.Fatalf("unexpected pages %+v", listing.Pages)
+	}
+	if listing.Info.Series != "Saga" || listing.Info.Number != "12" || listing.Info.Writer != "Brian K. Vaughan" {
+		t.Fatalf("unexpected info %+v", listing.Info)
+	}
+
+	resp = get(listing.Pages[1].URL, "bytes=5-")
+	if resp.Code != http.StatusPartialContent || resp.Body.String() != "page10.jpg" {
+		t.Fatalf("unexpected range response %d %q", resp.Code, resp.Body.String())
+	}
+	if resp.Header().Get("Content-Type") != "image/jpeg" || resp.Header().Get("ETag") == "" {
+		t.Fatalf("unexpected headers %v", resp.Header())
+	}
+	if resp := get("/api/books/"+book.ID+"/pages/2", ""); resp.Code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d", resp.Code)
+	}
+	if resp := get("/api/books/"+sevenZip.ID+"/pages", ""); resp.Code != http.StatusUnsupportedMediaType {
+		t.Fatalf("expected 415 for cb7, got %d", resp.Code)
+	}
+}
+
+func TestBooksHandlerTranscodesTextBooks(t *testing.T) {
+	userStore := users.NewMemoryStore()
+	authSvc := auth.NewService(userStore)
+	user, _ := authSvc.Register("reader@example.com", "secret")
+	secret := []byte("jwt")
+	token, _ := auth.NewToken(secret, user.ID)
+
+	novel := "第一章 初入江湖\n他走出了山门，心中想着师父说过的话。\n第二章 风云再起\n这一年，天下大乱。\n"
+	encoded, _ := simplifiedchinese.GBK.NewEncoder().Bytes([]byte(novel))
+	store := books.NewMemoryStore()
+	key, _ := webdav.ParseKey("00112233445566778899aabbccddeeff00112233445566778899aabbccddeeff")
+	webSvc := webdav.NewService(webdav.NewMemoryStore(), contentClient{data: encoded}, key, store, nil, nil)
+	conn, _ := webSvc.Create(context.Background(), user.ID, "https://dav.example.com", "reader", "pw", webdav.Filter{}, "", "")
+	book, _ := store.Upsert(user.ID, books.Book{SourcePath: "/novel.txt", Title: "novel", Format: "txt", ConnectionID: conn.ID})
+	h := handlers.NewBooksHandler(secret, store, webSvc, nil)
+	get := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp := httptest.NewRecorder()
+		h.ServeHTTP(resp, req)
+		return resp
+	}
+
+	resp := get("/api/books/" + book.ID + "/content")
+	if resp.Body.String() != novel || resp.Header().Get("Content-Type") != "text/plain; charset=utf-8" {
+		t.Fatalf("unexpected content %q %v", resp.Body.String(), resp.Header())
+	}
+	if resp.Header().Get("X-Source-Charset") != "gb18030" {
+		t.Fatalf("unexpected charset %q", resp.Header().Get("X-Source-Charset"))
+	}
+
+	resp = get("/api/books/" + book.ID + "/toc")
+	var toc struct {
+		Toc []struct {
+			Title string `json:"title"`
+			URL   string `json:"url"`
+		} `json:"toc"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&toc); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(toc.Toc) != 2 || toc.Toc[1].Title != "第二章 风云再起" {
+		t.Fatalf("unexpected toc %+v", toc.Toc)
+	}
+	resp = get(toc.Toc[1].URL)
+	if resp.Body.String() != "第二章 风云再起\n这一年，天下大乱。\n" {
+		t.Fatalf("unexpected chapter %q", resp.Body.String())
+	}
+}
+
+func TestBooksHandlerServesFB2Document(t *testing.T) {
+	userStore := users.NewMemoryStore()
+	authSvc := auth.NewService(userStore)
+	user, _ := authSvc.Register("reader@example.com", "secret")
+	secret := []byte("jwt")
+	token, _ := auth.NewToken(secret, user.ID)
+
+	source := `<?xml version="1.0" encoding="utf-8"?>
+<FictionBook xmlns="http://www.gribuser.ru/xml/fictionbook/2.0" xmlns:l="http://www.w3.org/1999/xlink">
+<description><title-info><book-title>Tale</book-title></title-info></description>
+<body><section><title><p>One</p></title><p>Hi<a l:href="#n1" type="note">1</a> <a l:href="javascript:x()">there</a></p><image l:href="#pic.png"/></section></body>
+<body name="notes"><section id="n1"><p>A note</p></section></body>
+<binary id="pic.png" content-type="image/png">iVBORw0KGgo=</binary>
+</FictionBook>`
+	store := books.NewMemoryStore()
+	key, _ := webdav.ParseKey("00112233445566778899aabbccddeeff00112233445566778899aabbccddeeff")
+	webSvc := webdav.NewService(webdav.NewMemoryStore(), contentClient{data: []byte(source)}, key, store, nil, nil)
+	conn, _ := webSvc.Create(context.Background(), user.ID, "https://dav.example.com", "reader", "pw", webdav.Filter{}, "", "")
+	book, _ := store.Upsert(user.ID, books.Book{SourcePath: "/tale.fb2", Title: "tale", Format: "fb2", ConnectionID: conn.ID})
+	h := handlers.NewBooksHandler(secret, store, webSvc, nil)
+	get := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp := httptest.NewRecorder()
+		h.ServeHTTP(resp, req)
+		return resp
+	}
+
+	resp := get("/api/books/" + book.ID + "/document")
+	if strings.Contains(resp.Body.String(), "javascript") {
+		t.Fatalf("unsafe link in json %s", resp.Body.String())
+	}
+	var doc struct {
+		Title    string `json:"title"`
+		Sections []struct {
+			ID     string `json:"id"`
+			Title  string `json:"title"`
+			Blocks []struct {
+				ID   string `json:"id"`
+				Kind string `json:"kind"`
+				Src  string `json:"src"`
+			} `json:"blocks"`
+		} `json:"sections"`
+		Footnotes []struct {
+			ID string `json:"id"`
+		} `json:"footnotes"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if doc.Title != "Tale" || len(doc.Sections) != 1 || doc.Sections[0].Title != "One" || len(doc.Sections[0].Blocks) != 3 {
+		t.Fatalf("unexpected document %+v", doc)
+	}
+	image := doc.Sections[0].Blocks[2]
+	if image.Kind != "image" || image.Src != "/api/books/"+book.ID+"/resources/pic.png" {
+		t.Fatalf("unexpected image %+v", image)
+	}
+	if len(doc.Footnotes) != 1 || doc.Footnotes[0].ID != "fn-n1" {
+		t.Fatalf("unexpected footnotes %+v", doc.Footnotes)
+	}
+
+	resp = get("/api/books/" + book.ID + "/document?format=html")
+	body := resp.Body.String()
+	if !strings.Contains(body, `<p id="b2">Hi<sup><a href="#fn-n1" role="doc-noteref">1</a></sup> there</p>`) || strings.Contains(body, "javascript") {
+		t.Fatalf("unexpected html %s", body)
+	}
+
+	resp = get(image.Src)
+	if resp.Code != http.StatusOK || resp.Header().Get("Content-Type") != "image/png" || !bytes.HasPrefix(resp.Body.Bytes(), []byte("\x89PNG")) {
+		t.Fatalf("unexpected resource %d %v", resp.Code, resp.Header())
+	}
+	if resp := get("/api/books/" + book.ID + "/document?format=pdf"); resp.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for unknown format, got %d", resp.Code)
+	}
+}
+
+func TestBooksHandlerServesDOCXDocument(t *testing.T) {
+	userStore := users.NewMemoryStore()
+	authSvc := auth.NewService(userStore)
+	user, _ := authSvc.Register("reader@example.com", "secret")
+	secret := []byte("jwt")
+	token, _ := auth.NewToken(secret, user.ID)
+
+	var pixel bytes.Buffer
+	_ = png.Encode(&pixel, image.NewRGBA(image.Rect(0, 0, 1, 1)))
+	var archive bytes.Buffer
+	writer := zip.NewWriter(&archive)
+	for name, content := range map[string]string{
+		"word/document.xml": `<w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships" xmlns:a="http://schemas.openxmlformats.org/drawingml/2006/main"><w:body>
+<w:p><w:r><w:t>Hello design</w:t></w:r></w:p>
+<w:p><w:r><w:drawing><a:blip r:embed="rId1"/></w:drawing></w:r></w:p>
+</w:body></w:document>`,
+		"word/_rels/document.xml.rels": `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Target="media/image1.png"/></Relationships>`,
+		"word/media/image1.png":        pixel.String(),
+	} {
+		w, _ := writer.Create(name)
+		_, _ = w.Write([]byte(content))
+	}
+	_ = writer.Close()
+	store := books.NewMemoryStore()
+	key, _ := webdav.ParseKey("00112233445566778899aabbccddeeff00112233445566778899aabbccddeeff")
+	webSvc := webdav.NewService(webdav.NewMemoryStore(), contentClient{data: archive.Bytes()}, key, store, nil, nil)
+	conn, _ := webSvc.Create(context.Background(), user.ID, "https://dav.example.com", "reader", "pw", webdav.Filter{}, "", "")
+	book, _ := store.Upsert(user.ID, books.Book{SourcePath: "/design.docx", Title: "design", Format: "docx", ConnectionID: conn.ID})
+	h := handlers.NewBooksHandler(secret, store, webSvc, nil)
+	get := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp := httptest.NewRecorder()
+		h.ServeHTTP(resp, req)
+		return resp
+	}
+
+	resp := get("/api/books/" + book.ID + "/document?format=html")
+	imageURL := "/api/books/" + book.ID + "/resources/word/media/image1.png"
+	body := resp.Body.String()
+	if resp.Code != http.StatusOK || !strings.Contains(body, `<p id="b1">Hello design</p>`) || !strings.Contains(body, `<img src="`+imageURL+`"`) {
+		t.Fatalf("unexpected html %d %s", resp.Code, body)
+	}
+	resp = get(imageURL)
+	if resp.Code != http.StatusOK || resp.Header().Get("Content-Type") != "image/png" || !bytes.Equal(resp.Body.Bytes(), pixel.Bytes()) {
+		t.Fatalf("unexpected image %d %v", resp.Code, resp.Header())
+	}
+	if resp := get("/api/books/" + book.ID + "/resources/word/document.xml"); resp.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for non-image part, got %d", resp.Code)
+	}
+}
+
+// buildMOBI returns an uncompressed MOBI 6 book whose text is split at
+// page breaks, with one image record.
+func buildMOBI(text string, image []byte) []byte {
+	rec := make([]byte, 16+0xe8)
+	binary.BigEndian.PutUint16(rec[0:], 1)
+	binary.BigEndian.PutUint32(rec[4:], uint32(len(text)))
+	binary.BigEndian.PutUint16(rec[8:], 1)
+	copy(rec[16:], "MOBI")
+	binary.BigEndian.PutUint32(rec[20:], 0xe8)
+	binary.BigEndian.PutUint32(rec[28:], 65001)
+	binary.BigEndian.PutUint32(rec[0x24:], 6)
+	binary.BigEndian.PutUint32(rec[0x6c:], 2)
+	binary.BigEndian.PutUint32(rec[0xf4:], 0xffffffff)
+	records := [][]byte{rec, []byte(text), image}
+	out := make([]byte, 78+len(records)*8+2)
+	copy(out[60:], "BOOKMOBI")
+	binary.BigEndian.PutUint16(out[76:], uint16(len(records)))
+	offset := len(out)
+	for i, record := range records {
+		binary.BigEndian.PutUint32(out[78+i*8:], uint32(offset))
+		offset += len(record)
+	}
+	for _, record := range records {
+		out = append(out, record...)
+	}
+	return out
+}
+
+func TestBooksHandlerServesMOBIChapters(t *testing.T) {
+	userStore := users.NewMemoryStore()
+	authSvc := auth.NewService(userStore)
+	user, _ := authSvc.Register("reader@example.com", "secret")
+	secret := []byte("jwt")
+	token, _ := auth.NewToken(secret, user.ID)
+
+	var pixel bytes.Buffer
+	_ = png.Encode(&pixel, image.NewRGBA(image.Rect(0, 0, 1, 1)))
+	text := `<html><body><p>Start <a filepos=XXXXXXXXXX>on</a></p><img recindex="00001">` +
+		`<mbp:pagebreak/><p>Second part</p></body></html>`
+	target := strings.Index(text, "<mbp:pagebreak")
+	text = strings.Replace(text, "XXXXXXXXXX", fmt.Sprintf("%010d", target), 1)
+	store := books.NewMemoryStore()
+	key, _ := webdav.ParseKey("00112233445566778899aabbccddeeff00112233445566778899aabbccddeeff")
+	webSvc := webdav.NewService(webdav.NewMemoryStore(), contentClient{data: buildMOBI(text, pixel.Bytes())}, key, store, nil, nil)
+	conn, _ := webSvc.Create(context.Background(), user.ID, "https://dav.example.com", "reader", "pw", webdav.Filter{}, "", "")
+	book, _ := store.Upsert(user.ID, books.Book{SourcePath: "/novel.azw3", Title: "novel", Format: "azw3", ConnectionID: conn.ID})
+	h := handlers.NewBooksHandler(secret, store, webSvc, nil)
+	get := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp := httptest.NewRecorder()
+		h.ServeHTTP(resp, req)
+		return resp
+	}
+
+	resp := get("/api/books/" + book.ID + "/toc")
+	var toc struct {
+		Chapters []struct {
+			URL string `json:"url"`
+		} `json:"chapters"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&toc); err != nil || len(toc.Chapters) != 2 {
+		t.Fatalf("unexpected toc %d %+v: %v", resp.Code, toc, err)
+	}
+	resp = get(toc.Chapters[0].URL)
+	imageURL := "/api/books/" + book.ID + "/resources/images/00001.png"
+	body := resp.Body.String()
+	if resp.Code != http.StatusOK || !strings.Contains(body, `href="/api/books/`+book.ID+`/chapters/1#filepos`+strconv.Itoa(target)+`"`) || !strings.Contains(body, `src="`+imageURL+`"`) {
+		t.Fatalf("unexpected chapter %d %s", resp.Code, body)
+	}
+	resp = get(imageURL)
+	if resp.Code != http.StatusOK || resp.Header().Get("Content-Type") != "image/png" || !bytes.Equal(resp.Body.Bytes(), pixel.Bytes()) {
+		t.Fatalf("unexpected image %d %v", resp.Code, resp.Header())
+	}
+}
+
+func TestBooksHandlerPassesRangesThrough(t *testing.T) {
+	userStore := users.NewMemoryStore()
+	authSvc := auth.NewService(userStore)
+	user, _ := authSvc.Register("reader@example.com", "secret")
+	secret := []byte("jwt")
+	token, _ := auth.NewToken(secret, user.ID)
+
+	data := []byte("%PDF-1.7 page one page two page three")
+	modified := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	dav := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "PROPFIND" {
+			w.WriteHeader(http.StatusMultiStatus)
+			_, _ = w.Write([]byte(`<d:multistatus xmlns:d="DAV:"></d:multistatus>`))
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, r, "book.pdf", modified, bytes.NewReader(data))
+	}))
+	defer dav.Close()
+
+	store := books.NewMemoryStore()
+	key, _ := webdav.ParseKey("00112233445566778899aabbccddeeff00112233445566778899aabbccddeeff")
+	webSvc := webdav.NewService(webdav.NewMemoryStore(), webdav.NewHTTPClient(nil, webdav.ClientOptions{}), key, store, nil, nil)
+	conn, err := webSvc.Create(context.Background(), user.ID, dav.URL, "reader", "pw", webdav.Filter{}, "", "")
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	book, _ := store.Upsert(user.ID, books.Book{SourcePath: "/book.pdf", Title: "book", Format: "pdf", ConnectionID: conn.ID})
+	h := handlers.NewBooksHandler(secret, store, webSvc, nil)
+	get := func(headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/books/"+book.ID+"/content", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		resp := httptest.NewRecorder()
+		h.ServeHTTP(resp, req)
+		return resp
+	}
+
+	resp := get(nil)
+	if resp.Code != http.StatusOK || resp.Body.String() != string(data) {
+		t.Fatalf("expected full content, got %d %q", resp.Code, resp.Body.String())
+	}
+	header := resp.Header()
+	if header.Get("Content-Length") != strconv.Itoa(len(data)) || header.Get("Accept-Ranges") != "bytes" ||
+		header.Get("ETag") != `"v1"` || header.Get("Content-Type") != "application/pdf" ||
+		header.Get("Last-Modified") != modified.Format(http.TimeFormat) {
+		t.Fatalf("unexpected headers %v", header)
+	}
+
+	resp = get(map[string]string{"Range": "bytes=9-16"})
+	if resp.Code != http.StatusPartialContent || resp.Body.String() != "page one" ||
+		resp.Header().Get("Content-Range") != fmt.Sprintf("bytes 9-16/%d", len(data)) || resp.Header().Get("Content-Length") != "8" {
+		t.Fatalf("unexpected range response %d %q %v", resp.Code, resp.Body.String(), resp.Header())
+	}
+	if resp := get(map[string]string{"If-None-Match": `"v1"`}); resp.Code != http.StatusNotModified || resp.Body.Len() != 0 {
+		t.Fatalf("expected 304, got %d", resp.Code)
+	}
+	if resp := get(map[string]string{"If-Modified-Since": modified.Format(http.TimeFormat)}); resp.Code != http.StatusNotModified {
+		t.Fatalf("expected 304 for If-Modified-Since, got %d", resp.Code)
+	}
+	if resp := get(map[string]string{"Range": "bytes=500-"}); resp.Code != http.StatusRequestedRangeNotSatisfiable ||
+		resp.Header().Get("Content-Range") != fmt.Sprintf("bytes */%d", len(data)) {
+		t.Fatalf("expected 416, got %d %v", resp.Code, resp.Header())
+	}
+}
+
+func TestBooksHandlerServesCachedContentOffline(t *testing.T) {
+	userStore := users.NewMemoryStore()
+	authSvc := auth.NewService(userStore)
+	user, _ := authSvc.Register("reader@example.com", "secret")
+	secret := []byte("jwt")
+	token, _ := auth.NewToken(secret, user.ID)
+
+	data := []byte("%PDF-1.7 page one page two page three")
+	modified := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	dav := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "PROPFIND" {
+			w.WriteHeader(http.StatusMultiStatus)
+			_, _ = w.Write([]byte(`<d:multistatus xmlns:d="DAV:"><d:response><d:href>/book.pdf</d:href><d:propstat><d:prop>` +
+				`<d:getcontentlength>37</d:getcontentlength><d:getetag>"v1"</d:getetag></d:prop></d:propstat></d:response></d:multistatus>`))
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, r, "book.pdf", modified, bytes.NewReader(data))
+	}))
+
+	store := books.NewMemoryStore()
+	key, _ := webdav.ParseKey("00112233445566778899aabbccddeeff00112233445566778899aabbccddeeff")
+	cache, err := webdav.NewContentCache(t.TempDir(), webdav.CacheOptions{})
+	if err != nil {
+		t.Fatalf("cache: %v", err)
+	}
+	client := webdav.NewHTTPClient(nil, webdav.ClientOptions{Retries: -1})
+	webSvc := webdav.NewService(webdav.NewMemoryStore(), client, key, store, nil, cache)
+	conn, err := webSvc.Create(context.Background(), user.ID, dav.URL, "reader", "pw", webdav.Filter{}, "", "")
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if err := webSvc.Sync(context.Background(), user.ID, conn.ID); err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+	book, _ := store.GetBySourcePath(user.ID, "/book.pdf")
+	h := handlers.NewBooksHandler(secret, store, webSvc, nil)
+	do := func(method, path string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		resp := httptest.NewRecorder()
+		h.ServeHTTP(resp, req)
+		return resp
+	}
+
+	if resp := do(http.MethodGet, "/api/books/"+book.ID+"/content", nil); resp.Code != http.StatusOK || resp.Body.String() != string(data) {
+		t.Fatalf("expected full content, got %d", resp.Code)
+	}
+	if resp := do(http.MethodPut, "/api/books/"+book.ID+"/pin", nil); resp.Code != http.StatusNoContent {
+		t.Fatalf("expected pin to succeed, got %d", resp.Code)
+	}
+	dav.Close()
+
+	resp := do(http.MethodGet, "/api/books/"+book.ID+"/content", map[string]string{"Range": "bytes=9-16"})
+	if resp.Code != http.StatusPartialContent || resp.Body.String() != "page one" ||
+		resp.Header().Get("Content-Range") != fmt.Sprintf("bytes 9-16/%d", len(data)) || resp.Header().Get("Content-Type") != "application/pdf" {
+		t.Fatalf("unexpected cached range response %d %q %v", resp.Code, resp.Body.String(), resp.Header())
+	}
+	if resp := do(http.MethodGet, "/api/books/"+book.ID+"/content", map[string]string{"If-None-Match": `"v1"`}); resp.Code != http.StatusNotModified {
+		t.Fatalf("expected 304 from the cache, got %d", resp.Code)
+	}
+	if resp := do(http.MethodDelete, "/api/books/"+book.ID+"/pin", nil); resp.Code != http.StatusNoContent {
+		t.Fatalf("expected unpin to succeed, got %d", resp.Code)
+	}
+	if resp := do(http.MethodPut, "/api/books/missing/pin", nil); resp.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for unknown book, got %d", resp.Code)
+	}
+}
+
+func TestBooksHandlerUploadsBook(t *testing.T) {
+	userStore := users.NewMemoryStore()
+	authSvc := auth.NewService(userStore)
+	user, _ := authSvc.Register("reader@example.com", "secret")
+	secret := []byte("jwt")
+	token, _ := auth.NewToken(secret, user.ID)
+
+	store := books.NewMemoryStore()
+	key, _ := webdav.ParseKey("00112233445566778899aabbccddeeff00112233445566778899aabbccddeeff")
+	webSvc := webdav.NewService(webdav.NewMemoryStore(), contentClient{}, key, store, nil, nil)
+	conn, err := webSvc.Create(context.Background(), user.ID, "https://dav.example.com", "reader", "pw", webdav.Filter{}, "", "")
+	if err != nil {
+		t.Fatalf("create connection: %v", err)
+	}
+	h := handlers.NewBooksHandler(secret, store, webSvc, nil)
+	upload := func(name string) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		form := multipart.NewWriter(&body)
+		_ = form.WriteField("connection_id", conn.ID)
+		_ = form.WriteField("folder", "New")
+		part, _ := form.CreateFormFile("file", name)
+		_, _ = part.Write([]byte("content"))
+		_ = form.Close()
+		req := httptest.NewRequest(http.MethodPost, "/api/books/upload", &body)
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Content-Type", form.FormDataContentType())
+		resp := httptest.NewRecorder()
+		h.ServeHTTP(resp, req)
+		return resp
+	}
+
+	resp := upload("Dune.epub")
+	if resp.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", resp.Code)
+	}
+	var created booksResponse
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if created.SourcePath != "/New/Dune.epub" || created.Format != "epub" {
+		t.Fatalf("unexpected book %+v", created)
+	}
+	if list, _ := store.ListByUser(user.ID); len(list) != 1 {
+		t.Fatalf("expected book indexed, got %d", len(list))
+	}
+	if resp := upload("setup.exe"); resp.Code != http.StatusUnsupportedMediaType {
+		t.Fatalf("expected 415, got %d", resp.Code)
+	}
+}
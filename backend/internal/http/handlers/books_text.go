@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/EROQIN/relite-reader/backend/internal/plaintext"
+)
+
+// maxTextSize bounds the plain text books that are split into chapters.
+const maxTextSize = 64 << 20
+
+func (h *BooksHandler) openText(w http.ResponseWriter, r *http.Request, userID, bookID string) (string, []plaintext.Chapter, bool) {
+	_, spool, release, ok := h.openBook(w, r, userID, bookID, "txt")
+	if !ok {
+		return "", nil, false
+	}
+	defer release()
+	data, err := io.ReadAll(io.NewSectionReader(spool, 0, min(spool.Size, maxTextSize)))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return "", nil, false
+	}
+	text, _ := plaintext.Decode(data)
+	return text, plaintext.Split(text), true
+}
+
+func (h *BooksHandler) handleTextTOC(w http.ResponseWriter, r *http.Request, userID, bookID string) {
+	_, chapters, ok := h.openText(w, r, userID, bookID)
+	if !ok {
+		return
+	}
+	base := "/api/books/" + url.PathEscape(bookID) + "/chapters/"
+	resp := tocResponse{Chapters: []chapterResponse{}, Toc: []tocEntryResponse{}}
+	for i, chapter := range chapters {
+		chapterURL := base + strconv.Itoa(i)
+		resp.Chapters = append(resp.Chapters, chapterResponse{Index: i, Linear: true, URL: chapterURL})
+		if chapter.Title != "" {
+			resp.Toc = append(resp.Toc, tocEntryResponse{Title: chapter.Title, Chapter: i, URL: chapterURL})
+		}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (h *BooksHandler) handleTextChapter(w http.ResponseWriter, r *http.Request, userID, bookID string, index int) {
+	text, chapters, ok := h.openText(w, r, userID, bookID)
+	if !ok {
+		return
+	}
+	if index < 0 || index >= len(chapters) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	chapter := chapters[index]
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = io.WriteString(w, text[chapter.Start:chapter.End])
+}
@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/EROQIN/relite-reader/backend/internal/webdav"
+)
+
+// maxUploadSize bounds the file accepted by POST /api/books/upload.
+const maxUploadSize = 256 << 20
+
+// handleUpload stores a multipart "file" in the library folder of the
+// connection named by "connection_id", below the optional "folder".
+func (h *BooksHandler) handleUpload(w http.ResponseWriter, r *http.Request, userID string) {
+	if h.webSvc == nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize+1<<20)
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+	connectionID := r.FormValue("connection_id")
+	file, header, err := r.FormFile("file")
+	if err != nil || connectionID == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+	if header.Size > maxUploadSize {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		return
+	}
+	// Large files are spooled to disk by ParseMultipartForm and streamed
+	// from there.
+	book, err := h.webSvc.Upload(r.Context(), userID, connectionID, r.FormValue("folder"), header.Filename, file, header.Size)
+	switch {
+	case err == nil:
+		writeJSON(w, http.StatusCreated, h.bookResponse(userID, book))
+	case errors.Is(err, webdav.ErrUnsupportedFormat):
+		http.Error(w, "unsupported format", http.StatusUnsupportedMediaType)
+	case errors.Is(err, webdav.ErrInvalidUpload):
+		http.Error(w, "invalid file name or folder", http.StatusBadRequest)
+	case errors.Is(err, webdav.ErrFileExists):
+		w.WriteHeader(http.StatusConflict)
+	case errors.Is(err, webdav.ErrNotFound):
+		w.WriteHeader(http.StatusNotFound)
+	default:
+		w.WriteHeader(http.StatusBadGateway)
+	}
+}
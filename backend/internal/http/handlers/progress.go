@@ -5,20 +5,28 @@ import (
 	"net/http"
 	"strings"
 
+	"github.com/EROQIN/relite-reader/backend/internal/books"
 	"github.com/EROQIN/relite-reader/backend/internal/progress"
 )
 
 type ProgressHandler struct {
 	secret []byte
 	store  progress.Store
+	books  books.Store
 }
 
 type progressPayload struct {
 	Location float64 `json:"location"`
 }
 
-func NewProgressHandler(secret []byte, store progress.Store) *ProgressHandler {
-	return &ProgressHandler{secret: secret, store: store}
+type progressResponse struct {
+	progress.Progress
+	Page      int `json:"page,omitempty"`
+	PageCount int `json:"page_count,omitempty"`
+}
+
+func NewProgressHandler(secret []byte, store progress.Store, booksStore books.Store) *ProgressHandler {
+	return &ProgressHandler{secret: secret, store: store, books: booksStore}
 }
 
 func (h *ProgressHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -53,7 +61,7 @@ func (h *ProgressHandler) handleGet(w http.ResponseWriter, r *http.Request, user
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	writeJSON(w, http.StatusOK, progressValue)
+	writeJSON(w, http.StatusOK, h.toResponse(userID, progressValue))
 }
 
 func (h *ProgressHandler) handlePut(w http.ResponseWriter, r *http.Request, userID, bookID string) {
@@ -67,5 +75,19 @@ func (h *ProgressHandler) handlePut(w http.ResponseWriter, r *http.Request, user
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	writeJSON(w, http.StatusOK, updated)
+	writeJSON(w, http.StatusOK, h.toResponse(userID, updated))
+}
+
+func (h *ProgressHandler) toResponse(userID string, value progress.Progress) progressResponse {
+	resp := progressResponse{Progress: value}
+	if h.books == nil {
+		return resp
+	}
+	book, err := h.books.GetByID(userID, value.BookID)
+	if err != nil || book.PageCount <= 0 {
+		return resp
+	}
+	resp.PageCount = book.PageCount
+	resp.Page = progress.Page(value.Location, book.PageCount)
+	return resp
 }
@@ -8,6 +8,7 @@ import (
 	"testing"
 
 	"github.com/EROQIN/relite-reader/backend/internal/auth"
+	"github.com/EROQIN/relite-reader/backend/internal/books"
 	"github.com/EROQIN/relite-reader/backend/internal/http/handlers"
 	"github.com/EROQIN/relite-reader/backend/internal/progress"
 	"github.com/EROQIN/relite-reader/backend/internal/users"
@@ -15,7 +16,7 @@ import (
 
 func TestProgressHandlerRequiresAuth(t *testing.T) {
 	store := progress.NewMemoryStore()
-	h := handlers.NewProgressHandler([]byte("jwt"), store)
+	h := handlers.NewProgressHandler([]byte("jwt"), store, nil)
 	req := httptest.NewRequest(http.MethodGet, "/api/progress/book-1", nil)
 	resp := httptest.NewRecorder()
 	h.ServeHTTP(resp, req)
@@ -32,7 +33,7 @@ func TestProgressHandlerUpdateAndGet(t *testing.T) {
 	token, _ := auth.NewToken(secret, user.ID)
 
 	store := progress.NewMemoryStore()
-	h := handlers.NewProgressHandler(secret, store)
+	h := handlers.NewProgressHandler(secret, store, nil)
 
 	body, _ := json.Marshal(map[string]float64{"location": 0.55})
 	updateReq := httptest.NewRequest(http.MethodPut, "/api/progress/book-1", bytes.NewReader(body))
@@ -58,3 +59,32 @@ func TestProgressHandlerUpdateAndGet(t *testing.T) {
 		t.Fatalf("expected 0.55, got %f", payload.Location)
 	}
 }
+
+func TestProgressHandlerReportsPages(t *testing.T) {
+	userStore := users.NewMemoryStore()
+	authSvc := auth.NewService(userStore)
+	user, _ := authSvc.Register("reader@example.com", "secret")
+	secret := []byte("jwt")
+	token, _ := auth.NewToken(secret, user.ID)
+
+	bookStore := books.NewMemoryStore()
+	book, _ := bookStore.Upsert(user.ID, books.Book{SourcePath: "/a.pdf", Title: "A", Format: "pdf", PageCount: 420})
+	store := progress.NewMemoryStore()
+	_, _ = store.Save(user.ID, book.ID, 0.2690)
+	h := handlers.NewProgressHandler(secret, store, bookStore)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/progress/"+book.ID, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp := httptest.NewRecorder()
+	h.ServeHTTP(resp, req)
+	var payload struct {
+		Page      int `json:"page"`
+		PageCount int `json:"page_count"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if payload.Page != 113 || payload.PageCount != 420 {
+		t.Fatalf("expected page 113 of 420, got %d of %d", payload.Page, payload.PageCount)
+	}
+}
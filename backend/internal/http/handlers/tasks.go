@@ -1,13 +1,20 @@
 package handlers
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/EROQIN/relite-reader/backend/internal/tasks"
 )
 
+// streamKeepAlive is how often an idle task stream sends a comment.
+const streamKeepAlive = 25 * time.Second
+
 type TasksHandler struct {
 	secret []byte
 	store  tasks.Store
@@ -37,13 +44,21 @@ func (h *TasksHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusOK, items)
 		return
 	}
+	if r.URL.Path == "/api/tasks/stream" {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		h.handleStream(w, r, userID)
+		return
+	}
 	if !strings.HasPrefix(r.URL.Path, "/api/tasks/") {
 		w.WriteHeader(http.StatusNotFound)
 		return
 	}
 	trimmed := strings.TrimPrefix(r.URL.Path, "/api/tasks/")
 	parts := strings.Split(trimmed, "/")
-	if len(parts) != 2 || parts[1] != "retry" {
+	if len(parts) != 2 || (parts[1] != "retry" && parts[1] != "cancel") {
 		w.WriteHeader(http.StatusNotFound)
 		return
 	}
@@ -55,19 +70,93 @@ func (h *TasksHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusServiceUnavailable)
 		return
 	}
-	task, err := h.store.Get(userID, parts[0])
-	if err != nil {
-		if errors.Is(err, tasks.ErrNotFound) {
-			w.WriteHeader(http.StatusNotFound)
-			return
-		}
+	if parts[1] == "cancel" {
+		h.handleCancel(w, userID, parts[0])
+		return
+	}
+	task, err := h.queue.Requeue(userID, parts[0])
+	switch {
+	case err == nil:
+		writeJSON(w, http.StatusOK, task)
+	case errors.Is(err, tasks.ErrNotFound):
+		w.WriteHeader(http.StatusNotFound)
+	case errors.Is(err, tasks.ErrActive):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, tasks.ErrUnknownType):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// handleCancel answers 200 with a canceled task, or 202 while a running
+// task is still stopping.
+func (h *TasksHandler) handleCancel(w http.ResponseWriter, userID, id string) {
+	task, err := h.queue.Cancel(userID, id)
+	switch {
+	case err == nil && task.Status == tasks.StatusRunning:
+		writeJSON(w, http.StatusAccepted, task)
+	case err == nil:
+		writeJSON(w, http.StatusOK, task)
+	case errors.Is(err, tasks.ErrNotFound):
+		w.WriteHeader(http.StatusNotFound)
+	case errors.Is(err, tasks.ErrFinished), errors.Is(err, tasks.ErrRunningElsewhere):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// handleStream sends the user's tasks and then every change to them as
+// Server-Sent Events, one "task" event per task state.
+func (h *TasksHandler) handleStream(w http.ResponseWriter, r *http.Request, userID string) {
+	if h.queue == nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	created, err := h.queue.Enqueue(userID, task.Type, task.Payload)
+	// Subscribe first so no change between the listing and the stream is lost.
+	sub := h.queue.Subscribe(userID)
+	defer sub.Close()
+	items, err := h.store.ListByUser(userID)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	writeJSON(w, http.StatusCreated, created)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	for _, task := range items {
+		writeTaskEvent(w, task)
+	}
+	flusher.Flush()
+	keepAlive := time.NewTicker(streamKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-sub.Ready():
+			for _, task := range sub.Next() {
+				writeTaskEvent(w, task)
+			}
+		case <-keepAlive.C:
+			// A comment line keeps proxies from closing an idle stream.
+			_, _ = io.WriteString(w, ": keep-alive\n\n")
+		}
+		flusher.Flush()
+	}
+}
+
+func writeTaskEvent(w io.Writer, task tasks.Task) {
+	payload, err := json.Marshal(task)
+	if err != nil {
+		return
+	}
+	_, _ = fmt.Fprintf(w, "event: task\ndata: %s\n\n", payload)
 }
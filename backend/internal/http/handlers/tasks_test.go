@@ -1,8 +1,12 @@
 package handlers_test
 
 import (
+	"bufio"
+	"context"
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/EROQIN/relite-reader/backend/internal/auth"
@@ -55,14 +59,128 @@ func TestTasksHandlerRetriesTask(t *testing.T) {
 		Status:  tasks.StatusError,
 		Payload: map[string]string{"format": "kfx"},
 	})
-	queue := tasks.NewQueue(store, nil, 2)
+	registry := tasks.NewRegistry()
+	registry.Register("format", func(context.Context, tasks.Task) error { return nil }, tasks.TypeOptions{})
+	queue := tasks.NewQueue(store, registry, tasks.QueueOptions{Workers: 1})
 	h := handlers.NewTasksHandler(secret, store, queue)
 
 	req := httptest.NewRequest(http.MethodPost, "/api/tasks/"+task.ID+"/retry", nil)
 	req.Header.Set("Authorization", "Bearer "+token)
 	resp := httptest.NewRecorder()
 	h.ServeHTTP(resp, req)
-	if resp.Code != http.StatusCreated {
-		t.Fatalf("expected 201, got %d", resp.Code)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.Code)
+	}
+	var requeued tasks.Task
+	_ = json.NewDecoder(resp.Body).Decode(&requeued)
+	if requeued.ID != task.ID || requeued.Status != tasks.StatusQueued {
+		t.Fatalf("expected task requeued in place, got %+v", requeued)
+	}
+
+	// Requeueing a task that is still queued conflicts.
+	req = httptest.NewRequest(http.MethodPost, "/api/tasks/"+task.ID+"/retry", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp = httptest.NewRecorder()
+	h.ServeHTTP(resp, req)
+	if resp.Code != http.StatusConflict {
+		t.Fatalf("expected 409, got %d", resp.Code)
+	}
+
+	legacy, _ := store.Create(tasks.Task{UserID: user.ID, Type: "convert", Status: tasks.StatusError})
+	req = httptest.NewRequest(http.MethodPost, "/api/tasks/"+legacy.ID+"/retry", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp = httptest.NewRecorder()
+	h.ServeHTTP(resp, req)
+	if resp.Code != http.StatusBadRequest {
+		t.Fatalf("expected unknown task type rejected, got %d", resp.Code)
+	}
+}
+
+func TestTasksHandlerCancelsTask(t *testing.T) {
+	userStore := users.NewMemoryStore()
+	authSvc := auth.NewService(userStore)
+	user, _ := authSvc.Register("reader@example.com", "secret")
+	secret := []byte("jwt")
+	token, _ := auth.NewToken(secret, user.ID)
+
+	store := tasks.NewMemoryStore()
+	registry := tasks.NewRegistry()
+	registry.Register("format", func(context.Context, tasks.Task) error { return nil }, tasks.TypeOptions{})
+	queue := tasks.NewQueue(store, registry, tasks.QueueOptions{Workers: 1})
+	task, _ := queue.Enqueue(user.ID, "format", nil)
+	h := handlers.NewTasksHandler(secret, store, queue)
+
+	cancel := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/tasks/"+task.ID+"/cancel", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp := httptest.NewRecorder()
+		h.ServeHTTP(resp, req)
+		return resp
+	}
+	resp := cancel()
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.Code)
+	}
+	var canceled tasks.Task
+	_ = json.NewDecoder(resp.Body).Decode(&canceled)
+	if canceled.Status != tasks.StatusCanceled {
+		t.Fatalf("expected canceled task, got %q", canceled.Status)
+	}
+	if resp := cancel(); resp.Code != http.StatusConflict {
+		t.Fatalf("expected 409 for a finished task, got %d", resp.Code)
+	}
+}
+
+func TestTasksHandlerStreamsTaskChanges(t *testing.T) {
+	userStore := users.NewMemoryStore()
+	authSvc := auth.NewService(userStore)
+	user, _ := authSvc.Register("reader@example.com", "secret")
+	secret := []byte("jwt")
+	token, _ := auth.NewToken(secret, user.ID)
+
+	store := tasks.NewMemoryStore()
+	registry := tasks.NewRegistry()
+	registry.Register("format", func(context.Context, tasks.Task) error { return nil }, tasks.TypeOptions{})
+	queue := tasks.NewQueue(store, registry, tasks.QueueOptions{Workers: 1})
+	existing, _ := queue.Enqueue(user.ID, "format", nil)
+	srv := httptest.NewServer(handlers.NewTasksHandler(secret, store, queue))
+	defer srv.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/api/tasks/stream", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("stream: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("unexpected response %d %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	reader := bufio.NewReader(resp.Body)
+	next := func() tasks.Task {
+		t.Helper()
+		var task tasks.Task
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				t.Fatalf("read: %v", err)
+			}
+			if data, ok := strings.CutPrefix(line, "data: "); ok {
+				_ = json.Unmarshal([]byte(data), &task)
+				return task
+			}
+		}
+	}
+	if first := next(); first.ID != existing.ID {
+		t.Fatalf("expected current tasks first, got %+v", first)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	queue.Start(ctx)
+	for {
+		changed := next()
+		if changed.ID == existing.ID && changed.Status == tasks.StatusSuccess {
+			break
+		}
 	}
 }
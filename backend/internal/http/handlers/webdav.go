@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 	"strings"
 	"time"
@@ -18,15 +19,94 @@ type webdavPayload struct {
 	BaseURL  string `json:"base_url"`
 	Username string `json:"username"`
 	Secret   string `json:"secret"`
+	webdavFilter
+	WriteBack string `json:"write_back"`
+	Schedule  string `json:"schedule"`
+}
+
+type webdavFilter struct {
+	RootPath      string   `json:"root_path"`
+	Include       []string `json:"include"`
+	Exclude       []string `json:"exclude"`
+	MaxDepth      int      `json:"max_depth"`
+	SupportedOnly bool     `json:"supported_only"`
+}
+
+func (f webdavFilter) toFilter() webdav.Filter {
+	return webdav.Filter{
+		RootPath:      f.RootPath,
+		Include:       f.Include,
+		Exclude:       f.Exclude,
+		MaxDepth:      f.MaxDepth,
+		SupportedOnly: f.SupportedOnly,
+	}
+}
+
+// webdavUpdatePayload is the body of PUT /api/webdav/{id}. Settings left
+// out keep their stored values, so clients that only edit the credentials
+// do not reset the filter, write-back mode or schedule.
+type webdavUpdatePayload struct {
+	BaseURL       string    `json:"base_url"`
+	Username      string    `json:"username"`
+	Secret        string    `json:"secret"`
+	RootPath      *string   `json:"root_path"`
+	Include       *[]string `json:"include"`
+	Exclude       *[]string `json:"exclude"`
+	MaxDepth      *int      `json:"max_depth"`
+	SupportedOnly *bool     `json:"supported_only"`
+	WriteBack     *string   `json:"write_back"`
+	Schedule      *string   `json:"schedule"`
+}
+
+// merge returns the settings of conn overridden by those in the payload.
+func (p webdavUpdatePayload) merge(conn webdav.Connection) (webdav.Filter, webdav.WriteBack, string) {
+	filter := conn.Filter
+	if p.RootPath != nil {
+		filter.RootPath = *p.RootPath
+	}
+	if p.Include != nil {
+		filter.Include = *p.Include
+	}
+	if p.Exclude != nil {
+		filter.Exclude = *p.Exclude
+	}
+	if p.MaxDepth != nil {
+		filter.MaxDepth = *p.MaxDepth
+	}
+	if p.SupportedOnly != nil {
+		filter.SupportedOnly = *p.SupportedOnly
+	}
+	writeBack := conn.WriteBack
+	if p.WriteBack != nil {
+		writeBack = webdav.WriteBack(*p.WriteBack)
+	}
+	schedule := conn.Schedule
+	if p.Schedule != nil {
+		schedule = *p.Schedule
+	}
+	return filter, writeBack, schedule
 }
 
 type webdavResponse struct {
-	ID             string `json:"id"`
-	BaseURL        string `json:"base_url"`
-	Username       string `json:"username"`
-	LastSyncStatus string `json:"last_sync_status"`
-	LastError      string `json:"last_error"`
-	LastSyncAt     string `json:"last_sync_at"`
+	ID       string `json:"id"`
+	BaseURL  string `json:"base_url"`
+	Username string `json:"username"`
+	webdavFilter
+	WriteBack      string           `json:"write_back"`
+	Schedule       string           `json:"schedule"`
+	NextSyncAt     string           `json:"next_sync_at"`
+	LastSyncStatus string           `json:"last_sync_status"`
+	LastError      string           `json:"last_error"`
+	LastSyncAt     string           `json:"last_sync_at"`
+	LastSyncResult webdavSyncResult `json:"last_sync_result"`
+}
+
+type webdavSyncResult struct {
+	Added     int `json:"added"`
+	Changed   int `json:"changed"`
+	Removed   int `json:"removed"`
+	Unchanged int `json:"unchanged"`
+	Moved     int `json:"moved"`
 }
 
 func NewWebDAVHandler(secret []byte, svc *webdav.Service) *WebDAVHandler {
@@ -63,7 +143,7 @@ func (h *WebDAVHandler) handleCreate(w http.ResponseWriter, r *http.Request, use
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	conn, err := h.svc.Create(userID, payload.BaseURL, payload.Username, payload.Secret)
+	conn, err := h.svc.Create(r.Context(), userID, payload.BaseURL, payload.Username, payload.Secret, payload.toFilter(), webdav.WriteBack(payload.WriteBack), payload.Schedule)
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		return
@@ -93,18 +173,32 @@ func (h *WebDAVHandler) handleItem(w http.ResponseWriter, r *http.Request, userI
 		return
 	}
 	if len(parts) == 2 && parts[1] == "sync" && r.Method == http.MethodPost {
-		_ = h.svc.Sync(userID, id)
-		w.WriteHeader(http.StatusAccepted)
+		task, err := h.svc.QueueSync(userID, id)
+		if errors.Is(err, webdav.ErrNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusAccepted, task)
 		return
 	}
 	switch r.Method {
 	case http.MethodPut:
-		var payload webdavPayload
+		var payload webdavUpdatePayload
 		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
-		conn, err := h.svc.Update(userID, id, payload.BaseURL, payload.Username, payload.Secret)
+		conn, err := h.svc.Get(userID, id)
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		filter, writeBack, schedule := payload.merge(conn)
+		conn, err = h.svc.Update(r.Context(), userID, id, payload.BaseURL, payload.Username, payload.Secret, filter, writeBack, schedule)
 		if err != nil {
 			w.WriteHeader(http.StatusBadRequest)
 			return
@@ -126,12 +220,33 @@ func toWebDAVResponse(conn webdav.Connection) webdavResponse {
 	if !conn.LastSyncAt.IsZero() {
 		lastSyncAt = conn.LastSyncAt.UTC().Format(time.RFC3339)
 	}
+	nextSyncAt := ""
+	if !conn.NextSyncAt.IsZero() {
+		nextSyncAt = conn.NextSyncAt.UTC().Format(time.RFC3339)
+	}
 	return webdavResponse{
-		ID:             conn.ID,
-		BaseURL:        conn.BaseURL,
-		Username:       conn.Username,
+		ID:       conn.ID,
+		BaseURL:  conn.BaseURL,
+		Username: conn.Username,
+		webdavFilter: webdavFilter{
+			RootPath:      conn.Filter.RootPath,
+			Include:       conn.Filter.Include,
+			Exclude:       conn.Filter.Exclude,
+			MaxDepth:      conn.Filter.MaxDepth,
+			SupportedOnly: conn.Filter.SupportedOnly,
+		},
+		WriteBack:      string(conn.WriteBack),
+		Schedule:       conn.Schedule,
+		NextSyncAt:     nextSyncAt,
 		LastSyncStatus: conn.LastSyncStatus,
 		LastError:      conn.LastError,
 		LastSyncAt:     lastSyncAt,
+		LastSyncResult: webdavSyncResult{
+			Added:     conn.LastSyncResult.Added,
+			Changed:   conn.LastSyncResult.Changed,
+			Removed:   conn.LastSyncResult.Removed,
+			Unchanged: conn.LastSyncResult.Unchanged,
+			Moved:     conn.LastSyncResult.Moved,
+		},
 	}
 }
@@ -2,6 +2,7 @@ package handlers_test
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"io"
 	"net/http"
@@ -22,10 +23,24 @@ import (
 
 type stubClient struct{ err error }
 
-func (s stubClient) List(_, _, _ string) ([]webdav.Entry, error) { return nil, s.err }
+func (s stubClient) List(_ context.Context, _, _, _ string, _ func(string) bool) ([]webdav.Entry, error) {
+	return nil, s.err
+}
+
+func (s stubClient) Fetch(_ context.Context, _, _, _, _ string, _ webdav.ContentRequest) (webdav.Content, error) {
+	return webdav.Content{}, s.err
+}
+
+func (s stubClient) Put(_ context.Context, _, _, _, _ string, _ io.ReadSeeker, _ int64, _ string) (string, error) {
+	return "", s.err
+}
 
-func (s stubClient) Fetch(_, _, _, _ string) (io.ReadCloser, string, error) {
-	return nil, "", s.err
+func (s stubClient) MakeCollection(_ context.Context, _, _, _, _ string) error {
+	return s.err
+}
+
+func (s stubClient) Stat(_ context.Context, _, _, _, _ string) (webdav.Entry, error) {
+	return webdav.Entry{}, s.err
 }
 
 func TestWebDAVHandlersRequireAuth(t *testing.T) {
@@ -38,9 +53,9 @@ func TestWebDAVHandlersRequireAuth(t *testing.T) {
 	progressStore := progress.NewMemoryStore()
 	tasksStore := tasks.NewMemoryStore()
 	key, _ := webdav.ParseKey("00112233445566778899aabbccddeeff00112233445566778899aabbccddeeff")
-	webSvc := webdav.NewService(webStore, stubClient{err: nil}, key, bookStore, nil)
+	webSvc := webdav.NewService(webStore, stubClient{err: nil}, key, bookStore, nil, nil)
 	annotationsStore := annotations.NewMemoryStore()
-	router := apphttp.NewRouterWithAuthAndWebDAV(authSvc, []byte("jwt-secret"), webSvc, bookStore, annotationsStore, bookmarksStore, prefsStore, progressStore, tasksStore, nil)
+	router := apphttp.NewRouterWithAuthAndWebDAV(authSvc, []byte("jwt-secret"), webSvc, bookStore, annotationsStore, bookmarksStore, prefsStore, progressStore, tasksStore, nil, nil)
 
 	req := httptest.NewRequest(http.MethodGet, "/api/webdav", nil)
 	resp := httptest.NewRecorder()
@@ -64,9 +79,9 @@ func TestWebDAVCreateAndList(t *testing.T) {
 	progressStore := progress.NewMemoryStore()
 	tasksStore := tasks.NewMemoryStore()
 	key, _ := webdav.ParseKey("00112233445566778899aabbccddeeff00112233445566778899aabbccddeeff")
-	webSvc := webdav.NewService(webStore, stubClient{err: nil}, key, bookStore, nil)
+	webSvc := webdav.NewService(webStore, stubClient{err: nil}, key, bookStore, nil, nil)
 	annotationsStore := annotations.NewMemoryStore()
-	router := apphttp.NewRouterWithAuthAndWebDAV(authSvc, jwtSecret, webSvc, bookStore, annotationsStore, bookmarksStore, prefsStore, progressStore, tasksStore, nil)
+	router := apphttp.NewRouterWithAuthAndWebDAV(authSvc, jwtSecret, webSvc, bookStore, annotationsStore, bookmarksStore, prefsStore, progressStore, tasksStore, nil, nil)
 
 	payload := map[string]string{"base_url": "https://dav.example.com", "username": "reader", "secret": "pw"}
 	body, _ := json.Marshal(payload)
@@ -87,3 +102,171 @@ func TestWebDAVCreateAndList(t *testing.T) {
 		t.Fatalf("expected 200, got %d", listResp.Code)
 	}
 }
+
+func TestWebDAVCreateStoresFilter(t *testing.T) {
+	store := users.NewMemoryStore()
+	authSvc := auth.NewService(store)
+	user, _ := authSvc.Register("reader@example.com", "secret")
+	jwtSecret := []byte("jwt-secret")
+	token, _ := auth.NewToken(jwtSecret, user.ID)
+
+	bookStore := books.NewMemoryStore()
+	key, _ := webdav.ParseKey("00112233445566778899aabbccddeeff00112233445566778899aabbccddeeff")
+	webSvc := webdav.NewService(webdav.NewMemoryStore(), stubClient{err: nil}, key, bookStore, nil, nil)
+	router := apphttp.NewRouterWithAuthAndWebDAV(authSvc, jwtSecret, webSvc, bookStore, annotations.NewMemoryStore(), bookmarks.NewMemoryStore(), preferences.NewMemoryStore(), progress.NewMemoryStore(), tasks.NewMemoryStore(), nil, nil)
+
+	post := func(payload string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/webdav", bytes.NewReader([]byte(payload)))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Content-Type", "application/json")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		return resp
+	}
+	resp := post(`{"base_url":"https://dav.example.com","username":"reader","secret":"pw","root_path":"Books/","exclude":[".Trash"],"max_depth":2,"supported_only":true}`)
+	if resp.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", resp.Code)
+	}
+	var created struct {
+		RootPath      string   `json:"root_path"`
+		Exclude       []string `json:"exclude"`
+		MaxDepth      int      `json:"max_depth"`
+		SupportedOnly bool     `json:"supported_only"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if created.RootPath != "/Books" || len(created.Exclude) != 1 || created.MaxDepth != 2 || !created.SupportedOnly {
+		t.Fatalf("unexpected filter %+v", created)
+	}
+	if resp := post(`{"base_url":"https://dav.example.com","username":"reader","secret":"pw","include":["[a-"]}`); resp.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for bad pattern, got %d", resp.Code)
+	}
+}
+
+func TestWebDAVUpdateKeepsOmittedSettings(t *testing.T) {
+	store := users.NewMemoryStore()
+	authSvc := auth.NewService(store)
+	user, _ := authSvc.Register("reader@example.com", "secret")
+	jwtSecret := []byte("jwt-secret")
+	token, _ := auth.NewToken(jwtSecret, user.ID)
+
+	bookStore := books.NewMemoryStore()
+	key, _ := webdav.ParseKey("00112233445566778899aabbccddeeff00112233445566778899aabbccddeeff")
+	webSvc := webdav.NewService(webdav.NewMemoryStore(), stubClient{err: nil}, key, bookStore, nil, nil)
+	router := apphttp.NewRouterWithAuthAndWebDAV(authSvc, jwtSecret, webSvc, bookStore, annotations.NewMemoryStore(), bookmarks.NewMemoryStore(), preferences.NewMemoryStore(), progress.NewMemoryStore(), tasks.NewMemoryStore(), nil, nil)
+
+	send := func(method, path, payload string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewReader([]byte(payload)))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Content-Type", "application/json")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		return resp
+	}
+	type connection struct {
+		ID            string   `json:"id"`
+		Username      string   `json:"username"`
+		RootPath      string   `json:"root_path"`
+		Exclude       []string `json:"exclude"`
+		MaxDepth      int      `json:"max_depth"`
+		SupportedOnly bool     `json:"supported_only"`
+		WriteBack     string   `json:"write_back"`
+		Schedule      string   `json:"schedule"`
+	}
+	resp := send(http.MethodPost, "/api/webdav", `{"base_url":"https://dav.example.com","username":"reader","secret":"pw","root_path":"Books","exclude":[".Trash"],"max_depth":2,"supported_only":true,"write_back":"relite","schedule":"6h"}`)
+	if resp.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", resp.Code)
+	}
+	var created connection
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+
+	resp = send(http.MethodPut, "/api/webdav/"+created.ID, `{"base_url":"https://dav.example.com","username":"other","secret":"pw2"}`)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.Code)
+	}
+	var updated connection
+	if err := json.NewDecoder(resp.Body).Decode(&updated); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if updated.Username != "other" || updated.RootPath != "/Books" || len(updated.Exclude) != 1 || updated.MaxDepth != 2 || !updated.SupportedOnly || updated.WriteBack != "relite" || updated.Schedule != "6h" {
+		t.Fatalf("unexpected connection after update %+v", updated)
+	}
+
+	resp = send(http.MethodPut, "/api/webdav/"+created.ID, `{"base_url":"https://dav.example.com","username":"other","secret":"pw2","exclude":[],"write_back":""}`)
+	updated = connection{}
+	if err := json.NewDecoder(resp.Body).Decode(&updated); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(updated.Exclude) != 0 || updated.WriteBack != "" || updated.RootPath != "/Books" {
+		t.Fatalf("expected explicit settings to apply, got %+v", updated)
+	}
+	if resp := send(http.MethodPut, "/api/webdav/missing", `{"base_url":"https://dav.example.com","username":"other","secret":"pw2"}`); resp.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for unknown connection, got %d", resp.Code)
+	}
+}
+
+func TestWebDAVSyncQueuesTask(t *testing.T) {
+	store := users.NewMemoryStore()
+	authSvc := auth.NewService(store)
+	user, _ := authSvc.Register("reader@example.com", "secret")
+	jwtSecret := []byte("jwt-secret")
+	token, _ := auth.NewToken(jwtSecret, user.ID)
+
+	bookStore := books.NewMemoryStore()
+	tasksStore := tasks.NewMemoryStore()
+	registry := tasks.NewRegistry()
+	queue := tasks.NewQueue(tasksStore, registry, tasks.QueueOptions{Workers: 1})
+	key, _ := webdav.ParseKey("00112233445566778899aabbccddeeff00112233445566778899aabbccddeeff")
+	webSvc := webdav.NewService(webdav.NewMemoryStore(), stubClient{err: nil}, key, bookStore, queue, nil)
+	webSvc.Register(registry, 1)
+	router := apphttp.NewRouterWithAuthAndWebDAV(authSvc, jwtSecret, webSvc, bookStore, annotations.NewMemoryStore(), bookmarks.NewMemoryStore(), preferences.NewMemoryStore(), progress.NewMemoryStore(), tasksStore, queue, nil)
+
+	post := func(path, payload string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader([]byte(payload)))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Content-Type", "application/json")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		return resp
+	}
+	resp := post("/api/webdav", `{"base_url":"https://dav.example.com","username":"reader","secret":"pw","schedule":"0 3 * * *"}`)
+	if resp.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", resp.Code)
+	}
+	var created struct {
+		ID       string `json:"id"`
+		Schedule string `json:"schedule"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if created.Schedule != "0 3 * * *" {
+		t.Fatalf("unexpected schedule %q", created.Schedule)
+	}
+	if resp := post("/api/webdav", `{"base_url":"https://dav.example.com","username":"reader","secret":"pw","schedule":"sometimes"}`); resp.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for bad schedule, got %d", resp.Code)
+	}
+
+	resp = post("/api/webdav/"+created.ID+"/sync", "")
+	if resp.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d", resp.Code)
+	}
+	var task tasks.Task
+	if err := json.NewDecoder(resp.Body).Decode(&task); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if task.Type != webdav.SyncTask || task.Payload["connection_id"] != created.ID {
+		t.Fatalf("unexpected task %+v", task)
+	}
+	var again tasks.Task
+	_ = json.NewDecoder(post("/api/webdav/"+created.ID+"/sync", "").Body).Decode(&again)
+	if again.ID != task.ID {
+		t.Fatalf("expected the pending sync returned, got %q", again.ID)
+	}
+	if resp := post("/api/webdav/missing/sync", ""); resp.Code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d", resp.Code)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/EROQIN/relite-reader/backend/internal/auth"
 	"github.com/EROQIN/relite-reader/backend/internal/bookmarks"
 	"github.com/EROQIN/relite-reader/backend/internal/books"
+	"github.com/EROQIN/relite-reader/backend/internal/covers"
 	"github.com/EROQIN/relite-reader/backend/internal/http/handlers"
 	"github.com/EROQIN/relite-reader/backend/internal/preferences"
 	"github.com/EROQIN/relite-reader/backend/internal/progress"
@@ -40,15 +41,16 @@ func NewRouterWithAuthAndWebDAV(
 	progressStore progress.Store,
 	tasksStore tasks.Store,
 	queue *tasks.Queue,
+	coverCache *covers.Cache,
 ) http.Handler {
 	mux := http.NewServeMux()
 	authHandler := handlers.NewAuthHandler(svc, secret)
 	webHandler := handlers.NewWebDAVHandler(secret, webSvc)
-	booksHandler := handlers.NewBooksHandler(secret, booksStore, webSvc)
+	booksHandler := handlers.NewBooksHandler(secret, booksStore, webSvc, coverCache)
 	annotationsHandler := handlers.NewAnnotationsHandler(secret, annotationsStore)
 	bookmarksHandler := handlers.NewBookmarksHandler(secret, bookmarksStore)
 	prefsHandler := handlers.NewPreferencesHandler(secret, prefsStore)
-	progressHandler := handlers.NewProgressHandler(secret, progressStore)
+	progressHandler := handlers.NewProgressHandler(secret, progressStore, booksStore)
 	tasksHandler := handlers.NewTasksHandler(secret, tasksStore, queue)
 	mux.HandleFunc("/api/health", handlers.Health)
 	mux.HandleFunc("/api/auth/register", authHandler.Register)
@@ -56,6 +58,7 @@ func NewRouterWithAuthAndWebDAV(
 	mux.Handle("/api/webdav", webHandler)
 	mux.Handle("/api/webdav/", webHandler)
 	mux.Handle("/api/books", booksHandler)
+	mux.Handle("/api/books/", booksHandler)
 	mux.Handle("/api/annotations/", annotationsHandler)
 	mux.Handle("/api/bookmarks/", bookmarksHandler)
 	mux.Handle("/api/preferences", prefsHandler)
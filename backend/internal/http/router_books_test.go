@@ -27,10 +27,10 @@ func TestRouterWithBooksRoutes(t *testing.T) {
 	tasksStore := tasks.NewMemoryStore()
 	webStore := webdav.NewMemoryStore()
 	key, _ := webdav.ParseKey("00112233445566778899aabbccddeeff00112233445566778899aabbccddeeff")
-	webSvc := webdav.NewService(webStore, noopClient{}, key, bookStore, nil)
+	webSvc := webdav.NewService(webStore, noopClient{}, key, bookStore, nil, nil)
 
 	annotationsStore := annotations.NewMemoryStore()
-	router := apphttp.NewRouterWithAuthAndWebDAV(authSvc, []byte("jwt"), webSvc, bookStore, annotationsStore, bookmarksStore, prefsStore, progressStore, tasksStore, nil)
+	router := apphttp.NewRouterWithAuthAndWebDAV(authSvc, []byte("jwt"), webSvc, bookStore, annotationsStore, bookmarksStore, prefsStore, progressStore, tasksStore, nil, nil)
 	req := httptest.NewRequest(http.MethodGet, "/api/books", nil)
 	resp := httptest.NewRecorder()
 	router.ServeHTTP(resp, req)
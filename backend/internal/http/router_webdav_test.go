@@ -1,6 +1,7 @@
 package http_test
 
 import (
+	"context"
 	"io"
 	"net/http"
 	"net/http/httptest"
@@ -20,10 +21,24 @@ import (
 
 type noopClient struct{}
 
-func (noopClient) List(_, _, _ string) ([]webdav.Entry, error) { return nil, nil }
+func (noopClient) List(_ context.Context, _, _, _ string, _ func(string) bool) ([]webdav.Entry, error) {
+	return nil, nil
+}
+
+func (noopClient) Fetch(_ context.Context, _, _, _, _ string, _ webdav.ContentRequest) (webdav.Content, error) {
+	return webdav.Content{}, nil
+}
+
+func (noopClient) Put(_ context.Context, _, _, _, _ string, _ io.ReadSeeker, _ int64, _ string) (string, error) {
+	return "", nil
+}
+
+func (noopClient) MakeCollection(_ context.Context, _, _, _, _ string) error {
+	return nil
+}
 
-func (noopClient) Fetch(_, _, _, _ string) (io.ReadCloser, string, error) {
-	return nil, "", nil
+func (noopClient) Stat(_ context.Context, _, _, _, filePath string) (webdav.Entry, error) {
+	return webdav.Entry{Path: filePath}, nil
 }
 
 func TestRouterWithWebDAVRoutes(t *testing.T) {
@@ -36,10 +51,10 @@ func TestRouterWithWebDAVRoutes(t *testing.T) {
 	tasksStore := tasks.NewMemoryStore()
 	webStore := webdav.NewMemoryStore()
 	key, _ := webdav.ParseKey("00112233445566778899aabbccddeeff00112233445566778899aabbccddeeff")
-	webSvc := webdav.NewService(webStore, noopClient{}, key, bookStore, nil)
+	webSvc := webdav.NewService(webStore, noopClient{}, key, bookStore, nil, nil)
 
 	annotationsStore := annotations.NewMemoryStore()
-	router := apphttp.NewRouterWithAuthAndWebDAV(authSvc, []byte("jwt"), webSvc, bookStore, annotationsStore, bookmarksStore, prefsStore, progressStore, tasksStore, nil)
+	router := apphttp.NewRouterWithAuthAndWebDAV(authSvc, []byte("jwt"), webSvc, bookStore, annotationsStore, bookmarksStore, prefsStore, progressStore, tasksStore, nil, nil)
 	req := httptest.NewRequest(http.MethodGet, "/api/webdav", nil)
 	resp := httptest.NewRecorder()
 	router.ServeHTTP(resp, req)
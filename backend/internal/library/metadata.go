@@ -0,0 +1,96 @@
+package library
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/EROQIN/relite-reader/backend/internal/books"
+	"github.com/EROQIN/relite-reader/backend/internal/comics"
+	"github.com/EROQIN/relite-reader/backend/internal/epub"
+	"github.com/EROQIN/relite-reader/backend/internal/mobi"
+	"github.com/EROQIN/relite-reader/backend/internal/pdf"
+)
+
+func applyEPUBMetadata(book *books.Book, r io.ReaderAt, size int64) error {
+	parsed, err := epub.Open(r, size)
+	if err != nil {
+		return fmt.Errorf("read epub metadata: %w", err)
+	}
+	md := parsed.Metadata
+	setIfPresent(&book.Title, md.Title)
+	setIfPresent(&book.Author, md.Author())
+	setIfPresent(&book.Language, md.Language)
+	setIfPresent(&book.Identifier, md.Identifier)
+	if md.Series != "" {
+		book.Series = md.Series
+		book.SeriesIndex = md.SeriesIndex
+	}
+	return nil
+}
+
+// applyMOBIMetadata reads the EXTH header of MOBI, AZW and AZW3 books.
+// Encrypted books, including KFX files, fail with mobi.ErrDRM so the task
+// explains why they cannot be opened.
+func applyMOBIMetadata(book *books.Book, r io.ReaderAt, size int64) error {
+	parsed, err := mobi.Open(r, size)
+	if err != nil {
+		return fmt.Errorf("read mobi metadata: %w", err)
+	}
+	md := parsed.Metadata
+	setIfPresent(&book.Title, md.Title)
+	setIfPresent(&book.Author, md.Author())
+	setIfPresent(&book.Language, md.Language)
+	setIfPresent(&book.Identifier, md.Identifier())
+	setIfPresent(&book.Subject, strings.Join(md.Subjects, ", "))
+	return nil
+}
+
+func applyPDFInfo(book *books.Book, r io.ReaderAt, size int64) error {
+	info, err := pdf.ReadInfo(r, size)
+	if err != nil {
+		return fmt.Errorf("read pdf metadata: %w", err)
+	}
+	setIfPresent(&book.Title, info.Title)
+	setIfPresent(&book.Author, info.Author)
+	setIfPresent(&book.Subject, info.Subject)
+	if info.PageCount > 0 {
+		book.PageCount = info.PageCount
+	}
+	return nil
+}
+
+// applyComicInfo reads ComicInfo.xml and the page count of a ZIP or TAR
+// comic archive.
+func applyComicInfo(book *books.Book, format string, r io.ReaderAt, size int64) error {
+	archive, err := comics.Open(format, r, size)
+	if err != nil {
+		return fmt.Errorf("read comic archive: %w", err)
+	}
+	book.PageCount = len(archive.Pages())
+	info, ok := archive.Info()
+	if !ok {
+		return nil
+	}
+	setIfPresent(&book.Title, info.Title)
+	setIfPresent(&book.Author, info.Writer)
+	setIfPresent(&book.Language, info.Language)
+	if info.Series != "" {
+		book.Series = info.Series
+		if number, ok := info.IssueNumber(); ok {
+			book.SeriesIndex = number
+		}
+		if info.Title == "" && info.Number != "" {
+			book.Title = info.Series + " #" + info.Number
+		}
+	}
+	return nil
+}
+
+// setIfPresent keeps the existing value (usually derived from the file
+// name) when the book does not declare the field.
+func setIfPresent(field *string, value string) {
+	if value != "" {
+		*field = value
+	}
+}
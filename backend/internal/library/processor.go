@@ -0,0 +1,147 @@
+package library
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+
+	"github.com/EROQIN/relite-reader/backend/internal/books"
+	"github.com/EROQIN/relite-reader/backend/internal/covers"
+	"github.com/EROQIN/relite-reader/backend/internal/formats"
+	"github.com/EROQIN/relite-reader/backend/internal/tasks"
+)
+
+var ErrUnsupportedFormat = errors.New("unsupported format")
+
+// ContentOpener fetches the raw bytes of a book from its source.
+type ContentOpener interface {
+	OpenContent(ctx context.Context, userID, bookID string) (io.ReadCloser, string, error)
+}
+
+// Enqueuer schedules follow-up tasks.
+type Enqueuer interface {
+	Enqueue(userID, taskType string, payload map[string]string) (tasks.Task, error)
+}
+
+// Task types handled by the Processor.
+const (
+	FormatTask = "format"
+	CoverTask  = "cover"
+)
+
+// Processor runs the background work queued for books once their content
+// is reachable.
+type Processor struct {
+	books   books.Store
+	content ContentOpener
+	queue   Enqueuer
+	covers  *covers.Cache
+}
+
+func NewProcessor(booksStore books.Store, content ContentOpener, queue Enqueuer, coverCache *covers.Cache) *Processor {
+	return &Processor{books: booksStore, content: content, queue: queue, covers: coverCache}
+}
+
+// Register adds the format and cover task handlers to registry.
+func (p *Processor) Register(registry *tasks.Registry) {
+	registry.Register(FormatTask, p.handleFormat, tasks.TypeOptions{})
+	registry.Register(CoverTask, p.handleCover, tasks.TypeOptions{})
+}
+
+func (p *Processor) handleFormat(ctx context.Context, task tasks.Task) error {
+	bookID := task.Payload["book_id"]
+	if bookID == "" {
+		return tasks.Permanent(errors.New("missing book_id"))
+	}
+	book, err := p.books.GetByID(task.UserID, bookID)
+	if errors.Is(err, books.ErrNotFound) {
+		return tasks.Permanent(err)
+	}
+	if err != nil {
+		return err
+	}
+	file, err := OpenSpool(ctx, p.content, task.UserID, bookID)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	detected, ok := formats.DetectContent(book.SourcePath, file, file.Size)
+	if !ok {
+		return tasks.Permanent(ErrUnsupportedFormat)
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(hash, io.NewSectionReader(file, 0, file.Size)); err != nil {
+		return err
+	}
+	updated := book
+	updated.Format = detected.Format
+	updated.ContentHash = hex.EncodeToString(hash.Sum(nil))
+	// Keep the corrected format even when metadata extraction fails.
+	var extractErr error
+	switch detected.Format {
+	case "epub":
+		extractErr = applyEPUBMetadata(&updated, file, file.Size)
+	case "mobi", "azw", "azw3", "azw4", "kfx":
+		extractErr = applyMOBIMetadata(&updated, file, file.Size)
+	case "pdf":
+		extractErr = applyPDFInfo(&updated, file, file.Size)
+	case "cbz", "cbt":
+		extractErr = applyComicInfo(&updated, detected.Format, file, file.Size)
+	}
+	if updated != book {
+		// Written by ID: the file may have been moved while it was read.
+		_, err := p.books.UpdateMetadata(task.UserID, updated)
+		if errors.Is(err, books.ErrNotFound) {
+			return tasks.Permanent(err)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if extractErr != nil {
+		// The same bytes fail the same way on every attempt.
+		return tasks.Permanent(extractErr)
+	}
+	if p.queue != nil && p.covers != nil && covers.Supported(updated.Format) {
+		if _, err := p.queue.Enqueue(task.UserID, CoverTask, map[string]string{"book_id": bookID}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *Processor) handleCover(ctx context.Context, task tasks.Task) error {
+	if p.covers == nil {
+		return tasks.Permanent(errors.New("missing cover cache"))
+	}
+	bookID := task.Payload["book_id"]
+	if bookID == "" {
+		return tasks.Permanent(errors.New("missing book_id"))
+	}
+	book, err := p.books.GetByID(task.UserID, bookID)
+	if errors.Is(err, books.ErrNotFound) {
+		return tasks.Permanent(err)
+	}
+	if err != nil {
+		return err
+	}
+	if !covers.Supported(book.Format) {
+		return nil
+	}
+	file, err := OpenSpool(ctx, p.content, task.UserID, bookID)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	image, err := covers.Extract(book.Format, file, file.Size)
+	if errors.Is(err, covers.ErrNoCover) {
+		// Books without artwork fall back to the client placeholder.
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return p.covers.Store(task.UserID, bookID, image)
+}
@@ -0,0 +1,311 @@
+package library
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/EROQIN/relite-reader/backend/internal/books"
+	"github.com/EROQIN/relite-reader/backend/internal/covers"
+	"github.com/EROQIN/relite-reader/backend/internal/mobi"
+	"github.com/EROQIN/relite-reader/backend/internal/tasks"
+)
+
+type fakeOpener struct {
+	data []byte
+	err  error
+}
+
+func (f fakeOpener) OpenContent(_ context.Context, _, _ string) (io.ReadCloser, string, error) {
+	if f.err != nil {
+		return nil, "", f.err
+	}
+	return io.NopCloser(bytes.NewReader(f.data)), "application/octet-stream", nil
+}
+
+// handle runs a task through a queue with the handlers processor registers
+// and returns it once it has finished. Failed tasks are not retried.
+func handle(t *testing.T, processor *Processor, task tasks.Task) tasks.Task {
+	t.Helper()
+	registry := tasks.NewRegistry()
+	processor.Register(registry)
+	store := tasks.NewMemoryStore()
+	queue := tasks.NewQueue(store, registry, tasks.QueueOptions{Workers: 1})
+	sub := queue.Subscribe(task.UserID)
+	defer sub.Close()
+	task.Status = tasks.StatusQueued
+	task.MaxAttempts = 1
+	created, err := store.Create(task)
+	if err != nil {
+		t.Fatalf("create task: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	queue.Start(ctx)
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case <-sub.Ready():
+			for _, changed := range sub.Next() {
+				if changed.ID == created.ID && changed.Status != tasks.StatusQueued && changed.Status != tasks.StatusRunning {
+					return changed
+				}
+			}
+		case <-timeout:
+			t.Fatalf("task %s did not finish", task.Type)
+		}
+	}
+}
+
+func TestProcessorCorrectsFormat(t *testing.T) {
+	store := books.NewMemoryStore()
+	book, _ := store.Upsert("user-1", books.Book{SourcePath: "/library/report.bin", Title: "report", Format: "bin"})
+	processor := NewProcessor(store, fakeOpener{data: buildPDF()}, nil, nil)
+	task := tasks.Task{UserID: "user-1", Type: FormatTask, Payload: map[string]string{"book_id": book.ID}}
+	if done := handle(t, processor, task); done.Status != tasks.StatusSuccess {
+		t.Fatalf("handle: %s %s", done.Status, done.Error)
+	}
+	updated, _ := store.GetByID("user-1", book.ID)
+	if updated.Format != "pdf" {
+		t.Fatalf("expected pdf, got %q", updated.Format)
+	}
+	if updated.Title != "Quarterly Report" || updated.Author != "Finance" || updated.PageCount != 3 {
+		t.Fatalf("unexpected pdf metadata %+v", updated)
+	}
+	sum := sha256.Sum256(buildPDF())
+	if updated.ContentHash != hex.EncodeToString(sum[:]) {
+		t.Fatalf("expected content hash recorded, got %q", updated.ContentHash)
+	}
+}
+
+// movingOpener moves the book to another path while its content is read,
+// as a sync finding the file renamed would.
+type movingOpener struct {
+	store *books.MemoryStore
+	to    string
+	data  []byte
+}
+
+func (m movingOpener) OpenContent(_ context.Context, userID, bookID string) (io.ReadCloser, string, error) {
+	if _, err := m.store.Move(userID, bookID, m.to); err != nil {
+		return nil, "", err
+	}
+	return io.NopCloser(bytes.NewReader(m.data)), "application/pdf", nil
+}
+
+func TestProcessorKeepsMoveDuringFormat(t *testing.T) {
+	store := books.NewMemoryStore()
+	book, _ := store.Upsert("user-1", books.Book{SourcePath: "/library/report.bin", Title: "report", Format: "bin"})
+	processor := NewProcessor(store, movingOpener{store: store, to: "/library/moved/report.pdf", data: buildPDF()}, nil, nil)
+	task := tasks.Task{UserID: "user-1", Type: FormatTask, Payload: map[string]string{"book_id": book.ID}}
+	if done := handle(t, processor, task); done.Status != tasks.StatusSuccess {
+		t.Fatalf("handle: %s %s", done.Status, done.Error)
+	}
+	list, _ := store.ListByUser("user-1")
+	if len(list) != 1 {
+		t.Fatalf("expected one book, got %+v", list)
+	}
+	if list[0].ID != book.ID || list[0].SourcePath != "/library/moved/report.pdf" || list[0].Format != "pdf" || list[0].Title != "Quarterly Report" {
+		t.Fatalf("unexpected book %+v", list[0])
+	}
+}
+
+func TestProcessorKeepsFormatWhenMetadataFails(t *testing.T) {
+	store := books.NewMemoryStore()
+	book, _ := store.Upsert("user-1", books.Book{SourcePath: "/library/broken.bin", Title: "broken", Format: "bin"})
+	processor := NewProcessor(store, fakeOpener{data: []byte("%PDF-1.7\n")}, nil, nil)
+	task := tasks.Task{UserID: "user-1", Type: FormatTask, Payload: map[string]string{"book_id": book.ID}}
+	if done := handle(t, processor, task); done.Status != tasks.StatusError {
+		t.Fatalf("expected metadata error, got %s", done.Status)
+	}
+	updated, _ := store.GetByID("user-1", book.ID)
+	if updated.Format != "pdf" {
+		t.Fatalf("expected pdf, got %q", updated.Format)
+	}
+}
+
+func TestProcessorRejectsUnknownContent(t *testing.T) {
+	store := books.NewMemoryStore()
+	book, _ := store.Upsert("user-1", books.Book{SourcePath: "/library/data.bin", Title: "data", Format: "bin"})
+	processor := NewProcessor(store, fakeOpener{data: []byte{0x00, 0x01, 0x02}}, nil, nil)
+	task := tasks.Task{UserID: "user-1", Type: FormatTask, Payload: map[string]string{"book_id": book.ID}}
+	done := handle(t, processor, task)
+	if done.Status != tasks.StatusError || !strings.Contains(done.Error, ErrUnsupportedFormat.Error()) {
+		t.Fatalf("expected unsupported format without retries, got %s %s", done.Status, done.Error)
+	}
+}
+
+// buildMOBI returns a text-less Mobipocket file with a full name and an
+// EXTH author.
+func buildMOBI(encryption uint16) []byte {
+	const title, author = "A Wizard of Earthsea", "Ursula K. Le Guin"
+	rec := make([]byte, 16+0xe8)
+	binary.BigEndian.PutUint16(rec[12:], encryption)
+	copy(rec[16:], "MOBI")
+	binary.BigEndian.PutUint32(rec[20:], 0xe8)
+	binary.BigEndian.PutUint32(rec[28:], 65001)
+	binary.BigEndian.PutUint32(rec[0x24:], 6)
+	binary.BigEndian.PutUint32(rec[0x80:], 0x40)
+	rec = append(rec, "EXTH"...)
+	rec = binary.BigEndian.AppendUint32(rec, uint32(20+len(author)))
+	rec = binary.BigEndian.AppendUint32(rec, 1)
+	rec = binary.BigEndian.AppendUint32(rec, 100)
+	rec = binary.BigEndian.AppendUint32(rec, uint32(8+len(author)))
+	rec = append(rec, author...)
+	binary.BigEndian.PutUint32(rec[0x54:], uint32(len(rec)))
+	binary.BigEndian.PutUint32(rec[0x58:], uint32(len(title)))
+	rec = append(rec, title...)
+	pdb := make([]byte, 88)
+	copy(pdb[60:], "BOOKMOBI")
+	binary.BigEndian.PutUint16(pdb[76:], 1)
+	binary.BigEndian.PutUint32(pdb[78:], uint32(len(pdb)))
+	return append(pdb, rec...)
+}
+
+func TestProcessorAppliesMOBIMetadata(t *testing.T) {
+	store := books.NewMemoryStore()
+	book, _ := store.Upsert("user-1", books.Book{SourcePath: "/library/earthsea.azw3", Title: "earthsea", Format: "mobi"})
+	processor := NewProcessor(store, fakeOpener{data: buildMOBI(0)}, nil, nil)
+	task := tasks.Task{UserID: "user-1", Type: FormatTask, Payload: map[string]string{"book_id": book.ID}}
+	if done := handle(t, processor, task); done.Status != tasks.StatusSuccess {
+		t.Fatalf("handle: %s %s", done.Status, done.Error)
+	}
+	updated, _ := store.GetByID("user-1", book.ID)
+	if updated.Title != "A Wizard of Earthsea" || updated.Author != "Ursula K. Le Guin" {
+		t.Fatalf("unexpected mobi metadata %+v", updated)
+	}
+}
+
+func TestProcessorReportsDRMProtectedMOBI(t *testing.T) {
+	store := books.NewMemoryStore()
+	book, _ := store.Upsert("user-1", books.Book{SourcePath: "/library/bought.azw", Title: "bought", Format: "bin"})
+	processor := NewProcessor(store, fakeOpener{data: buildMOBI(2)}, nil, nil)
+	task := tasks.Task{UserID: "user-1", Type: FormatTask, Payload: map[string]string{"book_id": book.ID}}
+	if done := handle(t, processor, task); !strings.Contains(done.Error, mobi.ErrDRM.Error()) {
+		t.Fatalf("expected DRM error, got %s", done.Error)
+	}
+	updated, _ := store.GetByID("user-1", book.ID)
+	if updated.Format != "azw" || updated.Title != "bought" {
+		t.Fatalf("expected format only to change, got %+v", updated)
+	}
+}
+
+func buildEPUB(t *testing.T, opf string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	writer := zip.NewWriter(&buf)
+	mimetype, _ := writer.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store})
+	_, _ = mimetype.Write([]byte("application/epub+zip"))
+	container, _ := writer.Create("META-INF/container.xml")
+	_, _ = container.Write([]byte(`<container><rootfiles><rootfile full-path="content.opf" media-type="application/oebps-package+xml"/></rootfiles></container>`))
+	pkg, _ := writer.Create("content.opf")
+	_, _ = pkg.Write([]byte(opf))
+	if err := writer.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestProcessorAppliesEPUBMetadata(t *testing.T) {
+	store := books.NewMemoryStore()
+	book, _ := store.Upsert("user-1", books.Book{SourcePath: "/library/the_hobbit_v2_final.epub", Title: "the_hobbit_v2_final", Format: "epub"})
+	data := buildEPUB(t, `<package xmlns="http://www.idpf.org/2007/opf" unique-identifier="id">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:title>The Hobbit</dc:title>
+    <dc:creator>J. R. R. Tolkien</dc:creator>
+    <dc:language>en</dc:language>
+    <dc:identifier id="id">urn:isbn:9780261102217</dc:identifier>
+    <meta name="calibre:series" content="Middle-earth"/>
+    <meta name="calibre:series_index" content="1"/>
+  </metadata>
+</package>`)
+	processor := NewProcessor(store, fakeOpener{data: data}, nil, nil)
+	task := tasks.Task{UserID: "user-1", Type: FormatTask, Payload: map[string]string{"book_id": book.ID}}
+	if done := handle(t, processor, task); done.Status != tasks.StatusSuccess {
+		t.Fatalf("handle: %s %s", done.Status, done.Error)
+	}
+	updated, _ := store.GetByID("user-1", book.ID)
+	if updated.Title != "The Hobbit" || updated.Author != "J. R. R. Tolkien" {
+		t.Fatalf("unexpected metadata %+v", updated)
+	}
+	if updated.Language != "en" || updated.Identifier != "urn:isbn:9780261102217" {
+		t.Fatalf("unexpected language/identifier %+v", updated)
+	}
+	if updated.Series != "Middle-earth" || updated.SeriesIndex != 1 {
+		t.Fatalf("unexpected series %+v", updated)
+	}
+}
+
+type recordingQueue struct {
+	tasks []tasks.Task
+}
+
+func (q *recordingQueue) Enqueue(userID, taskType string, payload map[string]string) (tasks.Task, error) {
+	task := tasks.Task{UserID: userID, Type: taskType, Payload: payload}
+	q.tasks = append(q.tasks, task)
+	return task, nil
+}
+
+func TestProcessorCachesCoverAfterFormat(t *testing.T) {
+	store := books.NewMemoryStore()
+	book, _ := store.Upsert("user-1", books.Book{SourcePath: "/comics/issue-1.cbz", Title: "issue-1", Format: "cbz"})
+	var img bytes.Buffer
+	_ = png.Encode(&img, image.NewRGBA(image.Rect(0, 0, 40, 60)))
+	var buf bytes.Buffer
+	writer := zip.NewWriter(&buf)
+	page, _ := writer.Create("page-01.png")
+	_, _ = page.Write(img.Bytes())
+	_ = writer.Close()
+
+	queue := &recordingQueue{}
+	cache := covers.NewCache(t.TempDir())
+	processor := NewProcessor(store, fakeOpener{data: buf.Bytes()}, queue, cache)
+	task := tasks.Task{UserID: "user-1", Type: FormatTask, Payload: map[string]string{"book_id": book.ID}}
+	if done := handle(t, processor, task); done.Status != tasks.StatusSuccess {
+		t.Fatalf("format: %s %s", done.Status, done.Error)
+	}
+	if updated, _ := store.GetByID("user-1", book.ID); updated.PageCount != 1 {
+		t.Fatalf("expected page count from archive, got %d", updated.PageCount)
+	}
+	if len(queue.tasks) != 1 || queue.tasks[0].Type != CoverTask {
+		t.Fatalf("expected cover task, got %+v", queue.tasks)
+	}
+	if done := handle(t, processor, queue.tasks[0]); done.Status != tasks.StatusSuccess {
+		t.Fatalf("cover: %s %s", done.Status, done.Error)
+	}
+	if !cache.Has("user-1", book.ID) {
+		t.Fatalf("expected cached cover")
+	}
+}
+
+func buildPDF() []byte {
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [] /Count 3 >>",
+		"<< /Title (Quarterly Report) /Author (Finance) >>",
+	}
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, body := range objects {
+		offsets[i] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i+1, body)
+	}
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R /Info 3 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return buf.Bytes()
+}
@@ -0,0 +1,45 @@
+package library
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+)
+
+// Spool is a temporary on-disk copy of a book's content, giving format
+// parsers the random access that a WebDAV response body lacks.
+type Spool struct {
+	*os.File
+	Size int64
+}
+
+// OpenSpool copies the content of a book into a temporary file. Close
+// removes the file.
+func OpenSpool(ctx context.Context, content ContentOpener, userID, bookID string) (*Spool, error) {
+	if content == nil {
+		return nil, errors.New("missing content source")
+	}
+	reader, _, err := content.OpenContent(ctx, userID, bookID)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	file, err := os.CreateTemp("", "relite-book-*")
+	if err != nil {
+		return nil, err
+	}
+	spool := &Spool{File: file}
+	spool.Size, err = io.Copy(file, reader)
+	if err != nil {
+		_ = spool.Close()
+		return nil, err
+	}
+	return spool, nil
+}
+
+func (s *Spool) Close() error {
+	err := s.File.Close()
+	_ = os.Remove(s.File.Name())
+	return err
+}
@@ -0,0 +1,123 @@
+package library
+
+import (
+	"context"
+	"sync"
+)
+
+// SpoolCache keeps the most recently opened books on disk so that requests
+// for individual chapters or pages do not download the whole file again.
+type SpoolCache struct {
+	content ContentOpener
+	limit   int
+
+	mu      sync.Mutex
+	entries map[string]*cachedSpool
+	order   []string
+}
+
+type cachedSpool struct {
+	spool   *Spool
+	refs    int
+	evicted bool
+}
+
+func NewSpoolCache(content ContentOpener, limit int) *SpoolCache {
+	if limit <= 0 {
+		limit = 4
+	}
+	return &SpoolCache{content: content, limit: limit, entries: make(map[string]*cachedSpool)}
+}
+
+// Acquire returns the spooled content of a book, downloading it when no
+// copy for this version is cached. The release func must be called once
+// the caller is done reading.
+func (c *SpoolCache) Acquire(ctx context.Context, userID, bookID, version string) (*Spool, func(), error) {
+	key := userID + "/" + bookID + "@" + version
+	if entry := c.lookup(key); entry != nil {
+		return entry.spool, c.releaser(entry), nil
+	}
+	spool, err := OpenSpool(ctx, c.content, userID, bookID)
+	if err != nil {
+		return nil, nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if existing, ok := c.entries[key]; ok {
+		// Another request finished the download first.
+		_ = spool.Close()
+		existing.refs++
+		c.touchLocked(key)
+		return existing.spool, c.releaser(existing), nil
+	}
+	entry := &cachedSpool{spool: spool, refs: 1}
+	c.entries[key] = entry
+	c.order = append(c.order, key)
+	c.evictLocked()
+	return spool, c.releaser(entry), nil
+}
+
+func (c *SpoolCache) lookup(key string) *cachedSpool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	entry.refs++
+	c.touchLocked(key)
+	return entry
+}
+
+func (c *SpoolCache) releaser(entry *cachedSpool) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			entry.refs--
+			if entry.evicted && entry.refs == 0 {
+				_ = entry.spool.Close()
+			}
+		})
+	}
+}
+
+func (c *SpoolCache) touchLocked(key string) {
+	for i, existing := range c.order {
+		if existing == key {
+			c.order = append(c.order[:i], c.order[i+1:]...)
+			break
+		}
+	}
+	c.order = append(c.order, key)
+}
+
+// evictLocked drops the least recently used entries over the limit. Files
+// still being read are removed when their last reader releases them.
+func (c *SpoolCache) evictLocked() {
+	for len(c.order) > c.limit {
+		key := c.order[0]
+		c.order = c.order[1:]
+		entry := c.entries[key]
+		delete(c.entries, key)
+		entry.evicted = true
+		if entry.refs == 0 {
+			_ = entry.spool.Close()
+		}
+	}
+}
+
+// Close removes every cached file that is not in use.
+func (c *SpoolCache) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, entry := range c.entries {
+		entry.evicted = true
+		if entry.refs == 0 {
+			_ = entry.spool.Close()
+		}
+		delete(c.entries, key)
+	}
+	c.order = nil
+}
@@ -0,0 +1,52 @@
+package library
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"testing"
+)
+
+type countingOpener struct {
+	data  []byte
+	calls int
+}
+
+func (c *countingOpener) OpenContent(_ context.Context, _, _ string) (io.ReadCloser, string, error) {
+	c.calls++
+	return io.NopCloser(bytes.NewReader(c.data)), "", nil
+}
+
+func TestSpoolCacheReusesDownloads(t *testing.T) {
+	opener := &countingOpener{data: []byte("comic-bytes")}
+	cache := NewSpoolCache(opener, 1)
+	first, release, err := cache.Acquire(context.Background(), "user-1", "b-1", "v1")
+	if err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+	release()
+	again, release, _ := cache.Acquire(context.Background(), "user-1", "b-1", "v1")
+	release()
+	if opener.calls != 1 || again != first || again.Size != int64(len(opener.data)) {
+		t.Fatalf("expected cached spool, got %d downloads", opener.calls)
+	}
+
+	held, releaseHeld, _ := cache.Acquire(context.Background(), "user-1", "b-1", "v2")
+	if opener.calls != 2 {
+		t.Fatalf("expected new version to download again")
+	}
+	if _, err := os.Stat(first.Name()); !os.IsNotExist(err) {
+		t.Fatalf("expected evicted spool to be removed")
+	}
+	_, releaseOther, _ := cache.Acquire(context.Background(), "user-1", "b-2", "v1")
+	if _, err := os.Stat(held.Name()); err != nil {
+		t.Fatalf("expected spool in use to survive eviction: %v", err)
+	}
+	releaseHeld()
+	if _, err := os.Stat(held.Name()); !os.IsNotExist(err) {
+		t.Fatalf("expected spool to be removed after release")
+	}
+	releaseOther()
+	cache.Close()
+}
@@ -0,0 +1,225 @@
+package mobi
+
+import (
+	"encoding/binary"
+)
+
+const (
+	compressionNone    = 1
+	compressionPalmDOC = 2
+	compressionHuffman = 17480
+)
+
+// maxHuffDepth bounds the recursion through compressed dictionary phrases.
+const maxHuffDepth = 32
+
+// decompressPalmDOC expands the LZ77 variant used by PalmDOC text records.
+// limit bounds the output so hostile back-references cannot balloon.
+func decompressPalmDOC(src []byte, limit int) ([]byte, error) {
+	out := make([]byte, 0, min(len(src)*2, limit))
+	for i := 0; i < len(src); {
+		c := src[i]
+		i++
+		switch {
+		case c == 0 || (c >= 0x09 && c <= 0x7f):
+			out = append(out, c)
+		case c <= 0x08:
+			n := int(c)
+			if i+n > len(src) {
+				return nil, ErrInvalid
+			}
+			out = append(out, src[i:i+n]...)
+			i += n
+		case c >= 0xc0:
+			out = append(out, ' ', c^0x80)
+		default:
+			if i >= len(src) {
+				return nil, ErrInvalid
+			}
+			pair := int(c)<<8 | int(src[i])
+			i++
+			distance := (pair & 0x3fff) >> 3
+			length := pair&0x07 + 3
+			if distance == 0 || distance > len(out) {
+				return nil, ErrInvalid
+			}
+			// Copies may overlap their own output, so go byte by byte.
+			start := len(out) - distance
+			for j := range length {
+				out = append(out, out[start+j])
+			}
+		}
+		if len(out) > limit {
+			return nil, ErrTooLarge
+		}
+	}
+	return out, nil
+}
+
+// huffCDIC decodes the Huffman/dictionary compression of Mobipocket
+// books: a HUFF record with the code tables followed by CDIC records that
+// hold the phrases, which may themselves be compressed.
+type huffCDIC struct {
+	codes   [256]huffCode
+	mincode [33]uint64
+	maxcode [33]uint64
+	phrases []huffPhrase
+}
+
+type huffCode struct {
+	length   int
+	terminal bool
+	maxcode  uint64
+}
+
+type huffPhrase struct {
+	data     []byte
+	expanded bool
+	busy     bool
+}
+
+func newHuffCDIC(huff []byte, cdics [][]byte) (*huffCDIC, error) {
+	if len(huff) < 24 || string(huff[:8]) != "HUFF\x00\x00\x00\x18" {
+		return nil, ErrInvalid
+	}
+	codes := int(binary.BigEndian.Uint32(huff[8:]))
+	limits := int(binary.BigEndian.Uint32(huff[12:]))
+	if codes < 0 || codes+256*4 > len(huff) || limits < 0 || limits+64*4 > len(huff) {
+		return nil, ErrInvalid
+	}
+	h := &huffCDIC{}
+	for i := range h.codes {
+		v := binary.BigEndian.Uint32(huff[codes+i*4:])
+		length := int(v & 0x1f)
+		if length == 0 {
+			return nil, ErrInvalid
+		}
+		h.codes[i] = huffCode{
+			length:   length,
+			terminal: v&0x80 != 0,
+			maxcode:  (uint64(v>>8)+1)<<(32-length) - 1,
+		}
+	}
+	for length := 1; length <= 32; length++ {
+		pair := huff[limits+(length-1)*8:]
+		h.mincode[length] = uint64(binary.BigEndian.Uint32(pair)) << (32 - length)
+		h.maxcode[length] = (uint64(binary.BigEndian.Uint32(pair[4:]))+1)<<(32-length) - 1
+	}
+	for _, cdic := range cdics {
+		if len(cdic) < 16 || string(cdic[:8]) != "CDIC\x00\x00\x00\x10" {
+			return nil, ErrInvalid
+		}
+		total := int(binary.BigEndian.Uint32(cdic[8:]))
+		bits := binary.BigEndian.Uint32(cdic[12:])
+		if bits > 16 {
+			return nil, ErrInvalid
+		}
+		n := min(1<<bits, total-len(h.phrases))
+		for i := range n {
+			if 16+i*2+2 > len(cdic) {
+				return nil, ErrInvalid
+			}
+			offset := 16 + int(binary.BigEndian.Uint16(cdic[16+i*2:]))
+			if offset+2 > len(cdic) {
+				return nil, ErrInvalid
+			}
+			length := binary.BigEndian.Uint16(cdic[offset:])
+			end := offset + 2 + int(length&0x7fff)
+			if end > len(cdic) {
+				return nil, ErrInvalid
+			}
+			h.phrases = append(h.phrases, huffPhrase{data: cdic[offset+2 : end], expanded: length&0x8000 != 0})
+		}
+	}
+	return h, nil
+}
+
+// decompress appends the expansion of one text record to out.
+func (h *huffCDIC) decompress(out, data []byte, limit, depth int) ([]byte, error) {
+	if depth > maxHuffDepth {
+		return nil, ErrInvalid
+	}
+	bitsLeft := len(data) * 8
+	padded := make([]byte, len(data)+8)
+	copy(padded, data)
+	pos := 0
+	x := binary.BigEndian.Uint64(padded)
+	n := 32
+	for {
+		if n <= 0 {
+			pos += 4
+			if pos+8 > len(padded) {
+				break
+			}
+			x = binary.BigEndian.Uint64(padded[pos:])
+			n += 32
+		}
+		code := (x >> n) & 0xffffffff
+		entry := h.codes[code>>24]
+		length, maxcode := entry.length, entry.maxcode
+		if !entry.terminal {
+			for length < 32 && code < h.mincode[length] {
+				length++
+			}
+			maxcode = h.maxcode[length]
+		}
+		n -= length
+		bitsLeft -= length
+		if bitsLeft < 0 {
+			break
+		}
+		index := (maxcode - code) >> (32 - length)
+		if index >= uint64(len(h.phrases)) {
+			return nil, ErrInvalid
+		}
+		phrase := &h.phrases[index]
+		if !phrase.expanded {
+			if phrase.busy {
+				return nil, ErrInvalid
+			}
+			phrase.busy = true
+			expanded, err := h.decompress(nil, phrase.data, limit, depth+1)
+			phrase.busy = false
+			if err != nil {
+				return nil, err
+			}
+			phrase.data, phrase.expanded = expanded, true
+		}
+		out = append(out, phrase.data...)
+		if len(out) > limit {
+			return nil, ErrTooLarge
+		}
+	}
+	return out, nil
+}
+
+// trailingSize returns the number of bytes of trailing entries at the end
+// of a text record, as announced by the extra data flags of the header.
+func trailingSize(data []byte, flags uint16) int {
+	size := len(data)
+	num := 0
+	for f := flags >> 1; f != 0; f >>= 1 {
+		if f&1 == 0 {
+			continue
+		}
+		// Each entry ends with its own size as a backwards varint.
+		value, shift := 0, 0
+		for p := size - num; p > 0; {
+			v := data[p-1]
+			value |= int(v&0x7f) << shift
+			shift += 7
+			p--
+			if v&0x80 != 0 || shift >= 28 {
+				break
+			}
+		}
+		num += value
+		if num >= size {
+			return size
+		}
+	}
+	if flags&1 != 0 && size-num > 0 {
+		num += int(data[size-num-1]&0x03) + 1
+	}
+	return min(num, size)
+}
@@ -0,0 +1,661 @@
+package mobi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/EROQIN/relite-reader/backend/internal/epub"
+	"github.com/EROQIN/relite-reader/backend/internal/xhtml"
+	"golang.org/x/text/encoding/charmap"
+)
+
+// content is the decoded text of a book, filled in by Load.
+type content struct {
+	// text is the flow 0 markup for KF8 and the whole book for MOBI 6.
+	text  []byte
+	parts []part
+	// fragments is the KF8 fragment table that kindle:pos links index.
+	fragments []fragment
+	// flows holds the KF8 stylesheets and SVG images; flow 0 is the text.
+	flows [][]byte
+	// targets are the MOBI 6 byte positions that links and the NCX point
+	// at; an anchor is inserted before each one.
+	targets []int
+	toc     []epub.TocEntry
+	// images caches resource names by 1-based image number; an empty
+	// name marks a record that is not an image.
+	images map[int]string
+}
+
+// part is one chapter: a page-break delimited range of MOBI 6 text, or a
+// KF8 skeleton with its fragments inserted into data.
+type part struct {
+	start, end int
+	data       []byte
+}
+
+type fragment struct {
+	insert int
+	file   int
+	start  int
+	length int
+	aid    string
+}
+
+var (
+	pageBreak    = regexp.MustCompile(`(?i)<mbp:pagebreak`)
+	fileposAttr  = regexp.MustCompile(`(?i)\bfilepos\s*=\s*["']?0*(\d+)["']?`)
+	recindexAttr = regexp.MustCompile(`(?i)\brecindex\s*=\s*["']?0*(\d+)["']?`)
+	markupTag    = regexp.MustCompile(`<[^>]*>`)
+	aidTag       = regexp.MustCompile(`<[^<>]*\said\s*=\s*['"][^'"]+['"][^<>]*>`)
+	aidAttr      = regexp.MustCompile(`\said\s*=\s*['"]([^'"]+)['"]`)
+	idAttr       = regexp.MustCompile(`(?i)^<[^>]*\sid\s*=\s*['"]([^'"]*)['"]`)
+	anyIDAttr    = regexp.MustCompile(`(?i)\sid\s*=`)
+	posLink      = regexp.MustCompile(`^kindle:pos:fid:([0-9A-Va-v]{4}):off:([0-9A-Va-v]{10})`)
+	embedLink    = regexp.MustCompile(`^kindle:embed:([0-9A-Va-v]{4})`)
+	flowLink     = regexp.MustCompile(`^kindle:flow:([0-9A-Va-v]{4})(?:\?mime=([a-z+/]+))?`)
+	imageHref    = regexp.MustCompile(`^images/(\d{5})\.[a-z]+$`)
+	flowHref     = regexp.MustCompile(`^flows/(\d{4})\.(css|svg)$`)
+)
+
+// Load decompresses the text and splits it into chapters. Repeated calls
+// return the first result; Chapters, TOC and RenderChapter call it as
+// needed.
+func (b *Book) Load() error {
+	if b.loaded {
+		return b.loadErr
+	}
+	b.loaded = true
+	raw, err := b.readText()
+	if err == nil {
+		if b.KF8 {
+			err = b.loadKF8(raw)
+		} else {
+			err = b.loadMOBI6(raw)
+		}
+	}
+	b.loadErr = err
+	return err
+}
+
+func (b *Book) readText() ([]byte, error) {
+	h := b.header
+	if h.textLength > maxTextSize {
+		return nil, ErrTooLarge
+	}
+	var decode func(out, data []byte) ([]byte, error)
+	switch h.compression {
+	case compressionNone:
+		decode = func(out, data []byte) ([]byte, error) {
+			if len(out)+len(data) > maxTextSize {
+				return nil, ErrTooLarge
+			}
+			return append(out, data...), nil
+		}
+	case compressionPalmDOC:
+		decode = func(out, data []byte) ([]byte, error) {
+			text, err := decompressPalmDOC(data, maxTextSize-len(out))
+			return append(out, text...), err
+		}
+	case compressionHuffman:
+		huff, err := b.record(h.huffOffset)
+		if err != nil {
+			return nil, err
+		}
+		var cdics [][]byte
+		for i := 1; i < h.huffCount; i++ {
+			cdic, err := b.record(h.huffOffset + i)
+			if err != nil {
+				return nil, err
+			}
+			cdics = append(cdics, cdic)
+		}
+		dict, err := newHuffCDIC(huff, cdics)
+		if err != nil {
+			return nil, err
+		}
+		decode = func(out, data []byte) ([]byte, error) {
+			return dict.decompress(out, data, maxTextSize, 0)
+		}
+	default:
+		return nil, ErrInvalid
+	}
+	out := make([]byte, 0, h.textLength)
+	for i := 1; i <= h.textRecords; i++ {
+		data, err := b.record(h.base + i)
+		if err != nil {
+			return nil, err
+		}
+		data = data[:len(data)-trailingSize(data, h.extraFlags)]
+		if out, err = decode(out, data); err != nil {
+			return nil, err
+		}
+	}
+	if h.textLength > 0 && len(out) > h.textLength {
+		out = out[:h.textLength]
+	}
+	return out, nil
+}
+
+func (b *Book) loadMOBI6(raw []byte) error {
+	b.text = raw
+	start := 0
+	for _, loc := range pageBreak.FindAllIndex(raw, -1) {
+		// Breaks before any text (title pages made of markup only) do
+		// not start a chapter of their own.
+		if cut := loc[0]; hasContent(raw[start:cut]) {
+			b.parts = append(b.parts, part{start: start, end: cut})
+			start = cut
+		}
+	}
+	if len(b.parts) > 0 && !hasContent(raw[start:]) {
+		b.parts[len(b.parts)-1].end = len(raw)
+	} else {
+		b.parts = append(b.parts, part{start: start, end: len(raw)})
+	}
+	targets := make(map[int]bool)
+	for _, m := range fileposAttr.FindAllSubmatch(raw, -1) {
+		if pos, err := strconv.Atoi(string(m[1])); err == nil && pos < len(raw) {
+			targets[pos] = true
+		}
+	}
+	b.toc = b.ncxTOC(func(entry indexEntry) (int, string) {
+		pos := entry.value(1, 0)
+		if pos < 0 || pos >= len(raw) {
+			return -1, ""
+		}
+		targets[pos] = true
+		return b.partAt(pos), "filepos" + strconv.Itoa(pos)
+	})
+	for pos := range targets {
+		b.targets = append(b.targets, pos)
+	}
+	sort.Ints(b.targets)
+	return nil
+}
+
+// hasContent reports whether markup holds visible text or an image.
+func hasContent(markup []byte) bool {
+	if bytes.Contains(bytes.ToLower(markup), []byte("<img")) {
+		return true
+	}
+	return len(bytes.TrimSpace(markupTag.ReplaceAll(markup, nil))) > 0
+}
+
+func (b *Book) loadKF8(raw []byte) error {
+	bounds := []int{0, len(raw)}
+	if b.header.fdst >= 0 {
+		rec, err := b.record(b.header.fdst)
+		if err != nil {
+			return err
+		}
+		if len(rec) >= 12 && string(rec[:4]) == "FDST" {
+			count := int(binary.BigEndian.Uint32(rec[8:]))
+			if count > 0 && 12+count*8 <= len(rec) {
+				bounds = bounds[:0]
+				for i := range count {
+					bounds = append(bounds, int(binary.BigEndian.Uint32(rec[12+i*8:])))
+				}
+				bounds = append(bounds, len(raw))
+			}
+		}
+	}
+	for i := 0; i+1 < len(bounds); i++ {
+		start := min(bounds[i], len(raw))
+		end := min(max(bounds[i+1], start), len(raw))
+		b.flows = append(b.flows, raw[start:end])
+	}
+	b.text = b.flows[0]
+	b.flows[0] = nil
+	if err := b.buildParts(); err != nil {
+		return err
+	}
+	b.toc = b.ncxTOC(func(entry indexEntry) (int, string) {
+		if fid := entry.value(6, 0); fid >= 0 {
+			return b.position(fid, entry.value(6, 1))
+		}
+		pos := entry.value(1, 0)
+		chapter := b.partAt(pos)
+		if chapter < 0 {
+			return -1, ""
+		}
+		return chapter, b.idBefore(chapter, pos)
+	})
+	return nil
+}
+
+// buildParts rebuilds the KF8 documents by inserting every fragment into
+// its skeleton.
+func (b *Book) buildParts() error {
+	if b.header.skeletons < 0 || b.header.fragments < 0 {
+		b.parts = []part{{start: 0, end: len(b.text), data: b.text}}
+		return nil
+	}
+	skeletons, _, err := b.readIndex(b.header.skeletons)
+	if err != nil {
+		return err
+	}
+	fragments, cncx, err := b.readIndex(b.header.fragments)
+	if err != nil {
+		return err
+	}
+	for _, entry := range fragments {
+		insert, err := strconv.Atoi(entry.label)
+		if err != nil {
+			return ErrInvalid
+		}
+		f := fragment{insert: insert, file: entry.value(3, 0), start: entry.value(6, 0), length: entry.value(6, 1)}
+		if f.length < 0 {
+			return ErrInvalid
+		}
+		// Selectors look like P-//*[@aid='0UL'].
+		if selector := cncx[entry.value(2, 0)]; len(selector) > 14 {
+			f.aid = selector[12 : len(selector)-2]
+		}
+		b.fragments = append(b.fragments, f)
+	}
+	next := 0
+	for _, entry := range skeletons {
+		count, start, length := entry.value(1, 0), entry.value(6, 0), entry.value(6, 1)
+		if count < 0 || start < 0 || length < 0 || start+length > len(b.text) {
+			return ErrInvalid
+		}
+		skeleton := slices.Clone(b.text[start : start+length])
+		pos := start + length
+		for range count {
+			if next >= len(b.fragments) {
+				return ErrInvalid
+			}
+			f := &b.fragments[next]
+			if pos+f.length > len(b.text) {
+				return ErrInvalid
+			}
+			insert := f.insert - start
+			if insert < 0 || insert > len(skeleton) || brokenInsert(skeleton, insert) {
+				// Some files carry wrong insert positions; the fragment
+				// selector names the element it belongs in.
+				end := aidTagEnd(skeleton, f.aid)
+				if end < 0 {
+					return ErrInvalid
+				}
+				insert = end + 1
+				if f.start > 0 && insert+f.start <= len(skeleton) {
+					insert += f.start
+				}
+				f.insert = insert + start
+			}
+			skeleton = slices.Insert(skeleton, insert, b.text[pos:pos+f.length]...)
+			pos += f.length
+			next++
+		}
+		b.parts = append(b.parts, part{start: start, end: pos, data: skeleton})
+	}
+	if len(b.parts) == 0 {
+		return ErrInvalid
+	}
+	return nil
+}
+
+// brokenInsert reports whether an insert position falls inside a tag.
+func brokenInsert(skeleton []byte, insert int) bool {
+	head, tail := skeleton[:insert], skeleton[insert:]
+	return bytes.IndexByte(tail, '>') < bytes.IndexByte(tail, '<') ||
+		bytes.LastIndexByte(head, '>') < bytes.LastIndexByte(head, '<')
+}
+
+func aidTagEnd(skeleton []byte, aid string) int {
+	if aid == "" {
+		return -1
+	}
+	for _, attr := range []string{`aid="` + aid + `"`, `aid='` + aid + `'`} {
+		if i := bytes.Index(skeleton, []byte(attr)); i >= 0 {
+			if j := bytes.IndexByte(skeleton[i:], '>'); j >= 0 {
+				return i + j
+			}
+		}
+	}
+	return -1
+}
+
+// partAt returns the chapter that holds a text position.
+func (b *Book) partAt(pos int) int {
+	for i, p := range b.parts {
+		if pos >= p.start && pos < p.end {
+			return i
+		}
+	}
+	return -1
+}
+
+// position resolves a kindle:pos link, given as a fragment number and an
+// offset into it, to a chapter and the closest element id before it.
+func (b *Book) position(fid, offset int) (int, string) {
+	if fid < 0 || fid >= len(b.fragments) || offset < 0 {
+		return -1, ""
+	}
+	pos := b.fragments[fid].insert + offset
+	chapter := b.partAt(pos)
+	if chapter < 0 {
+		if file := b.fragments[fid].file; file >= 0 && file < len(b.parts) {
+			return file, ""
+		}
+		return -1, ""
+	}
+	return chapter, b.idBefore(chapter, pos)
+}
+
+// idBefore finds the id of the last tag that opens at or before pos. KF8
+// books rarely keep their original ids, so aid attributes count too and
+// are exposed as "aid-" ids by RenderChapter.
+func (b *Book) idBefore(chapter, pos int) string {
+	data := b.parts[chapter].data
+	npos := min(max(pos-b.parts[chapter].start, 0), len(data))
+	gt, lt := bytes.IndexByte(data[npos:], '>'), bytes.IndexByte(data[npos:], '<')
+	if gt >= 0 && (lt <= 0 || gt < lt) {
+		npos += gt + 1
+	}
+	head := data[:npos]
+	for end := len(head); end > 0; {
+		gt := bytes.LastIndexByte(head[:end], '>')
+		if gt < 0 {
+			break
+		}
+		lt := bytes.LastIndexByte(head[:gt], '<')
+		if lt < 0 {
+			break
+		}
+		tag := head[lt : gt+1]
+		end = lt
+		switch {
+		case bytes.HasPrefix(tag, []byte("<body")):
+			return ""
+		case bytes.HasPrefix(tag, []byte("<meta")):
+			continue
+		}
+		if m := idAttr.FindSubmatch(tag); m != nil {
+			return string(m[1])
+		}
+		if m := aidAttr.FindSubmatch(tag); m != nil {
+			return "aid-" + string(m[1])
+		}
+	}
+	return ""
+}
+
+type tocItem struct {
+	entry  epub.TocEntry
+	parent int
+}
+
+// ncxTOC reads the NCX index, resolving each entry's target with target.
+func (b *Book) ncxTOC(target func(indexEntry) (int, string)) []epub.TocEntry {
+	if b.header.ncx < 0 {
+		return nil
+	}
+	entries, cncx, err := b.readIndex(b.header.ncx)
+	if err != nil {
+		return nil
+	}
+	items := make([]tocItem, len(entries))
+	for i, entry := range entries {
+		title := b.header.decode([]byte(cncx[entry.value(3, 0)]))
+		chapter, fragment := target(entry)
+		item := epub.TocEntry{Title: strings.Join(strings.Fields(title), " "), Fragment: fragment, Chapter: chapter}
+		if chapter >= 0 {
+			item.Href = chapterHref(chapter)
+		}
+		items[i] = tocItem{entry: item, parent: entry.value(21, 0)}
+	}
+	children := make(map[int][]int)
+	var roots []int
+	for i, item := range items {
+		// Parents always precede their children, which also rules out
+		// cycles in hostile files.
+		if item.parent >= 0 && item.parent < i {
+			children[item.parent] = append(children[item.parent], i)
+		} else {
+			roots = append(roots, i)
+		}
+	}
+	var build func([]int) []epub.TocEntry
+	build = func(ids []int) []epub.TocEntry {
+		var out []epub.TocEntry
+		for _, id := range ids {
+			entry := items[id].entry
+			entry.Children = build(children[id])
+			out = append(out, entry)
+		}
+		return out
+	}
+	return build(roots)
+}
+
+func chapterHref(index int) string {
+	return fmt.Sprintf("part%04d.xhtml", index)
+}
+
+// Chapters lists the parts of the book in reading order. It is empty when
+// the text cannot be decoded.
+func (b *Book) Chapters() []epub.Chapter {
+	if b.Load() != nil {
+		return nil
+	}
+	out := make([]epub.Chapter, len(b.parts))
+	for i := range b.parts {
+		out[i] = epub.Chapter{
+			Index:     i,
+			ID:        strings.TrimSuffix(chapterHref(i), ".xhtml"),
+			Href:      chapterHref(i),
+			MediaType: "application/xhtml+xml",
+			Linear:    true,
+		}
+	}
+	return out
+}
+
+// TOC returns the NCX navigation, or one entry per chapter for books
+// without one.
+func (b *Book) TOC() []epub.TocEntry {
+	if b.Load() != nil {
+		return nil
+	}
+	if len(b.toc) > 0 {
+		return b.toc
+	}
+	var out []epub.TocEntry
+	for _, chapter := range b.Chapters() {
+		out = append(out, epub.TocEntry{Title: chapter.ID, Href: chapter.Href, Chapter: chapter.Index})
+	}
+	return out
+}
+
+// RenderChapter returns the sanitized XHTML of a chapter with kindle:
+// links, filepos links and image references rewritten through urls.
+func (b *Book) RenderChapter(index int, urls epub.URLMapper) ([]byte, error) {
+	if err := b.Load(); err != nil {
+		return nil, err
+	}
+	if index < 0 || index >= len(b.parts) {
+		return nil, epub.ErrChapterRange
+	}
+	var data []byte
+	if b.KF8 {
+		data = aidTag.ReplaceAllFunc(b.parts[index].data, func(tag []byte) []byte {
+			if anyIDAttr.Match(tag) {
+				return tag
+			}
+			return aidAttr.ReplaceAll(tag, []byte(` id="aid-$1"`))
+		})
+	} else {
+		data = b.mobi6Chapter(index)
+	}
+	return xhtml.Sanitize(data, func(kind xhtml.Kind, ref string) (string, bool) {
+		ref = strings.TrimSpace(ref)
+		if ref == "" {
+			return "", false
+		}
+		if kind == xhtml.Link {
+			if chapter, fragment, ok := b.link(ref); ok {
+				if chapter == index && fragment != "" {
+					return "#" + fragment, true
+				}
+				return urls.Chapter(chapter, fragment), true
+			}
+			if strings.HasPrefix(ref, "#") {
+				return ref, true
+			}
+			if parsed, err := url.Parse(ref); err == nil && (parsed.Scheme == "http" || parsed.Scheme == "https" || parsed.Scheme == "mailto") {
+				return ref, true
+			}
+			return "", false
+		}
+		if href, ok := b.resourceHref(ref); ok {
+			return urls.Resource(href), true
+		}
+		return "", false
+	})
+}
+
+// mobi6Chapter cuts a chapter out of MOBI 6 markup, inserting anchors for
+// link targets and turning filepos and recindex attributes into regular
+// href and src references.
+func (b *Book) mobi6Chapter(index int) []byte {
+	p := b.parts[index]
+	var buf bytes.Buffer
+	last := p.start
+	for i := sort.SearchInts(b.targets, p.start); i < len(b.targets) && b.targets[i] < p.end; i++ {
+		at := b.targets[i]
+		// Anchors must not land inside a tag.
+		if lt := bytes.LastIndexByte(b.text[last:at], '<'); lt >= 0 && bytes.IndexByte(b.text[last+lt:at], '>') < 0 {
+			at = last + lt
+		}
+		buf.Write(b.text[last:at])
+		fmt.Fprintf(&buf, `<a id="filepos%d"></a>`, b.targets[i])
+		last = at
+	}
+	buf.Write(b.text[last:p.end])
+	data := fileposAttr.ReplaceAll(buf.Bytes(), []byte(`href="#filepos$1"`))
+	data = recindexAttr.ReplaceAllFunc(data, func(attr []byte) []byte {
+		n, _ := strconv.Atoi(string(recindexAttr.FindSubmatch(attr)[1]))
+		if name, ok := b.imageName(n); ok {
+			return []byte(`src="` + name + `"`)
+		}
+		return nil
+	})
+	if !b.header.utf8 {
+		if decoded, err := charmap.Windows1252.NewDecoder().Bytes(data); err == nil {
+			data = decoded
+		}
+	}
+	return data
+}
+
+// link resolves internal links to a chapter and fragment.
+func (b *Book) link(ref string) (int, string, bool) {
+	if rest, ok := strings.CutPrefix(ref, "#filepos"); ok && !b.KF8 {
+		pos, err := strconv.Atoi(rest)
+		if err != nil {
+			return 0, "", false
+		}
+		chapter := b.partAt(pos)
+		return chapter, "filepos" + rest, chapter >= 0
+	}
+	if m := posLink.FindStringSubmatch(ref); m != nil {
+		fid, _ := strconv.ParseInt(m[1], 32, 64)
+		offset, _ := strconv.ParseInt(m[2], 32, 64)
+		chapter, fragment := b.position(int(fid), int(offset))
+		return chapter, fragment, chapter >= 0
+	}
+	return 0, "", false
+}
+
+// resourceHref maps an image or flow reference to the resource name
+// served by Resource.
+func (b *Book) resourceHref(ref string) (string, bool) {
+	if m := imageHref.FindStringSubmatch(ref); m != nil {
+		n, _ := strconv.Atoi(m[1])
+		name, ok := b.imageName(n)
+		return name, ok && name == ref
+	}
+	if m := embedLink.FindStringSubmatch(ref); m != nil {
+		n, _ := strconv.ParseInt(m[1], 32, 64)
+		return b.imageName(int(n))
+	}
+	if m := flowLink.FindStringSubmatch(ref); m != nil {
+		n, _ := strconv.ParseInt(m[1], 32, 64)
+		if n < 1 || int(n) >= len(b.flows) {
+			return "", false
+		}
+		switch m[2] {
+		case "", "text/css":
+			return fmt.Sprintf("flows/%04d.css", n), true
+		case "image/svg+xml":
+			return fmt.Sprintf("flows/%04d.svg", n), true
+		}
+	}
+	return "", false
+}
+
+// imageName names the n-th image record (counting from 1) after its type.
+func (b *Book) imageName(n int) (string, bool) {
+	if b.images == nil {
+		b.images = make(map[int]string)
+	}
+	if name, ok := b.images[n]; ok {
+		return name, name != ""
+	}
+	name := ""
+	if n >= 1 && b.firstImage >= 0 {
+		if data, err := b.record(b.firstImage + n - 1); err == nil {
+			if typ := imageType(data); typ != "" {
+				name = fmt.Sprintf("images/%05d.%s", n, strings.TrimPrefix(typ, "image/"))
+			}
+		}
+	}
+	b.images[n] = name
+	return name, name != ""
+}
+
+// Resource returns an image record or a KF8 stylesheet or SVG flow by the
+// name used in rendered chapters.
+func (b *Book) Resource(href string, urls epub.URLMapper) ([]byte, string, error) {
+	if m := imageHref.FindStringSubmatch(href); m != nil {
+		n, _ := strconv.Atoi(m[1])
+		if name, ok := b.imageName(n); !ok || name != href {
+			return nil, "", ErrInvalid
+		}
+		data, err := b.record(b.firstImage + n - 1)
+		if err != nil {
+			return nil, "", err
+		}
+		return data, imageType(data), nil
+	}
+	m := flowHref.FindStringSubmatch(href)
+	if m == nil {
+		return nil, "", ErrInvalid
+	}
+	if err := b.Load(); err != nil {
+		return nil, "", err
+	}
+	n, _ := strconv.Atoi(m[1])
+	if n < 1 || n >= len(b.flows) {
+		return nil, "", ErrInvalid
+	}
+	if m[2] == "svg" {
+		return b.flows[n], "image/svg+xml", nil
+	}
+	css := xhtml.RewriteCSS(string(b.flows[n]), func(ref string) (string, bool) {
+		if target, ok := b.resourceHref(ref); ok {
+			return urls.Resource(target), true
+		}
+		return "", false
+	})
+	return []byte(css), "text/css", nil
+}
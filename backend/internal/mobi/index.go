@@ -0,0 +1,235 @@
+package mobi
+
+import (
+	"encoding/binary"
+)
+
+// maxIndexEntries bounds the entries read from one INDX table.
+const maxIndexEntries = 1 << 20
+
+// indexEntry is one row of an INDX table: a label and the tag values that
+// follow it.
+type indexEntry struct {
+	label string
+	tags  map[int][]int
+}
+
+// value returns the i-th value of a tag, or -1 when it is absent.
+func (e indexEntry) value(tag, i int) int {
+	if values := e.tags[tag]; i < len(values) {
+		return values[i]
+	}
+	return -1
+}
+
+type tagDef struct {
+	tag, perEntry int
+	mask          byte
+	end           bool
+}
+
+// readIndex parses the INDX table whose header is record idx. Labels and
+// other strings stored in the CNCX records that follow the table are
+// returned keyed by their offset.
+func (b *Book) readIndex(idx int) ([]indexEntry, map[int]string, error) {
+	data, err := b.record(idx)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(data) < 56 || string(data[:4]) != "INDX" {
+		return nil, nil, ErrInvalid
+	}
+	headerLen := int(binary.BigEndian.Uint32(data[4:]))
+	records := int(binary.BigEndian.Uint32(data[24:]))
+	cncxRecords := int(binary.BigEndian.Uint32(data[52:]))
+	if records > 4096 || cncxRecords > 4096 {
+		return nil, nil, ErrInvalid
+	}
+	controlBytes, tags, err := readTagx(data, headerLen)
+	if err != nil {
+		return nil, nil, err
+	}
+	cncx := make(map[int]string)
+	for j := range cncxRecords {
+		rec, err := b.record(idx + records + 1 + j)
+		if err != nil {
+			return nil, nil, err
+		}
+		readCNCX(rec, j*0x10000, cncx)
+	}
+	var entries []indexEntry
+	for i := idx + 1; i <= idx+records; i++ {
+		data, err := b.record(i)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(data) < 28 || string(data[:4]) != "INDX" {
+			return nil, nil, ErrInvalid
+		}
+		idxt := int(binary.BigEndian.Uint32(data[20:]))
+		count := int(binary.BigEndian.Uint32(data[24:]))
+		if idxt+4+count*2 > len(data) || len(entries)+count > maxIndexEntries {
+			return nil, nil, ErrInvalid
+		}
+		positions := make([]int, count+1)
+		for j := range count {
+			positions[j] = int(binary.BigEndian.Uint16(data[idxt+4+j*2:]))
+		}
+		positions[count] = idxt
+		for j := range count {
+			start, end := positions[j], positions[j+1]
+			if start >= end || end > len(data) {
+				return nil, nil, ErrInvalid
+			}
+			labelEnd := start + 1 + int(data[start])
+			if labelEnd > end {
+				return nil, nil, ErrInvalid
+			}
+			values, err := readTags(data[:end], labelEnd, controlBytes, tags)
+			if err != nil {
+				return nil, nil, err
+			}
+			entries = append(entries, indexEntry{label: string(data[start+1 : labelEnd]), tags: values})
+		}
+	}
+	return entries, cncx, nil
+}
+
+func readTagx(data []byte, start int) (int, []tagDef, error) {
+	if start < 0 || start+12 > len(data) || string(data[start:start+4]) != "TAGX" {
+		return 0, nil, ErrInvalid
+	}
+	length := int(binary.BigEndian.Uint32(data[start+4:]))
+	controlBytes := int(binary.BigEndian.Uint32(data[start+8:]))
+	if start+length > len(data) || controlBytes > 8 {
+		return 0, nil, ErrInvalid
+	}
+	var tags []tagDef
+	for pos := start + 12; pos+4 <= start+length; pos += 4 {
+		tags = append(tags, tagDef{
+			tag:      int(data[pos]),
+			perEntry: int(data[pos+1]),
+			mask:     data[pos+2],
+			end:      data[pos+3] == 1,
+		})
+	}
+	return controlBytes, tags, nil
+}
+
+// readTags decodes the control bytes and variable-width values of one
+// entry starting at pos.
+func readTags(data []byte, pos, controlBytes int, tags []tagDef) (map[int][]int, error) {
+	if pos+controlBytes > len(data) {
+		return nil, ErrInvalid
+	}
+	type pending struct {
+		tag, count, bytes, perEntry int
+	}
+	var found []pending
+	control := 0
+	for _, def := range tags {
+		if def.end {
+			control++
+			continue
+		}
+		if control >= controlBytes || def.mask == 0 {
+			return nil, ErrInvalid
+		}
+		value := data[pos+control] & def.mask
+		if value == 0 {
+			continue
+		}
+		if value != def.mask {
+			mask := def.mask
+			for mask&1 == 0 {
+				mask >>= 1
+				value >>= 1
+			}
+			found = append(found, pending{tag: def.tag, count: int(value), perEntry: def.perEntry})
+			continue
+		}
+		if bitCount(def.mask) == 1 {
+			found = append(found, pending{tag: def.tag, count: 1, perEntry: def.perEntry})
+			continue
+		}
+		// All mask bits set: a varint with the byte length of the values
+		// follows the control bytes.
+		found = append(found, pending{tag: def.tag, count: -1, perEntry: def.perEntry})
+	}
+	next := pos + controlBytes
+	for i := range found {
+		if found[i].count >= 0 {
+			continue
+		}
+		n, consumed, ok := forwardVarint(data, next)
+		if !ok {
+			return nil, ErrInvalid
+		}
+		found[i].bytes = n
+		next += consumed
+	}
+	out := make(map[int][]int, len(found))
+	for _, item := range found {
+		var values []int
+		if item.count >= 0 {
+			for range item.count * item.perEntry {
+				value, consumed, ok := forwardVarint(data, next)
+				if !ok {
+					return nil, ErrInvalid
+				}
+				values = append(values, value)
+				next += consumed
+			}
+		} else {
+			for used := 0; used < item.bytes; {
+				value, consumed, ok := forwardVarint(data, next)
+				if !ok {
+					return nil, ErrInvalid
+				}
+				values = append(values, value)
+				next += consumed
+				used += consumed
+			}
+		}
+		out[item.tag] = values
+	}
+	return out, nil
+}
+
+// forwardVarint reads a big-endian base-128 number whose last byte has
+// the high bit set.
+func forwardVarint(data []byte, pos int) (value, consumed int, ok bool) {
+	for consumed < 5 {
+		if pos+consumed >= len(data) {
+			return 0, 0, false
+		}
+		v := data[pos+consumed]
+		consumed++
+		value = value<<7 | int(v&0x7f)
+		if v&0x80 != 0 {
+			return value, consumed, true
+		}
+	}
+	return 0, 0, false
+}
+
+func readCNCX(data []byte, base int, out map[int]string) {
+	for pos := 0; pos < len(data) && data[pos] != 0; {
+		length, consumed, ok := forwardVarint(data, pos)
+		if !ok {
+			return
+		}
+		start := pos + consumed
+		end := min(start+length, len(data))
+		out[base+pos] = string(data[start:end])
+		pos = end
+	}
+}
+
+func bitCount(b byte) int {
+	n := 0
+	for ; b != 0; b &= b - 1 {
+		n++
+	}
+	return n
+}
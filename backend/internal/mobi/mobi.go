@@ -0,0 +1,386 @@
+// Package mobi reads Mobipocket and Kindle (AZW, AZW3/KF8) books. Legacy
+// MOBI text is split at page breaks; KF8 text is rebuilt into its
+// original parts from the skeleton and fragment indexes. Both are served
+// through the same chapter types as EPUB.
+package mobi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+var (
+	ErrInvalid = errors.New("invalid mobi")
+	// ErrDRM is returned for encrypted books, which cannot be read
+	// without the purchaser's key.
+	ErrDRM = errors.New("book is DRM-protected")
+	// ErrUnsupported covers Kindle containers that are not PalmDB files,
+	// such as Topaz and KFX.
+	ErrUnsupported = errors.New("unsupported kindle format")
+	ErrNoCover     = errors.New("mobi has no cover image")
+	ErrTooLarge    = errors.New("mobi text too large")
+)
+
+// maxRecordSize bounds a single PalmDB record; text records are 4 KiB and
+// images rarely exceed a few hundred KiB.
+const maxRecordSize = 16 << 20
+
+// maxTextSize bounds the decompressed markup of a book.
+const maxTextSize = 64 << 20
+
+// noIndex marks an absent record index in the MOBI header.
+const noIndex = 0xffffffff
+
+const (
+	exthAuthor      = 100
+	exthPublisher   = 101
+	exthDescription = 103
+	exthISBN        = 104
+	exthSubject     = 105
+	exthPublished   = 106
+	exthASIN        = 113
+	exthKF8Boundary = 121
+	exthCoverOffset = 201
+	exthThumbOffset = 202
+	exthTitle       = 503
+	exthLanguage    = 524
+)
+
+type Metadata struct {
+	Title       string
+	Authors     []string
+	Publisher   string
+	Description string
+	Language    string
+	ISBN        string
+	ASIN        string
+	Subjects    []string
+	Published   string
+}
+
+// Author joins all EXTH creators.
+func (m Metadata) Author() string {
+	return strings.Join(m.Authors, ", ")
+}
+
+// Identifier prefers the Amazon ASIN over the ISBN.
+func (m Metadata) Identifier() string {
+	if m.ASIN != "" {
+		return m.ASIN
+	}
+	return m.ISBN
+}
+
+type Book struct {
+	r io.ReaderAt
+	// offsets holds the start of every record followed by the file size.
+	offsets  []int64
+	Metadata Metadata
+	// KF8 is set for AZW3 books and the KF8 half of joint MOBI files.
+	KF8 bool
+
+	header     header
+	firstImage int
+	cover      int
+
+	loaded  bool
+	loadErr error
+	content
+}
+
+// header is the PalmDOC and MOBI header of a record 0. Record indexes are
+// absolute: the KF8 header of a joint file counts from its own record 0.
+type header struct {
+	// base is the record index of this header.
+	base        int
+	compression int
+	textLength  int
+	textRecords int
+	encryption  int
+	version     int
+	utf8        bool
+	fullName    []byte
+	locale      int
+	firstImage  int
+	huffOffset  int
+	huffCount   int
+	extraFlags  uint16
+	exth        map[uint32][][]byte
+	fdst        int
+	ncx         int
+	fragments   int
+	skeletons   int
+}
+
+// Open reads the record table, headers and EXTH metadata. The text is
+// decoded separately by Load.
+func Open(r io.ReaderAt, size int64) (*Book, error) {
+	head := make([]byte, 78)
+	if _, err := r.ReadAt(head, 0); err != nil {
+		if bytes.HasPrefix(head, []byte("TPZ")) || bytes.HasPrefix(head, []byte("CONT")) {
+			return nil, ErrUnsupported
+		}
+		return nil, ErrInvalid
+	}
+	switch {
+	case bytes.HasPrefix(head, []byte("\xeaDRMION\xee")):
+		return nil, ErrDRM
+	case bytes.HasPrefix(head, []byte("TPZ")), bytes.HasPrefix(head, []byte("CONT")):
+		return nil, ErrUnsupported
+	case string(head[60:68]) != "BOOKMOBI":
+		return nil, ErrInvalid
+	}
+	count := int(binary.BigEndian.Uint16(head[76:]))
+	if count == 0 {
+		return nil, ErrInvalid
+	}
+	table := make([]byte, count*8)
+	if _, err := r.ReadAt(table, 78); err != nil {
+		return nil, ErrInvalid
+	}
+	b := &Book{r: r, offsets: make([]int64, count+1), cover: -1}
+	for i := range count {
+		offset := int64(binary.BigEndian.Uint32(table[i*8:]))
+		if offset > size || (i > 0 && offset < b.offsets[i-1]) {
+			return nil, ErrInvalid
+		}
+		b.offsets[i] = offset
+	}
+	b.offsets[count] = size
+
+	rec0, err := b.record(0)
+	if err != nil {
+		return nil, err
+	}
+	main, err := parseHeader(rec0, 0)
+	if err != nil {
+		return nil, err
+	}
+	b.header = main
+	b.KF8 = main.version >= 8
+	// Joint files carry a MOBI 6 book followed by a BOUNDARY record and
+	// the KF8 version, whose header EXTH 121 points at.
+	if values := main.exth[exthKF8Boundary]; len(values) > 0 && len(values[0]) == 4 && !b.KF8 {
+		boundary := int(binary.BigEndian.Uint32(values[0]))
+		if marker, err := b.record(boundary - 1); err == nil && bytes.HasPrefix(marker, []byte("BOUNDARY")) {
+			if rec, err := b.record(boundary); err == nil {
+				if kf8, err := parseHeader(rec, boundary); err == nil {
+					b.header, b.KF8 = kf8, true
+				}
+			}
+		}
+	}
+	if main.encryption != 0 || b.header.encryption != 0 {
+		return nil, ErrDRM
+	}
+	// Resources are shared by both halves of a joint file and numbered
+	// from the MOBI 6 header.
+	b.firstImage = main.firstImage
+	b.Metadata = main.metadata()
+	if b.Metadata.Title == "" {
+		b.Metadata = b.header.metadata()
+	}
+	for _, typ := range []uint32{exthCoverOffset, exthThumbOffset} {
+		if values := main.exth[typ]; len(values) > 0 && len(values[0]) == 4 {
+			offset := binary.BigEndian.Uint32(values[0])
+			if offset != noIndex && b.firstImage >= 0 {
+				b.cover = b.firstImage + int(offset)
+				break
+			}
+		}
+	}
+	return b, nil
+}
+
+func (b *Book) record(i int) ([]byte, error) {
+	if i < 0 || i >= len(b.offsets)-1 {
+		return nil, ErrInvalid
+	}
+	start, end := b.offsets[i], b.offsets[i+1]
+	if end-start > maxRecordSize {
+		return nil, ErrInvalid
+	}
+	data := make([]byte, end-start)
+	if _, err := b.r.ReadAt(data, start); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return data, nil
+}
+
+func parseHeader(rec []byte, base int) (header, error) {
+	if len(rec) < 16 {
+		return header{}, ErrInvalid
+	}
+	h := header{
+		base:        base,
+		compression: int(binary.BigEndian.Uint16(rec[0:])),
+		textLength:  int(binary.BigEndian.Uint32(rec[4:])),
+		textRecords: int(binary.BigEndian.Uint16(rec[8:])),
+		encryption:  int(binary.BigEndian.Uint16(rec[12:])),
+		firstImage:  -1,
+		fdst:        -1,
+		ncx:         -1,
+		fragments:   -1,
+		skeletons:   -1,
+	}
+	if len(rec) < 24 || string(rec[16:20]) != "MOBI" {
+		return h, ErrInvalid
+	}
+	end := min(16+int(binary.BigEndian.Uint32(rec[20:])), len(rec))
+	field := func(offset int) (uint32, bool) {
+		if offset+4 > end {
+			return 0, false
+		}
+		return binary.BigEndian.Uint32(rec[offset:]), true
+	}
+	// index converts a header field into an absolute record index.
+	index := func(offset int) int {
+		value, ok := field(offset)
+		if !ok || value == noIndex {
+			return -1
+		}
+		return base + int(value)
+	}
+	if encoding, _ := field(0x1c); encoding == 65001 {
+		h.utf8 = true
+	}
+	version, _ := field(0x24)
+	h.version = int(version)
+	if offset, ok := field(0x54); ok {
+		length, _ := field(0x58)
+		if int64(offset)+int64(length) <= int64(len(rec)) {
+			h.fullName = rec[offset : offset+length]
+		}
+	}
+	locale, _ := field(0x5c)
+	h.locale = int(locale)
+	h.firstImage = index(0x6c)
+	h.huffOffset = index(0x70)
+	count, _ := field(0x74)
+	h.huffCount = int(count)
+	if h.version >= 5 && end >= 0xf4 {
+		h.extraFlags = binary.BigEndian.Uint16(rec[0xf2:])
+	}
+	h.ncx = index(0xf4)
+	if h.version >= 8 {
+		h.fdst = index(0xc0)
+		h.fragments = index(0xf8)
+		h.skeletons = index(0xfc)
+	}
+	if flags, _ := field(0x80); flags&0x40 != 0 {
+		h.exth = parseEXTH(rec[end:])
+	}
+	return h, nil
+}
+
+func parseEXTH(data []byte) map[uint32][][]byte {
+	if len(data) < 12 || string(data[:4]) != "EXTH" {
+		return nil
+	}
+	out := make(map[uint32][][]byte)
+	count := int(binary.BigEndian.Uint32(data[8:]))
+	pos := 12
+	for range count {
+		if pos+8 > len(data) {
+			break
+		}
+		typ := binary.BigEndian.Uint32(data[pos:])
+		length := int(binary.BigEndian.Uint32(data[pos+4:]))
+		if length < 8 || pos+length > len(data) {
+			break
+		}
+		out[typ] = append(out[typ], data[pos+8:pos+length])
+		pos += length
+	}
+	return out
+}
+
+func (h header) decode(data []byte) string {
+	if h.utf8 {
+		return string(bytes.ToValidUTF8(data, []byte("\ufffd")))
+	}
+	out, err := charmap.Windows1252.NewDecoder().Bytes(data)
+	if err != nil {
+		return string(data)
+	}
+	return string(out)
+}
+
+func (h header) metadata() Metadata {
+	text := func(typ uint32) []string {
+		var out []string
+		for _, value := range h.exth[typ] {
+			if s := strings.TrimSpace(h.decode(value)); s != "" {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	first := func(typ uint32) string {
+		if values := text(typ); len(values) > 0 {
+			return values[0]
+		}
+		return ""
+	}
+	md := Metadata{
+		Title:       first(exthTitle),
+		Authors:     text(exthAuthor),
+		Publisher:   first(exthPublisher),
+		Description: first(exthDescription),
+		Language:    first(exthLanguage),
+		ISBN:        first(exthISBN),
+		ASIN:        first(exthASIN),
+		Subjects:    text(exthSubject),
+		Published:   first(exthPublished),
+	}
+	if md.Title == "" {
+		md.Title = strings.TrimSpace(h.decode(h.fullName))
+	}
+	if md.Language == "" {
+		md.Language = localeLanguages[h.locale&0xff]
+	}
+	return md
+}
+
+// localeLanguages maps the primary Windows language id stored in the MOBI
+// header to a BCP 47 tag, for books without EXTH 524.
+var localeLanguages = map[int]string{
+	0x01: "ar", 0x02: "bg", 0x03: "ca", 0x04: "zh", 0x05: "cs", 0x06: "da",
+	0x07: "de", 0x08: "el", 0x09: "en", 0x0a: "es", 0x0b: "fi", 0x0c: "fr",
+	0x0d: "he", 0x0e: "hu", 0x0f: "is", 0x10: "it", 0x11: "ja", 0x12: "ko",
+	0x13: "nl", 0x14: "no", 0x15: "pl", 0x16: "pt", 0x18: "ro", 0x19: "ru",
+	0x1a: "hr", 0x1b: "sk", 0x1d: "sv", 0x1e: "th", 0x1f: "tr", 0x22: "uk",
+}
+
+// Cover returns the image referenced by EXTH 201, or the thumbnail from
+// EXTH 202 when there is no full-size cover.
+func (b *Book) Cover() ([]byte, error) {
+	if b.cover < 0 {
+		return nil, ErrNoCover
+	}
+	data, err := b.record(b.cover)
+	if err != nil || imageType(data) == "" {
+		return nil, ErrNoCover
+	}
+	return data, nil
+}
+
+// imageType sniffs the media type of an image record.
+func imageType(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte("\xff\xd8\xff")):
+		return "image/jpeg"
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		return "image/png"
+	case bytes.HasPrefix(data, []byte("GIF8")):
+		return "image/gif"
+	case bytes.HasPrefix(data, []byte("BM")) && len(data) > 14:
+		return "image/bmp"
+	}
+	return ""
+}
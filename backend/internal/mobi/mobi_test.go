@@ -0,0 +1,406 @@
+package mobi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/EROQIN/relite-reader/backend/internal/epub"
+)
+
+var testPNG = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+var testURLs = epub.URLMapper{
+	Chapter: func(index int, fragment string) string {
+		return "ch" + strconv.Itoa(index) + "#" + fragment
+	},
+	Resource: func(href string) string { return "res/" + href },
+}
+
+type exthRecord struct {
+	typ  uint32
+	data []byte
+}
+
+func u32(v int) []byte {
+	return binary.BigEndian.AppendUint32(nil, uint32(v))
+}
+
+// buildRecord0 returns a PalmDOC and MOBI header. fields overrides header
+// words by their offset from the start of the record.
+func buildRecord0(compression, encryption uint16, textLength, textRecords int, fields map[int]uint32, exth []exthRecord, name string) []byte {
+	rec := make([]byte, 0x108)
+	binary.BigEndian.PutUint16(rec[0:], compression)
+	binary.BigEndian.PutUint32(rec[4:], uint32(textLength))
+	binary.BigEndian.PutUint16(rec[8:], uint16(textRecords))
+	binary.BigEndian.PutUint16(rec[10:], 4096)
+	binary.BigEndian.PutUint16(rec[12:], encryption)
+	copy(rec[16:], "MOBI")
+	binary.BigEndian.PutUint32(rec[20:], 0x108-16)
+	binary.BigEndian.PutUint32(rec[24:], 2)
+	binary.BigEndian.PutUint32(rec[28:], 65001)
+	binary.BigEndian.PutUint32(rec[0x24:], 6)
+	for _, offset := range []int{0x6c, 0xc0, 0xf4, 0xf8, 0xfc, 0x104} {
+		binary.BigEndian.PutUint32(rec[offset:], noIndex)
+	}
+	for offset, value := range fields {
+		binary.BigEndian.PutUint32(rec[offset:], value)
+	}
+	if len(exth) > 0 {
+		binary.BigEndian.PutUint32(rec[0x80:], 0x40)
+		var body []byte
+		for _, record := range exth {
+			body = append(body, u32(int(record.typ))...)
+			body = append(body, u32(len(record.data)+8)...)
+			body = append(body, record.data...)
+		}
+		rec = append(rec, "EXTH"...)
+		rec = append(rec, u32(len(body)+12)...)
+		rec = append(rec, u32(len(exth))...)
+		rec = append(rec, body...)
+	}
+	binary.BigEndian.PutUint32(rec[0x54:], uint32(len(rec)))
+	binary.BigEndian.PutUint32(rec[0x58:], uint32(len(name)))
+	return append(rec, name...)
+}
+
+func buildPDB(records ...[]byte) []byte {
+	out := make([]byte, 78+len(records)*8+2)
+	copy(out, "Test_Book")
+	copy(out[60:], "BOOKMOBI")
+	binary.BigEndian.PutUint16(out[76:], uint16(len(records)))
+	offset := len(out)
+	for i, record := range records {
+		binary.BigEndian.PutUint32(out[78+i*8:], uint32(offset))
+		binary.BigEndian.PutUint32(out[82+i*8:], uint32(i*2))
+		offset += len(record)
+	}
+	for _, record := range records {
+		out = append(out, record...)
+	}
+	return out
+}
+
+// palmLiterals encodes text as PalmDOC without back-references.
+func palmLiterals(text string) []byte {
+	var out []byte
+	for _, c := range []byte(text) {
+		if c == 0 || (c >= 0x09 && c <= 0x7f) {
+			out = append(out, c)
+		} else {
+			out = append(out, 0x01, c)
+		}
+	}
+	return out
+}
+
+func openBook(t *testing.T, data []byte) *Book {
+	t.Helper()
+	book, err := Open(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if err := book.Load(); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	return book
+}
+
+func TestDecompressPalmDOC(t *testing.T) {
+	src := append([]byte("Hello"), 0x80, 0x2a, 0xe1, 0x02, 0x80, 0x81)
+	out, err := decompressPalmDOC(src, 1024)
+	if err != nil {
+		t.Fatalf("decompress: %v", err)
+	}
+	if string(out) != "HelloHello a\x80\x81" {
+		t.Fatalf("unexpected output %q", out)
+	}
+	if _, err := decompressPalmDOC([]byte{0x80, 0x2a}, 1024); !errors.Is(err, ErrInvalid) {
+		t.Fatalf("expected invalid back-reference, got %v", err)
+	}
+	if _, err := decompressPalmDOC(src, 8); !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("expected limit error, got %v", err)
+	}
+}
+
+func TestHuffCDIC(t *testing.T) {
+	// Every byte is a terminal 8-bit code; byte b selects phrase 255-b.
+	huff := make([]byte, 24+256*4+64*8)
+	copy(huff, "HUFF\x00\x00\x00\x18")
+	binary.BigEndian.PutUint32(huff[8:], 24)
+	binary.BigEndian.PutUint32(huff[12:], 24+256*4)
+	for i := range 256 {
+		binary.BigEndian.PutUint32(huff[24+i*4:], 255<<8|0x80|8)
+	}
+	phrases := []struct {
+		data    []byte
+		literal bool
+	}{
+		{[]byte("Hello "), true},
+		{[]byte("world"), true},
+		{[]byte{0xff, 0xfe}, false},
+	}
+	cdic := []byte("CDIC\x00\x00\x00\x10")
+	cdic = append(cdic, u32(len(phrases))...)
+	cdic = append(cdic, u32(2)...)
+	var table, body []byte
+	for _, phrase := range phrases {
+		table = binary.BigEndian.AppendUint16(table, uint16(len(phrases)*2+len(body)))
+		length := uint16(len(phrase.data))
+		if phrase.literal {
+			length |= 0x8000
+		}
+		body = binary.BigEndian.AppendUint16(body, length)
+		body = append(body, phrase.data...)
+	}
+	cdic = append(append(cdic, table...), body...)
+	dict, err := newHuffCDIC(huff, [][]byte{cdic})
+	if err != nil {
+		t.Fatalf("load tables: %v", err)
+	}
+	out, err := dict.decompress(nil, []byte{0xff, 0xfe, 0xfd}, 1024, 0)
+	if err != nil {
+		t.Fatalf("decompress: %v", err)
+	}
+	if string(out) != "Hello worldHello world" {
+		t.Fatalf("unexpected output %q", out)
+	}
+}
+
+func TestTrailingSize(t *testing.T) {
+	data := []byte("abcX\x01\x00\x00\x83")
+	if n := trailingSize(data, 0x03); n != 5 {
+		t.Fatalf("expected 5 trailing bytes, got %d", n)
+	}
+}
+
+func buildMOBI6(t *testing.T, encryption uint16) []byte {
+	t.Helper()
+	text := `<html><head><guide></guide></head><body><mbp:pagebreak/>` +
+		`<h1>Chapter One</h1><p>Caf` + "\xe9" + ` <a filepos=XXXXXXXXXX>next</a></p><img recindex="00001"/>` +
+		`<mbp:pagebreak/><h1>Chapter Two</h1><p>End</p></body></html>`
+	target := strings.LastIndex(text, "<mbp:pagebreak")
+	text = strings.Replace(text, "XXXXXXXXXX", fmt.Sprintf("%010d", target), 1)
+	half := len(text) / 2
+	rec0 := buildRecord0(2, encryption, len(text), 2, map[int]uint32{0x1c: 1252, 0x6c: 3}, []exthRecord{
+		{exthAuthor, []byte("Jane Doe")},
+		{exthAuthor, []byte("John Roe")},
+		{exthASIN, []byte("B00TEST")},
+		{exthTitle, []byte("Updated Title")},
+		{exthCoverOffset, u32(0)},
+	}, "Full Name")
+	return buildPDB(rec0, palmLiterals(text[:half]), palmLiterals(text[half:]), testPNG)
+}
+
+func TestMOBI6Book(t *testing.T) {
+	book := openBook(t, buildMOBI6(t, 0))
+	md := book.Metadata
+	if md.Title != "Updated Title" || md.Author() != "Jane Doe, John Roe" || md.Identifier() != "B00TEST" {
+		t.Fatalf("unexpected metadata %+v", md)
+	}
+	if book.KF8 {
+		t.Fatalf("expected a MOBI 6 book")
+	}
+	if cover, err := book.Cover(); err != nil || !bytes.Equal(cover, testPNG) {
+		t.Fatalf("unexpected cover %q: %v", cover, err)
+	}
+	if chapters := book.Chapters(); len(chapters) != 2 || chapters[1].Href != "part0001.xhtml" {
+		t.Fatalf("unexpected chapters %+v", chapters)
+	}
+	if toc := book.TOC(); len(toc) != 2 || toc[1].Chapter != 1 {
+		t.Fatalf("unexpected toc %+v", toc)
+	}
+	first, err := book.RenderChapter(0, testURLs)
+	if err != nil {
+		t.Fatalf("render: %v", err)
+	}
+	for _, want := range []string{"Café", `href="ch1#filepos`, `src="res/images/00001.png"`, "Chapter One"} {
+		if !strings.Contains(string(first), want) {
+			t.Fatalf("chapter 0 missing %q:\n%s", want, first)
+		}
+	}
+	if strings.Contains(string(first), "Chapter Two") {
+		t.Fatalf("chapter 0 should end at the page break:\n%s", first)
+	}
+	second, err := book.RenderChapter(1, testURLs)
+	if err != nil {
+		t.Fatalf("render: %v", err)
+	}
+	if !strings.Contains(string(second), `id="filepos`) || !strings.Contains(string(second), "Chapter Two") {
+		t.Fatalf("unexpected chapter 1:\n%s", second)
+	}
+	if _, err := book.RenderChapter(2, testURLs); !errors.Is(err, epub.ErrChapterRange) {
+		t.Fatalf("expected range error, got %v", err)
+	}
+	data, mediaType, err := book.Resource("images/00001.png", testURLs)
+	if err != nil || mediaType != "image/png" || !bytes.Equal(data, testPNG) {
+		t.Fatalf("unexpected resource %q %q: %v", data, mediaType, err)
+	}
+	if _, _, err := book.Resource("images/00002.png", testURLs); err == nil {
+		t.Fatalf("expected missing image to fail")
+	}
+}
+
+func TestOpenRejectsDRM(t *testing.T) {
+	data := buildMOBI6(t, 2)
+	if _, err := Open(bytes.NewReader(data), int64(len(data))); !errors.Is(err, ErrDRM) {
+		t.Fatalf("expected DRM error, got %v", err)
+	}
+	kfx := []byte("\xeaDRMION\xee" + strings.Repeat("\x00", 100))
+	if _, err := Open(bytes.NewReader(kfx), int64(len(kfx))); !errors.Is(err, ErrDRM) {
+		t.Fatalf("expected DRM error for KFX, got %v", err)
+	}
+}
+
+type testEntry struct {
+	label   string
+	control byte
+	values  []int
+}
+
+func varint(v int) []byte {
+	out := []byte{byte(v&0x7f) | 0x80}
+	for v >>= 7; v > 0; v >>= 7 {
+		out = append([]byte{byte(v & 0x7f)}, out...)
+	}
+	return out
+}
+
+// buildCNCX returns a string record and the offset of each string.
+func buildCNCX(values ...string) ([]byte, []int) {
+	var out []byte
+	var offsets []int
+	for _, value := range values {
+		offsets = append(offsets, len(out))
+		out = append(append(out, varint(len(value))...), value...)
+	}
+	return out, offsets
+}
+
+// buildIndex returns the header and data records of an INDX table, plus
+// its CNCX record when there is one.
+func buildIndex(tags []tagDef, entries []testEntry, cncx []byte) [][]byte {
+	header := make([]byte, 192)
+	copy(header, "INDX")
+	binary.BigEndian.PutUint32(header[4:], 192)
+	binary.BigEndian.PutUint32(header[24:], 1)
+	if cncx != nil {
+		binary.BigEndian.PutUint32(header[52:], 1)
+	}
+	header = append(header, "TAGX"...)
+	header = append(header, u32(12+len(tags)*4)...)
+	header = append(header, u32(1)...)
+	for _, tag := range tags {
+		end := byte(0)
+		if tag.end {
+			end = 1
+		}
+		header = append(header, byte(tag.tag), byte(tag.perEntry), tag.mask, end)
+	}
+	data := make([]byte, 192)
+	copy(data, "INDX")
+	var positions []int
+	for _, entry := range entries {
+		positions = append(positions, len(data))
+		data = append(data, byte(len(entry.label)))
+		data = append(data, entry.label...)
+		data = append(data, entry.control)
+		for _, value := range entry.values {
+			data = append(data, varint(value)...)
+		}
+	}
+	binary.BigEndian.PutUint32(data[20:], uint32(len(data)))
+	binary.BigEndian.PutUint32(data[24:], uint32(len(entries)))
+	data = append(data, "IDXT"...)
+	for _, pos := range positions {
+		data = binary.BigEndian.AppendUint16(data, uint16(pos))
+	}
+	records := [][]byte{header, data}
+	if cncx != nil {
+		records = append(records, cncx)
+	}
+	return records
+}
+
+func buildKF8(t *testing.T) []byte {
+	t.Helper()
+	skeleton1 := `<html><head><link href="kindle:flow:0001?mime=text/css" rel="stylesheet" type="text/css"/></head><body aid="0"></body></html>`
+	fragment1 := `<h1 id="c1">One</h1><p><a href="kindle:pos:fid:0001:off:0000000000">next</a><img src="kindle:embed:0001?mime=image/png"/></p>`
+	skeleton2 := `<html><head></head><body aid="1"></body></html>`
+	fragment2 := `<h2 aid="7">Two</h2><p>End</p>`
+	text := skeleton1 + fragment1 + skeleton2 + fragment2
+	css := `body{background:url(kindle:embed:0001?mime=image/png)}`
+	raw := text + css
+	start2 := len(skeleton1) + len(fragment1)
+
+	fdst := []byte("FDST")
+	fdst = append(fdst, u32(12)...)
+	fdst = append(fdst, u32(2)...)
+	for _, v := range []int{0, len(text), len(text), len(raw)} {
+		fdst = append(fdst, u32(v)...)
+	}
+	skel := buildIndex([]tagDef{{1, 1, 0x03, false}, {6, 2, 0x0c, false}, {0, 0, 0, true}}, []testEntry{
+		{"SKEL0000000", 0x05, []int{1, 0, len(skeleton1)}},
+		{"SKEL0000001", 0x05, []int{1, start2, len(skeleton2)}},
+	}, nil)
+	selectors, selectorOffsets := buildCNCX("P-//*[@aid='0']", "P-//*[@aid='1']")
+	frag := buildIndex([]tagDef{{2, 1, 0x01, false}, {3, 1, 0x02, false}, {4, 1, 0x04, false}, {6, 2, 0x08, false}, {0, 0, 0, true}}, []testEntry{
+		{strconv.Itoa(strings.Index(skeleton1, "</body>")), 0x0f, []int{selectorOffsets[0], 0, 0, 0, len(fragment1)}},
+		{strconv.Itoa(start2 + strings.Index(skeleton2, "</body>")), 0x0f, []int{selectorOffsets[1], 1, 1, 0, len(fragment2)}},
+	}, selectors)
+	titles, titleOffsets := buildCNCX("One", "Two")
+	ncx := buildIndex([]tagDef{{1, 1, 0x01, false}, {3, 1, 0x02, false}, {4, 1, 0x04, false}, {6, 2, 0x08, false}, {21, 1, 0x10, false}, {0, 0, 0, true}}, []testEntry{
+		{"0", 0x0e, []int{titleOffsets[0], 0, 0, 0}},
+		{"1", 0x1e, []int{titleOffsets[1], 1, 1, 0, 0}},
+	}, titles)
+
+	rec0 := buildRecord0(1, 0, len(raw), 1, map[int]uint32{
+		0x24: 8, 0x6c: 2, 0xc0: 3, 0xfc: 4, 0xf8: 6, 0xf4: 9,
+	}, nil, "KF8 Book")
+	records := [][]byte{rec0, []byte(raw), testPNG, fdst}
+	records = append(records, skel...)
+	records = append(records, frag...)
+	records = append(records, ncx...)
+	return buildPDB(records...)
+}
+
+func TestKF8Book(t *testing.T) {
+	book := openBook(t, buildKF8(t))
+	if !book.KF8 || book.Metadata.Title != "KF8 Book" {
+		t.Fatalf("unexpected book kf8=%v metadata=%+v", book.KF8, book.Metadata)
+	}
+	if chapters := book.Chapters(); len(chapters) != 2 {
+		t.Fatalf("unexpected chapters %+v", chapters)
+	}
+	toc := book.TOC()
+	if len(toc) != 1 || toc[0].Title != "One" || toc[0].Chapter != 0 || toc[0].Fragment != "c1" {
+		t.Fatalf("unexpected toc %+v", toc)
+	}
+	if children := toc[0].Children; len(children) != 1 || children[0].Chapter != 1 || children[0].Fragment != "aid-7" {
+		t.Fatalf("unexpected nested toc %+v", toc[0].Children)
+	}
+	first, err := book.RenderChapter(0, testURLs)
+	if err != nil {
+		t.Fatalf("render: %v", err)
+	}
+	for _, want := range []string{`<h1 id="c1">One</h1>`, `href="ch1#aid-7"`, `src="res/images/00001.png"`, `href="res/flows/0001.css"`} {
+		if !strings.Contains(string(first), want) {
+			t.Fatalf("chapter 0 missing %q:\n%s", want, first)
+		}
+	}
+	second, err := book.RenderChapter(1, testURLs)
+	if err != nil {
+		t.Fatalf("render: %v", err)
+	}
+	if !strings.Contains(string(second), `<h2 id="aid-7">Two</h2>`) {
+		t.Fatalf("unexpected chapter 1:\n%s", second)
+	}
+	css, mediaType, err := book.Resource("flows/0001.css", testURLs)
+	if err != nil || mediaType != "text/css" || !strings.Contains(string(css), `url("res/images/00001.png")`) {
+		t.Fatalf("unexpected stylesheet %q %q: %v", css, mediaType, err)
+	}
+}
@@ -0,0 +1,304 @@
+package pdf
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/md5"
+	"crypto/rc4"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"hash"
+)
+
+var passwordPadding = []byte{
+	0x28, 0xbf, 0x4e, 0x5e, 0x4e, 0x75, 0x8a, 0x41, 0x64, 0x00, 0x4e, 0x56, 0xff, 0xfa, 0x01, 0x08,
+	0x2e, 0x2e, 0x00, 0xb6, 0xd0, 0x68, 0x3e, 0x80, 0x2f, 0x0c, 0xa9, 0xfe, 0x64, 0x53, 0x69, 0x7a,
+}
+
+const (
+	methodIdentity = "Identity"
+	methodRC4      = "V2"
+	methodAES128   = "AESV2"
+	methodAES256   = "AESV3"
+)
+
+// decrypter implements the standard security handler for files that open
+// with an empty user password, which covers most "encrypted" PDFs that
+// only restrict printing or copying.
+type decrypter struct {
+	key             []byte
+	stringMethod    string
+	streamMethod    string
+	encryptMetadata bool
+}
+
+func newDecrypter(d *Document, encObj any) (*decrypter, error) {
+	enc := dictOf(d.Resolve(encObj))
+	if enc == nil || enc[Name("Filter")] != Name("Standard") {
+		return nil, ErrEncrypted
+	}
+	v, _ := d.Resolve(enc[Name("V")]).(int64)
+	r, _ := d.Resolve(enc[Name("R")]).(int64)
+	o, _ := d.Resolve(enc[Name("O")]).(String)
+	u, _ := d.Resolve(enc[Name("U")]).(String)
+	p, _ := d.Resolve(enc[Name("P")]).(int64)
+	dec := &decrypter{encryptMetadata: true, stringMethod: methodRC4, streamMethod: methodRC4}
+	if value, ok := d.Resolve(enc[Name("EncryptMetadata")]).(bool); ok {
+		dec.encryptMetadata = value
+	}
+	if v >= 4 {
+		filters := dictOf(d.Resolve(enc[Name("CF")]))
+		dec.stringMethod = cryptMethod(d, filters, enc[Name("StrF")])
+		dec.streamMethod = cryptMethod(d, filters, enc[Name("StmF")])
+	}
+	if v >= 5 {
+		ue, _ := d.Resolve(enc[Name("UE")]).(String)
+		key, err := aes256Key(int(r), []byte(u), []byte(ue))
+		if err != nil {
+			return nil, err
+		}
+		dec.key = key
+		return dec, nil
+	}
+	length := int64(40)
+	if value, ok := d.Resolve(enc[Name("Length")]).(int64); ok && value >= 40 && value <= 128 {
+		length = value
+	}
+	if v == 1 {
+		length = 40
+	}
+	var id []byte
+	if ids, ok := d.Resolve(d.Trailer[Name("ID")]).(Array); ok && len(ids) > 0 {
+		first, _ := d.Resolve(ids[0]).(String)
+		id = first
+	}
+	key := legacyKey(int(r), int(length/8), []byte(o), int32(p), id, dec.encryptMetadata)
+	if !checkUserKey(int(r), key, []byte(u), id) {
+		return nil, ErrEncrypted
+	}
+	dec.key = key
+	return dec, nil
+}
+
+func cryptMethod(d *Document, filters Dict, name any) string {
+	filterName, _ := d.Resolve(name).(Name)
+	if filterName == "" || filterName == "Identity" {
+		return methodIdentity
+	}
+	filter := dictOf(d.Resolve(filters[filterName]))
+	method, _ := d.Resolve(filter[Name("CFM")]).(Name)
+	switch method {
+	case "AESV2":
+		return methodAES128
+	case "AESV3":
+		return methodAES256
+	case "None":
+		return methodIdentity
+	}
+	return methodRC4
+}
+
+// legacyKey computes the file key for revisions 2-4 (algorithm 2).
+func legacyKey(r, n int, o []byte, p int32, id []byte, encryptMetadata bool) []byte {
+	h := md5.New()
+	h.Write(passwordPadding)
+	h.Write(padTo32(o))
+	var pb [4]byte
+	binary.LittleEndian.PutUint32(pb[:], uint32(p))
+	h.Write(pb[:])
+	h.Write(id)
+	if r >= 4 && !encryptMetadata {
+		h.Write([]byte{0xff, 0xff, 0xff, 0xff})
+	}
+	sum := h.Sum(nil)
+	if n > len(sum) {
+		n = len(sum)
+	}
+	if r >= 3 {
+		for i := 0; i < 50; i++ {
+			next := md5.Sum(sum[:n])
+			sum = next[:]
+		}
+	}
+	return sum[:n]
+}
+
+func padTo32(b []byte) []byte {
+	if len(b) >= 32 {
+		return b[:32]
+	}
+	return append(append([]byte(nil), b...), passwordPadding[:32-len(b)]...)
+}
+
+// checkUserKey validates the computed key against /U (algorithms 4 and 5).
+func checkUserKey(r int, key, u, id []byte) bool {
+	if len(u) < 16 {
+		return false
+	}
+	if r == 2 {
+		out := rc4Crypt(key, passwordPadding)
+		return bytes.Equal(out, u[:32])
+	}
+	h := md5.New()
+	h.Write(passwordPadding)
+	h.Write(id)
+	out := h.Sum(nil)
+	for i := 0; i < 20; i++ {
+		round := make([]byte, len(key))
+		for j := range key {
+			round[j] = key[j] ^ byte(i)
+		}
+		out = rc4Crypt(round, out)
+	}
+	return bytes.Equal(out[:16], u[:16])
+}
+
+// aes256Key derives the file key for revisions 5 and 6 with an empty
+// user password.
+func aes256Key(r int, u, ue []byte) ([]byte, error) {
+	if len(u) < 48 || len(ue) < 32 {
+		return nil, ErrEncrypted
+	}
+	validationSalt := u[32:40]
+	keySalt := u[40:48]
+	var check, intermediate []byte
+	if r >= 6 {
+		check = hardenedHash(nil, validationSalt)
+		intermediate = hardenedHash(nil, keySalt)
+	} else {
+		sum := sha256.Sum256(validationSalt)
+		check = sum[:]
+		sum = sha256.Sum256(keySalt)
+		intermediate = sum[:]
+	}
+	if !bytes.Equal(check, u[:32]) {
+		return nil, ErrEncrypted
+	}
+	block, err := aes.NewCipher(intermediate)
+	if err != nil {
+		return nil, err
+	}
+	key := make([]byte, 32)
+	cipher.NewCBCDecrypter(block, make([]byte, aes.BlockSize)).CryptBlocks(key, ue[:32])
+	return key, nil
+}
+
+// hardenedHash is algorithm 2.B from ISO 32000-2 (user password variant).
+func hardenedHash(password, salt []byte) []byte {
+	first := sha256.Sum256(append(append([]byte(nil), password...), salt...))
+	k := first[:]
+	for i := 0; ; i++ {
+		unit := append(append([]byte(nil), password...), k...)
+		k1 := bytes.Repeat(unit, 64)
+		block, err := aes.NewCipher(k[:16])
+		if err != nil {
+			return nil
+		}
+		e := make([]byte, len(k1))
+		cipher.NewCBCEncrypter(block, k[16:32]).CryptBlocks(e, k1)
+		sum := 0
+		for _, b := range e[:16] {
+			sum += int(b)
+		}
+		var h hash.Hash
+		switch sum % 3 {
+		case 0:
+			h = sha256.New()
+		case 1:
+			h = sha512.New384()
+		default:
+			h = sha512.New()
+		}
+		h.Write(e)
+		k = h.Sum(nil)
+		if i >= 63 && int(e[len(e)-1]) <= i-31 {
+			break
+		}
+	}
+	return k[:32]
+}
+
+func rc4Crypt(key, data []byte) []byte {
+	c, err := rc4.NewCipher(key)
+	if err != nil {
+		return nil
+	}
+	out := make([]byte, len(data))
+	c.XORKeyStream(out, data)
+	return out
+}
+
+func (c *decrypter) objectKey(ref Ref, method string) []byte {
+	if method == methodAES256 {
+		return c.key
+	}
+	h := md5.New()
+	h.Write(c.key)
+	h.Write([]byte{byte(ref.Num), byte(ref.Num >> 8), byte(ref.Num >> 16), byte(ref.Gen), byte(ref.Gen >> 8)})
+	if method == methodAES128 {
+		h.Write([]byte("sAlT"))
+	}
+	sum := h.Sum(nil)
+	n := len(c.key) + 5
+	if n > 16 {
+		n = 16
+	}
+	return sum[:n]
+}
+
+func (c *decrypter) decrypt(data []byte, ref Ref, method string) []byte {
+	switch method {
+	case methodIdentity:
+		return data
+	case methodRC4:
+		return rc4Crypt(c.objectKey(ref, method), data)
+	}
+	if len(data) < aes.BlockSize*2 || len(data)%aes.BlockSize != 0 {
+		return data
+	}
+	block, err := aes.NewCipher(c.objectKey(ref, method))
+	if err != nil {
+		return data
+	}
+	out := make([]byte, len(data)-aes.BlockSize)
+	cipher.NewCBCDecrypter(block, data[:aes.BlockSize]).CryptBlocks(out, data[aes.BlockSize:])
+	if pad := int(out[len(out)-1]); pad > 0 && pad <= aes.BlockSize && pad <= len(out) {
+		out = out[:len(out)-pad]
+	}
+	return out
+}
+
+func (c *decrypter) decryptStrings(obj any, ref Ref) any {
+	switch v := obj.(type) {
+	case String:
+		return String(c.decrypt(v, ref, c.stringMethod))
+	case Array:
+		out := make(Array, len(v))
+		for i, item := range v {
+			out[i] = c.decryptStrings(item, ref)
+		}
+		return out
+	case Dict:
+		out := make(Dict, len(v))
+		for key, item := range v {
+			out[key] = c.decryptStrings(item, ref)
+		}
+		return out
+	case Stream:
+		if v.Dict[Name("Type")] == Name("XRef") {
+			return v
+		}
+		v.Dict = c.decryptStrings(v.Dict, ref).(Dict)
+		return v
+	}
+	return obj
+}
+
+func (c *decrypter) decryptStream(data []byte, stream Stream) []byte {
+	if stream.Dict[Name("Type")] == Name("Metadata") && !c.encryptMetadata {
+		return data
+	}
+	return c.decrypt(data, stream.ref, c.streamMethod)
+}
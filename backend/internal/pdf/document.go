@@ -0,0 +1,601 @@
+package pdf
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"regexp"
+	"strconv"
+)
+
+var (
+	ErrInvalid   = errors.New("invalid pdf")
+	ErrEncrypted = errors.New("pdf is encrypted")
+)
+
+// maxStreamSize bounds decoded streams (object streams, metadata).
+const maxStreamSize = 64 << 20
+
+type xrefEntry struct {
+	kind   byte // 1: in-file object, 2: compressed in an object stream
+	offset int64
+	stream int
+	index  int
+}
+
+type Document struct {
+	r       io.ReaderAt
+	size    int64
+	xref    map[int]xrefEntry
+	Trailer Dict
+	cache   map[int]any
+	objStms map[int]*objectStream
+	crypt   *decrypter
+	// Encrypted reports whether the file declares an /Encrypt dictionary.
+	Encrypted bool
+	loading   map[int]bool
+}
+
+type objectStream struct {
+	data    []byte
+	offsets map[int]int64
+}
+
+// Open reads the cross-reference data of a PDF. Damaged xref sections are
+// rebuilt by scanning the file for object headers.
+func Open(r io.ReaderAt, size int64) (*Document, error) {
+	doc := &Document{
+		r:       r,
+		size:    size,
+		xref:    make(map[int]xrefEntry),
+		cache:   make(map[int]any),
+		objStms: make(map[int]*objectStream),
+		loading: make(map[int]bool),
+	}
+	if err := doc.checkHeader(); err != nil {
+		return nil, err
+	}
+	if err := doc.loadXrefChain(); err != nil || doc.Trailer[Name("Root")] == nil {
+		doc.xref = make(map[int]xrefEntry)
+		doc.Trailer = nil
+		if err := doc.reconstruct(); err != nil {
+			return nil, err
+		}
+	}
+	if enc, ok := doc.Trailer[Name("Encrypt")]; ok && enc != nil {
+		doc.Encrypted = true
+		crypt, err := newDecrypter(doc, enc)
+		if err == nil {
+			doc.crypt = crypt
+			// Drop anything parsed before the key was known.
+			doc.cache = make(map[int]any)
+			doc.objStms = make(map[int]*objectStream)
+		}
+	}
+	return doc, nil
+}
+
+func (d *Document) checkHeader() error {
+	head := make([]byte, 1024)
+	n, _ := d.r.ReadAt(head, 0)
+	if !bytes.Contains(head[:n], []byte("%PDF-")) {
+		return ErrInvalid
+	}
+	return nil
+}
+
+func (d *Document) loadXrefChain() error {
+	offset, err := d.findStartXref()
+	if err != nil {
+		return err
+	}
+	seen := map[int64]bool{}
+	for offset > 0 && !seen[offset] {
+		seen[offset] = true
+		trailer, err := d.readXrefSection(offset)
+		if err != nil {
+			return err
+		}
+		if d.Trailer == nil {
+			d.Trailer = trailer
+		}
+		// Hybrid files keep compressed entries in an extra xref stream.
+		if stm, ok := trailer[Name("XRefStm")].(int64); ok && !seen[stm] {
+			seen[stm] = true
+			if _, err := d.readXrefSection(stm); err != nil {
+				return err
+			}
+		}
+		prev, _ := trailer[Name("Prev")].(int64)
+		offset = prev
+	}
+	if d.Trailer == nil {
+		return ErrInvalid
+	}
+	return nil
+}
+
+func (d *Document) findStartXref() (int64, error) {
+	tail := int64(2048)
+	if tail > d.size {
+		tail = d.size
+	}
+	buf := make([]byte, tail)
+	n, err := d.r.ReadAt(buf, d.size-tail)
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+	buf = buf[:n]
+	idx := bytes.LastIndex(buf, []byte("startxref"))
+	if idx < 0 {
+		return 0, ErrInvalid
+	}
+	fields := bytes.Fields(buf[idx+len("startxref"):])
+	if len(fields) == 0 {
+		return 0, ErrInvalid
+	}
+	offset, err := strconv.ParseInt(string(fields[0]), 10, 64)
+	if err != nil || offset <= 0 || offset >= d.size {
+		return 0, ErrInvalid
+	}
+	return offset, nil
+}
+
+func (d *Document) readXrefSection(offset int64) (Dict, error) {
+	lex := newLexer(d.r, offset, d.size)
+	tok, err := lex.next()
+	if err != nil {
+		return nil, err
+	}
+	if tok == keyword("xref") {
+		return d.readXrefTable(lex)
+	}
+	lex.unread(tok)
+	obj, _, err := d.readIndirect(lex)
+	if err != nil {
+		return nil, err
+	}
+	stream, ok := obj.(Stream)
+	if !ok || stream.Dict[Name("Type")] != Name("XRef") {
+		return nil, ErrInvalid
+	}
+	return stream.Dict, d.readXrefStream(stream)
+}
+
+func (d *Document) readXrefTable(lex *lexer) (Dict, error) {
+	for {
+		tok, err := lex.next()
+		if err != nil {
+			return nil, err
+		}
+		if tok == keyword("trailer") {
+			obj, err := lex.readObject()
+			if err != nil {
+				return nil, err
+			}
+			trailer, ok := obj.(Dict)
+			if !ok {
+				return nil, ErrInvalid
+			}
+			return trailer, nil
+		}
+		start, ok := tok.(int64)
+		if !ok {
+			return nil, ErrInvalid
+		}
+		countTok, err := lex.next()
+		if err != nil {
+			return nil, err
+		}
+		count, ok := countTok.(int64)
+		if !ok {
+			return nil, ErrInvalid
+		}
+		for i := int64(0); i < count; i++ {
+			offTok, err1 := lex.next()
+			_, err2 := lex.next()
+			kindTok, err3 := lex.next()
+			if err1 != nil || err2 != nil || err3 != nil {
+				return nil, ErrInvalid
+			}
+			num := int(start + i)
+			off, _ := offTok.(int64)
+			if _, exists := d.xref[num]; exists {
+				continue
+			}
+			if kindTok == keyword("n") {
+				d.xref[num] = xrefEntry{kind: 1, offset: off}
+			} else {
+				d.xref[num] = xrefEntry{}
+			}
+		}
+	}
+}
+
+func (d *Document) readXrefStream(stream Stream) error {
+	data, err := d.streamData(stream)
+	if err != nil {
+		return err
+	}
+	widths, ok := stream.Dict[Name("W")].(Array)
+	if !ok || len(widths) != 3 {
+		return ErrInvalid
+	}
+	var w [3]int
+	for i, value := range widths {
+		n, _ := value.(int64)
+		if n < 0 || n > 8 {
+			return ErrInvalid
+		}
+		w[i] = int(n)
+	}
+	size, _ := stream.Dict[Name("Size")].(int64)
+	index := Array{int64(0), size}
+	if custom, ok := stream.Dict[Name("Index")].(Array); ok && len(custom)%2 == 0 {
+		index = custom
+	}
+	rowLen := w[0] + w[1] + w[2]
+	if rowLen == 0 {
+		return ErrInvalid
+	}
+	pos := 0
+	for i := 0; i+1 < len(index); i += 2 {
+		start, _ := index[i].(int64)
+		count, _ := index[i+1].(int64)
+		for j := int64(0); j < count; j++ {
+			if pos+rowLen > len(data) {
+				return nil
+			}
+			row := data[pos : pos+rowLen]
+			pos += rowLen
+			kind := int64(1)
+			if w[0] > 0 {
+				kind = readField(row[:w[0]])
+			}
+			f2 := readField(row[w[0] : w[0]+w[1]])
+			f3 := readField(row[w[0]+w[1]:])
+			num := int(start + j)
+			if _, exists := d.xref[num]; exists {
+				continue
+			}
+			switch kind {
+			case 1:
+				d.xref[num] = xrefEntry{kind: 1, offset: f2}
+			case 2:
+				d.xref[num] = xrefEntry{kind: 2, stream: int(f2), index: int(f3)}
+			default:
+				d.xref[num] = xrefEntry{}
+			}
+		}
+	}
+	return nil
+}
+
+func readField(b []byte) int64 {
+	var n int64
+	for _, c := range b {
+		n = n<<8 | int64(c)
+	}
+	return n
+}
+
+var objHeader = regexp.MustCompile(`^\s*(\d+)\s+(\d+)\s+obj\b`)
+
+// reconstruct scans the whole file for "N G obj" headers when the xref
+// data is missing or corrupt.
+func (d *Document) reconstruct() error {
+	reader := bufio.NewReader(io.NewSectionReader(d.r, 0, d.size))
+	var offset int64
+	var trailerOffsets []int64
+	for {
+		line, err := reader.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			// Skip over long binary lines.
+			offset += int64(len(line))
+			continue
+		}
+		for _, part := range splitCR(line) {
+			if m := objHeader.FindSubmatch(part.data); m != nil {
+				num, _ := strconv.Atoi(string(m[1]))
+				d.xref[num] = xrefEntry{kind: 1, offset: offset + int64(part.start)}
+			}
+			if idx := bytes.Index(part.data, []byte("trailer")); idx >= 0 {
+				trailerOffsets = append(trailerOffsets, offset+int64(part.start+idx+len("trailer")))
+			}
+		}
+		offset += int64(len(line))
+		if err != nil {
+			break
+		}
+	}
+	if len(d.xref) == 0 {
+		return ErrInvalid
+	}
+	for i := len(trailerOffsets) - 1; i >= 0; i-- {
+		lex := newLexer(d.r, trailerOffsets[i], d.size)
+		if obj, err := lex.readObject(); err == nil {
+			if trailer, ok := obj.(Dict); ok && trailer[Name("Root")] != nil {
+				d.Trailer = trailer
+				return nil
+			}
+		}
+	}
+	// No classic trailer: look for an xref stream dictionary or the catalog.
+	d.Trailer = Dict{}
+	for num := range d.xref {
+		obj, err := d.object(num)
+		if err != nil {
+			continue
+		}
+		dict := dictOf(obj)
+		switch dict[Name("Type")] {
+		case Name("XRef"):
+			if dict[Name("Root")] != nil {
+				d.Trailer = dict
+				return nil
+			}
+		case Name("Catalog"):
+			d.Trailer[Name("Root")] = Ref{Num: num}
+		}
+	}
+	if d.Trailer[Name("Root")] == nil {
+		return ErrInvalid
+	}
+	return nil
+}
+
+type linePart struct {
+	start int
+	data  []byte
+}
+
+func splitCR(line []byte) []linePart {
+	var parts []linePart
+	start := 0
+	for i, b := range line {
+		if b == '\r' {
+			parts = append(parts, linePart{start: start, data: line[start:i]})
+			start = i + 1
+		}
+	}
+	return append(parts, linePart{start: start, data: line[start:]})
+}
+
+// readIndirect parses "N G obj ... endobj" at the lexer position.
+func (d *Document) readIndirect(lex *lexer) (any, Ref, error) {
+	numTok, err1 := lex.next()
+	genTok, err2 := lex.next()
+	objTok, err3 := lex.next()
+	if err1 != nil || err2 != nil || err3 != nil || objTok != keyword("obj") {
+		return nil, Ref{}, ErrInvalid
+	}
+	num, ok1 := numTok.(int64)
+	gen, ok2 := genTok.(int64)
+	if !ok1 || !ok2 {
+		return nil, Ref{}, ErrInvalid
+	}
+	ref := Ref{Num: int(num), Gen: int(gen)}
+	obj, err := lex.readObject()
+	if err != nil {
+		return nil, ref, err
+	}
+	if dict, ok := obj.(Dict); ok {
+		tok, err := lex.next()
+		if err == nil && tok == keyword("stream") {
+			return Stream{Dict: dict, Offset: lex.readStreamStart(), ref: ref}, ref, nil
+		}
+	}
+	return obj, ref, nil
+}
+
+// Resolve follows indirect references.
+func (d *Document) Resolve(obj any) any {
+	for i := 0; i < 32; i++ {
+		ref, ok := obj.(Ref)
+		if !ok {
+			return obj
+		}
+		resolved, err := d.object(ref.Num)
+		if err != nil {
+			return nil
+		}
+		obj = resolved
+	}
+	return nil
+}
+
+func (d *Document) object(num int) (any, error) {
+	if cached, ok := d.cache[num]; ok {
+		return cached, nil
+	}
+	if d.loading[num] {
+		return nil, ErrInvalid
+	}
+	d.loading[num] = true
+	defer delete(d.loading, num)
+	entry, ok := d.xref[num]
+	if !ok {
+		return nil, ErrInvalid
+	}
+	var obj any
+	switch entry.kind {
+	case 1:
+		lex := newLexer(d.r, entry.offset, d.size)
+		parsed, ref, err := d.readIndirect(lex)
+		if err != nil {
+			return nil, err
+		}
+		if d.crypt != nil {
+			parsed = d.crypt.decryptStrings(parsed, ref)
+		}
+		obj = parsed
+	case 2:
+		parsed, err := d.compressedObject(entry)
+		if err != nil {
+			return nil, err
+		}
+		obj = parsed
+	default:
+		return nil, nil
+	}
+	d.cache[num] = obj
+	return obj, nil
+}
+
+func (d *Document) compressedObject(entry xrefEntry) (any, error) {
+	stm, ok := d.objStms[entry.stream]
+	if !ok {
+		raw, err := d.object(entry.stream)
+		if err != nil {
+			return nil, err
+		}
+		stream, ok := raw.(Stream)
+		if !ok {
+			return nil, ErrInvalid
+		}
+		data, err := d.streamData(stream)
+		if err != nil {
+			return nil, err
+		}
+		n, _ := d.Resolve(stream.Dict[Name("N")]).(int64)
+		first, _ := d.Resolve(stream.Dict[Name("First")]).(int64)
+		stm = &objectStream{data: data, offsets: make(map[int]int64)}
+		lex := newLexer(bytes.NewReader(data), 0, int64(len(data)))
+		for i := int64(0); i < n; i++ {
+			// Pairs of "objnum offset"; entries are addressed by index.
+			_, err1 := lex.next()
+			offTok, err2 := lex.next()
+			if err1 != nil || err2 != nil {
+				break
+			}
+			off, _ := offTok.(int64)
+			stm.offsets[int(i)] = first + off
+		}
+		d.objStms[entry.stream] = stm
+	}
+	offset, ok := stm.offsets[entry.index]
+	if !ok || offset >= int64(len(stm.data)) {
+		return nil, ErrInvalid
+	}
+	lex := newLexer(bytes.NewReader(stm.data), offset, int64(len(stm.data)))
+	return lex.readObject()
+}
+
+// streamData returns the decoded bytes of a stream.
+func (d *Document) streamData(stream Stream) ([]byte, error) {
+	raw, err := d.rawStream(stream)
+	if err != nil {
+		return nil, err
+	}
+	if d.crypt != nil && stream.Dict[Name("Type")] != Name("XRef") {
+		raw = d.crypt.decryptStream(raw, stream)
+	}
+	return decodeStream(d, stream.Dict, raw)
+}
+
+func (d *Document) rawStream(stream Stream) ([]byte, error) {
+	length, ok := d.Resolve(stream.Dict[Name("Length")]).(int64)
+	if !ok || length < 0 || stream.Offset+length > d.size {
+		length = d.scanStreamLength(stream.Offset)
+	}
+	if length > maxStreamSize {
+		return nil, ErrInvalid
+	}
+	buf := make([]byte, length)
+	if _, err := d.r.ReadAt(buf, stream.Offset); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// scanStreamLength finds the "endstream" keyword for streams whose /Length
+// is missing or wrong.
+func (d *Document) scanStreamLength(offset int64) int64 {
+	const chunk = 64 << 10
+	marker := []byte("endstream")
+	buf := make([]byte, chunk+len(marker))
+	for pos := offset; pos < d.size && pos-offset < maxStreamSize; pos += chunk {
+		n, _ := d.r.ReadAt(buf, pos)
+		if idx := bytes.Index(buf[:n], marker); idx >= 0 {
+			end := pos + int64(idx)
+			return trimEOL(d, offset, end)
+		}
+		if n < len(buf) {
+			break
+		}
+	}
+	return 0
+}
+
+func trimEOL(d *Document, start, end int64) int64 {
+	tail := make([]byte, 2)
+	if end-start >= 2 {
+		if _, err := d.r.ReadAt(tail, end-2); err == nil {
+			if tail[0] == '\r' && tail[1] == '\n' {
+				return end - 2 - start
+			}
+			if tail[1] == '\n' || tail[1] == '\r' {
+				return end - 1 - start
+			}
+		}
+	}
+	return end - start
+}
+
+func dictOf(obj any) Dict {
+	switch v := obj.(type) {
+	case Dict:
+		return v
+	case Stream:
+		return v.Dict
+	}
+	return nil
+}
+
+// Catalog returns the document catalog (/Root).
+func (d *Document) Catalog() Dict {
+	return dictOf(d.Resolve(d.Trailer[Name("Root")]))
+}
+
+// PageCount reads /Count from the page tree root, falling back to counting
+// leaf pages when the count is missing.
+func (d *Document) PageCount() int {
+	pages := dictOf(d.Resolve(d.Catalog()[Name("Pages")]))
+	if pages == nil {
+		return 0
+	}
+	if count, ok := d.Resolve(pages[Name("Count")]).(int64); ok && count > 0 {
+		return int(count)
+	}
+	return d.countLeaves(pages, map[Ref]bool{}, 0)
+}
+
+func (d *Document) countLeaves(node Dict, seen map[Ref]bool, depth int) int {
+	if depth > maxDepth {
+		return 0
+	}
+	kids, ok := d.Resolve(node[Name("Kids")]).(Array)
+	if !ok {
+		return 1
+	}
+	total := 0
+	for _, kid := range kids {
+		if ref, ok := kid.(Ref); ok {
+			if seen[ref] {
+				continue
+			}
+			seen[ref] = true
+		}
+		child := dictOf(d.Resolve(kid))
+		if child == nil {
+			continue
+		}
+		if child[Name("Type")] == Name("Page") {
+			total++
+			continue
+		}
+		total += d.countLeaves(child, seen, depth+1)
+	}
+	return total
+}
@@ -0,0 +1,185 @@
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/ascii85"
+	"errors"
+	"io"
+)
+
+var ErrUnsupportedFilter = errors.New("unsupported pdf filter")
+
+func decodeStream(d *Document, dict Dict, data []byte) ([]byte, error) {
+	filters := d.Resolve(dict[Name("Filter")])
+	params := d.Resolve(dict[Name("DecodeParms")])
+	var names []Name
+	var paramList []Dict
+	switch f := filters.(type) {
+	case nil:
+		return data, nil
+	case Name:
+		names = []Name{f}
+		paramList = []Dict{dictOf(params)}
+	case Array:
+		list, _ := params.(Array)
+		for i, item := range f {
+			name, ok := d.Resolve(item).(Name)
+			if !ok {
+				return nil, ErrUnsupportedFilter
+			}
+			names = append(names, name)
+			var p Dict
+			if i < len(list) {
+				p = dictOf(d.Resolve(list[i]))
+			}
+			paramList = append(paramList, p)
+		}
+	default:
+		return nil, ErrUnsupportedFilter
+	}
+	var err error
+	for i, name := range names {
+		switch name {
+		case "FlateDecode", "Fl":
+			data, err = inflate(data)
+			if err != nil {
+				return nil, err
+			}
+			data, err = unpredict(d, data, paramList[i])
+		case "ASCIIHexDecode", "AHx":
+			data, err = asciiHex(data)
+		case "ASCII85Decode", "A85":
+			data, err = asciiBase85(data)
+		default:
+			return nil, ErrUnsupportedFilter
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+func inflate(data []byte) ([]byte, error) {
+	zr, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	out, err := io.ReadAll(io.LimitReader(zr, maxStreamSize))
+	// Many writers truncate the final checksum; keep what was inflated.
+	if err != nil && len(out) == 0 {
+		return nil, err
+	}
+	return out, nil
+}
+
+func unpredict(d *Document, data []byte, params Dict) ([]byte, error) {
+	if params == nil {
+		return data, nil
+	}
+	predictor, _ := d.Resolve(params[Name("Predictor")]).(int64)
+	if predictor < 10 {
+		return data, nil
+	}
+	columns := int64(1)
+	if value, ok := d.Resolve(params[Name("Columns")]).(int64); ok && value > 0 {
+		columns = value
+	}
+	colors := int64(1)
+	if value, ok := d.Resolve(params[Name("Colors")]).(int64); ok && value > 0 {
+		colors = value
+	}
+	bpc := int64(8)
+	if value, ok := d.Resolve(params[Name("BitsPerComponent")]).(int64); ok && value > 0 {
+		bpc = value
+	}
+	bpp := int((colors*bpc + 7) / 8)
+	rowLen := int((columns*colors*bpc + 7) / 8)
+	var out []byte
+	prev := make([]byte, rowLen)
+	for pos := 0; pos+rowLen+1 <= len(data); pos += rowLen + 1 {
+		filter := data[pos]
+		row := append([]byte(nil), data[pos+1:pos+1+rowLen]...)
+		for i := range row {
+			var left, upLeft byte
+			if i >= bpp {
+				left = row[i-bpp]
+				upLeft = prev[i-bpp]
+			}
+			up := prev[i]
+			switch filter {
+			case 1:
+				row[i] += left
+			case 2:
+				row[i] += up
+			case 3:
+				row[i] += byte((int(left) + int(up)) / 2)
+			case 4:
+				row[i] += paeth(left, up, upLeft)
+			}
+		}
+		out = append(out, row...)
+		prev = row
+	}
+	return out, nil
+}
+
+func paeth(a, b, c byte) byte {
+	p := int(a) + int(b) - int(c)
+	pa, pb, pc := abs(p-int(a)), abs(p-int(b)), abs(p-int(c))
+	if pa <= pb && pa <= pc {
+		return a
+	}
+	if pb <= pc {
+		return b
+	}
+	return c
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+func asciiHex(data []byte) ([]byte, error) {
+	var out []byte
+	var pending byte
+	half := false
+	for _, b := range data {
+		if b == '>' {
+			break
+		}
+		value, ok := unhex(b)
+		if !ok {
+			continue
+		}
+		if half {
+			out = append(out, pending<<4|value)
+		} else {
+			pending = value
+		}
+		half = !half
+	}
+	if half {
+		out = append(out, pending<<4)
+	}
+	return out, nil
+}
+
+func asciiBase85(data []byte) ([]byte, error) {
+	data = bytes.TrimSpace(data)
+	data = bytes.TrimPrefix(data, []byte("<~"))
+	if idx := bytes.Index(data, []byte("~>")); idx >= 0 {
+		data = data[:idx]
+	}
+	out := make([]byte, len(data))
+	n, _, err := ascii85.Decode(out, data, true)
+	if err != nil {
+		return nil, err
+	}
+	return out[:n], nil
+}
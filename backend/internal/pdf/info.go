@@ -0,0 +1,180 @@
+package pdf
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strings"
+	"unicode/utf16"
+)
+
+type Info struct {
+	Title     string
+	Author    string
+	Subject   string
+	Keywords  string
+	PageCount int
+	Encrypted bool
+}
+
+// ReadInfo opens a PDF and reads its page count and document metadata.
+// XMP metadata takes precedence over the legacy Info dictionary. Encrypted
+// files that need a user password still report their page count.
+func ReadInfo(r io.ReaderAt, size int64) (Info, error) {
+	doc, err := Open(r, size)
+	if err != nil {
+		return Info{}, err
+	}
+	return doc.Info(), nil
+}
+
+func (d *Document) Info() Info {
+	info := Info{PageCount: d.PageCount(), Encrypted: d.Encrypted}
+	if d.Encrypted && d.crypt == nil {
+		return info
+	}
+	if xmp, ok := d.xmp(); ok {
+		info.Title = xmp.Title
+		info.Author = xmp.Author
+		info.Subject = xmp.Subject
+		info.Keywords = xmp.Keywords
+	}
+	dict := dictOf(d.Resolve(d.Trailer[Name("Info")]))
+	fill := func(field *string, key Name) {
+		if *field != "" {
+			return
+		}
+		if value, ok := d.Resolve(dict[key]).(String); ok {
+			*field = strings.TrimSpace(DecodeText(value))
+		}
+	}
+	fill(&info.Title, "Title")
+	fill(&info.Author, "Author")
+	fill(&info.Subject, "Subject")
+	fill(&info.Keywords, "Keywords")
+	return info
+}
+
+// DecodeText converts a PDF text string (UTF-16BE with BOM, UTF-8 with BOM
+// or PDFDocEncoding) to UTF-8.
+func DecodeText(raw String) string {
+	b := []byte(raw)
+	switch {
+	case len(b) >= 2 && b[0] == 0xfe && b[1] == 0xff:
+		b = b[2:]
+		units := make([]uint16, 0, len(b)/2)
+		for i := 0; i+1 < len(b); i += 2 {
+			units = append(units, uint16(b[i])<<8|uint16(b[i+1]))
+		}
+		return string(utf16.Decode(units))
+	case len(b) >= 3 && b[0] == 0xef && b[1] == 0xbb && b[2] == 0xbf:
+		return string(b[3:])
+	}
+	var out strings.Builder
+	for _, c := range b {
+		if r, ok := pdfDocEncoding[c]; ok {
+			out.WriteRune(r)
+			continue
+		}
+		out.WriteRune(rune(c))
+	}
+	return out.String()
+}
+
+// pdfDocEncoding lists the code points where PDFDocEncoding differs from
+// ISO-8859-1.
+var pdfDocEncoding = map[byte]rune{
+	0x18: '˘', 0x19: 'ˇ', 0x1a: 'ˆ', 0x1b: '˙', 0x1c: '˝', 0x1d: '˛', 0x1e: '˚', 0x1f: '˜',
+	0x80: '•', 0x81: '†', 0x82: '‡', 0x83: '…', 0x84: '—', 0x85: '–', 0x86: 'ƒ', 0x87: '⁄',
+	0x88: '‹', 0x89: '›', 0x8a: '−', 0x8b: '‰', 0x8c: '„', 0x8d: '“', 0x8e: '”', 0x8f: '‘',
+	0x90: '’', 0x91: '‚', 0x92: '™', 0x93: 'ﬁ', 0x94: 'ﬂ', 0x95: 'Ł', 0x96: 'Œ', 0x97: 'Š',
+	0x98: 'Ÿ', 0x99: 'Ž', 0x9a: 'ı', 0x9b: 'ł', 0x9c: 'œ', 0x9d: 'š', 0x9e: 'ž', 0xa0: '€',
+}
+
+type xmpInfo struct {
+	Title    string
+	Author   string
+	Subject  string
+	Keywords string
+}
+
+func (d *Document) xmp() (xmpInfo, bool) {
+	stream, ok := d.Resolve(d.Catalog()[Name("Metadata")]).(Stream)
+	if !ok {
+		return xmpInfo{}, false
+	}
+	data, err := d.streamData(stream)
+	if err != nil {
+		return xmpInfo{}, false
+	}
+	return parseXMP(data)
+}
+
+// parseXMP pulls Dublin Core and PDF schema fields out of an XMP packet.
+func parseXMP(data []byte) (xmpInfo, bool) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	decoder.Strict = false
+	var info xmpInfo
+	var path []string
+	var creators []string
+	var text strings.Builder
+	found := false
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			break
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			path = append(path, t.Name.Local)
+			text.Reset()
+			// Simple properties may be written as attributes of rdf:Description.
+			if t.Name.Local == "Description" {
+				for _, attr := range t.Attr {
+					switch attr.Name.Local {
+					case "Keywords":
+						info.Keywords = strings.TrimSpace(attr.Value)
+						found = true
+					case "title":
+						info.Title = strings.TrimSpace(attr.Value)
+						found = true
+					}
+				}
+			}
+		case xml.CharData:
+			text.Write(t)
+		case xml.EndElement:
+			value := strings.TrimSpace(text.String())
+			if value != "" && len(path) >= 2 {
+				property := path[len(path)-1]
+				if property == "li" && len(path) >= 3 {
+					property = path[len(path)-3]
+				}
+				switch property {
+				case "title":
+					if info.Title == "" {
+						info.Title = value
+						found = true
+					}
+				case "creator":
+					creators = append(creators, value)
+					found = true
+				case "description":
+					if info.Subject == "" {
+						info.Subject = value
+						found = true
+					}
+				case "Keywords":
+					info.Keywords = value
+					found = true
+				}
+			}
+			text.Reset()
+			if len(path) > 0 {
+				path = path[:len(path)-1]
+			}
+		}
+	}
+	info.Author = strings.Join(creators, ", ")
+	return info, found
+}
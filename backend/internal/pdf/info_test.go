@@ -0,0 +1,208 @@
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/md5"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// buildPDF writes objects with a classic xref table. Objects are given as
+// their body without the "N 0 obj" wrapper.
+func buildPDF(objects []string, trailer string) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	offsets := make([]int, len(objects))
+	for i, body := range objects {
+		offsets[i] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i+1, body)
+	}
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d %s >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, trailer, xref)
+	return buf.Bytes()
+}
+
+func deflate(data []byte) []byte {
+	var buf bytes.Buffer
+	w := zlib.NewWriter(&buf)
+	_, _ = w.Write(data)
+	_ = w.Close()
+	return buf.Bytes()
+}
+
+func TestReadInfoFromInfoDictionary(t *testing.T) {
+	data := buildPDF([]string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R 4 0 R] /Count 2 >>",
+		"<< /Type /Page /Parent 2 0 R >>",
+		"<< /Type /Page /Parent 2 0 R >>",
+		"<< /Title (The \\(Annotated\\) Alice) /Author <FEFF004C0065007700690073> /Subject (Wonderland) >>",
+	}, "/Root 1 0 R /Info 5 0 R")
+	info, err := ReadInfo(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("read info: %v", err)
+	}
+	if info.Title != "The (Annotated) Alice" || info.Author != "Lewis" || info.Subject != "Wonderland" {
+		t.Fatalf("unexpected info %+v", info)
+	}
+	if info.PageCount != 2 {
+		t.Fatalf("expected 2 pages, got %d", info.PageCount)
+	}
+}
+
+func TestReadInfoPrefersXMP(t *testing.T) {
+	xmp := `<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+<rdf:Description xmlns:dc="http://purl.org/dc/elements/1.1/">
+<dc:title><rdf:Alt><rdf:li xml:lang="x-default">Войнa и мир</rdf:li></rdf:Alt></dc:title>
+<dc:creator><rdf:Seq><rdf:li>Лев Толстой</rdf:li></rdf:Seq></dc:creator>
+</rdf:Description></rdf:RDF></x:xmpmeta>`
+	data := buildPDF([]string{
+		"<< /Type /Catalog /Pages 2 0 R /Metadata 4 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R >>",
+		fmt.Sprintf("<< /Type /Metadata /Subtype /XML /Length %d >>\nstream\n%s\nendstream", len(xmp), xmp),
+		"<< /Title (Microsoft Word - draft.docx) /Subject (Novel) >>",
+	}, "/Root 1 0 R /Info 5 0 R")
+	info, err := ReadInfo(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("read info: %v", err)
+	}
+	if info.Title != "Войнa и мир" || info.Author != "Лев Толстой" {
+		t.Fatalf("unexpected xmp info %+v", info)
+	}
+	if info.Subject != "Novel" {
+		t.Fatalf("expected subject from Info dictionary, got %q", info.Subject)
+	}
+}
+
+func TestReadInfoFromXrefStream(t *testing.T) {
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.5\n")
+	// Objects 1-3 live in object stream 4; object 5 is the Info dictionary.
+	objs := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Count 420 /Kids [] >>",
+		"<< /Title (Compressed) /Author (Writer) >>",
+	}
+	var header, body strings.Builder
+	for i, obj := range objs {
+		fmt.Fprintf(&header, "%d %d ", i+1, body.Len())
+		body.WriteString(obj + "\n")
+	}
+	stmData := deflate([]byte(header.String() + body.String()))
+	stmOffset := buf.Len()
+	fmt.Fprintf(&buf, "4 0 obj\n<< /Type /ObjStm /N 3 /First %d /Filter /FlateDecode /Length %d >>\nstream\n", header.Len(), len(stmData))
+	buf.Write(stmData)
+	buf.WriteString("\nendstream\nendobj\n")
+	xrefOffset := buf.Len()
+	// Row layout: type(1) field2(2) field3(1), PNG up predictor per row.
+	rows := [][]byte{
+		{0, 0, 0, 0},
+		{2, 0, 4, 0},
+		{2, 0, 4, 1},
+		{2, 0, 4, 2},
+		{1, byte(stmOffset >> 8), byte(stmOffset), 0},
+		{1, byte(xrefOffset >> 8), byte(xrefOffset), 0},
+	}
+	var raw []byte
+	prev := make([]byte, 4)
+	for _, row := range rows {
+		raw = append(raw, 2)
+		for i := range row {
+			raw = append(raw, row[i]-prev[i])
+		}
+		prev = row
+	}
+	xrefData := deflate(raw)
+	fmt.Fprintf(&buf, "5 0 obj\n<< /Type /XRef /Size 6 /W [1 2 1] /Root 1 0 R /Info 3 0 R /Filter /FlateDecode /DecodeParms << /Columns 4 /Predictor 12 >> /Length %d >>\nstream\n", len(xrefData))
+	buf.Write(xrefData)
+	fmt.Fprintf(&buf, "\nendstream\nendobj\nstartxref\n%d\n%%%%EOF\n", xrefOffset)
+	data := buf.Bytes()
+	info, err := ReadInfo(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("read info: %v", err)
+	}
+	if info.Title != "Compressed" || info.Author != "Writer" || info.PageCount != 420 {
+		t.Fatalf("unexpected info %+v", info)
+	}
+}
+
+func TestReadInfoRebuildsBrokenXref(t *testing.T) {
+	data := buildPDF([]string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R >>",
+		"<< /Title (Recovered) >>",
+	}, "/Root 1 0 R /Info 4 0 R")
+	broken := bytes.Replace(data, []byte("startxref\n"), []byte("startxref\n9"), 1)
+	info, err := ReadInfo(bytes.NewReader(broken), int64(len(broken)))
+	if err != nil {
+		t.Fatalf("read info: %v", err)
+	}
+	if info.Title != "Recovered" || info.PageCount != 1 {
+		t.Fatalf("unexpected info %+v", info)
+	}
+}
+
+func TestReadInfoDecryptsEmptyUserPassword(t *testing.T) {
+	id := []byte("0123456789abcdef")
+	owner := bytes.Repeat([]byte{0x42}, 32)
+	perms := int32(-3904)
+	key := legacyKey(3, 16, owner, perms, id, true)
+	dec := &decrypter{key: key, stringMethod: methodRC4, streamMethod: methodRC4}
+	user := computeUserEntry(key, id)
+	title := rc4Crypt(dec.objectKey(Ref{Num: 4}, methodRC4), []byte("Locked Title"))
+	data := buildPDF([]string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R >>",
+		fmt.Sprintf("<< /Title <%x> >>", title),
+		fmt.Sprintf("<< /Filter /Standard /V 2 /R 3 /Length 128 /P %d /O <%x> /U <%x> >>", perms, owner, user),
+	}, fmt.Sprintf("/Root 1 0 R /Info 4 0 R /Encrypt 5 0 R /ID [<%x> <%x>]", id, id))
+	info, err := ReadInfo(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("read info: %v", err)
+	}
+	if !info.Encrypted || info.Title != "Locked Title" {
+		t.Fatalf("unexpected info %+v", info)
+	}
+}
+
+func TestReadInfoKeepsPageCountWhenPasswordRequired(t *testing.T) {
+	data := buildPDF([]string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R >>",
+		"<< /Title <00112233> >>",
+		fmt.Sprintf("<< /Filter /Standard /V 2 /R 3 /Length 128 /P -4 /O <%x> /U <%x> >>", bytes.Repeat([]byte{1}, 32), bytes.Repeat([]byte{2}, 32)),
+	}, "/Root 1 0 R /Info 4 0 R /Encrypt 5 0 R /ID [<00> <00>]")
+	info, err := ReadInfo(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("read info: %v", err)
+	}
+	if info.Title != "" || info.PageCount != 1 || !info.Encrypted {
+		t.Fatalf("unexpected info %+v", info)
+	}
+}
+
+func computeUserEntry(key, id []byte) []byte {
+	h := bytes.NewBuffer(nil)
+	h.Write(passwordPadding)
+	h.Write(id)
+	sum := md5.Sum(h.Bytes())
+	out := sum[:]
+	for i := 0; i < 20; i++ {
+		round := make([]byte, len(key))
+		for j := range key {
+			round[j] = key[j] ^ byte(i)
+		}
+		out = rc4Crypt(round, out)
+	}
+	return append(out, make([]byte, 16)...)
+}
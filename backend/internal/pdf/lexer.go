@@ -0,0 +1,417 @@
+package pdf
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strconv"
+)
+
+type Name string
+
+type String []byte
+
+type Dict map[Name]any
+
+type Array []any
+
+type Ref struct {
+	Num int
+	Gen int
+}
+
+type Stream struct {
+	Dict   Dict
+	Offset int64
+	ref    Ref
+}
+
+type keyword string
+
+var errSyntax = errors.New("pdf syntax error")
+
+// maxDepth guards nested arrays and dictionaries in hostile files.
+const maxDepth = 64
+
+type lexer struct {
+	r       *bufio.Reader
+	pos     int64
+	pending []any
+}
+
+func newLexer(ra io.ReaderAt, offset, size int64) *lexer {
+	return &lexer{r: bufio.NewReader(io.NewSectionReader(ra, offset, size-offset)), pos: offset}
+}
+
+func (l *lexer) readByte() (byte, error) {
+	b, err := l.r.ReadByte()
+	if err == nil {
+		l.pos++
+	}
+	return b, err
+}
+
+func (l *lexer) unreadByte() {
+	if l.r.UnreadByte() == nil {
+		l.pos--
+	}
+}
+
+func isSpace(b byte) bool {
+	switch b {
+	case 0, '\t', '\n', '\f', '\r', ' ':
+		return true
+	}
+	return false
+}
+
+func isDelim(b byte) bool {
+	switch b {
+	case '(', ')', '<', '>', '[', ']', '{', '}', '/', '%':
+		return true
+	}
+	return false
+}
+
+func (l *lexer) skipSpace() error {
+	for {
+		b, err := l.readByte()
+		if err != nil {
+			return err
+		}
+		if b == '%' {
+			for b != '\n' && b != '\r' {
+				if b, err = l.readByte(); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if !isSpace(b) {
+			l.unreadByte()
+			return nil
+		}
+	}
+}
+
+func (l *lexer) unread(tok any) {
+	l.pending = append(l.pending, tok)
+}
+
+func (l *lexer) next() (any, error) {
+	if n := len(l.pending); n > 0 {
+		tok := l.pending[n-1]
+		l.pending = l.pending[:n-1]
+		return tok, nil
+	}
+	if err := l.skipSpace(); err != nil {
+		return nil, err
+	}
+	b, err := l.readByte()
+	if err != nil {
+		return nil, err
+	}
+	switch b {
+	case '[', ']', '{', '}':
+		return keyword(b), nil
+	case '<':
+		c, err := l.readByte()
+		if err != nil {
+			return nil, err
+		}
+		if c == '<' {
+			return keyword("<<"), nil
+		}
+		l.unreadByte()
+		return l.hexString()
+	case '>':
+		c, err := l.readByte()
+		if err != nil {
+			return nil, err
+		}
+		if c == '>' {
+			return keyword(">>"), nil
+		}
+		return nil, errSyntax
+	case '(':
+		return l.literalString()
+	case '/':
+		return l.name()
+	}
+	l.unreadByte()
+	word, err := l.word()
+	if err != nil {
+		return nil, err
+	}
+	if n, err := strconv.ParseInt(word, 10, 64); err == nil {
+		return n, nil
+	}
+	if f, err := strconv.ParseFloat(word, 64); err == nil {
+		return f, nil
+	}
+	return keyword(word), nil
+}
+
+func (l *lexer) word() (string, error) {
+	var buf []byte
+	for {
+		b, err := l.readByte()
+		if err != nil {
+			if err == io.EOF && len(buf) > 0 {
+				return string(buf), nil
+			}
+			return "", err
+		}
+		if isSpace(b) || isDelim(b) {
+			l.unreadByte()
+			if len(buf) == 0 {
+				return "", errSyntax
+			}
+			return string(buf), nil
+		}
+		buf = append(buf, b)
+	}
+}
+
+func (l *lexer) name() (Name, error) {
+	var buf []byte
+	for {
+		b, err := l.readByte()
+		if err != nil {
+			if err == io.EOF {
+				return Name(buf), nil
+			}
+			return "", err
+		}
+		if isSpace(b) || isDelim(b) {
+			l.unreadByte()
+			return Name(buf), nil
+		}
+		if b == '#' {
+			hi, err1 := l.readByte()
+			lo, err2 := l.readByte()
+			if err1 != nil || err2 != nil {
+				return "", errSyntax
+			}
+			value, err := strconv.ParseUint(string([]byte{hi, lo}), 16, 8)
+			if err != nil {
+				return "", errSyntax
+			}
+			b = byte(value)
+		}
+		buf = append(buf, b)
+	}
+}
+
+func (l *lexer) hexString() (String, error) {
+	var buf []byte
+	var pending byte
+	half := false
+	for {
+		b, err := l.readByte()
+		if err != nil {
+			return nil, err
+		}
+		if b == '>' {
+			break
+		}
+		value, ok := unhex(b)
+		if !ok {
+			continue
+		}
+		if half {
+			buf = append(buf, pending<<4|value)
+		} else {
+			pending = value
+		}
+		half = !half
+	}
+	if half {
+		buf = append(buf, pending<<4)
+	}
+	return String(buf), nil
+}
+
+func unhex(b byte) (byte, bool) {
+	switch {
+	case b >= '0' && b <= '9':
+		return b - '0', true
+	case b >= 'a' && b <= 'f':
+		return b - 'a' + 10, true
+	case b >= 'A' && b <= 'F':
+		return b - 'A' + 10, true
+	}
+	return 0, false
+}
+
+func (l *lexer) literalString() (String, error) {
+	var buf []byte
+	depth := 1
+	for {
+		b, err := l.readByte()
+		if err != nil {
+			return nil, err
+		}
+		switch b {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return String(buf), nil
+			}
+		case '\r':
+			// A bare CR or CRLF inside a string is a single newline.
+			if c, err := l.readByte(); err == nil && c != '\n' {
+				l.unreadByte()
+			}
+			b = '\n'
+		case '\\':
+			c, err := l.readByte()
+			if err != nil {
+				return nil, err
+			}
+			switch c {
+			case 'n':
+				b = '\n'
+			case 'r':
+				b = '\r'
+			case 't':
+				b = '\t'
+			case 'b':
+				b = '\b'
+			case 'f':
+				b = '\f'
+			case '\r':
+				if d, err := l.readByte(); err == nil && d != '\n' {
+					l.unreadByte()
+				}
+				continue
+			case '\n':
+				continue
+			default:
+				if c >= '0' && c <= '7' {
+					value := int(c - '0')
+					for i := 0; i < 2; i++ {
+						d, err := l.readByte()
+						if err != nil {
+							break
+						}
+						if d < '0' || d > '7' {
+							l.unreadByte()
+							break
+						}
+						value = value*8 + int(d-'0')
+					}
+					b = byte(value)
+				} else {
+					b = c
+				}
+			}
+		}
+		buf = append(buf, b)
+	}
+}
+
+func (l *lexer) readObject() (any, error) {
+	return l.readObjectDepth(0)
+}
+
+func (l *lexer) readObjectDepth(depth int) (any, error) {
+	if depth > maxDepth {
+		return nil, errSyntax
+	}
+	tok, err := l.next()
+	if err != nil {
+		return nil, err
+	}
+	switch t := tok.(type) {
+	case keyword:
+		switch t {
+		case "[":
+			var arr Array
+			for {
+				next, err := l.next()
+				if err != nil {
+					return nil, err
+				}
+				if next == keyword("]") {
+					return arr, nil
+				}
+				l.unread(next)
+				item, err := l.readObjectDepth(depth + 1)
+				if err != nil {
+					return nil, err
+				}
+				arr = append(arr, item)
+			}
+		case "<<":
+			dict := Dict{}
+			for {
+				next, err := l.next()
+				if err != nil {
+					return nil, err
+				}
+				if next == keyword(">>") {
+					return dict, nil
+				}
+				key, ok := next.(Name)
+				if !ok {
+					return nil, errSyntax
+				}
+				value, err := l.readObjectDepth(depth + 1)
+				if err != nil {
+					return nil, err
+				}
+				dict[key] = value
+			}
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		case "null":
+			return nil, nil
+		}
+		return t, nil
+	case int64:
+		// Look ahead for an indirect reference: "<num> <gen> R".
+		second, err := l.next()
+		if err != nil {
+			return t, nil
+		}
+		gen, ok := second.(int64)
+		if !ok {
+			l.unread(second)
+			return t, nil
+		}
+		third, err := l.next()
+		if err != nil {
+			l.unread(second)
+			return t, nil
+		}
+		if third == keyword("R") {
+			return Ref{Num: int(t), Gen: int(gen)}, nil
+		}
+		l.unread(third)
+		l.unread(gen)
+		return t, nil
+	}
+	return tok, nil
+}
+
+// readStreamStart consumes the end-of-line after the "stream" keyword and
+// returns the offset of the first data byte.
+func (l *lexer) readStreamStart() int64 {
+	b, err := l.readByte()
+	if err != nil {
+		return l.pos
+	}
+	if b == '\r' {
+		if c, err := l.readByte(); err == nil && c != '\n' {
+			l.unreadByte()
+		}
+	} else if b != '\n' {
+		l.unreadByte()
+	}
+	return l.pos
+}
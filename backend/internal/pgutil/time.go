@@ -0,0 +1,12 @@
+// Package pgutil holds helpers shared by the PostgreSQL stores.
+package pgutil
+
+import "time"
+
+// NullTime stores the zero time as NULL.
+func NullTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
@@ -0,0 +1,16 @@
+package pgutil
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNullTime(t *testing.T) {
+	if NullTime(time.Time{}) != nil {
+		t.Fatalf("expected the zero time stored as NULL")
+	}
+	now := time.Now()
+	if got := NullTime(now); got == nil || !got.Equal(now) {
+		t.Fatalf("expected the time kept, got %v", got)
+	}
+}
@@ -0,0 +1,72 @@
+package plaintext
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// Chapter is a span of a decoded text. Start and End are byte offsets.
+type Chapter struct {
+	Title string
+	Start int
+	End   int
+}
+
+var headingPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`^第\s*[0-9０-９零〇一二三四五六七八九十百千万萬两兩]+\s*[章节節回卷集部篇]`),
+	regexp.MustCompile(`^(序章|序言|序|楔子|引子|前言|尾声|尾聲|后记|後記|番外)(\s|$|[:：　])`),
+	regexp.MustCompile(`(?i)^(chapter|part|book)\s+([0-9]+|[ivxlcdm]+|one|two|three|four|five|six|seven|eight|nine|ten|eleven|twelve|[a-z]+teen|twenty[a-z-]*|thirty[a-z-]*|forty[a-z-]*|fifty[a-z-]*)\b`),
+	regexp.MustCompile(`(?i)^(prologue|epilogue|preface|introduction|afterword)\s*$`),
+}
+
+// maxHeadingRunes keeps sentences that merely start with "第一章" out of
+// the table of contents.
+const maxHeadingRunes = 40
+
+// Split finds chapter headings in normalized text. Text before the first
+// heading becomes an untitled chapter when it is not blank. A text with
+// fewer than two headings is returned as a single chapter.
+func Split(text string) []Chapter {
+	var starts []Chapter
+	offset := 0
+	for offset < len(text) {
+		end := strings.IndexByte(text[offset:], '\n')
+		lineEnd := len(text)
+		if end >= 0 {
+			lineEnd = offset + end
+		}
+		line := strings.TrimSpace(strings.Trim(text[offset:lineEnd], "　"))
+		if isHeading(line) {
+			starts = append(starts, Chapter{Title: line, Start: offset})
+		}
+		offset = lineEnd + 1
+	}
+	if len(starts) < 2 {
+		return []Chapter{{Start: 0, End: len(text)}}
+	}
+	var out []Chapter
+	if strings.TrimSpace(text[:starts[0].Start]) != "" {
+		out = append(out, Chapter{Start: 0, End: starts[0].Start})
+	}
+	for i, chapter := range starts {
+		chapter.End = len(text)
+		if i+1 < len(starts) {
+			chapter.End = starts[i+1].Start
+		}
+		out = append(out, chapter)
+	}
+	return out
+}
+
+func isHeading(line string) bool {
+	if line == "" || utf8.RuneCountInString(line) > maxHeadingRunes {
+		return false
+	}
+	for _, pattern := range headingPatterns {
+		if pattern.MatchString(line) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,202 @@
+package plaintext
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/traditionalchinese"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+)
+
+// sampleSize is how much of a file is inspected to pick an encoding.
+const sampleSize = 64 << 10
+
+// Charset is a detected text encoding.
+type Charset struct {
+	Name     string
+	Encoding encoding.Encoding
+}
+
+var (
+	UTF8        = Charset{Name: "utf-8", Encoding: unicode.UTF8}
+	utf8BOM     = Charset{Name: "utf-8", Encoding: unicode.UTF8BOM}
+	utf16LE     = Charset{Name: "utf-16le", Encoding: unicode.UTF16(unicode.LittleEndian, unicode.UseBOM)}
+	utf16BE     = Charset{Name: "utf-16be", Encoding: unicode.UTF16(unicode.BigEndian, unicode.UseBOM)}
+	gb18030     = Charset{Name: "gb18030", Encoding: simplifiedchinese.GB18030}
+	big5        = Charset{Name: "big5", Encoding: traditionalchinese.Big5}
+	shiftJIS    = Charset{Name: "shift_jis", Encoding: japanese.ShiftJIS}
+	windows1252 = Charset{Name: "windows-1252", Encoding: charmap.Windows1252}
+)
+
+// Detect guesses the encoding of a text sample. Byte order marks win;
+// otherwise UTF-16 is recognised by its zero bytes, valid UTF-8 is taken
+// as is, text whose non-ASCII bytes stand alone is Windows-1252, and the
+// legacy CJK encodings are scored by how many common characters they
+// decode to.
+func Detect(sample []byte) Charset {
+	switch {
+	case bytes.HasPrefix(sample, []byte{0xef, 0xbb, 0xbf}):
+		return utf8BOM
+	case bytes.HasPrefix(sample, []byte{0xff, 0xfe}):
+		return utf16LE
+	case bytes.HasPrefix(sample, []byte{0xfe, 0xff}):
+		return utf16BE
+	}
+	if charset, ok := detectUTF16(sample); ok {
+		return charset
+	}
+	if validUTF8(sample) {
+		return UTF8
+	}
+	// Accented Latin letters are lone high bytes between ASCII; legacy
+	// CJK encodings use runs of two-byte characters.
+	high, isolated := 0, 0
+	for i, b := range sample {
+		if b < 0x80 {
+			continue
+		}
+		high++
+		if (i == 0 || sample[i-1] < 0x80) && (i+1 == len(sample) || sample[i+1] < 0x80) {
+			isolated++
+		}
+	}
+	if isolated*2 > high {
+		return windows1252
+	}
+	best, bestScore := windows1252, 0
+	for _, candidate := range []Charset{gb18030, big5, shiftJIS} {
+		if score := scoreCJK(sample, candidate); score > bestScore {
+			best, bestScore = candidate, score
+		}
+	}
+	return best
+}
+
+// detectUTF16 spots BOM-less UTF-16 by the zero high bytes of ASCII text.
+func detectUTF16(sample []byte) (Charset, bool) {
+	if len(sample) < 16 {
+		return Charset{}, false
+	}
+	var even, odd int
+	for i := 0; i+1 < len(sample); i += 2 {
+		if sample[i] == 0 {
+			even++
+		}
+		if sample[i+1] == 0 {
+			odd++
+		}
+	}
+	pairs := len(sample) / 2
+	switch {
+	case odd*10 > pairs*3 && even*10 < pairs:
+		return utf16LE, true
+	case even*10 > pairs*3 && odd*10 < pairs:
+		return utf16BE, true
+	}
+	return Charset{}, false
+}
+
+// validUTF8 tolerates a rune cut off at the end of the sample.
+func validUTF8(sample []byte) bool {
+	for i := 0; i < 3 && len(sample) > 0; i++ {
+		if utf8.Valid(sample) {
+			return true
+		}
+		if len(sample) < sampleSize {
+			return false
+		}
+		sample = sample[:len(sample)-1]
+	}
+	return utf8.Valid(sample)
+}
+
+func scoreCJK(sample []byte, charset Charset) int {
+	japanese := charset.Name == shiftJIS.Name
+	decoded, _, err := transform.Bytes(charset.Encoding.NewDecoder(), sample)
+	if err != nil {
+		return 0
+	}
+	score := 0
+	for _, r := range string(decoded) {
+		switch {
+		case r == utf8.RuneError || (r >= 0xe000 && r <= 0xf8ff):
+			score -= 8
+		case commonRunes[r]:
+			score += 4
+		case r >= 0x4e00 && r <= 0x9fff:
+			score++
+		case r >= 0x3040 && r <= 0x30ff:
+			// Kana in a Chinese decoding means the bytes were Japanese.
+			if japanese {
+				score += 3
+			} else {
+				score -= 2
+			}
+		case r >= 0x3000 && r <= 0x303f, r >= 0xff01 && r <= 0xff5e:
+			score++
+		case r >= 0xff61 && r <= 0xff9f:
+			// Half-width katakana is what GBK/Big5 bytes turn into
+			// under Shift-JIS.
+			score -= 2
+		}
+	}
+	return score
+}
+
+// commonHan lists frequent Chinese characters in simplified and
+// traditional form. Decoding with the wrong legacy encoding yields mostly
+// rare characters instead.
+const commonHan = "的一是不了在人有我他这个们中来上大为和国地到以说时要就出会可也你对生能而子那得于着下自之年过发后作里用道行所然家种事成方多经么去法学如都同现当没动面起看定天分还进好小部其些主样理心她本前开但因只从想实日军者意无力它与长把机十民第公此已工使情明性知全三又关点正业外将两高间由问很最重并物手应战向头文体政美相见被利什二等产或新己制身果加西斯月话合回特代内信表化老给世位次度门任常先海通教儿原东声提立及比员解水名真论处走义各入几口认条平系气题活尔更别打女变四神总何电数安少报才结反受目太量再感建务做接必场件计管期市直德资命山金指克许统区保至队形社便空决治展马科司五基眼书非则听白却界达光放强即像难且权思王象完设式色路记南品住告类求据程北边死张该交规万取拉格望觉术领共确传师观清今切院让识候带导争运笑飞风步改收根干造言联持组每济车亲极林服快办议往元英士证近失转夫令准布始怎呢存未远叫台单影具罗字爱击流备兵连调深商算质团集百需价花党华城石级整府离况亚请技际约示复病息究线似官火断精满支视消越器容照须九增研写称企八功吗包片史委乎查轻易早曾除农找装广显吧阿李标谈吃图念六引历首医局突专费号尽另周较注语仅考落青随选列武红响虽推势参希古众构房半节土投某案黑维革划敌致陈律足态护七兴派孩验责营星够章音跟志底站严巴例防族供效续施留讲型料终答紧黄绝奇察母京段依批群项故按河米围江织害斗双境客纪采举杀攻父苏密低朝友诉止细愿千值仍男钱破网热助倒育属坐帝限船脸职速刻乐否刚威毛状率甚独球般普怕弹校苦创假久错承印晚兰试股拿脑预谁益阳若哪微尼继送急血惊伤素药适波夜省初喜卫源食险待述陆习置居劳财环排福纳欢雷警获模充负云停木游龙树疑层冷洲冲射略范竟句室异激汉村哈策演简卡罪判担州静退既衣您宗积余痛检差富灵协角占配征修皮挥胜降阶审沉坚善妈刘读啊超免压银买皇养伊怀执副乱抗犯追帮宣佛岁航优怪香著田铁控税左右份穿艺背阵草脚概恶块顿敢守酒岛托央户烈洋哥索胡款靠评版宝座释景顾弟登货互付伯慢欧换闻危忙核暗姐介坏讨丽良序升监临亮露永呼味野架域沙掉括舰鱼杂误湾吉减编楚肯测败屋跑梦散温困剑渐封救贵枪缺楼县尚毫移娘朋画班智亦耳恩短掌恐遗固席松秘谢鲁遇康虑幸均销钟诗藏赶剧票损忽巨炮旧端探湖录叶春乡附吸予礼港雨呀板庭妇归睛饭额含顺输摇招婚脱补谓督毒油疗旅泽材灭逐莫笔亡鲜词圣择寻厂睡博勒烟授诺伦岸奥唐卖俄炸载洛健堂旁宫喝借君禁阴园谋宋避抓荣姑孙逃牙束跳顶玉镇雪午练迫爷篇肉嘴馆遍凡础洞卷坦牛宁纸诸训私庄祖丝翻暴森塔默握戏隐熟骨访弱蒙歌店鬼软典欲萨伙遭盘爸扩盖弄雄稳忘亿刺拥徒姆杨齐赛趣曲刀床迎冰虚玩析窗醒妻透购替塞努休虎扬途侵刑绿兄迅套贸毕唯谷轮库迹尤竞街促延震弃甲伟麻川申缓潜闪售灯针哲络抵朱埃抱鼓植纯夏忍页杰筑折郑贝尊吴秀混臣雅振染盛怒舞圆搞狂措姓残秋培迷诚宽宇猛摆梅毁伸摩盟末乃悲拍丁赵" +
+	"這個們來為國說時會對過發後裡種經麼學現當沒動還進樣實與長機無開關點業將兩間問應戰頭體產見話變條總電數報結場資許統區隊張該規萬覺術領確傳觀讓識帶導運飛風聯組濟車親極議證轉單羅愛擊備調質團華級離況亞請際約復線斷滿視須寫稱嗎歷醫專費號盡語僅隨選紅響雖勢眾構節說裝廣顯標談圖錢熱職樂剛獨彈創錯預誰陽繼驚傷藥險陸習歡龍樹層衝簡檢靈協權雙紀舉殺蘇細網聲氣門話頭讀買亂幫歲藝陣腳惡塊顧換聞壞討麗監臨雜誤灣減編測敗夢溫劍漸貴槍樓縣畫遺謝慮銷鐘詩劇損舊錄葉鄉禮婦飯額順輸補謂誦燈針鄭貝吳誠擺畢輪庫跡競"
+
+var commonRunes = func() map[rune]bool {
+	set := make(map[rune]bool, len(commonHan)/3)
+	for _, r := range commonHan {
+		set[r] = true
+	}
+	return set
+}()
+
+// NewReader returns a UTF-8 reader for r and the detected charset name.
+// Line endings are left untouched; see Normalize for display text.
+func NewReader(r io.Reader) (io.Reader, string, error) {
+	buffered := bufio.NewReaderSize(r, sampleSize)
+	sample, err := buffered.Peek(sampleSize)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return nil, "", err
+	}
+	charset := Detect(sample)
+	if charset.Name == UTF8.Name && charset.Encoding == unicode.UTF8 {
+		return buffered, charset.Name, nil
+	}
+	return transform.NewReader(buffered, charset.Encoding.NewDecoder()), charset.Name, nil
+}
+
+// Decode converts a whole file to normalized UTF-8 text.
+func Decode(data []byte) (string, string) {
+	sample := data
+	if len(sample) > sampleSize {
+		sample = sample[:sampleSize]
+	}
+	charset := Detect(sample)
+	decoded, _, err := transform.Bytes(charset.Encoding.NewDecoder(), data)
+	if err != nil {
+		decoded = bytes.ToValidUTF8(data, []byte("\ufffd"))
+	}
+	return Normalize(string(decoded)), charset.Name
+}
+
+// Normalize converts line endings to "\n" and drops NUL bytes and any
+// leading byte order mark.
+func Normalize(text string) string {
+	text = strings.TrimPrefix(text, "\ufeff")
+	return strings.NewReplacer("\r\n", "\n", "\r", "\n", "\x00", "").Replace(text)
+}
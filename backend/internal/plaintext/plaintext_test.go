@@ -0,0 +1,80 @@
+package plaintext
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/unicode"
+)
+
+func encode(t *testing.T, enc encoding.Encoding, text string) []byte {
+	t.Helper()
+	out, err := enc.NewEncoder().Bytes([]byte(text))
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	return out
+}
+
+func TestDetectEncodings(t *testing.T) {
+	simplified := "第一章 风起\n他看着远处的山，心中想起了那些年我们一起走过的路。这是一个很长的故事。\n"
+	traditional := "第一章 風起\n他看著遠處的山，心中想起了那些年我們一起走過的路。這是一個很長的故事。\n"
+	japanese := "第一章\n吾輩は猫である。名前はまだ無い。どこで生れたかとんと見当がつかぬ。\n"
+	cases := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"utf8", []byte(simplified), "utf-8"},
+		{"gbk", encode(t, gb18030.Encoding, simplified), "gb18030"},
+		{"big5", encode(t, big5.Encoding, traditional), "big5"},
+		{"sjis", encode(t, shiftJIS.Encoding, japanese), "shift_jis"},
+		{"cp1252", encode(t, windows1252.Encoding, "Café déjà vu, naïve façade — “quoted”.\n"), "windows-1252"},
+		{"utf16le-bom", encode(t, unicode.UTF16(unicode.LittleEndian, unicode.UseBOM), simplified), "utf-16le"},
+		{"utf16be-nobom", encode(t, unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM), "Chapter 1\nIt was a dark and stormy night."), "utf-16be"},
+	}
+	for _, tc := range cases {
+		if got := Detect(tc.data).Name; got != tc.want {
+			t.Fatalf("%s: expected %s, got %s", tc.name, tc.want, got)
+		}
+	}
+}
+
+func TestNewReaderTranscodes(t *testing.T) {
+	text := "第二回 宝玉初试云雨情\n"
+	reader, charset, err := NewReader(bytes.NewReader(encode(t, gb18030.Encoding, strings.Repeat(text, 20))))
+	if err != nil {
+		t.Fatalf("reader: %v", err)
+	}
+	out, _ := io.ReadAll(reader)
+	if charset != "gb18030" || !strings.HasPrefix(string(out), text) {
+		t.Fatalf("unexpected %s output %q", charset, out[:40])
+	}
+}
+
+func TestDecodeNormalizesLineEndings(t *testing.T) {
+	text, charset := Decode([]byte("\xef\xbb\xbfline one\r\nline two\rline three"))
+	if charset != "utf-8" || text != "line one\nline two\nline three" {
+		t.Fatalf("unexpected %s %q", charset, text)
+	}
+}
+
+func TestSplitChapters(t *testing.T) {
+	text := "书名：测试\n\n第一章 开始\n正文一。\n　　第十二章　结束\n正文二，第一章的回忆不算标题因为这一行实在是太长了太长了太长了太长了太长了太长了太长了。\nChapter 3\nEnglish.\n"
+	chapters := Split(text)
+	if len(chapters) != 4 {
+		t.Fatalf("expected 4 chapters, got %+v", chapters)
+	}
+	if chapters[0].Title != "" || chapters[1].Title != "第一章 开始" || chapters[2].Title != "第十二章　结束" || chapters[3].Title != "Chapter 3" {
+		t.Fatalf("unexpected titles %+v", chapters)
+	}
+	if got := text[chapters[3].Start:chapters[3].End]; got != "Chapter 3\nEnglish.\n" {
+		t.Fatalf("unexpected span %q", got)
+	}
+	if single := Split("no headings here\n"); len(single) != 1 || single[0].End != 17 {
+		t.Fatalf("expected a single chapter, got %+v", single)
+	}
+}
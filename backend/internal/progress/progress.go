@@ -23,3 +23,16 @@ func normalizeLocation(value float64) float64 {
 	}
 	return value
 }
+
+// Page maps a 0-1 location onto a 1-based page number for books with a
+// known page count.
+func Page(location float64, pageCount int) int {
+	if pageCount <= 0 {
+		return 0
+	}
+	page := int(math.Floor(normalizeLocation(location)*float64(pageCount))) + 1
+	if page > pageCount {
+		return pageCount
+	}
+	return page
+}
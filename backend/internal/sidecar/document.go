@@ -0,0 +1,109 @@
+package sidecar
+
+import (
+	"sort"
+	"time"
+)
+
+// Document is what a sidecar holds for one book.
+type Document struct {
+	Progress    *Progress
+	Annotations []Annotation
+	Bookmarks   []Bookmark
+}
+
+type Progress struct {
+	Location  float64
+	UpdatedAt time.Time
+}
+
+// Annotation is a highlight. ID is set for annotations exported by Relite;
+// entries added by other readers have none and keep their original
+// encoding in raw.
+type Annotation struct {
+	ID        string
+	Location  float64
+	BlockID   string
+	Quote     string
+	Note      string
+	Color     string
+	CreatedAt time.Time
+
+	raw any
+}
+
+// Bookmark follows the same ID rules as Annotation.
+type Bookmark struct {
+	ID        string
+	Label     string
+	Location  float64
+	BlockID   string
+	CreatedAt time.Time
+
+	raw any
+}
+
+func (d Document) Empty() bool {
+	return d.Progress == nil && len(d.Annotations) == 0 && len(d.Bookmarks) == 0
+}
+
+// Merge combines the local document with the sidecar found on the share.
+// Local entries win. Remote entries with an ID were exported by Relite, so
+// those missing locally were deleted and are dropped; entries from other
+// readers are kept unless they match a local entry by text and creation
+// time. The newer progress wins.
+func Merge(local, remote Document) Document {
+	out := Document{Progress: newerProgress(local.Progress, remote.Progress)}
+	out.Annotations = append(out.Annotations, local.Annotations...)
+	for _, annotation := range remote.Annotations {
+		if annotation.ID == "" && !hasAnnotation(local.Annotations, annotation) {
+			out.Annotations = append(out.Annotations, annotation)
+		}
+	}
+	out.Bookmarks = append(out.Bookmarks, local.Bookmarks...)
+	for _, bookmark := range remote.Bookmarks {
+		if bookmark.ID == "" && !hasBookmark(local.Bookmarks, bookmark) {
+			out.Bookmarks = append(out.Bookmarks, bookmark)
+		}
+	}
+	sort.SliceStable(out.Annotations, func(i, j int) bool {
+		return out.Annotations[i].CreatedAt.Before(out.Annotations[j].CreatedAt)
+	})
+	sort.SliceStable(out.Bookmarks, func(i, j int) bool {
+		return out.Bookmarks[i].CreatedAt.Before(out.Bookmarks[j].CreatedAt)
+	})
+	return out
+}
+
+func newerProgress(local, remote *Progress) *Progress {
+	if local == nil {
+		return remote
+	}
+	if remote != nil && remote.UpdatedAt.After(local.UpdatedAt) {
+		return remote
+	}
+	return local
+}
+
+func hasAnnotation(list []Annotation, other Annotation) bool {
+	for _, annotation := range list {
+		if annotation.Quote == other.Quote && sameSecond(annotation.CreatedAt, other.CreatedAt) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasBookmark(list []Bookmark, other Bookmark) bool {
+	for _, bookmark := range list {
+		if bookmark.Label == other.Label && sameSecond(bookmark.CreatedAt, other.CreatedAt) {
+			return true
+		}
+	}
+	return false
+}
+
+// sameSecond compares times at the resolution sidecar formats keep.
+func sameSecond(a, b time.Time) bool {
+	return a.Truncate(time.Second).Equal(b.Truncate(time.Second))
+}
@@ -0,0 +1,41 @@
+package sidecar
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMergeKeepsForeignEntriesAndDropsDeletedOnes(t *testing.T) {
+	base := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	local := Document{
+		Progress:    &Progress{Location: 0.4, UpdatedAt: base},
+		Annotations: []Annotation{{ID: "a1", Quote: "kept", CreatedAt: base}},
+		Bookmarks:   []Bookmark{{ID: "b1", Label: "mark", CreatedAt: base}},
+	}
+	remote := Document{
+		Progress: &Progress{Location: 0.7, UpdatedAt: base.Add(time.Hour)},
+		Annotations: []Annotation{
+			{ID: "a1", Quote: "kept", CreatedAt: base},
+			{ID: "a2", Quote: "deleted in Relite", CreatedAt: base},
+			{Quote: "from another reader", CreatedAt: base.Add(time.Minute)},
+			// The local annotation, rewritten by a reader that dropped its ID.
+			{Quote: "kept", CreatedAt: base.Add(300 * time.Millisecond)},
+		},
+		Bookmarks: []Bookmark{{Label: "foreign", CreatedAt: base.Add(-time.Minute)}},
+	}
+	merged := Merge(local, remote)
+	if merged.Progress.Location != 0.7 {
+		t.Fatalf("expected newer remote progress, got %v", merged.Progress.Location)
+	}
+	if len(merged.Annotations) != 2 || merged.Annotations[0].ID != "a1" || merged.Annotations[1].Quote != "from another reader" {
+		t.Fatalf("unexpected annotations %+v", merged.Annotations)
+	}
+	if len(merged.Bookmarks) != 2 || merged.Bookmarks[0].Label != "foreign" || merged.Bookmarks[1].ID != "b1" {
+		t.Fatalf("unexpected bookmarks %+v", merged.Bookmarks)
+	}
+
+	remote.Progress.UpdatedAt = base.Add(-time.Hour)
+	if merged := Merge(local, remote); merged.Progress.Location != 0.4 {
+		t.Fatalf("expected newer local progress, got %v", merged.Progress.Location)
+	}
+}
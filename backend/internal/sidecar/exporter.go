@@ -0,0 +1,239 @@
+package sidecar
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/EROQIN/relite-reader/backend/internal/annotations"
+	"github.com/EROQIN/relite-reader/backend/internal/bookmarks"
+	"github.com/EROQIN/relite-reader/backend/internal/books"
+	"github.com/EROQIN/relite-reader/backend/internal/progress"
+	"github.com/EROQIN/relite-reader/backend/internal/tasks"
+	"github.com/EROQIN/relite-reader/backend/internal/webdav"
+)
+
+// maxAttempts bounds the read-merge-write rounds of one sidecar when other
+// writers keep changing it.
+const maxAttempts = 3
+
+var ErrConflict = errors.New("sidecar kept changing while writing")
+
+// Format encodes a Document into one sidecar flavour. Encode receives the
+// current file, if any, so that content it does not manage is kept.
+type Format interface {
+	Decode(data []byte, modTime time.Time) (Document, error)
+	Encode(doc Document, previous []byte) ([]byte, error)
+}
+
+// Share is the part of the WebDAV service the exporter writes through.
+type Share interface {
+	Get(userID, id string) (webdav.Connection, error)
+	ReadFile(ctx context.Context, userID, connectionID, filePath string) (webdav.RemoteFile, error)
+	WriteFile(ctx context.Context, userID, connectionID, filePath string, data []byte, ifMatch string) (string, error)
+	MakeDir(ctx context.Context, userID, connectionID, dirPath string) error
+}
+
+// Exporter writes the annotations, bookmarks and progress of each book
+// into a sidecar next to it on the share.
+type Exporter struct {
+	share       Share
+	books       books.Store
+	annotations annotations.Store
+	bookmarks   bookmarks.Store
+	progress    progress.Store
+
+	mu sync.Mutex
+	// exported holds a digest of the local document last written per
+	// book, so unchanged books are skipped.
+	exported map[string]string
+}
+
+func NewExporter(share Share, booksStore books.Store, annotationsStore annotations.Store, bookmarksStore bookmarks.Store, progressStore progress.Store) *Exporter {
+	return &Exporter{
+		share:       share,
+		books:       booksStore,
+		annotations: annotationsStore,
+		bookmarks:   bookmarksStore,
+		progress:    progressStore,
+		exported:    make(map[string]string),
+	}
+}
+
+// FormatFor returns the sidecar format of a write-back mode.
+func FormatFor(mode webdav.WriteBack) (Format, bool) {
+	switch mode {
+	case webdav.WriteBackRelite:
+		return Relite{}, true
+	case webdav.WriteBackKOReader:
+		return KOReader{}, true
+	}
+	return nil, false
+}
+
+// Register adds the writeback task handler to registry.
+func (e *Exporter) Register(registry *tasks.Registry) {
+	registry.Register(webdav.WriteBackTask, e.Handle, tasks.TypeOptions{})
+}
+
+// Handle runs a writeback task.
+func (e *Exporter) Handle(ctx context.Context, task tasks.Task) error {
+	connectionID := task.Payload["connection_id"]
+	if connectionID == "" {
+		return tasks.Permanent(errors.New("missing connection_id"))
+	}
+	return e.ExportConnection(ctx, task.UserID, connectionID)
+}
+
+// ExportConnection writes the sidecars of every book of a connection whose
+// local data changed since it was last exported. It carries on past
+// failing books and returns the first error.
+func (e *Exporter) ExportConnection(ctx context.Context, userID, connectionID string) error {
+	conn, err := e.share.Get(userID, connectionID)
+	if err != nil {
+		return err
+	}
+	format, ok := FormatFor(conn.WriteBack)
+	if !ok {
+		return nil
+	}
+	list, err := e.books.ListByUser(userID)
+	if err != nil {
+		return err
+	}
+	var firstErr error
+	for _, book := range list {
+		if book.ConnectionID != connectionID || book.Missing {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		local, err := e.local(userID, book.ID)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		if local.Empty() {
+			continue
+		}
+		key := string(conn.WriteBack) + "\x00" + userID + "\x00" + book.ID
+		digest := digestOf(local)
+		e.mu.Lock()
+		unchanged := e.exported[key] == digest
+		e.mu.Unlock()
+		if unchanged {
+			continue
+		}
+		if err := e.export(ctx, userID, conn, format, webdav.SidecarPath(conn.WriteBack, book.SourcePath), local); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		e.mu.Lock()
+		e.exported[key] = digest
+		e.mu.Unlock()
+	}
+	return firstErr
+}
+
+// export merges the local document into the sidecar on the share. The
+// write is conditional on the version read, so a concurrent change by
+// another reader is merged again instead of overwritten.
+func (e *Exporter) export(ctx context.Context, userID string, conn webdav.Connection, format Format, sidecarPath string, local Document) error {
+	if conn.WriteBack == webdav.WriteBackKOReader {
+		if err := e.share.MakeDir(ctx, userID, conn.ID, path.Dir(sidecarPath)); err != nil {
+			return err
+		}
+	}
+	for range maxAttempts {
+		remote, err := e.share.ReadFile(ctx, userID, conn.ID, sidecarPath)
+		exists := err == nil
+		if err != nil && !errors.Is(err, webdav.ErrFileNotFound) {
+			return err
+		}
+		merged := local
+		ifMatch := ""
+		if exists {
+			doc, err := format.Decode(remote.Data, remote.ModTime)
+			if err != nil {
+				// Leave a file we cannot read alone.
+				return err
+			}
+			merged = Merge(local, doc)
+			ifMatch = remote.ETag
+			if ifMatch == "" {
+				ifMatch = "*"
+			}
+		}
+		data, err := format.Encode(merged, remote.Data)
+		if err != nil {
+			return err
+		}
+		_, err = e.share.WriteFile(ctx, userID, conn.ID, sidecarPath, data, ifMatch)
+		if !errors.Is(err, webdav.ErrPreconditionFailed) {
+			return err
+		}
+	}
+	return ErrConflict
+}
+
+// local collects the data Relite holds for a book.
+func (e *Exporter) local(userID, bookID string) (Document, error) {
+	var doc Document
+	if e.annotations != nil {
+		list, err := e.annotations.ListByBook(userID, bookID)
+		if err != nil {
+			return Document{}, err
+		}
+		for _, annotation := range list {
+			doc.Annotations = append(doc.Annotations, Annotation{
+				ID:        annotation.ID,
+				Location:  annotation.Location,
+				BlockID:   annotation.BlockID,
+				Quote:     annotation.Quote,
+				Note:      annotation.Note,
+				Color:     annotation.Color,
+				CreatedAt: annotation.CreatedAt,
+			})
+		}
+	}
+	if e.bookmarks != nil {
+		list, err := e.bookmarks.ListByBook(userID, bookID)
+		if err != nil {
+			return Document{}, err
+		}
+		for _, bookmark := range list {
+			doc.Bookmarks = append(doc.Bookmarks, Bookmark{
+				ID:        bookmark.ID,
+				Label:     bookmark.Label,
+				Location:  bookmark.Location,
+				BlockID:   bookmark.BlockID,
+				CreatedAt: bookmark.CreatedAt,
+			})
+		}
+	}
+	if e.progress != nil {
+		current, err := e.progress.Get(userID, bookID)
+		if err != nil {
+			return Document{}, err
+		}
+		if !current.UpdatedAt.IsZero() {
+			doc.Progress = &Progress{Location: current.Location, UpdatedAt: current.UpdatedAt}
+		}
+	}
+	return doc, nil
+}
+
+func digestOf(doc Document) string {
+	data, _ := Relite{}.Encode(doc, nil)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
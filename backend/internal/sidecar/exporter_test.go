@@ -0,0 +1,129 @@
+package sidecar
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/EROQIN/relite-reader/backend/internal/annotations"
+	"github.com/EROQIN/relite-reader/backend/internal/bookmarks"
+	"github.com/EROQIN/relite-reader/backend/internal/books"
+	"github.com/EROQIN/relite-reader/backend/internal/progress"
+	"github.com/EROQIN/relite-reader/backend/internal/webdav"
+)
+
+// memoryShare keeps files with numbered ETags and honours conditional
+// writes. beforeWrite runs before each write, to simulate another writer.
+type memoryShare struct {
+	conn        webdav.Connection
+	files       map[string]webdav.RemoteFile
+	dirs        []string
+	writes      int
+	beforeWrite func()
+	version     int
+}
+
+func (s *memoryShare) Get(_, _ string) (webdav.Connection, error) { return s.conn, nil }
+
+func (s *memoryShare) ReadFile(_ context.Context, _, _, filePath string) (webdav.RemoteFile, error) {
+	file, ok := s.files[filePath]
+	if !ok {
+		return webdav.RemoteFile{}, webdav.ErrFileNotFound
+	}
+	return file, nil
+}
+
+func (s *memoryShare) WriteFile(_ context.Context, _, _, filePath string, data []byte, ifMatch string) (string, error) {
+	if s.beforeWrite != nil {
+		hook := s.beforeWrite
+		s.beforeWrite = nil
+		hook()
+	}
+	current, exists := s.files[filePath]
+	if (ifMatch == "" && exists) || (ifMatch != "" && ifMatch != "*" && ifMatch != current.ETag) {
+		return "", webdav.ErrPreconditionFailed
+	}
+	s.writes++
+	return s.put(filePath, data), nil
+}
+
+func (s *memoryShare) put(filePath string, data []byte) string {
+	s.version++
+	etag := fmt.Sprintf(`"%d"`, s.version)
+	s.files[filePath] = webdav.RemoteFile{Data: data, ETag: etag, ModTime: time.Now()}
+	return etag
+}
+
+func (s *memoryShare) MakeDir(_ context.Context, _, _, dirPath string) error {
+	s.dirs = append(s.dirs, dirPath)
+	return nil
+}
+
+func newExporterFixture(t *testing.T, mode webdav.WriteBack) (*Exporter, *memoryShare, annotations.Store, string) {
+	t.Helper()
+	booksStore := books.NewMemoryStore()
+	annotationsStore := annotations.NewMemoryStore()
+	bookmarksStore := bookmarks.NewMemoryStore()
+	progressStore := progress.NewMemoryStore()
+	book, _ := booksStore.Upsert("user-1", books.Book{Title: "Dune", SourcePath: "/Books/Dune.epub", ConnectionID: "conn-1"})
+	_, _ = booksStore.Upsert("user-1", books.Book{Title: "Other", SourcePath: "/Books/Other.epub", ConnectionID: "conn-2"})
+	if _, err := annotationsStore.Create("user-1", book.ID, 0.1, "spice", "must flow", "yellow", "b3"); err != nil {
+		t.Fatalf("annotation: %v", err)
+	}
+	_, _ = bookmarksStore.Create("user-1", book.ID, "Part two", 0.4, "")
+	_, _ = progressStore.Save("user-1", book.ID, 0.42)
+	share := &memoryShare{conn: webdav.Connection{ID: "conn-1", WriteBack: mode}, files: map[string]webdav.RemoteFile{}}
+	return NewExporter(share, booksStore, annotationsStore, bookmarksStore, progressStore), share, annotationsStore, book.ID
+}
+
+func TestExporterWritesSidecarsOnce(t *testing.T) {
+	exporter, share, annotationsStore, bookID := newExporterFixture(t, webdav.WriteBackRelite)
+	if err := exporter.ExportConnection(context.Background(), "user-1", "conn-1"); err != nil {
+		t.Fatalf("export: %v", err)
+	}
+	file, ok := share.files["/Books/Dune.epub.relite.json"]
+	if !ok || len(share.files) != 1 {
+		t.Fatalf("expected one sidecar, got %v", share.files)
+	}
+	doc, _ := Relite{}.Decode(file.Data, file.ModTime)
+	if len(doc.Annotations) != 1 || doc.Annotations[0].Note != "must flow" || len(doc.Bookmarks) != 1 || doc.Progress.Location != 0.42 {
+		t.Fatalf("unexpected sidecar %s", file.Data)
+	}
+
+	if err := exporter.ExportConnection(context.Background(), "user-1", "conn-1"); err != nil || share.writes != 1 {
+		t.Fatalf("expected unchanged book skipped, got %d writes (%v)", share.writes, err)
+	}
+	_, _ = annotationsStore.Create("user-1", bookID, 0.2, "second", "", "", "")
+	if err := exporter.ExportConnection(context.Background(), "user-1", "conn-1"); err != nil || share.writes != 2 {
+		t.Fatalf("expected changed book rewritten, got %d writes (%v)", share.writes, err)
+	}
+}
+
+func TestExporterMergesConcurrentChanges(t *testing.T) {
+	exporter, share, _, _ := newExporterFixture(t, webdav.WriteBackKOReader)
+	sidecarPath := "/Books/Dune.sdr/metadata.epub.lua"
+	share.put(sidecarPath, []byte(`return { ["percent_finished"] = 0.1, ["annotations"] = {} }`))
+	// KOReader adds a highlight between our read and our write.
+	share.beforeWrite = func() {
+		share.put(sidecarPath, []byte(`return {
+    ["percent_finished"] = 0.1,
+    ["annotations"] = {
+        [1] = { ["datetime"] = "2024-05-01 12:00:00", ["pos0"] = "a", ["pos1"] = "b", ["text"] = "from koreader" },
+    },
+}`))
+	}
+	if err := exporter.ExportConnection(context.Background(), "user-1", "conn-1"); err != nil {
+		t.Fatalf("export: %v", err)
+	}
+	if len(share.dirs) != 1 || share.dirs[0] != "/Books/Dune.sdr" {
+		t.Fatalf("expected the .sdr folder created, got %v", share.dirs)
+	}
+	// The file changed after the local progress was saved, so its
+	// percentage is the newer one.
+	data := string(share.files[sidecarPath].Data)
+	if !strings.Contains(data, `"from koreader"`) || !strings.Contains(data, `"spice"`) || !strings.Contains(data, `["percent_finished"] = 0.1,`) {
+		t.Fatalf("expected both writers merged:\n%s", data)
+	}
+}
@@ -0,0 +1,91 @@
+package sidecar
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+var exported = Document{
+	Progress:    &Progress{Location: 0.5, UpdatedAt: time.Date(2024, 5, 2, 8, 0, 0, 0, time.UTC)},
+	Annotations: []Annotation{{ID: "a1", Location: 0.3, BlockID: "b12", Quote: "quote", Note: "note", Color: "yellow", CreatedAt: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}},
+	Bookmarks:   []Bookmark{{ID: "m1", Label: "Chapter 2", Location: 0.2, CreatedAt: time.Date(2024, 5, 1, 13, 0, 0, 0, time.UTC)}},
+}
+
+func TestKOReaderKeepsItsOwnContent(t *testing.T) {
+	data, err := KOReader{}.Encode(exported, []byte(koreaderSample))
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	table, err := parseLua(string(data))
+	if err != nil {
+		t.Fatalf("parse: %v\n%s", err, data)
+	}
+	if props, ok := table.get("doc_props").(*luaTable); !ok || props.str("title") != "Nineteen Eighty-Four" {
+		t.Fatalf("expected doc_props kept:\n%s", data)
+	}
+	doc, err := KOReader{}.Decode(data, time.Now())
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	// Encoding replaces the annotation list with the document's, so the
+	// KOReader highlight only survives when it was merged in.
+	if len(doc.Annotations) != 1 || doc.Annotations[0].ID != "a1" || doc.Annotations[0].BlockID != "b12" || doc.Annotations[0].Note != "note" {
+		t.Fatalf("unexpected annotations %+v", doc.Annotations)
+	}
+	if len(doc.Bookmarks) != 1 || doc.Bookmarks[0].Label != "Chapter 2" || !doc.Bookmarks[0].CreatedAt.Equal(exported.Bookmarks[0].CreatedAt) {
+		t.Fatalf("unexpected bookmarks %+v", doc.Bookmarks)
+	}
+	if doc.Progress == nil || !doc.Progress.UpdatedAt.Equal(exported.Progress.UpdatedAt) {
+		t.Fatalf("expected progress timestamp kept, got %+v", doc.Progress)
+	}
+
+	remote, _ := KOReader{}.Decode([]byte(koreaderSample), time.Now())
+	merged, err := KOReader{}.Encode(Merge(exported, remote), []byte(koreaderSample))
+	if err != nil {
+		t.Fatalf("encode merged: %v", err)
+	}
+	if !strings.Contains(string(merged), `["pos0"] = "/body/DocFragment[2]/body/p[3]/text().0"`) {
+		t.Fatalf("expected the KOReader highlight kept verbatim:\n%s", merged)
+	}
+}
+
+func TestKOReaderProgressMovedByAnotherReader(t *testing.T) {
+	data, _ := KOReader{}.Encode(exported, nil)
+	moved := strings.Replace(string(data), `["percent_finished"] = 0.5`, `["percent_finished"] = 0.75`, 1)
+	modified := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	doc, err := KOReader{}.Decode([]byte(moved), modified)
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if doc.Progress.Location != 0.75 || !doc.Progress.UpdatedAt.Equal(modified) {
+		t.Fatalf("expected progress dated by the file, got %+v", doc.Progress)
+	}
+}
+
+func TestReliteRoundTripsAndKeepsUnknownFields(t *testing.T) {
+	previous := []byte(`{"version":1,"app":{"name":"other"},"annotations":[{"quote":"foreign","location":0.9,"created_at":"2024-04-01T00:00:00Z","style":"wavy"}],"bookmarks":[]}`)
+	remote, err := Relite{}.Decode(previous, time.Now())
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	data, err := Relite{}.Encode(Merge(exported, remote), previous)
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		t.Fatalf("invalid json: %v", err)
+	}
+	if _, ok := fields["app"]; !ok || !strings.Contains(string(data), `"style": "wavy"`) {
+		t.Fatalf("expected unknown fields kept:\n%s", data)
+	}
+	doc, err := Relite{}.Decode(data, time.Now())
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(doc.Annotations) != 2 || doc.Annotations[1].ID != "a1" || doc.Progress.Location != 0.5 || len(doc.Bookmarks) != 1 {
+		t.Fatalf("unexpected document %+v", doc)
+	}
+}
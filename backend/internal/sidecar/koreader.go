@@ -0,0 +1,123 @@
+package sidecar
+
+import (
+	"time"
+)
+
+// koreaderTime is the layout of KOReader's annotation datetime, in local
+// time.
+const koreaderTime = "2006-01-02 15:04:05"
+
+// KOReader is the .sdr/metadata.<ext>.lua sidecar format. Relite writes
+// its entries into the "annotations" list with relite_* fields and the
+// progress into percent_finished; everything else in the file, including
+// entries made in KOReader, is kept.
+type KOReader struct{}
+
+func (KOReader) Decode(data []byte, modTime time.Time) (Document, error) {
+	table, err := parseLua(string(data))
+	if err != nil {
+		return Document{}, err
+	}
+	var doc Document
+	if percent, ok := table.num("percent_finished"); ok {
+		progress := &Progress{Location: percent, UpdatedAt: modTime}
+		// The percentage Relite wrote comes with its own timestamp; one
+		// that differs was moved by another reader when the file changed.
+		if written, ok := table.num("relite_percent"); ok && written == percent {
+			if at, err := time.Parse(time.RFC3339, table.str("relite_progress_at")); err == nil {
+				progress.UpdatedAt = at
+			}
+		}
+		doc.Progress = progress
+	}
+	if list, ok := table.get("annotations").(*luaTable); ok {
+		for _, value := range list.list() {
+			entry, ok := value.(*luaTable)
+			if !ok {
+				continue
+			}
+			created, _ := time.ParseInLocation(koreaderTime, entry.str("datetime"), time.Local)
+			location, _ := entry.num("relite_location")
+			kind := entry.str("relite_kind")
+			if kind == "bookmark" || (kind == "" && entry.get("pos0") == nil) {
+				doc.Bookmarks = append(doc.Bookmarks, Bookmark{
+					ID:        entry.str("relite_id"),
+					Label:     entry.str("text"),
+					Location:  location,
+					BlockID:   entry.str("relite_block_id"),
+					CreatedAt: created,
+					raw:       entry,
+				})
+				continue
+			}
+			doc.Annotations = append(doc.Annotations, Annotation{
+				ID:        entry.str("relite_id"),
+				Location:  location,
+				BlockID:   entry.str("relite_block_id"),
+				Quote:     entry.str("text"),
+				Note:      entry.str("note"),
+				Color:     entry.str("color"),
+				CreatedAt: created,
+				raw:       entry,
+			})
+		}
+	}
+	return doc, nil
+}
+
+func (KOReader) Encode(doc Document, previous []byte) ([]byte, error) {
+	table := &luaTable{}
+	if len(previous) > 0 {
+		if parsed, err := parseLua(string(previous)); err == nil {
+			table = parsed
+		}
+	}
+	if doc.Progress != nil {
+		table.set("percent_finished", doc.Progress.Location)
+		table.set("relite_percent", doc.Progress.Location)
+		table.set("relite_progress_at", doc.Progress.UpdatedAt.UTC().Format(time.RFC3339))
+	}
+	var entries []any
+	for _, annotation := range doc.Annotations {
+		if raw, ok := annotation.raw.(*luaTable); ok && annotation.ID == "" {
+			entries = append(entries, raw)
+			continue
+		}
+		entry := &luaTable{}
+		entry.set("datetime", annotation.CreatedAt.Local().Format(koreaderTime))
+		entry.set("drawer", "lighten")
+		entry.set("text", annotation.Quote)
+		if annotation.Note != "" {
+			entry.set("note", annotation.Note)
+		}
+		if annotation.Color != "" {
+			entry.set("color", annotation.Color)
+		}
+		entry.set("relite_id", annotation.ID)
+		entry.set("relite_kind", "annotation")
+		entry.set("relite_location", annotation.Location)
+		if annotation.BlockID != "" {
+			entry.set("relite_block_id", annotation.BlockID)
+		}
+		entries = append(entries, entry)
+	}
+	for _, bookmark := range doc.Bookmarks {
+		if raw, ok := bookmark.raw.(*luaTable); ok && bookmark.ID == "" {
+			entries = append(entries, raw)
+			continue
+		}
+		entry := &luaTable{}
+		entry.set("datetime", bookmark.CreatedAt.Local().Format(koreaderTime))
+		entry.set("text", bookmark.Label)
+		entry.set("relite_id", bookmark.ID)
+		entry.set("relite_kind", "bookmark")
+		entry.set("relite_location", bookmark.Location)
+		if bookmark.BlockID != "" {
+			entry.set("relite_block_id", bookmark.BlockID)
+		}
+		entries = append(entries, entry)
+	}
+	table.set("annotations", newLuaList(entries))
+	return []byte(dumpLua(table)), nil
+}
@@ -0,0 +1,459 @@
+package sidecar
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+var errLuaSyntax = errors.New("invalid lua table")
+
+// luaTable is a Lua table literal as written by KOReader's serializer:
+// keys are strings or numbers; values are strings, numbers, booleans or
+// tables. Field order is kept so unknown fields survive a rewrite.
+type luaTable struct {
+	fields []luaField
+}
+
+type luaField struct {
+	key   any
+	value any
+}
+
+func (t *luaTable) get(key any) any {
+	for _, field := range t.fields {
+		if field.key == key {
+			return field.value
+		}
+	}
+	return nil
+}
+
+func (t *luaTable) set(key, value any) {
+	for i, field := range t.fields {
+		if field.key == key {
+			t.fields[i].value = value
+			return
+		}
+	}
+	t.fields = append(t.fields, luaField{key: key, value: value})
+}
+
+func (t *luaTable) str(key string) string {
+	value, _ := t.get(key).(string)
+	return value
+}
+
+func (t *luaTable) num(key string) (float64, bool) {
+	value, ok := t.get(key).(float64)
+	return value, ok
+}
+
+// list returns the values under consecutive integer keys from 1.
+func (t *luaTable) list() []any {
+	var out []any
+	for i := 1; ; i++ {
+		value := t.get(float64(i))
+		if value == nil {
+			return out
+		}
+		out = append(out, value)
+	}
+}
+
+func newLuaList(values []any) *luaTable {
+	table := &luaTable{}
+	for i, value := range values {
+		table.fields = append(table.fields, luaField{key: float64(i + 1), value: value})
+	}
+	return table
+}
+
+// parseLua reads a "return { ... }" chunk.
+func parseLua(src string) (*luaTable, error) {
+	p := &luaParser{src: src}
+	p.skip()
+	if !p.word("return") {
+		return nil, errLuaSyntax
+	}
+	value, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+	table, ok := value.(*luaTable)
+	p.skip()
+	if !ok || p.pos != len(p.src) {
+		return nil, errLuaSyntax
+	}
+	return table, nil
+}
+
+type luaParser struct {
+	src string
+	pos int
+}
+
+// skip passes over whitespace and comments.
+func (p *luaParser) skip() {
+	for p.pos < len(p.src) {
+		switch {
+		case strings.ContainsRune(" \t\r\n", rune(p.src[p.pos])):
+			p.pos++
+		case strings.HasPrefix(p.src[p.pos:], "--"):
+			p.pos += 2
+			if level, ok := p.longBracket(); ok {
+				_, _ = p.longString(level)
+				continue
+			}
+			for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+				p.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (p *luaParser) word(word string) bool {
+	end := p.pos + len(word)
+	if !strings.HasPrefix(p.src[p.pos:], word) || (end < len(p.src) && isIdentByte(p.src[end])) {
+		return false
+	}
+	p.pos = end
+	return true
+}
+
+func (p *luaParser) value() (any, error) {
+	p.skip()
+	if p.pos >= len(p.src) {
+		return nil, errLuaSyntax
+	}
+	c := p.src[p.pos]
+	switch {
+	case c == '{':
+		return p.table()
+	case c == '"' || c == '\'':
+		return p.quoted()
+	case c == '[':
+		level, ok := p.longBracket()
+		if !ok {
+			return nil, errLuaSyntax
+		}
+		return p.longString(level)
+	case c == '-' || c == '.' || (c >= '0' && c <= '9'):
+		return p.number()
+	case p.word("true"):
+		return true, nil
+	case p.word("false"):
+		return false, nil
+	case p.word("nil"):
+		return nil, nil
+	}
+	return nil, errLuaSyntax
+}
+
+func (p *luaParser) table() (*luaTable, error) {
+	p.pos++
+	table := &luaTable{}
+	next := 1
+	for {
+		p.skip()
+		if p.pos >= len(p.src) {
+			return nil, errLuaSyntax
+		}
+		if p.src[p.pos] == '}' {
+			p.pos++
+			return table, nil
+		}
+		var key any
+		switch {
+		case p.src[p.pos] == '[' && !strings.HasPrefix(p.src[p.pos:], "[[") && !strings.HasPrefix(p.src[p.pos:], "[="):
+			p.pos++
+			k, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			p.skip()
+			if !p.consume(']') {
+				return nil, errLuaSyntax
+			}
+			p.skip()
+			if !p.consume('=') {
+				return nil, errLuaSyntax
+			}
+			key = k
+		case isIdentStart(p.src[p.pos]):
+			start := p.pos
+			for p.pos < len(p.src) && isIdentByte(p.src[p.pos]) {
+				p.pos++
+			}
+			name := p.src[start:p.pos]
+			p.skip()
+			if p.consume('=') {
+				key = name
+			} else {
+				p.pos = start
+			}
+		}
+		value, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		if key == nil {
+			key = float64(next)
+			next++
+		}
+		if value != nil {
+			table.fields = append(table.fields, luaField{key: key, value: value})
+		}
+		p.skip()
+		if !p.consume(',') && !p.consume(';') {
+			p.skip()
+			if p.pos >= len(p.src) || p.src[p.pos] != '}' {
+				return nil, errLuaSyntax
+			}
+		}
+	}
+}
+
+func (p *luaParser) consume(c byte) bool {
+	if p.pos < len(p.src) && p.src[p.pos] == c {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *luaParser) number() (float64, error) {
+	start := p.pos
+	if p.src[p.pos] == '-' {
+		p.pos++
+	}
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		if isIdentByte(c) || c == '.' || ((c == '-' || c == '+') && (p.src[p.pos-1] == 'e' || p.src[p.pos-1] == 'E')) {
+			p.pos++
+			continue
+		}
+		break
+	}
+	text := p.src[start:p.pos]
+	if value, err := strconv.ParseFloat(text, 64); err == nil {
+		return value, nil
+	}
+	if value, err := strconv.ParseInt(text, 0, 64); err == nil {
+		return float64(value), nil
+	}
+	return 0, errLuaSyntax
+}
+
+func (p *luaParser) quoted() (string, error) {
+	quote := p.src[p.pos]
+	p.pos++
+	var b strings.Builder
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		p.pos++
+		switch c {
+		case quote:
+			return b.String(), nil
+		case '\n':
+			return "", errLuaSyntax
+		case '\\':
+			if err := p.escape(&b); err != nil {
+				return "", err
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", errLuaSyntax
+}
+
+func (p *luaParser) escape(b *strings.Builder) error {
+	if p.pos >= len(p.src) {
+		return errLuaSyntax
+	}
+	c := p.src[p.pos]
+	p.pos++
+	switch c {
+	case 'a':
+		b.WriteByte('\a')
+	case 'b':
+		b.WriteByte('\b')
+	case 'f':
+		b.WriteByte('\f')
+	case 'n', '\n':
+		b.WriteByte('\n')
+	case 'r':
+		b.WriteByte('\r')
+	case 't':
+		b.WriteByte('\t')
+	case 'v':
+		b.WriteByte('\v')
+	case '\\', '"', '\'':
+		b.WriteByte(c)
+	case 'z':
+		for p.pos < len(p.src) && strings.ContainsRune(" \t\r\n", rune(p.src[p.pos])) {
+			p.pos++
+		}
+	case 'x':
+		if p.pos+2 > len(p.src) {
+			return errLuaSyntax
+		}
+		value, err := strconv.ParseUint(p.src[p.pos:p.pos+2], 16, 8)
+		if err != nil {
+			return errLuaSyntax
+		}
+		b.WriteByte(byte(value))
+		p.pos += 2
+	case 'u':
+		end := strings.IndexByte(p.src[p.pos:], '}')
+		if !strings.HasPrefix(p.src[p.pos:], "{") || end < 0 {
+			return errLuaSyntax
+		}
+		value, err := strconv.ParseUint(p.src[p.pos+1:p.pos+end], 16, 32)
+		if err != nil {
+			return errLuaSyntax
+		}
+		b.WriteRune(rune(value))
+		p.pos += end + 1
+	default:
+		if c < '0' || c > '9' {
+			return errLuaSyntax
+		}
+		start := p.pos - 1
+		for p.pos < len(p.src) && p.pos-start < 3 && p.src[p.pos] >= '0' && p.src[p.pos] <= '9' {
+			p.pos++
+		}
+		value, err := strconv.Atoi(p.src[start:p.pos])
+		if err != nil || value > 255 {
+			return errLuaSyntax
+		}
+		b.WriteByte(byte(value))
+	}
+	return nil
+}
+
+// longBracket consumes "[[" or "[==[" and returns its level.
+func (p *luaParser) longBracket() (int, bool) {
+	if p.pos >= len(p.src) || p.src[p.pos] != '[' {
+		return 0, false
+	}
+	i := p.pos + 1
+	for i < len(p.src) && p.src[i] == '=' {
+		i++
+	}
+	if i >= len(p.src) || p.src[i] != '[' {
+		return 0, false
+	}
+	level := i - p.pos - 1
+	p.pos = i + 1
+	return level, true
+}
+
+func (p *luaParser) longString(level int) (string, error) {
+	closing := "]" + strings.Repeat("=", level) + "]"
+	end := strings.Index(p.src[p.pos:], closing)
+	if end < 0 {
+		return "", errLuaSyntax
+	}
+	text := strings.TrimPrefix(p.src[p.pos:p.pos+end], "\n")
+	p.pos += end + len(closing)
+	return text, nil
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isIdentByte(c byte) bool {
+	return isIdentStart(c) || (c >= '0' && c <= '9')
+}
+
+// dumpLua writes a table the way KOReader does: one field per line, keys
+// in brackets, numeric keys before string keys.
+func dumpLua(table *luaTable) string {
+	var b strings.Builder
+	b.WriteString("-- we can read Lua syntax here!\nreturn ")
+	writeLuaValue(&b, table, 0)
+	b.WriteString("\n")
+	return b.String()
+}
+
+func writeLuaValue(b *strings.Builder, value any, depth int) {
+	switch v := value.(type) {
+	case *luaTable:
+		fields := append([]luaField(nil), v.fields...)
+		sort.SliceStable(fields, func(i, j int) bool { return luaKeyLess(fields[i].key, fields[j].key) })
+		b.WriteString("{\n")
+		indent := strings.Repeat("    ", depth+1)
+		for _, field := range fields {
+			b.WriteString(indent)
+			b.WriteString("[")
+			writeLuaValue(b, field.key, depth+1)
+			b.WriteString("] = ")
+			writeLuaValue(b, field.value, depth+1)
+			b.WriteString(",\n")
+		}
+		b.WriteString(strings.Repeat("    ", depth))
+		b.WriteString("}")
+	case string:
+		b.WriteString(quoteLua(v))
+	case float64:
+		b.WriteString(formatLuaNumber(v))
+	case bool:
+		b.WriteString(strconv.FormatBool(v))
+	default:
+		b.WriteString("nil")
+	}
+}
+
+func luaKeyLess(a, b any) bool {
+	an, aNum := a.(float64)
+	bn, bNum := b.(float64)
+	switch {
+	case aNum && bNum:
+		return an < bn
+	case aNum != bNum:
+		return aNum
+	}
+	as, _ := a.(string)
+	bs, _ := b.(string)
+	return as < bs
+}
+
+func formatLuaNumber(v float64) string {
+	if v == math.Trunc(v) && math.Abs(v) < 1e15 {
+		return strconv.FormatInt(int64(v), 10)
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func quoteLua(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case r == '"' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r == '\n':
+			b.WriteString(`\n`)
+		case r == '\r':
+			b.WriteString(`\r`)
+		case r == utf8.RuneError && size == 1, r < 0x20 || r == 0x7f:
+			fmt.Fprintf(&b, `\%03d`, s[i])
+		default:
+			b.WriteString(s[i : i+size])
+		}
+		i += size
+	}
+	b.WriteByte('"')
+	return b.String()
+}
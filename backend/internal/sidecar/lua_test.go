@@ -0,0 +1,78 @@
+package sidecar
+
+import (
+	"testing"
+)
+
+const koreaderSample = `-- we can read Lua syntax here!
+return {
+    ["annotations"] = {
+        [1] = {
+            ["chapter"] = "Chapter \"One\"",
+            ["datetime"] = "2024-05-01 12:00:00",
+            ["drawer"] = "lighten",
+            ["pos0"] = "/body/DocFragment[2]/body/p[3]/text().0",
+            ["pos1"] = "/body/DocFragment[2]/body/p[3]/text().42",
+            ["text"] = "It was a bright\ncold day",
+        },
+    },
+    ["doc_props"] = {
+        ["title"] = 'Nineteen Eighty-Four',
+        authors = [[George Orwell]],
+    },
+    ["percent_finished"] = 0.25,
+    ["summary"] = { status = "reading", ["pages"] = 328, },
+    --[[ a long
+    comment ]]
+    ["flags"] = { true, false, nil, -1.5e2 },
+}
+`
+
+func TestParseLuaReadsKOReaderFiles(t *testing.T) {
+	table, err := parseLua(koreaderSample)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if percent, ok := table.num("percent_finished"); !ok || percent != 0.25 {
+		t.Fatalf("unexpected percent %v", table.get("percent_finished"))
+	}
+	annotations := table.get("annotations").(*luaTable).list()
+	if len(annotations) != 1 || annotations[0].(*luaTable).str("text") != "It was a bright\ncold day" {
+		t.Fatalf("unexpected annotations %+v", annotations)
+	}
+	props := table.get("doc_props").(*luaTable)
+	if props.str("title") != "Nineteen Eighty-Four" || props.str("authors") != "George Orwell" {
+		t.Fatalf("unexpected props %+v", props)
+	}
+	flags := table.get("flags").(*luaTable)
+	if flags.get(float64(1)) != true || flags.get(float64(2)) != false || flags.get(float64(4)) != -150.0 {
+		t.Fatalf("unexpected positional values %+v", flags.fields)
+	}
+}
+
+func TestDumpLuaRoundTrips(t *testing.T) {
+	table, err := parseLua(koreaderSample)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	dumped := dumpLua(table)
+	again, err := parseLua(dumped)
+	if err != nil {
+		t.Fatalf("parse dump: %v\n%s", err, dumped)
+	}
+	if dumpLua(again) != dumped {
+		t.Fatalf("dump is not stable:\n%s\n%s", dumped, dumpLua(again))
+	}
+	chapter := again.get("annotations").(*luaTable).list()[0].(*luaTable).str("chapter")
+	if chapter != `Chapter "One"` {
+		t.Fatalf("unexpected chapter %q", chapter)
+	}
+}
+
+func TestParseLuaRejectsGarbage(t *testing.T) {
+	for _, src := range []string{"", "return", "return {", `return { ["a"] = }`, "return 1", "return {} trailing"} {
+		if _, err := parseLua(src); err == nil {
+			t.Fatalf("expected error for %q", src)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package sidecar
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Relite is the .relite.json sidecar format. Top-level keys it does not
+// know about are kept when a file is rewritten.
+type Relite struct{}
+
+type reliteFile struct {
+	Version     int               `json:"version"`
+	Progress    *reliteProgress   `json:"progress,omitempty"`
+	Annotations []json.RawMessage `json:"annotations"`
+	Bookmarks   []json.RawMessage `json:"bookmarks"`
+}
+
+type reliteProgress struct {
+	Location  float64   `json:"location"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type reliteAnnotation struct {
+	ID        string    `json:"id,omitempty"`
+	Location  float64   `json:"location"`
+	BlockID   string    `json:"block_id,omitempty"`
+	Quote     string    `json:"quote"`
+	Note      string    `json:"note,omitempty"`
+	Color     string    `json:"color,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type reliteBookmark struct {
+	ID        string    `json:"id,omitempty"`
+	Label     string    `json:"label"`
+	Location  float64   `json:"location"`
+	BlockID   string    `json:"block_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (Relite) Decode(data []byte, _ time.Time) (Document, error) {
+	var file reliteFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return Document{}, err
+	}
+	var doc Document
+	if file.Progress != nil {
+		doc.Progress = &Progress{Location: file.Progress.Location, UpdatedAt: file.Progress.UpdatedAt}
+	}
+	for _, raw := range file.Annotations {
+		var entry reliteAnnotation
+		if err := json.Unmarshal(raw, &entry); err != nil {
+			return Document{}, err
+		}
+		doc.Annotations = append(doc.Annotations, Annotation{
+			ID:        entry.ID,
+			Location:  entry.Location,
+			BlockID:   entry.BlockID,
+			Quote:     entry.Quote,
+			Note:      entry.Note,
+			Color:     entry.Color,
+			CreatedAt: entry.CreatedAt,
+			raw:       raw,
+		})
+	}
+	for _, raw := range file.Bookmarks {
+		var entry reliteBookmark
+		if err := json.Unmarshal(raw, &entry); err != nil {
+			return Document{}, err
+		}
+		doc.Bookmarks = append(doc.Bookmarks, Bookmark{
+			ID:        entry.ID,
+			Label:     entry.Label,
+			Location:  entry.Location,
+			BlockID:   entry.BlockID,
+			CreatedAt: entry.CreatedAt,
+			raw:       raw,
+		})
+	}
+	return doc, nil
+}
+
+func (Relite) Encode(doc Document, previous []byte) ([]byte, error) {
+	out := make(map[string]json.RawMessage)
+	if len(previous) > 0 {
+		_ = json.Unmarshal(previous, &out)
+	}
+	file := reliteFile{
+		Version:     1,
+		Annotations: []json.RawMessage{},
+		Bookmarks:   []json.RawMessage{},
+	}
+	if doc.Progress != nil {
+		file.Progress = &reliteProgress{Location: doc.Progress.Location, UpdatedAt: doc.Progress.UpdatedAt.UTC()}
+	}
+	for _, annotation := range doc.Annotations {
+		raw, ok := annotation.raw.(json.RawMessage)
+		if annotation.ID != "" || !ok {
+			var err error
+			raw, err = json.Marshal(reliteAnnotation{
+				ID:        annotation.ID,
+				Location:  annotation.Location,
+				BlockID:   annotation.BlockID,
+				Quote:     annotation.Quote,
+				Note:      annotation.Note,
+				Color:     annotation.Color,
+				CreatedAt: annotation.CreatedAt.UTC(),
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+		file.Annotations = append(file.Annotations, raw)
+	}
+	for _, bookmark := range doc.Bookmarks {
+		raw, ok := bookmark.raw.(json.RawMessage)
+		if bookmark.ID != "" || !ok {
+			var err error
+			raw, err = json.Marshal(reliteBookmark{
+				ID:        bookmark.ID,
+				Label:     bookmark.Label,
+				Location:  bookmark.Location,
+				BlockID:   bookmark.BlockID,
+				CreatedAt: bookmark.CreatedAt.UTC(),
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+		file.Bookmarks = append(file.Bookmarks, raw)
+	}
+	known, err := json.Marshal(file)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(known, &fields); err != nil {
+		return nil, err
+	}
+	delete(out, "progress")
+	for key, value := range fields {
+		out[key] = value
+	}
+	return json.MarshalIndent(out, "", "  ")
+}
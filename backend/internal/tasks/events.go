@@ -0,0 +1,84 @@
+package tasks
+
+import "sync"
+
+// Subscription receives the changes to a user's tasks. Until they are read
+// only the latest state of each task is kept, so a slow reader never holds
+// up the queue and never misses where a task ended up.
+type Subscription struct {
+	queue  *Queue
+	userID string
+	ready  chan struct{}
+
+	mu      sync.Mutex
+	order   []string
+	pending map[string]Task
+}
+
+// Subscribe starts receiving the changes to userID's tasks. Close the
+// subscription when done.
+func (q *Queue) Subscribe(userID string) *Subscription {
+	sub := &Subscription{
+		queue:   q,
+		userID:  userID,
+		ready:   make(chan struct{}, 1),
+		pending: make(map[string]Task),
+	}
+	q.subsMu.Lock()
+	defer q.subsMu.Unlock()
+	if q.subs[userID] == nil {
+		q.subs[userID] = make(map[*Subscription]struct{})
+	}
+	q.subs[userID][sub] = struct{}{}
+	return sub
+}
+
+// Ready is signaled when Next has changes to return.
+func (s *Subscription) Ready() <-chan struct{} {
+	return s.ready
+}
+
+// Next returns the tasks that changed since the last call, in the order
+// they first changed.
+func (s *Subscription) Next() []Task {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]Task, 0, len(s.order))
+	for _, id := range s.order {
+		out = append(out, s.pending[id])
+	}
+	s.order = s.order[:0]
+	clear(s.pending)
+	return out
+}
+
+func (s *Subscription) Close() {
+	s.queue.subsMu.Lock()
+	defer s.queue.subsMu.Unlock()
+	delete(s.queue.subs[s.userID], s)
+	if len(s.queue.subs[s.userID]) == 0 {
+		delete(s.queue.subs, s.userID)
+	}
+}
+
+func (s *Subscription) push(task Task) {
+	s.mu.Lock()
+	if _, ok := s.pending[task.ID]; !ok {
+		s.order = append(s.order, task.ID)
+	}
+	s.pending[task.ID] = task
+	s.mu.Unlock()
+	select {
+	case s.ready <- struct{}{}:
+	default:
+	}
+}
+
+// publish sends a task's new state to its user's subscriptions.
+func (q *Queue) publish(task Task) {
+	q.subsMu.Lock()
+	defer q.subsMu.Unlock()
+	for sub := range q.subs[task.UserID] {
+		sub.push(task)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"os"
 	"path/filepath"
+	"slices"
 	"sync"
 	"time"
 )
@@ -47,9 +48,12 @@ func (s *FileStore) Update(task Task) error {
 	if s.items[task.UserID] == nil {
 		return ErrNotFound
 	}
-	if _, ok := s.items[task.UserID][task.ID]; !ok {
+	existing, ok := s.items[task.UserID][task.ID]
+	if !ok {
 		return ErrNotFound
 	}
+	// Leases only change through Claim, Renew and ResetRunning.
+	task.Owner, task.LeaseUntil = existing.Owner, existing.LeaseUntil
 	task.UpdatedAt = time.Now().UTC()
 	s.items[task.UserID][task.ID] = task
 	return s.persistLocked()
@@ -75,6 +79,111 @@ func (s *FileStore) ListByUser(userID string) ([]Task, error) {
 	return out, nil
 }
 
+func (s *FileStore) Claim(types []string, owner string, now, leaseUntil time.Time) (Task, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var claimed Task
+	found := false
+	for _, userTasks := range s.items {
+		for _, task := range userTasks {
+			if task.Status != StatusQueued || task.NextRunAt.After(now) || !matchesType(task.Type, types) {
+				continue
+			}
+			if !found || olderTask(task, claimed) {
+				claimed = task
+				found = true
+			}
+		}
+	}
+	if !found {
+		return Task{}, ErrNotFound
+	}
+	claimed.Status = StatusRunning
+	claimed.Owner, claimed.LeaseUntil = owner, leaseUntil
+	claimed.Attempts++
+	claimed.UpdatedAt = time.Now().UTC()
+	s.items[claimed.UserID][claimed.ID] = claimed
+	if err := s.persistLocked(); err != nil {
+		return Task{}, err
+	}
+	return claimed, nil
+}
+
+func (s *FileStore) Renew(owner string, ids []string, leaseUntil time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, userTasks := range s.items {
+		for id, task := range userTasks {
+			if task.Status != StatusRunning || task.Owner != owner || !slices.Contains(ids, id) {
+				continue
+			}
+			task.LeaseUntil = leaseUntil
+			userTasks[id] = task
+		}
+	}
+	return s.persistLocked()
+}
+
+func (s *FileStore) ResetRunning(types []string, owner string, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, userTasks := range s.items {
+		for id, task := range userTasks {
+			if task.Status != StatusRunning || !matchesType(task.Type, types) || !releasable(task, owner, now) {
+				continue
+			}
+			task.Status = StatusQueued
+			task.Owner, task.LeaseUntil = "", time.Time{}
+			task.UpdatedAt = now
+			userTasks[id] = task
+		}
+	}
+	return s.persistLocked()
+}
+
+func (s *FileStore) CancelQueued(userID, id string) (Task, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	task, ok := s.items[userID][id]
+	if !ok {
+		return Task{}, false, ErrNotFound
+	}
+	if task.Status != StatusQueued {
+		return task, false, nil
+	}
+	task.Status = StatusCanceled
+	task.NextRunAt = time.Time{}
+	task.UpdatedAt = time.Now().UTC()
+	s.items[userID][id] = task
+	if err := s.persistLocked(); err != nil {
+		return Task{}, false, err
+	}
+	return task, true, nil
+}
+
+func (s *FileStore) FailUnknown(types []string, now time.Time) ([]Task, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []Task
+	for _, userTasks := range s.items {
+		for id, task := range userTasks {
+			if task.Status != StatusQueued || slices.Contains(types, task.Type) {
+				continue
+			}
+			failUnknown(&task, now)
+			userTasks[id] = task
+			out = append(out, task)
+		}
+	}
+	if len(out) == 0 {
+		return nil, nil
+	}
+	if err := s.persistLocked(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (s *FileStore) load() error {
 	payload, err := os.ReadFile(s.path)
 	if err != nil {
@@ -2,6 +2,7 @@ package tasks
 
 import (
 	"errors"
+	"slices"
 	"sync"
 	"time"
 )
@@ -37,9 +38,12 @@ func (s *MemoryStore) Update(task Task) error {
 	if s.items[task.UserID] == nil {
 		return ErrNotFound
 	}
-	if _, ok := s.items[task.UserID][task.ID]; !ok {
+	existing, ok := s.items[task.UserID][task.ID]
+	if !ok {
 		return ErrNotFound
 	}
+	// Leases only change through Claim, Renew and ResetRunning.
+	task.Owner, task.LeaseUntil = existing.Owner, existing.LeaseUntil
 	task.UpdatedAt = time.Now().UTC()
 	s.items[task.UserID][task.ID] = task
 	return nil
@@ -64,3 +68,122 @@ func (s *MemoryStore) ListByUser(userID string) ([]Task, error) {
 	}
 	return out, nil
 }
+
+// Claim takes the oldest queued task across users.
+func (s *MemoryStore) Claim(types []string, owner string, now, leaseUntil time.Time) (Task, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var claimed Task
+	found := false
+	for _, userTasks := range s.items {
+		for _, task := range userTasks {
+			if task.Status != StatusQueued || task.NextRunAt.After(now) || !matchesType(task.Type, types) {
+				continue
+			}
+			if !found || olderTask(task, claimed) {
+				claimed = task
+				found = true
+			}
+		}
+	}
+	if !found {
+		return Task{}, ErrNotFound
+	}
+	claimed.Status = StatusRunning
+	claimed.Owner, claimed.LeaseUntil = owner, leaseUntil
+	claimed.Attempts++
+	claimed.UpdatedAt = time.Now().UTC()
+	s.items[claimed.UserID][claimed.ID] = claimed
+	return claimed, nil
+}
+
+func (s *MemoryStore) Renew(owner string, ids []string, leaseUntil time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, userTasks := range s.items {
+		for id, task := range userTasks {
+			if task.Status != StatusRunning || task.Owner != owner || !slices.Contains(ids, id) {
+				continue
+			}
+			task.LeaseUntil = leaseUntil
+			userTasks[id] = task
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) ResetRunning(types []string, owner string, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, userTasks := range s.items {
+		for id, task := range userTasks {
+			if task.Status != StatusRunning || !matchesType(task.Type, types) || !releasable(task, owner, now) {
+				continue
+			}
+			task.Status = StatusQueued
+			task.Owner, task.LeaseUntil = "", time.Time{}
+			task.UpdatedAt = now
+			userTasks[id] = task
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) CancelQueued(userID, id string) (Task, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	task, ok := s.items[userID][id]
+	if !ok {
+		return Task{}, false, ErrNotFound
+	}
+	if task.Status != StatusQueued {
+		return task, false, nil
+	}
+	task.Status = StatusCanceled
+	task.NextRunAt = time.Time{}
+	task.UpdatedAt = time.Now().UTC()
+	s.items[userID][id] = task
+	return task, true, nil
+}
+
+func (s *MemoryStore) FailUnknown(types []string, now time.Time) ([]Task, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []Task
+	for _, userTasks := range s.items {
+		for id, task := range userTasks {
+			if task.Status != StatusQueued || slices.Contains(types, task.Type) {
+				continue
+			}
+			failUnknown(&task, now)
+			userTasks[id] = task
+			out = append(out, task)
+		}
+	}
+	return out, nil
+}
+
+// failUnknown fails a queued task whose type nothing handles.
+func failUnknown(task *Task, now time.Time) {
+	task.Status = StatusError
+	task.Error = UnknownType(task.Type).Error()
+	task.NextRunAt = time.Time{}
+	task.UpdatedAt = now
+}
+
+// releasable reports whether a running task may be queued again: owner
+// held it, or its lease ran out.
+func releasable(task Task, owner string, now time.Time) bool {
+	return (owner != "" && task.Owner == owner) || task.LeaseUntil.Before(now)
+}
+
+func matchesType(taskType string, types []string) bool {
+	return len(types) == 0 || slices.Contains(types, taskType)
+}
+
+func olderTask(a, b Task) bool {
+	if !a.CreatedAt.Equal(b.CreatedAt) {
+		return a.CreatedAt.Before(b.CreatedAt)
+	}
+	return a.ID < b.ID
+}
@@ -1,6 +1,9 @@
 package tasks
 
-import "testing"
+import (
+	"testing"
+	"time"
+)
 
 func TestMemoryStoreCreateAndList(t *testing.T) {
 	store := NewMemoryStore()
@@ -16,3 +19,78 @@ func TestMemoryStoreCreateAndList(t *testing.T) {
 		t.Fatalf("expected 1 task, got %d", len(list))
 	}
 }
+
+func TestMemoryStoreClaimsOldestQueuedTask(t *testing.T) {
+	store := NewMemoryStore()
+	first, _ := store.Create(Task{UserID: "user-2", Type: "format", Status: StatusQueued})
+	time.Sleep(time.Millisecond)
+	_, _ = store.Create(Task{UserID: "user-1", Type: "format", Status: StatusQueued})
+	_, _ = store.Create(Task{UserID: "user-1", Type: "sync", Status: StatusQueued})
+
+	claimed, err := store.Claim([]string{"format"}, "server-1", time.Now(), time.Now().Add(time.Minute))
+	if err != nil || claimed.ID != first.ID || claimed.Status != StatusRunning {
+		t.Fatalf("expected oldest task claimed, got %+v (%v)", claimed, err)
+	}
+	if _, err := store.Claim([]string{"format"}, "server-1", time.Now(), time.Now().Add(time.Minute)); err != nil {
+		t.Fatalf("expected second task claimed, got %v", err)
+	}
+	_, _ = store.Create(Task{UserID: "user-1", Type: "format", Status: StatusQueued, NextRunAt: time.Now().Add(time.Minute)})
+	if _, err := store.Claim([]string{"format"}, "server-1", time.Now(), time.Now().Add(time.Minute)); err != ErrNotFound {
+		t.Fatalf("expected no queued task, got %v", err)
+	}
+	if err := store.ResetRunning([]string{"format"}, "server-1", time.Now()); err != nil {
+		t.Fatalf("reset: %v", err)
+	}
+	reset, _ := store.Get("user-2", first.ID)
+	if reset.Status != StatusQueued || reset.Owner != "" {
+		t.Fatalf("expected running task queued again, got %+v", reset)
+	}
+}
+
+func TestMemoryStoreCancelsOnlyQueuedTasks(t *testing.T) {
+	store := NewMemoryStore()
+	queued, _ := store.Create(Task{UserID: "user-1", Type: "format", Status: StatusQueued})
+	canceled, ok, err := store.CancelQueued("user-1", queued.ID)
+	if err != nil || !ok || canceled.Status != StatusCanceled {
+		t.Fatalf("expected queued task canceled, got %+v %v (%v)", canceled, ok, err)
+	}
+	running, _ := store.Create(Task{UserID: "user-1", Type: "format", Status: StatusRunning})
+	if got, ok, err := store.CancelQueued("user-1", running.ID); err != nil || ok || got.Status != StatusRunning {
+		t.Fatalf("expected running task left alone, got %+v %v (%v)", got, ok, err)
+	}
+	if _, _, err := store.CancelQueued("user-1", "missing"); err != ErrNotFound {
+		t.Fatalf("expected not found, got %v", err)
+	}
+}
+
+func TestMemoryStoreResetsOnlyExpiredLeasesOfOtherServers(t *testing.T) {
+	store := NewMemoryStore()
+	now := time.Now()
+	ours, _ := store.Create(Task{UserID: "user-1", Type: "format", Status: StatusQueued})
+	_, _ = store.Claim(nil, "server-1", now, now.Add(time.Minute))
+	theirs, _ := store.Create(Task{UserID: "user-1", Type: "format", Status: StatusQueued})
+	_, _ = store.Claim(nil, "server-2", now, now.Add(time.Minute))
+
+	if err := store.ResetRunning(nil, "server-1", now); err != nil {
+		t.Fatalf("reset: %v", err)
+	}
+	if task, _ := store.Get("user-1", ours.ID); task.Status != StatusQueued {
+		t.Fatalf("expected own task queued again, got %q", task.Status)
+	}
+	if task, _ := store.Get("user-1", theirs.ID); task.Status != StatusRunning || task.Owner != "server-2" {
+		t.Fatalf("expected leased task left alone, got %+v", task)
+	}
+
+	// A heartbeat keeps the lease; without one it runs out.
+	if err := store.Renew("server-2", []string{theirs.ID}, now.Add(2*time.Minute)); err != nil {
+		t.Fatalf("renew: %v", err)
+	}
+	_ = store.ResetRunning(nil, "", now.Add(90*time.Second))
+	if task, _ := store.Get("user-1", theirs.ID); task.Status != StatusRunning {
+		t.Fatalf("expected renewed lease kept, got %q", task.Status)
+	}
+	_ = store.ResetRunning(nil, "", now.Add(3*time.Minute))
+	if task, _ := store.Get("user-1", theirs.ID); task.Status != StatusQueued {
+		t.Fatalf("expected expired lease reset, got %q", task.Status)
+	}
+}
@@ -4,7 +4,9 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"time"
 
+	"github.com/EROQIN/relite-reader/backend/internal/pgutil"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
@@ -31,6 +33,13 @@ CREATE TABLE IF NOT EXISTS tasks (
 );
 CREATE INDEX IF NOT EXISTS idx_tasks_user_id ON tasks (user_id);
 CREATE INDEX IF NOT EXISTS idx_tasks_status ON tasks (status);
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS attempts INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS max_attempts INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS next_run_at TIMESTAMPTZ;
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS error_log JSONB NOT NULL DEFAULT '[]'::jsonb;
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS progress JSONB NOT NULL DEFAULT '{}'::jsonb;
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS owner TEXT NOT NULL DEFAULT '';
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS lease_until TIMESTAMPTZ;
 `)
 	return err
 }
@@ -44,22 +53,21 @@ func (s *PostgresStore) Create(task Task) (Task, error) {
 	if err != nil {
 		return Task{}, err
 	}
-	var payloadRaw []byte
-	err = s.pool.QueryRow(ctx, `
-INSERT INTO tasks (id, user_id, type, status, error, payload)
-VALUES ($1, $2, $3, $4, $5, $6)
-RETURNING id, user_id, type, status, error, payload, created_at, updated_at;`,
-		task.ID, task.UserID, task.Type, task.Status, task.Error, payload,
-	).Scan(&task.ID, &task.UserID, &task.Type, &task.Status, &task.Error, &payloadRaw, &task.CreatedAt, &task.UpdatedAt)
+	errorLog, err := encodeErrorLog(task.ErrorLog)
 	if err != nil {
 		return Task{}, err
 	}
-	decoded, err := decodePayload(payloadRaw)
+	progress, err := json.Marshal(task.Progress)
 	if err != nil {
 		return Task{}, err
 	}
-	task.Payload = decoded
-	return task, nil
+	return scanTask(s.pool.QueryRow(ctx, `
+INSERT INTO tasks (id, user_id, type, status, error, payload, attempts, max_attempts, next_run_at, error_log, progress)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+RETURNING `+taskColumns+`;`,
+		task.ID, task.UserID, task.Type, task.Status, task.Error, payload,
+		task.Attempts, task.MaxAttempts, pgutil.NullTime(task.NextRunAt), errorLog, progress,
+	))
 }
 
 func (s *PostgresStore) Update(task Task) error {
@@ -68,14 +76,27 @@ func (s *PostgresStore) Update(task Task) error {
 	if err != nil {
 		return err
 	}
+	errorLog, err := encodeErrorLog(task.ErrorLog)
+	if err != nil {
+		return err
+	}
+	progress, err := json.Marshal(task.Progress)
+	if err != nil {
+		return err
+	}
 	ct, err := s.pool.Exec(ctx, `
 UPDATE tasks
 SET status = $1,
     error = $2,
     payload = $3,
+    attempts = $4,
+    max_attempts = $5,
+    next_run_at = $6,
+    error_log = $7,
+    progress = $8,
     updated_at = NOW()
-WHERE id = $4 AND user_id = $5;`,
-		task.Status, task.Error, payload, task.ID, task.UserID,
+WHERE id = $9 AND user_id = $10;`,
+		task.Status, task.Error, payload, task.Attempts, task.MaxAttempts, pgutil.NullTime(task.NextRunAt), errorLog, progress, task.ID, task.UserID,
 	)
 	if err != nil {
 		return err
@@ -88,32 +109,22 @@ WHERE id = $4 AND user_id = $5;`,
 
 func (s *PostgresStore) Get(userID, id string) (Task, error) {
 	ctx := context.Background()
-	var payloadRaw []byte
-	var task Task
-	err := s.pool.QueryRow(ctx, `
-SELECT id, user_id, type, status, error, payload, created_at, updated_at
+	task, err := scanTask(s.pool.QueryRow(ctx, `
+SELECT `+taskColumns+`
 FROM tasks
 WHERE user_id = $1 AND id = $2;`,
 		userID, id,
-	).Scan(&task.ID, &task.UserID, &task.Type, &task.Status, &task.Error, &payloadRaw, &task.CreatedAt, &task.UpdatedAt)
-	if err != nil {
-		if errors.Is(err, pgx.ErrNoRows) {
-			return Task{}, ErrNotFound
-		}
-		return Task{}, err
-	}
-	decoded, err := decodePayload(payloadRaw)
-	if err != nil {
-		return Task{}, err
+	))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Task{}, ErrNotFound
 	}
-	task.Payload = decoded
-	return task, nil
+	return task, err
 }
 
 func (s *PostgresStore) ListByUser(userID string) ([]Task, error) {
 	ctx := context.Background()
 	rows, err := s.pool.Query(ctx, `
-SELECT id, user_id, type, status, error, payload, created_at, updated_at
+SELECT `+taskColumns+`
 FROM tasks
 WHERE user_id = $1
 ORDER BY created_at DESC;`,
@@ -125,16 +136,10 @@ ORDER BY created_at DESC;`,
 	defer rows.Close()
 	var out []Task
 	for rows.Next() {
-		var payloadRaw []byte
-		var task Task
-		if err := rows.Scan(&task.ID, &task.UserID, &task.Type, &task.Status, &task.Error, &payloadRaw, &task.CreatedAt, &task.UpdatedAt); err != nil {
-			return nil, err
-		}
-		decoded, err := decodePayload(payloadRaw)
+		task, err := scanTask(rows)
 		if err != nil {
 			return nil, err
 		}
-		task.Payload = decoded
 		out = append(out, task)
 	}
 	if err := rows.Err(); err != nil {
@@ -143,6 +148,175 @@ ORDER BY created_at DESC;`,
 	return out, nil
 }
 
+func (s *PostgresStore) Claim(types []string, owner string, now, leaseUntil time.Time) (Task, error) {
+	ctx := context.Background()
+	// SKIP LOCKED lets workers of several servers claim side by side.
+	task, err := scanTask(s.pool.QueryRow(ctx, `
+UPDATE tasks
+SET status = $1,
+    attempts = attempts + 1,
+    owner = $5,
+    lease_until = $6,
+    updated_at = NOW()
+WHERE id = (
+  SELECT id FROM tasks
+  WHERE status = $2
+    AND (next_run_at IS NULL OR next_run_at <= $3)
+    AND (cardinality($4::text[]) = 0 OR type = ANY($4))
+  ORDER BY created_at, id
+  LIMIT 1
+  FOR UPDATE SKIP LOCKED
+)
+RETURNING `+taskColumns+`;`,
+		StatusRunning, StatusQueued, now, typeList(types), owner, leaseUntil,
+	))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Task{}, ErrNotFound
+	}
+	return task, err
+}
+
+func (s *PostgresStore) Renew(owner string, ids []string, leaseUntil time.Time) error {
+	ctx := context.Background()
+	_, err := s.pool.Exec(ctx, `
+UPDATE tasks
+SET lease_until = $1
+WHERE status = $2 AND owner = $3 AND id = ANY($4::text[]);`,
+		leaseUntil, StatusRunning, owner, ids,
+	)
+	return err
+}
+
+func (s *PostgresStore) ResetRunning(types []string, owner string, now time.Time) error {
+	ctx := context.Background()
+	_, err := s.pool.Exec(ctx, `
+UPDATE tasks
+SET status = $1,
+    owner = '',
+    lease_until = NULL,
+    updated_at = NOW()
+WHERE status = $2
+  AND (cardinality($3::text[]) = 0 OR type = ANY($3))
+  AND (($4 <> '' AND owner = $4) OR lease_until IS NULL OR lease_until < $5);`,
+		StatusQueued, StatusRunning, typeList(types), owner, now,
+	)
+	return err
+}
+
+func (s *PostgresStore) CancelQueued(userID, id string) (Task, bool, error) {
+	ctx := context.Background()
+	task, err := scanTask(s.pool.QueryRow(ctx, `
+UPDATE tasks
+SET status = $1, next_run_at = NULL, updated_at = NOW()
+WHERE user_id = $2 AND id = $3 AND status = $4
+RETURNING `+taskColumns+`;`,
+		StatusCanceled, userID, id, StatusQueued,
+	))
+	if errors.Is(err, pgx.ErrNoRows) {
+		task, err := s.Get(userID, id)
+		return task, false, err
+	}
+	if err != nil {
+		return Task{}, false, err
+	}
+	return task, true, nil
+}
+
+func (s *PostgresStore) FailUnknown(types []string, now time.Time) ([]Task, error) {
+	ctx := context.Background()
+	rows, err := s.pool.Query(ctx, `
+SELECT id, type FROM tasks
+WHERE status = $1 AND NOT (type = ANY($2::text[]));`,
+		StatusQueued, typeList(types),
+	)
+	if err != nil {
+		return nil, err
+	}
+	found := make(map[string]string)
+	for rows.Next() {
+		var id, taskType string
+		if err := rows.Scan(&id, &taskType); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		found[id] = taskType
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	var out []Task
+	for id, taskType := range found {
+		// The status check leaves tasks claimed in the meantime alone.
+		task, err := scanTask(s.pool.QueryRow(ctx, `
+UPDATE tasks
+SET status = $1, error = $2, next_run_at = NULL, updated_at = $3
+WHERE id = $4 AND status = $5
+RETURNING `+taskColumns+`;`,
+			StatusError, UnknownType(taskType).Error(), now, id, StatusQueued,
+		))
+		if errors.Is(err, pgx.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, task)
+	}
+	return out, nil
+}
+
+const taskColumns = `id, user_id, type, status, error, payload, attempts, max_attempts, next_run_at, error_log, progress, owner, lease_until, created_at, updated_at`
+
+func scanTask(row pgx.Row) (Task, error) {
+	var task Task
+	var payloadRaw, errorLogRaw, progressRaw []byte
+	var nextRun, leaseUntil *time.Time
+	err := row.Scan(
+		&task.ID, &task.UserID, &task.Type, &task.Status, &task.Error, &payloadRaw,
+		&task.Attempts, &task.MaxAttempts, &nextRun, &errorLogRaw, &progressRaw,
+		&task.Owner, &leaseUntil, &task.CreatedAt, &task.UpdatedAt,
+	)
+	if err != nil {
+		return Task{}, err
+	}
+	if nextRun != nil {
+		task.NextRunAt = *nextRun
+	}
+	if leaseUntil != nil {
+		task.LeaseUntil = *leaseUntil
+	}
+	if task.Payload, err = decodePayload(payloadRaw); err != nil {
+		return Task{}, err
+	}
+	if len(errorLogRaw) > 0 {
+		if err := json.Unmarshal(errorLogRaw, &task.ErrorLog); err != nil {
+			return Task{}, err
+		}
+	}
+	if len(progressRaw) > 0 {
+		if err := json.Unmarshal(progressRaw, &task.Progress); err != nil {
+			return Task{}, err
+		}
+	}
+	return task, nil
+}
+
+// typeList keeps an empty filter from being sent as NULL.
+func typeList(types []string) []string {
+	if types == nil {
+		return []string{}
+	}
+	return types
+}
+
+func encodeErrorLog(log []AttemptError) ([]byte, error) {
+	if log == nil {
+		log = []AttemptError{}
+	}
+	return json.Marshal(log)
+}
+
 func encodePayload(payload map[string]string) ([]byte, error) {
 	if payload == nil {
 		payload = map[string]string{}
@@ -23,6 +23,16 @@ func TestPostgresStoreCRUD(t *testing.T) {
 	if err != nil {
 		t.Fatalf("create: %v", err)
 	}
+	canceled, err := store.Create(Task{UserID: userID, Type: "format", Status: StatusQueued})
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if got, ok, err := store.CancelQueued(userID, canceled.ID); err != nil || !ok || got.Status != StatusCanceled {
+		t.Fatalf("expected queued task canceled, got %+v %v (%v)", got, ok, err)
+	}
+	if got, ok, err := store.CancelQueued(userID, canceled.ID); err != nil || ok || got.Status != StatusCanceled {
+		t.Fatalf("expected canceled task left alone, got %+v %v (%v)", got, ok, err)
+	}
 	created.Status = StatusRunning
 	if err := store.Update(created); err != nil {
 		t.Fatalf("update: %v", err)
@@ -34,4 +44,35 @@ func TestPostgresStoreCRUD(t *testing.T) {
 	if len(list) == 0 {
 		t.Fatalf("expected tasks")
 	}
+	if err := store.ResetRunning([]string{"format"}, "", time.Now()); err != nil {
+		t.Fatalf("reset: %v", err)
+	}
+	// Other users' tasks may be queued too; claim until ours comes up.
+	for {
+		claimed, err := store.Claim([]string{"format"}, "server-1", time.Now(), time.Now().Add(time.Minute))
+		if err != nil {
+			t.Fatalf("claim: %v", err)
+		}
+		if claimed.ID == created.ID {
+			if claimed.Status != StatusRunning || claimed.Attempts != 1 || claimed.Owner != "server-1" {
+				t.Fatalf("expected claimed task running its first attempt, got %+v", claimed)
+			}
+			break
+		}
+	}
+	if err := store.ResetRunning([]string{"format"}, "server-2", time.Now()); err != nil {
+		t.Fatalf("reset: %v", err)
+	}
+	if task, _ := store.Get(userID, created.ID); task.Status != StatusRunning {
+		t.Fatalf("expected leased task left alone, got %q", task.Status)
+	}
+	if err := store.Renew("server-1", []string{created.ID}, time.Now().Add(-time.Second)); err != nil {
+		t.Fatalf("renew: %v", err)
+	}
+	if err := store.ResetRunning([]string{"format"}, "server-2", time.Now()); err != nil {
+		t.Fatalf("reset: %v", err)
+	}
+	if task, _ := store.Get(userID, created.ID); task.Status != StatusQueued || task.Owner != "" {
+		t.Fatalf("expected expired lease reset, got %+v", task)
+	}
 }
@@ -0,0 +1,61 @@
+package tasks
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// progressInterval spaces out the progress saved to the store; every
+// report still reaches subscribers.
+const progressInterval = 500 * time.Millisecond
+
+// Progress is how far a running task has come.
+type Progress struct {
+	Current int    `json:"current"`
+	Total   int    `json:"total"`
+	Message string `json:"message,omitempty"`
+}
+
+type progressKey struct{}
+
+// reporter saves the progress of the task running in a handler's context.
+type reporter struct {
+	queue *Queue
+
+	mu    sync.Mutex
+	task  Task
+	saved time.Time
+	done  bool
+}
+
+// ReportProgress records how far the task running in ctx has come, such as
+// current of total files with a short message. Outside a task it does
+// nothing.
+func ReportProgress(ctx context.Context, current, total int, message string) {
+	r, ok := ctx.Value(progressKey{}).(*reporter)
+	if !ok {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.done {
+		return
+	}
+	r.task.Progress = Progress{Current: current, Total: total, Message: message}
+	now := time.Now().UTC()
+	r.task.UpdatedAt = now
+	if now.Sub(r.saved) >= progressInterval || current >= total {
+		r.saved = now
+		_ = r.queue.store.Update(r.task)
+	}
+	r.queue.publish(r.task)
+}
+
+// finish stops further reports and returns the last progress.
+func (r *reporter) finish() Progress {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.done = true
+	return r.task.Progress
+}
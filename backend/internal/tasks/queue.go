@@ -2,66 +2,405 @@ package tasks
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"sync"
+	"time"
+)
+
+// pollInterval is how often idle workers look for tasks queued without
+// waking them, such as by another server on the same store.
+const pollInterval = 5 * time.Second
+
+const (
+	defaultWorkers = 4
+	// defaultLease is how long a claimed task stays with its server
+	// without a heartbeat; the lease is renewed four times as often.
+	defaultLease = 2 * time.Minute
+)
+
+var (
+	ErrActive   = errors.New("task is queued or running")
+	ErrFinished = errors.New("task has finished")
+	// ErrRunningElsewhere is returned when canceling a task that another
+	// server is running.
+	ErrRunningElsewhere = errors.New("task is running on another server")
 )
 
 type HandlerFunc func(context.Context, Task) error
 
+// QueueOptions configures a Queue. Zero values mean the default.
+type QueueOptions struct {
+	// Workers is the size of the worker pool.
+	Workers int
+	// Owner names this server in the tasks it claims. It must stay the
+	// same across restarts and differ between servers sharing a store;
+	// it defaults to the host name.
+	Owner string
+	// Lease is how long a claimed task is kept from other servers
+	// without a heartbeat.
+	Lease time.Duration
+}
+
+// Queue runs the tasks of a Store with the handlers of a Registry. The store
+// is the source of truth: workers claim queued tasks from it, so tasks left
+// behind by a restart are picked up again and Enqueue never waits for a free
+// worker. Claimed tasks are leased to this server, so several servers can
+// share a store; a server that stops renewing its leases has its tasks
+// queued again by the others.
 type Queue struct {
-	store   Store
-	handler HandlerFunc
-	ch      chan Task
+	store    Store
+	registry *Registry
+	workers  int
+	owner    string
+	lease    time.Duration
+	wake     chan struct{}
+	reset    sync.Once
+
+	mu      sync.Mutex
+	running map[string]int
+	active  map[string]*activeTask
+
+	subsMu sync.Mutex
+	subs   map[string]map[*Subscription]struct{}
+}
+
+// activeTask is a task a worker of this queue is running.
+type activeTask struct {
+	cancel   context.CancelFunc
+	canceled bool
+	// finished is set once the worker has decided the task's final
+	// status; a later Cancel is too late.
+	finished bool
 }
 
-func NewQueue(store Store, handler HandlerFunc, buffer int) *Queue {
-	if handler == nil {
-		handler = DefaultHandler
+func NewQueue(store Store, registry *Registry, options QueueOptions) *Queue {
+	if registry == nil {
+		registry = NewRegistry()
 	}
-	if buffer <= 0 {
-		buffer = 100
+	if options.Workers <= 0 {
+		options.Workers = defaultWorkers
+	}
+	if options.Owner == "" {
+		options.Owner, _ = os.Hostname()
+		if options.Owner == "" {
+			options.Owner = newTaskID()
+		}
+	}
+	if options.Lease <= 0 {
+		options.Lease = defaultLease
+	}
+	return &Queue{
+		store:    store,
+		registry: registry,
+		workers:  options.Workers,
+		owner:    options.Owner,
+		lease:    options.Lease,
+		wake:     make(chan struct{}, 1),
+		running:  make(map[string]int),
+		active:   make(map[string]*activeTask),
+		subs:     make(map[string]map[*Subscription]struct{}),
 	}
-	return &Queue{store: store, handler: handler, ch: make(chan Task, buffer)}
 }
 
+// Enqueue stores a queued task. Types without a handler are rejected with
+// ErrUnknownType.
 func (q *Queue) Enqueue(userID, taskType string, payload map[string]string) (Task, error) {
+	entry, err := q.registry.lookup(taskType)
+	if err != nil {
+		return Task{}, err
+	}
 	created, err := q.store.Create(Task{
-		UserID:  userID,
-		Type:    taskType,
-		Status:  StatusQueued,
-		Payload: payload,
+		UserID:      userID,
+		Type:        taskType,
+		Status:      StatusQueued,
+		Payload:     payload,
+		MaxAttempts: entry.options.maxAttempts(),
 	})
 	if err != nil {
 		return Task{}, err
 	}
-	q.ch <- created
+	q.publish(created)
+	q.notify()
 	return created, nil
 }
 
-func (q *Queue) Start(ctx context.Context) {
-	go func() {
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			case task := <-q.ch:
-				task.Status = StatusRunning
-				_ = q.store.Update(task)
-				if err := q.handler(ctx, task); err != nil {
-					task.Status = StatusError
-					task.Error = err.Error()
-					_ = q.store.Update(task)
-					continue
-				}
-				task.Status = StatusSuccess
-				task.Error = ""
-				_ = q.store.Update(task)
+// Pending returns a queued or running task of userID with the given type
+// and payload, so callers can avoid queueing the same work twice. The
+// store is asked, so tasks queued by another server or before a restart
+// count too. A queued task is returned ahead of a running one.
+func (q *Queue) Pending(userID, taskType string, payload map[string]string) (Task, bool, error) {
+	list, err := q.store.ListByUser(userID)
+	if err != nil {
+		return Task{}, false, err
+	}
+	var found Task
+	ok := false
+	for _, task := range list {
+		if task.Type != taskType || (task.Status != StatusQueued && task.Status != StatusRunning) {
+			continue
+		}
+		if !maps.Equal(task.Payload, payload) {
+			continue
+		}
+		if task.Status == StatusQueued {
+			return task, true, nil
+		}
+		found, ok = task, true
+	}
+	return found, ok, nil
+}
+
+// Requeue runs a finished task again in place, with its attempts reset and
+// its error log kept. Tasks still queued or running return ErrActive.
+func (q *Queue) Requeue(userID, id string) (Task, error) {
+	task, err := q.store.Get(userID, id)
+	if err != nil {
+		return Task{}, err
+	}
+	if task.Status == StatusQueued || task.Status == StatusRunning {
+		return Task{}, ErrActive
+	}
+	entry, err := q.registry.lookup(task.Type)
+	if err != nil {
+		return Task{}, err
+	}
+	task.Status = StatusQueued
+	task.Error = ""
+	task.Attempts = 0
+	task.MaxAttempts = entry.options.maxAttempts()
+	task.NextRunAt = time.Time{}
+	task.Progress = Progress{}
+	return q.save(task)
+}
+
+// Cancel stops a task. A queued task is canceled right away; a running one
+// has its context canceled and is marked canceled once its handler returns.
+// Finished tasks return ErrFinished, and tasks another server holds a live
+// lease on return ErrRunningElsewhere.
+func (q *Queue) Cancel(userID, id string) (Task, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	// The store cancels the task only while it is still queued, so a claim
+	// by another server in the meantime wins.
+	task, canceled, err := q.store.CancelQueued(userID, id)
+	for err == nil && !canceled && task.Status == StatusQueued {
+		// Queued again in the meantime, such as for a retry.
+		task, canceled, err = q.store.CancelQueued(userID, id)
+	}
+	if err != nil {
+		return Task{}, err
+	}
+	if canceled {
+		q.publish(task)
+		return task, nil
+	}
+	switch task.Status {
+	case StatusRunning:
+		if active, ok := q.active[id]; ok {
+			if active.finished {
+				return Task{}, ErrFinished
 			}
+			active.canceled = true
+			active.cancel()
+			return task, nil
+		}
+		if !releasable(task, q.owner, time.Now().UTC()) {
+			return Task{}, ErrRunningElsewhere
+		}
+		// Its server stopped before finishing it.
+	default:
+		return Task{}, ErrFinished
+	}
+	task.Status = StatusCanceled
+	task.NextRunAt = time.Time{}
+	return q.save(task)
+}
+
+// Start runs the worker pool until ctx is done. Tasks this server still
+// holds from before a restart, and tasks whose lease ran out, are put back
+// in the queue first. Queued tasks of types without a handler, such as
+// ones left from before an upgrade, fail with ErrUnknownType instead of
+// waiting forever.
+func (q *Queue) Start(ctx context.Context) {
+	q.reset.Do(func() {
+		now := time.Now().UTC()
+		types := q.registry.Types()
+		_ = q.store.ResetRunning(types, q.owner, now)
+		failed, _ := q.store.FailUnknown(types, now)
+		for _, task := range failed {
+			q.publish(task)
+		}
+	})
+	for range q.workers {
+		go q.work(ctx)
+	}
+	go q.heartbeat(ctx)
+}
+
+// heartbeat renews the leases of the tasks this server runs and queues
+// again the tasks of servers that stopped renewing theirs.
+func (q *Queue) heartbeat(ctx context.Context) {
+	ticker := time.NewTicker(q.lease / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		now := time.Now().UTC()
+		if ids := q.activeIDs(); len(ids) > 0 {
+			_ = q.store.Renew(q.owner, ids, now.Add(q.lease))
+		}
+		_ = q.store.ResetRunning(q.registry.Types(), "", now)
+		q.notify()
+	}
+}
+
+func (q *Queue) activeIDs() []string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	ids := make([]string, 0, len(q.active))
+	for id := range q.active {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func (q *Queue) work(ctx context.Context) {
+	for ctx.Err() == nil {
+		if task, taskCtx, ok := q.claim(ctx); ok {
+			// Let another idle worker look for more.
+			q.notify()
+			q.run(ctx, taskCtx, task)
+			q.release(task)
+			continue
 		}
-	}()
+		select {
+		case <-ctx.Done():
+			return
+		case <-q.wake:
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// claim takes the oldest due task of a type below its concurrency limit and
+// returns it with the context its handler runs in.
+func (q *Queue) claim(ctx context.Context) (Task, context.Context, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	types := q.registry.available(q.running)
+	if len(types) == 0 {
+		return Task{}, nil, false
+	}
+	now := time.Now().UTC()
+	task, err := q.store.Claim(types, q.owner, now, now.Add(q.lease))
+	if err != nil {
+		return Task{}, nil, false
+	}
+	q.running[task.Type]++
+	q.publish(task)
+	taskCtx, cancel := context.WithCancel(ctx)
+	q.active[task.ID] = &activeTask{cancel: cancel}
+	return task, taskCtx, true
+}
+
+func (q *Queue) release(task Task) {
+	q.mu.Lock()
+	q.running[task.Type]--
+	q.active[task.ID].cancel()
+	delete(q.active, task.ID)
+	q.mu.Unlock()
+	// A worker may be waiting for this type's slot.
+	q.notify()
+}
+
+// finish reports whether the task was canceled and keeps Cancel from
+// signaling it from now on, so the status the worker saves stands.
+func (q *Queue) finish(id string) (canceled bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	active := q.active[id]
+	active.finished = true
+	return active.canceled
+}
+
+func (q *Queue) run(ctx, taskCtx context.Context, task Task) {
+	entry, err := q.registry.lookup(task.Type)
+	if err != nil {
+		err = Permanent(err)
+	} else {
+		timeout := entry.options.timeout()
+		progress := &reporter{queue: q, task: task}
+		handlerCtx, stop := context.WithTimeout(context.WithValue(taskCtx, progressKey{}, progress), timeout)
+		err = entry.handler(handlerCtx, task)
+		if err != nil && errors.Is(handlerCtx.Err(), context.DeadlineExceeded) {
+			err = fmt.Errorf("timed out after %s", timeout)
+		}
+		stop()
+		task.Progress = progress.finish()
+	}
+	if ctx.Err() != nil {
+		// The server is stopping; the task stays running and is queued
+		// again on the next start.
+		return
+	}
+	task.NextRunAt = time.Time{}
+	if q.finish(task.ID) {
+		task.Status = StatusCanceled
+		_, _ = q.save(task)
+		return
+	}
+	if err == nil {
+		task.Status = StatusSuccess
+		task.Error = ""
+		_, _ = q.save(task)
+		return
+	}
+	now := time.Now().UTC()
+	task.Error = err.Error()
+	task.ErrorLog = append(task.ErrorLog, AttemptError{Attempt: task.Attempts, Error: task.Error, At: now})
+	if len(task.ErrorLog) > maxErrorLog {
+		task.ErrorLog = task.ErrorLog[len(task.ErrorLog)-maxErrorLog:]
+	}
+	maxAttempts := task.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = entry.options.maxAttempts()
+	}
+	switch {
+	case IsPermanent(err):
+		task.Status = StatusError
+	case task.Attempts >= maxAttempts:
+		task.Status = StatusDead
+	default:
+		delay := entry.options.retryDelay(task.Attempts)
+		task.Status = StatusQueued
+		task.NextRunAt = now.Add(delay)
+		// Wake a worker when the retry is due rather than at the next poll.
+		defer time.AfterFunc(delay, q.notify)
+	}
+	_, _ = q.save(task)
+}
+
+// save stores a task's new state, tells subscribers and wakes a worker in
+// case the task was queued.
+func (q *Queue) save(task Task) (Task, error) {
+	if err := q.store.Update(task); err != nil {
+		return Task{}, err
+	}
+	task.UpdatedAt = time.Now().UTC()
+	q.publish(task)
+	q.notify()
+	return task, nil
 }
 
-func DefaultHandler(_ context.Context, task Task) error {
-	if task.Type == "format" {
-		return nil
+func (q *Queue) notify() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
 	}
-	return nil
 }
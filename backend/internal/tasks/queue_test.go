@@ -0,0 +1,486 @@
+package tasks
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestQueueRecoversUnfinishedTasks(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tasks.json")
+	store, _ := NewFileStore(path)
+	queued, _ := store.Create(Task{UserID: "user-1", Type: "format", Status: StatusQueued})
+	running, _ := store.Create(Task{UserID: "user-2", Type: "format", Status: StatusRunning})
+	other, _ := store.Create(Task{UserID: "user-1", Type: "sync", Status: StatusRunning})
+
+	// A restart reloads the store and starts a new queue on it.
+	reloaded, _ := NewFileStore(path)
+	handled := make(chan string, 4)
+	registry := NewRegistry()
+	registry.Register("format", func(_ context.Context, task Task) error {
+		handled <- task.ID
+		return nil
+	}, TypeOptions{})
+	queue := NewQueue(reloaded, registry, QueueOptions{Workers: 1})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	queue.Start(ctx)
+
+	seen := map[string]bool{}
+	for range 2 {
+		select {
+		case id := <-handled:
+			seen[id] = true
+		case <-time.After(time.Second):
+			t.Fatalf("expected recovered tasks to run, got %v", seen)
+		}
+	}
+	if !seen[queued.ID] || !seen[running.ID] {
+		t.Fatalf("unexpected tasks handled %v", seen)
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		task, _ := reloaded.Get("user-2", running.ID)
+		if task.Status == StatusSuccess {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected recovered task to succeed, got %q", task.Status)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if task, _ := reloaded.Get("user-1", other.ID); task.Status != StatusRunning {
+		t.Fatalf("expected other task types left alone, got %q", task.Status)
+	}
+}
+
+func TestQueueTakesOverExpiredLeases(t *testing.T) {
+	store := NewMemoryStore()
+	task, _ := store.Create(Task{UserID: "user-1", Type: "format", Status: StatusQueued})
+	claimedAt := time.Now()
+	_, _ = store.Claim(nil, "server-2", claimedAt, claimedAt.Add(200*time.Millisecond))
+
+	handled := make(chan time.Time, 1)
+	registry := NewRegistry()
+	registry.Register("format", func(context.Context, Task) error {
+		handled <- time.Now()
+		return nil
+	}, TypeOptions{})
+	queue := NewQueue(store, registry, QueueOptions{Workers: 1, Owner: "server-1", Lease: 40 * time.Millisecond})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	queue.Start(ctx)
+
+	select {
+	case at := <-handled:
+		if at.Before(claimedAt.Add(200 * time.Millisecond)) {
+			t.Fatalf("expected the other server's lease respected")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("expected expired lease taken over")
+	}
+	if got, _ := store.Get("user-1", task.ID); got.Attempts != 2 {
+		t.Fatalf("expected a second attempt, got %+v", got)
+	}
+}
+
+func TestQueueEnqueueDoesNotBlock(t *testing.T) {
+	store := NewMemoryStore()
+	registry := NewRegistry()
+	registry.Register("format", func(context.Context, Task) error { return nil }, TypeOptions{})
+	queue := NewQueue(store, registry, QueueOptions{})
+	done := make(chan struct{})
+	go func() {
+		for range 500 {
+			_, _ = queue.Enqueue("user-1", "format", nil)
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("expected enqueue without workers not to block")
+	}
+	list, _ := store.ListByUser("user-1")
+	if len(list) != 500 {
+		t.Fatalf("expected 500 queued tasks, got %d", len(list))
+	}
+}
+
+func TestQueueRejectsUnknownTypes(t *testing.T) {
+	store := NewMemoryStore()
+	queue := NewQueue(store, NewRegistry(), QueueOptions{})
+	if _, err := queue.Enqueue("user-1", "convert", nil); !errors.Is(err, ErrUnknownType) {
+		t.Fatalf("expected unknown type, got %v", err)
+	}
+	if list, _ := store.ListByUser("user-1"); len(list) != 0 {
+		t.Fatalf("expected nothing stored, got %d", len(list))
+	}
+}
+
+func TestQueueFailsStoredTasksOfUnknownTypes(t *testing.T) {
+	store := NewMemoryStore()
+	stale, _ := store.Create(Task{UserID: "user-1", Type: "convert", Status: StatusQueued})
+	running, _ := store.Create(Task{UserID: "user-1", Type: "convert", Status: StatusRunning})
+	registry := NewRegistry()
+	registry.Register("format", func(context.Context, Task) error { return nil }, TypeOptions{})
+	queue := NewQueue(store, registry, QueueOptions{Workers: 1})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	queue.Start(ctx)
+
+	got, _ := store.Get("user-1", stale.ID)
+	if got.Status != StatusError || !strings.Contains(got.Error, `unknown task type "convert"`) {
+		t.Fatalf("expected queued task of unknown type failed, got %+v", got)
+	}
+	if got, _ := store.Get("user-1", running.ID); got.Status != StatusRunning {
+		t.Fatalf("expected running task left alone, got %q", got.Status)
+	}
+}
+
+func TestQueueRequeueKeepsActiveTasks(t *testing.T) {
+	registry := NewRegistry()
+	registry.Register("format", func(context.Context, Task) error { return nil }, TypeOptions{})
+	queue := NewQueue(NewMemoryStore(), registry, QueueOptions{})
+	task, _ := queue.Enqueue("user-1", "format", nil)
+	if _, err := queue.Requeue("user-1", task.ID); err != ErrActive {
+		t.Fatalf("expected queued task kept, got %v", err)
+	}
+	if _, err := queue.Requeue("user-1", "missing"); err != ErrNotFound {
+		t.Fatalf("expected not found, got %v", err)
+	}
+}
+
+func TestQueueLimitsConcurrencyPerType(t *testing.T) {
+	store := NewMemoryStore()
+	registry := NewRegistry()
+	release := make(chan struct{})
+	var active, peak atomic.Int32
+	registry.Register("sync", func(context.Context, Task) error {
+		n := active.Add(1)
+		if n > peak.Load() {
+			peak.Store(n)
+		}
+		<-release
+		active.Add(-1)
+		return nil
+	}, TypeOptions{Concurrency: 1})
+	formatted := make(chan struct{})
+	registry.Register("format", func(context.Context, Task) error {
+		close(formatted)
+		return nil
+	}, TypeOptions{})
+	queue := NewQueue(store, registry, QueueOptions{Workers: 3})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	queue.Start(ctx)
+
+	for range 3 {
+		_, _ = queue.Enqueue("user-1", "sync", nil)
+	}
+	_, _ = queue.Enqueue("user-1", "format", nil)
+	select {
+	case <-formatted:
+	case <-time.After(time.Second):
+		t.Fatalf("expected format task to run beside the syncs")
+	}
+	time.Sleep(20 * time.Millisecond)
+	if peak.Load() != 1 {
+		t.Fatalf("expected one sync at a time, got %d", peak.Load())
+	}
+	close(release)
+	deadline := time.Now().Add(time.Second)
+	for {
+		list, _ := store.ListByUser("user-1")
+		done := 0
+		for _, task := range list {
+			if task.Status == StatusSuccess {
+				done++
+			}
+		}
+		if done == len(list) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected every task to finish, got %d of %d", done, len(list))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if peak.Load() != 1 {
+		t.Fatalf("expected one sync at a time, got %d", peak.Load())
+	}
+}
+
+func TestQueueRetriesFailedTasks(t *testing.T) {
+	store := NewMemoryStore()
+	registry := NewRegistry()
+	var calls atomic.Int32
+	registry.Register("flaky", func(context.Context, Task) error {
+		if calls.Add(1) < 3 {
+			return errors.New("connection reset")
+		}
+		return nil
+	}, TypeOptions{MaxAttempts: 3, Backoff: time.Millisecond})
+	registry.Register("broken", func(context.Context, Task) error {
+		return errors.New("timeout")
+	}, TypeOptions{MaxAttempts: 2, Backoff: time.Millisecond})
+	registry.Register("invalid", func(context.Context, Task) error {
+		return Permanent(errors.New("missing book_id"))
+	}, TypeOptions{})
+	queue := NewQueue(store, registry, QueueOptions{Workers: 2})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	queue.Start(ctx)
+
+	flaky, _ := queue.Enqueue("user-1", "flaky", nil)
+	broken, _ := queue.Enqueue("user-1", "broken", nil)
+	invalid, _ := queue.Enqueue("user-1", "invalid", nil)
+	if flaky.MaxAttempts != 3 || invalid.MaxAttempts != defaultMaxAttempts {
+		t.Fatalf("expected max attempts from the type, got %d and %d", flaky.MaxAttempts, invalid.MaxAttempts)
+	}
+	flaky = waitForStatus(t, store, flaky.ID, StatusSuccess)
+	if flaky.Attempts != 3 || len(flaky.ErrorLog) != 2 || flaky.ErrorLog[1].Attempt != 2 || flaky.Error != "" {
+		t.Fatalf("unexpected retried task %+v", flaky)
+	}
+	broken = waitForStatus(t, store, broken.ID, StatusDead)
+	if broken.Attempts != 2 || len(broken.ErrorLog) != 2 || broken.Error != "timeout" {
+		t.Fatalf("unexpected dead task %+v", broken)
+	}
+	invalid = waitForStatus(t, store, invalid.ID, StatusError)
+	if invalid.Attempts != 1 {
+		t.Fatalf("expected permanent failure not retried, got %d attempts", invalid.Attempts)
+	}
+
+	requeued, err := queue.Requeue("user-1", broken.ID)
+	if err != nil || requeued.ID != broken.ID || requeued.Attempts != 0 || len(requeued.ErrorLog) != 2 {
+		t.Fatalf("expected dead task requeued in place, got %+v (%v)", requeued, err)
+	}
+	broken = waitForStatus(t, store, broken.ID, StatusDead)
+	if len(broken.ErrorLog) != 4 {
+		t.Fatalf("expected error log kept across requeues, got %d", len(broken.ErrorLog))
+	}
+}
+
+func TestTypeOptionsRetryDelayDoubles(t *testing.T) {
+	options := TypeOptions{Backoff: time.Minute}
+	for attempt, want := range map[int]time.Duration{1: time.Minute, 2: 2 * time.Minute, 4: 8 * time.Minute, 20: time.Hour} {
+		if got := options.retryDelay(attempt); got != want {
+			t.Fatalf("attempt %d: expected %v, got %v", attempt, want, got)
+		}
+	}
+}
+
+func waitForStatus(t *testing.T, store Store, id, status string) Task {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		task, _ := store.Get("user-1", id)
+		if task.Status == status {
+			return task
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %s, got %+v", status, task)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestQueueCancelsTasks(t *testing.T) {
+	store := NewMemoryStore()
+	registry := NewRegistry()
+	started := make(chan string, 2)
+	registry.Register("slow", func(ctx context.Context, task Task) error {
+		started <- task.ID
+		<-ctx.Done()
+		return ctx.Err()
+	}, TypeOptions{})
+	queue := NewQueue(store, registry, QueueOptions{Workers: 1})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	queue.Start(ctx)
+
+	running, _ := queue.Enqueue("user-1", "slow", nil)
+	select {
+	case <-started:
+	case <-time.After(time.Second):
+		t.Fatalf("expected task to start")
+	}
+	// The only worker is busy, so this one stays queued.
+	queued, _ := queue.Enqueue("user-1", "slow", nil)
+	canceled, err := queue.Cancel("user-1", queued.ID)
+	if err != nil || canceled.Status != StatusCanceled {
+		t.Fatalf("expected queued task canceled, got %+v (%v)", canceled, err)
+	}
+	stopping, err := queue.Cancel("user-1", running.ID)
+	if err != nil || stopping.Status != StatusRunning {
+		t.Fatalf("expected running task signaled, got %+v (%v)", stopping, err)
+	}
+	running = waitForStatus(t, store, running.ID, StatusCanceled)
+	if len(running.ErrorLog) != 0 {
+		t.Fatalf("expected cancellation not logged as a failure, got %+v", running.ErrorLog)
+	}
+	if _, err := queue.Cancel("user-1", running.ID); err != ErrFinished {
+		t.Fatalf("expected finished task kept, got %v", err)
+	}
+	select {
+	case id := <-started:
+		t.Fatalf("expected canceled task not to run, got %s", id)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestQueueCancelRespectsOtherServers(t *testing.T) {
+	store := NewMemoryStore()
+	registry := NewRegistry()
+	registry.Register("slow", func(context.Context, Task) error { return nil }, TypeOptions{})
+	queue := NewQueue(store, registry, QueueOptions{Owner: "server-1"})
+	now := time.Now()
+	leased, _ := store.Create(Task{UserID: "user-1", Type: "slow", Status: StatusQueued})
+	_, _ = store.Claim(nil, "server-2", now, now.Add(time.Minute))
+	if _, err := queue.Cancel("user-1", leased.ID); err != ErrRunningElsewhere {
+		t.Fatalf("expected task of another server kept, got %v", err)
+	}
+	expired, _ := store.Create(Task{UserID: "user-1", Type: "slow", Status: StatusQueued})
+	_, _ = store.Claim(nil, "server-2", now, now.Add(-time.Second))
+	if task, err := queue.Cancel("user-1", expired.ID); err != nil || task.Status != StatusCanceled {
+		t.Fatalf("expected task with an expired lease canceled, got %+v (%v)", task, err)
+	}
+}
+
+// claimingStore lets another server claim every task right before Cancel
+// tries to cancel it.
+type claimingStore struct {
+	*MemoryStore
+}
+
+func (s claimingStore) CancelQueued(userID, id string) (Task, bool, error) {
+	now := time.Now()
+	_, _ = s.Claim(nil, "server-2", now, now.Add(time.Minute))
+	return s.MemoryStore.CancelQueued(userID, id)
+}
+
+func TestQueueCancelLosesToConcurrentClaim(t *testing.T) {
+	store := claimingStore{NewMemoryStore()}
+	queue := NewQueue(store, NewRegistry(), QueueOptions{Owner: "server-1"})
+	task, _ := store.Create(Task{UserID: "user-1", Type: "slow", Status: StatusQueued})
+	if _, err := queue.Cancel("user-1", task.ID); err != ErrRunningElsewhere {
+		t.Fatalf("expected claimed task kept, got %v", err)
+	}
+	if got, _ := store.Get("user-1", task.ID); got.Status != StatusRunning || got.Owner != "server-2" {
+		t.Fatalf("expected task left with the other server, got %+v", got)
+	}
+}
+
+func TestQueueCancelAfterFinishIsTooLate(t *testing.T) {
+	store := NewMemoryStore()
+	queue := NewQueue(store, NewRegistry(), QueueOptions{})
+	task, _ := store.Create(Task{UserID: "user-1", Type: "slow", Status: StatusRunning})
+	queue.active[task.ID] = &activeTask{cancel: func() {}}
+	// The worker settles the final status before Cancel gets the lock.
+	if queue.finish(task.ID) {
+		t.Fatalf("expected task not canceled")
+	}
+	if _, err := queue.Cancel("user-1", task.ID); err != ErrFinished {
+		t.Fatalf("expected cancel after finish rejected, got %v", err)
+	}
+}
+
+func TestQueueTimesOutTasks(t *testing.T) {
+	store := NewMemoryStore()
+	registry := NewRegistry()
+	registry.Register("hang", func(ctx context.Context, _ Task) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}, TypeOptions{MaxAttempts: 1, Timeout: 10 * time.Millisecond})
+	queue := NewQueue(store, registry, QueueOptions{Workers: 1})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	queue.Start(ctx)
+
+	task, _ := queue.Enqueue("user-1", "hang", nil)
+	task = waitForStatus(t, store, task.ID, StatusDead)
+	if task.Error != "timed out after 10ms" {
+		t.Fatalf("unexpected error %q", task.Error)
+	}
+}
+
+func TestQueueUsesConfiguredTimeouts(t *testing.T) {
+	timeouts, err := ParseTimeouts(" hang=20ms, sync=2h ")
+	if err != nil || timeouts["hang"] != 20*time.Millisecond || timeouts["sync"] != 2*time.Hour {
+		t.Fatalf("unexpected timeouts %v (%v)", timeouts, err)
+	}
+	for _, raw := range []string{"sync", "=1h", "sync=soon", "sync=-1m"} {
+		if _, err := ParseTimeouts(raw); err == nil {
+			t.Fatalf("expected %q rejected", raw)
+		}
+	}
+
+	store := NewMemoryStore()
+	registry := NewRegistry()
+	registry.SetTimeout("hang", timeouts["hang"])
+	registry.Register("hang", func(ctx context.Context, _ Task) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}, TypeOptions{MaxAttempts: 1, Timeout: time.Hour})
+	queue := NewQueue(store, registry, QueueOptions{Workers: 1})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	queue.Start(ctx)
+
+	task, _ := queue.Enqueue("user-1", "hang", nil)
+	task = waitForStatus(t, store, task.ID, StatusDead)
+	if task.Error != "timed out after 20ms" {
+		t.Fatalf("unexpected error %q", task.Error)
+	}
+}
+
+func TestQueueReportsProgress(t *testing.T) {
+	store := NewMemoryStore()
+	registry := NewRegistry()
+	registry.Register("sync", func(ctx context.Context, _ Task) error {
+		for i := range 3 {
+			ReportProgress(ctx, i+1, 3, "Checking files")
+		}
+		return nil
+	}, TypeOptions{})
+	queue := NewQueue(store, registry, QueueOptions{Workers: 1})
+	sub := queue.Subscribe("user-1")
+	defer sub.Close()
+	other := queue.Subscribe("user-2")
+	defer other.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	queue.Start(ctx)
+
+	task, _ := queue.Enqueue("user-1", "sync", nil)
+	var last Task
+	for last.Status != StatusSuccess {
+		select {
+		case <-sub.Ready():
+			for _, changed := range sub.Next() {
+				if changed.ID != task.ID {
+					t.Fatalf("unexpected task %s", changed.ID)
+				}
+				last = changed
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected task changes, last %+v", last)
+		}
+	}
+	want := Progress{Current: 3, Total: 3, Message: "Checking files"}
+	if last.Progress != want {
+		t.Fatalf("expected final progress published, got %+v", last.Progress)
+	}
+	if stored, _ := store.Get("user-1", task.ID); stored.Progress != want {
+		t.Fatalf("expected final progress stored, got %+v", stored.Progress)
+	}
+	if changes := other.Next(); len(changes) != 0 {
+		t.Fatalf("expected other users not told, got %d changes", len(changes))
+	}
+	// Reporting outside a task does nothing.
+	ReportProgress(context.Background(), 1, 2, "")
+}
@@ -0,0 +1,129 @@
+package tasks
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrUnknownType = errors.New("unknown task type")
+
+// TypeOptions tunes how tasks of one type run. Zero values mean the default.
+type TypeOptions struct {
+	// Concurrency caps how many tasks of the type run at once. By default
+	// only the worker pool limits them.
+	Concurrency int
+	// MaxAttempts is how often a failing task runs before it is dead.
+	MaxAttempts int
+	// Backoff is the wait before the first retry; it doubles with every
+	// further attempt.
+	Backoff time.Duration
+	// Timeout bounds a single attempt. An attempt that runs out of time
+	// fails and is retried.
+	Timeout time.Duration
+}
+
+type registration struct {
+	handler HandlerFunc
+	options TypeOptions
+}
+
+// Registry maps task types to the handlers that run them. Packages register
+// their types before the queue starts.
+type Registry struct {
+	mu      sync.RWMutex
+	entries map[string]registration
+	// timeouts override the timeouts types were registered with.
+	timeouts map[string]time.Duration
+}
+
+func NewRegistry() *Registry {
+	return &Registry{entries: make(map[string]registration), timeouts: make(map[string]time.Duration)}
+}
+
+// SetTimeout overrides the attempt timeout of a task type, such as from
+// configuration, whether the type is registered before or after.
+func (r *Registry) SetTimeout(taskType string, timeout time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.timeouts[taskType] = timeout
+}
+
+// Register sets the handler of a task type, replacing any earlier one.
+func (r *Registry) Register(taskType string, handler HandlerFunc, options TypeOptions) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries[taskType] = registration{handler: handler, options: options}
+}
+
+// Types returns the registered task types in order.
+func (r *Registry) Types() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	out := make([]string, 0, len(r.entries))
+	for taskType := range r.entries {
+		out = append(out, taskType)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func (r *Registry) lookup(taskType string) (registration, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	entry, ok := r.entries[taskType]
+	if !ok {
+		return registration{}, UnknownType(taskType)
+	}
+	if timeout, ok := r.timeouts[taskType]; ok {
+		entry.options.Timeout = timeout
+	}
+	return entry, nil
+}
+
+// available returns the registered types that can start another task while
+// running tasks of each type are in progress.
+func (r *Registry) available(running map[string]int) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var out []string
+	for taskType, entry := range r.entries {
+		if limit := entry.options.Concurrency; limit > 0 && running[taskType] >= limit {
+			continue
+		}
+		out = append(out, taskType)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// ParseTimeouts reads attempt timeouts per task type written as
+// "sync=2h,format=10m".
+func ParseTimeouts(raw string) (map[string]time.Duration, error) {
+	out := make(map[string]time.Duration)
+	for _, field := range strings.Split(raw, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		taskType, value, ok := strings.Cut(field, "=")
+		taskType = strings.TrimSpace(taskType)
+		if !ok || taskType == "" {
+			return nil, fmt.Errorf("invalid task timeout %q", field)
+		}
+		timeout, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("invalid task timeout %q", field)
+		}
+		out[taskType] = timeout
+	}
+	return out, nil
+}
+
+// UnknownType returns the error for a task type nothing handles.
+func UnknownType(taskType string) error {
+	return fmt.Errorf("%w %q", ErrUnknownType, taskType)
+}
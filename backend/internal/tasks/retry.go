@@ -0,0 +1,62 @@
+package tasks
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	defaultMaxAttempts = 3
+	defaultBackoff     = 30 * time.Second
+	maxBackoff         = time.Hour
+	defaultTimeout     = 30 * time.Minute
+	// maxErrorLog bounds the attempt errors kept on a task that is
+	// requeued again and again.
+	maxErrorLog = 20
+)
+
+type permanentError struct{ err error }
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// Permanent marks err as one that retrying cannot fix, such as a missing
+// payload field or an unsupported file. The task fails without retries.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return permanentError{err: err}
+}
+
+// IsPermanent reports whether err was marked with Permanent.
+func IsPermanent(err error) bool {
+	var permanent permanentError
+	return errors.As(err, &permanent)
+}
+
+func (o TypeOptions) maxAttempts() int {
+	if o.MaxAttempts > 0 {
+		return o.MaxAttempts
+	}
+	return defaultMaxAttempts
+}
+
+func (o TypeOptions) timeout() time.Duration {
+	if o.Timeout > 0 {
+		return o.Timeout
+	}
+	return defaultTimeout
+}
+
+// retryDelay is the wait after the given failed attempt.
+func (o TypeOptions) retryDelay(attempt int) time.Duration {
+	delay := o.Backoff
+	if delay <= 0 {
+		delay = defaultBackoff
+	}
+	for i := 1; i < attempt && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, maxBackoff)
+}
@@ -1,9 +1,29 @@
 package tasks
 
+import "time"
+
 // Store persists tasks for users.
 type Store interface {
 	Create(task Task) (Task, error)
 	Update(task Task) error
 	Get(userID, id string) (Task, error)
 	ListByUser(userID string) ([]Task, error)
+	// Claim marks the oldest queued task of one of types (any type when
+	// empty) that is due at now as running, leased to owner until
+	// leaseUntil, counts the attempt and returns it, or ErrNotFound when
+	// there is none.
+	Claim(types []string, owner string, now, leaseUntil time.Time) (Task, error)
+	// Renew extends owner's lease on its running tasks with the given IDs.
+	Renew(owner string, ids []string, leaseUntil time.Time) error
+	// ResetRunning puts running tasks of types back in the queue when
+	// owner holds them or their lease ran out before now. An empty owner
+	// only resets expired leases.
+	ResetRunning(types []string, owner string, now time.Time) error
+	// CancelQueued marks a task canceled if it is still queued. It
+	// returns the task as stored afterwards and whether it was canceled,
+	// or ErrNotFound.
+	CancelQueued(userID, id string) (Task, bool, error)
+	// FailUnknown marks queued tasks whose type is not among types as
+	// failed with UnknownType and returns them.
+	FailUnknown(types []string, now time.Time) ([]Task, error)
 }
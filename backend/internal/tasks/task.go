@@ -7,14 +7,31 @@ import (
 )
 
 type Task struct {
-	ID        string            `json:"id"`
-	UserID    string            `json:"user_id"`
-	Type      string            `json:"type"`
-	Status    string            `json:"status"`
-	Error     string            `json:"error"`
-	Payload   map[string]string `json:"payload"`
-	CreatedAt time.Time         `json:"created_at"`
-	UpdatedAt time.Time         `json:"updated_at"`
+	ID          string            `json:"id"`
+	UserID      string            `json:"user_id"`
+	Type        string            `json:"type"`
+	Status      string            `json:"status"`
+	Error       string            `json:"error"`
+	Payload     map[string]string `json:"payload"`
+	Attempts    int               `json:"attempts"`
+	MaxAttempts int               `json:"max_attempts"`
+	// NextRunAt holds a retry back until then; zero runs it right away.
+	NextRunAt time.Time      `json:"next_run_at"`
+	ErrorLog  []AttemptError `json:"error_log"`
+	Progress  Progress       `json:"progress"`
+	// Owner is the server running the task. It holds the task until
+	// LeaseUntil and keeps renewing the lease while the handler runs.
+	Owner      string    `json:"owner,omitempty"`
+	LeaseUntil time.Time `json:"lease_until"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// AttemptError records why one attempt of a task failed.
+type AttemptError struct {
+	Attempt int       `json:"attempt"`
+	Error   string    `json:"error"`
+	At      time.Time `json:"at"`
 }
 
 const (
@@ -22,6 +39,9 @@ const (
 	StatusRunning = "running"
 	StatusSuccess = "success"
 	StatusError   = "error"
+	// StatusDead marks a task that failed on every attempt.
+	StatusDead     = "dead"
+	StatusCanceled = "canceled"
 )
 
 func newTaskID() string {
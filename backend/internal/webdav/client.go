@@ -1,6 +1,7 @@
 package webdav
 
 import (
+	"context"
 	"errors"
 	"io"
 	"time"
@@ -10,17 +11,75 @@ type Entry struct {
 	Path    string
 	Size    int64
 	ModTime time.Time
+	ETag    string
 }
 
+// ContentRequest carries the Range and conditional headers of a download
+// through to the server. Empty fields are not sent.
+type ContentRequest struct {
+	Range           string
+	IfRange         string
+	IfNoneMatch     string
+	IfModifiedSince string
+}
+
+// Content is a downloaded file, part of one, or the answer that the copy
+// of the caller is current.
+type Content struct {
+	// Status is http.StatusOK, StatusPartialContent, StatusNotModified or
+	// StatusRequestedRangeNotSatisfiable. Body is nil unless it is 200 or
+	// 206.
+	Status      int
+	Body        io.ReadCloser
+	ContentType string
+	// Length is the size of Body, or -1 when the server did not say.
+	Length       int64
+	ContentRange string
+	AcceptRanges bool
+	ETag         string
+	LastModified time.Time
+	// Seeker is set when Body is a local copy from the content cache.
+	// Status is then 200 whatever was asked, and the caller answers Range
+	// and conditional headers itself.
+	Seeker io.ReadSeeker
+}
+
+// ErrPreconditionFailed is returned by Put when the file changed since
+// it was read, or exists when it was meant to be created.
+var ErrPreconditionFailed = errors.New("webdav precondition failed")
+
 type Client interface {
-	List(baseURL, username, secret string) ([]Entry, error)
-	Fetch(baseURL, username, secret, path string) (io.ReadCloser, string, error)
+	// List returns every file below baseURL. Folders for which skipDir,
+	// when set, reports true are not listed; it gets their decoded server
+	// path.
+	List(ctx context.Context, baseURL, username, secret string, skipDir func(dir string) bool) ([]Entry, error)
+	Fetch(ctx context.Context, baseURL, username, secret, path string, req ContentRequest) (Content, error)
+	// Put streams size bytes of body to path. See HTTPClient.Put for
+	// ifMatch.
+	Put(ctx context.Context, baseURL, username, secret, path string, body io.ReadSeeker, size int64, ifMatch string) (string, error)
+	MakeCollection(ctx context.Context, baseURL, username, secret, path string) error
+	// Stat returns a file as List would report it.
+	Stat(ctx context.Context, baseURL, username, secret, path string) (Entry, error)
 }
 
 type NoopClient struct{}
 
-func (NoopClient) List(_, _, _ string) ([]Entry, error) { return nil, nil }
+func (NoopClient) List(_ context.Context, _, _, _ string, _ func(string) bool) ([]Entry, error) {
+	return nil, nil
+}
+
+func (NoopClient) Fetch(_ context.Context, _, _, _, _ string, _ ContentRequest) (Content, error) {
+	return Content{}, errors.New("fetch not implemented")
+}
+
+func (NoopClient) Put(_ context.Context, _, _, _, _ string, _ io.ReadSeeker, _ int64, _ string) (string, error) {
+	return "", errors.New("put not implemented")
+}
+
+func (NoopClient) MakeCollection(_ context.Context, _, _, _, _ string) error {
+	return errors.New("mkcol not implemented")
+}
 
-func (NoopClient) Fetch(_, _, _, _ string) (io.ReadCloser, string, error) {
-	return nil, "", errors.New("fetch not implemented")
+func (NoopClient) Stat(_ context.Context, _, _, _, _ string) (Entry, error) {
+	return Entry{}, errors.New("stat not implemented")
 }
@@ -0,0 +1,465 @@
+package webdav
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrCacheDisabled is returned when pinning books without a content cache.
+var ErrCacheDisabled = errors.New("content cache disabled")
+
+const pinsFile = "pins.json"
+
+// ContentCache keeps downloaded books on disk as <dir>/<key> with a
+// <key>.json sidecar. Keys are derived from the connection, the path and
+// the version of the file seen by the last sync, so a changed file is
+// never served stale. Past the size limit the least recently used entries
+// are evicted, except pinned files and files that are being read.
+type ContentCache struct {
+	dir     string
+	options CacheOptions
+
+	mu      sync.Mutex
+	entries map[string]*cacheEntry
+	pins    map[string]struct{}
+	filling map[string]struct{}
+	size    int64
+}
+
+type cacheEntry struct {
+	cacheMeta
+	key      string
+	lastUsed time.Time
+	readers  int
+}
+
+type cacheMeta struct {
+	ConnectionID string    `json:"connection_id"`
+	Path         string    `json:"path"`
+	Version      string    `json:"version"`
+	ContentType  string    `json:"content_type"`
+	ETag         string    `json:"etag"`
+	LastModified time.Time `json:"last_modified"`
+	Size         int64     `json:"size"`
+}
+
+// CacheOptions tune the content cache. A MaxSize of 0 or less disables
+// eviction; a Prefetch of 0 disables prefetching.
+type CacheOptions struct {
+	// MaxSize is the size in bytes the cache is trimmed to.
+	MaxSize int64
+	// Prefetch makes every sync download new versions of the files read
+	// within this window, and of pinned files, in the background.
+	Prefetch time.Duration
+}
+
+// NewContentCache opens the cache in dir, picking up the entries left by
+// a previous run.
+func NewContentCache(dir string, options CacheOptions) (*ContentCache, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	c := &ContentCache{
+		dir:     dir,
+		options: options,
+		entries: make(map[string]*cacheEntry),
+		pins:    make(map[string]struct{}),
+		filling: make(map[string]struct{}),
+	}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.evictLocked()
+	c.mu.Unlock()
+	return c, nil
+}
+
+func (c *ContentCache) load() error {
+	names, err := os.ReadDir(c.dir)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		file := name.Name()
+		switch {
+		case strings.HasSuffix(file, ".tmp"):
+			_ = os.Remove(filepath.Join(c.dir, file))
+		case file != pinsFile && strings.HasSuffix(file, ".json"):
+			key := strings.TrimSuffix(file, ".json")
+			if err := c.loadEntry(key); err != nil {
+				c.removeFiles(key)
+			}
+		}
+	}
+	data, err := os.ReadFile(filepath.Join(c.dir, pinsFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var pins []string
+	if err := json.Unmarshal(data, &pins); err != nil {
+		return nil
+	}
+	for _, pin := range pins {
+		c.pins[pin] = struct{}{}
+	}
+	return nil
+}
+
+func (c *ContentCache) loadEntry(key string) error {
+	data, err := os.ReadFile(filepath.Join(c.dir, key+".json"))
+	if err != nil {
+		return err
+	}
+	var meta cacheMeta
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return err
+	}
+	if cacheKey(meta.ConnectionID, meta.Path, meta.Version) != key {
+		return errors.New("cache key mismatch")
+	}
+	info, err := os.Stat(filepath.Join(c.dir, key))
+	if err != nil {
+		return err
+	}
+	if info.Size() != meta.Size {
+		return errors.New("truncated cache entry")
+	}
+	c.entries[key] = &cacheEntry{cacheMeta: meta, key: key, lastUsed: info.ModTime()}
+	c.size += meta.Size
+	return nil
+}
+
+// Open returns the cached copy of a file version, if there is one. The
+// entry cannot be evicted until the returned content is closed.
+func (c *ContentCache) Open(connectionID, filePath, version string) (Content, bool) {
+	key := cacheKey(connectionID, filePath, version)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok {
+		return Content{}, false
+	}
+	file, err := os.Open(filepath.Join(c.dir, key))
+	if err != nil {
+		c.dropLocked(entry)
+		return Content{}, false
+	}
+	entry.readers++
+	entry.lastUsed = time.Now()
+	_ = os.Chtimes(file.Name(), entry.lastUsed, entry.lastUsed)
+	body := &cachedFile{File: file, cache: c, entry: entry}
+	return Content{
+		Status:       http.StatusOK,
+		Body:         body,
+		Seeker:       body,
+		ContentType:  entry.ContentType,
+		Length:       entry.Size,
+		AcceptRanges: true,
+		ETag:         entry.ETag,
+		LastModified: entry.LastModified,
+	}, true
+}
+
+// Has reports whether a file version is cached.
+func (c *ContentCache) Has(connectionID, filePath, version string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.entries[cacheKey(connectionID, filePath, version)]
+	return ok
+}
+
+// Wanted returns the paths of a connection that are pinned or were read
+// since the given time, in any version.
+func (c *ContentCache) Wanted(connectionID string, since time.Time) map[string]struct{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := make(map[string]struct{})
+	prefix := pinKey(connectionID, "")
+	for pin := range c.pins {
+		if strings.HasPrefix(pin, prefix) {
+			out[strings.TrimPrefix(pin, prefix)] = struct{}{}
+		}
+	}
+	for _, entry := range c.entries {
+		if entry.ConnectionID == connectionID && entry.lastUsed.After(since) {
+			out[entry.Path] = struct{}{}
+		}
+	}
+	return out
+}
+
+// SetPinned pins or unpins a file in all its versions. Pinned files are
+// never evicted.
+func (c *ContentCache) SetPinned(connectionID, filePath string, pinned bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if pinned {
+		c.pins[pinKey(connectionID, filePath)] = struct{}{}
+	} else {
+		delete(c.pins, pinKey(connectionID, filePath))
+		c.evictLocked()
+	}
+	return c.savePinsLocked()
+}
+
+// Pinned reports whether a file is pinned.
+func (c *ContentCache) Pinned(connectionID, filePath string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.pins[pinKey(connectionID, filePath)]
+	return ok
+}
+
+// RemoveConnection drops every entry and pin of a connection.
+func (c *ContentCache) RemoveConnection(connectionID string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, entry := range c.entries {
+		if entry.ConnectionID == connectionID {
+			c.dropLocked(entry)
+		}
+	}
+	prefix := pinKey(connectionID, "")
+	for pin := range c.pins {
+		if strings.HasPrefix(pin, prefix) {
+			delete(c.pins, pin)
+		}
+	}
+	return c.savePinsLocked()
+}
+
+// Size returns the total size of the cached files.
+func (c *ContentCache) Size() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.size
+}
+
+// claim marks a file version as being downloaded. It returns false when it
+// is cached already or another download is under way.
+func (c *ContentCache) claim(connectionID, filePath, version string) bool {
+	key := cacheKey(connectionID, filePath, version)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[key]; ok {
+		return false
+	}
+	if _, ok := c.filling[key]; ok {
+		return false
+	}
+	c.filling[key] = struct{}{}
+	return true
+}
+
+func (c *ContentCache) release(connectionID, filePath, version string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.filling, cacheKey(connectionID, filePath, version))
+}
+
+// fill wraps a complete download claimed with claim so that the cache
+// keeps a copy once the body has been read to the end. A body closed early
+// leaves no trace. Either way the claim is released.
+func (c *ContentCache) fill(meta cacheMeta, content Content) Content {
+	file, err := os.CreateTemp(c.dir, "fill-*.tmp")
+	if err != nil {
+		c.release(meta.ConnectionID, meta.Path, meta.Version)
+		return content
+	}
+	meta.ContentType = content.ContentType
+	meta.ETag = content.ETag
+	meta.LastModified = content.LastModified
+	content.Body = &fillBody{body: content.Body, file: file, cache: c, meta: meta, expected: content.Length}
+	return content
+}
+
+// commit moves a completed download into place, replacing older versions
+// of the same file, and evicts entries over the limit.
+func (c *ContentCache) commit(tmp string, meta cacheMeta) error {
+	key := cacheKey(meta.ConnectionID, meta.Path, meta.Version)
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	metaPath := filepath.Join(c.dir, key+".json")
+	if err := os.WriteFile(metaPath+".tmp", data, 0o644); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := os.Rename(tmp, filepath.Join(c.dir, key)); err != nil {
+		_ = os.Remove(metaPath + ".tmp")
+		return err
+	}
+	if err := os.Rename(metaPath+".tmp", metaPath); err != nil {
+		_ = os.Remove(filepath.Join(c.dir, key))
+		return err
+	}
+	if old, ok := c.entries[key]; ok {
+		c.size -= old.Size
+	}
+	for _, entry := range c.entries {
+		if entry.ConnectionID == meta.ConnectionID && entry.Path == meta.Path && entry.key != key {
+			c.dropLocked(entry)
+		}
+	}
+	c.entries[key] = &cacheEntry{cacheMeta: meta, key: key, lastUsed: time.Now()}
+	c.size += meta.Size
+	c.evictLocked()
+	return nil
+}
+
+// evictLocked removes least recently used entries until the cache fits
+// its limit. Pinned entries and entries being read are skipped, so the
+// cache can stay over the limit when they alone exceed it.
+func (c *ContentCache) evictLocked() {
+	for c.options.MaxSize > 0 && c.size > c.options.MaxSize {
+		var victim *cacheEntry
+		for _, entry := range c.entries {
+			if entry.readers > 0 {
+				continue
+			}
+			if _, pinned := c.pins[pinKey(entry.ConnectionID, entry.Path)]; pinned {
+				continue
+			}
+			if victim == nil || entry.lastUsed.Before(victim.lastUsed) {
+				victim = entry
+			}
+		}
+		if victim == nil {
+			return
+		}
+		c.dropLocked(victim)
+	}
+}
+
+func (c *ContentCache) dropLocked(entry *cacheEntry) {
+	if c.entries[entry.key] != entry {
+		return
+	}
+	delete(c.entries, entry.key)
+	c.size -= entry.Size
+	c.removeFiles(entry.key)
+}
+
+func (c *ContentCache) removeFiles(key string) {
+	_ = os.Remove(filepath.Join(c.dir, key+".json"))
+	_ = os.Remove(filepath.Join(c.dir, key))
+}
+
+func (c *ContentCache) savePinsLocked() error {
+	pins := make([]string, 0, len(c.pins))
+	for pin := range c.pins {
+		pins = append(pins, pin)
+	}
+	data, err := json.Marshal(pins)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(c.dir, pinsFile)
+	if err := os.WriteFile(path+".tmp", data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+func cacheKey(connectionID, filePath, version string) string {
+	sum := sha256.Sum256([]byte(connectionID + "\x00" + filePath + "\x00" + version))
+	return hex.EncodeToString(sum[:])
+}
+
+func pinKey(connectionID, filePath string) string {
+	return connectionID + "\x00" + filePath
+}
+
+// fileVersion identifies a version of a remote file: its ETag, or its
+// size and modification time when the server reports none.
+func fileVersion(file FileState) string {
+	if file.ETag != "" {
+		return "etag:" + file.ETag
+	}
+	return fmt.Sprintf("size:%d:%d", file.Size, file.ModTime.UnixNano())
+}
+
+// cachedFile releases its entry for eviction when closed.
+type cachedFile struct {
+	*os.File
+	cache *ContentCache
+	entry *cacheEntry
+	once  sync.Once
+}
+
+func (f *cachedFile) Close() error {
+	err := f.File.Close()
+	f.once.Do(func() {
+		f.cache.mu.Lock()
+		f.entry.readers--
+		f.cache.evictLocked()
+		f.cache.mu.Unlock()
+	})
+	return err
+}
+
+// fillBody copies a download into a temporary file while it is read.
+type fillBody struct {
+	body     io.ReadCloser
+	file     *os.File
+	cache    *ContentCache
+	meta     cacheMeta
+	expected int64
+	written  int64
+	failed   bool
+	done     bool
+}
+
+func (b *fillBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	if n > 0 && !b.failed && !b.done {
+		if _, werr := b.file.Write(p[:n]); werr != nil {
+			b.failed = true
+		}
+		b.written += int64(n)
+	}
+	if err == io.EOF && !b.failed && !b.done {
+		b.done = true
+		b.finish(b.expected < 0 || b.written == b.expected)
+	}
+	return n, err
+}
+
+func (b *fillBody) Close() error {
+	if !b.done {
+		b.done = true
+		b.finish(false)
+	}
+	return b.body.Close()
+}
+
+func (b *fillBody) finish(complete bool) {
+	defer b.cache.release(b.meta.ConnectionID, b.meta.Path, b.meta.Version)
+	name := b.file.Name()
+	if err := b.file.Close(); err != nil {
+		complete = false
+	}
+	if complete {
+		b.meta.Size = b.written
+		if b.cache.commit(name, b.meta) == nil {
+			return
+		}
+	}
+	_ = os.Remove(name)
+}
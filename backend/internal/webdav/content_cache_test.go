@@ -0,0 +1,275 @@
+package webdav
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/EROQIN/relite-reader/backend/internal/books"
+)
+
+// storeContent puts data into the cache as version of path, the way a
+// completed download does.
+func storeContent(t *testing.T, cache *ContentCache, path, version, data string) {
+	t.Helper()
+	if !cache.claim("conn-1", path, version) {
+		t.Fatalf("expected to claim %s", path)
+	}
+	meta := cacheMeta{ConnectionID: "conn-1", Path: path, Version: version}
+	content := cache.fill(meta, Content{Status: http.StatusOK, Body: io.NopCloser(strings.NewReader(data)), Length: int64(len(data))})
+	if _, err := io.ReadAll(content.Body); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	content.Body.Close()
+}
+
+func readCached(t *testing.T, cache *ContentCache, path, version string) (string, bool) {
+	t.Helper()
+	content, ok := cache.Open("conn-1", path, version)
+	if !ok {
+		return "", false
+	}
+	defer content.Body.Close()
+	data, err := io.ReadAll(content.Body)
+	if err != nil {
+		t.Fatalf("read cached: %v", err)
+	}
+	return string(data), true
+}
+
+func TestContentCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	cache, err := NewContentCache(t.TempDir(), CacheOptions{MaxSize: 10})
+	if err != nil {
+		t.Fatalf("new cache: %v", err)
+	}
+	storeContent(t, cache, "/A.epub", "v1", "aaaa")
+	storeContent(t, cache, "/B.epub", "v1", "bbbb")
+	time.Sleep(10 * time.Millisecond)
+	if _, ok := readCached(t, cache, "/A.epub", "v1"); !ok {
+		t.Fatalf("expected A cached")
+	}
+	storeContent(t, cache, "/C.epub", "v1", "cccc")
+	if !cache.Has("conn-1", "/A.epub", "v1") || cache.Has("conn-1", "/B.epub", "v1") || !cache.Has("conn-1", "/C.epub", "v1") {
+		t.Fatalf("expected B evicted")
+	}
+	if cache.Size() != 8 {
+		t.Fatalf("expected 8 bytes cached, got %d", cache.Size())
+	}
+
+	if err := cache.SetPinned("conn-1", "/A.epub", true); err != nil {
+		t.Fatalf("pin: %v", err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	storeContent(t, cache, "/D.epub", "v1", "dddd")
+	if !cache.Has("conn-1", "/A.epub", "v1") || cache.Has("conn-1", "/C.epub", "v1") {
+		t.Fatalf("expected the pinned file kept and C evicted")
+	}
+}
+
+func TestContentCacheKeepsFilesBeingRead(t *testing.T) {
+	cache, _ := NewContentCache(t.TempDir(), CacheOptions{MaxSize: 4})
+	storeContent(t, cache, "/A.epub", "v1", "aaaa")
+	open, ok := cache.Open("conn-1", "/A.epub", "v1")
+	if !ok {
+		t.Fatalf("expected A cached")
+	}
+	storeContent(t, cache, "/B.epub", "v1", "bbbb")
+	if !cache.Has("conn-1", "/A.epub", "v1") || cache.Has("conn-1", "/B.epub", "v1") {
+		t.Fatalf("expected the open file to survive eviction")
+	}
+	open.Body.Close()
+	storeContent(t, cache, "/B.epub", "v1", "bbbb")
+	if cache.Has("conn-1", "/A.epub", "v1") {
+		t.Fatalf("expected A evicted once closed")
+	}
+}
+
+func TestContentCacheReplacesOldVersions(t *testing.T) {
+	cache, _ := NewContentCache(t.TempDir(), CacheOptions{})
+	storeContent(t, cache, "/A.epub", "v1", "old")
+	storeContent(t, cache, "/A.epub", "v2", "newer")
+	if cache.Has("conn-1", "/A.epub", "v1") {
+		t.Fatalf("expected old version dropped")
+	}
+	if data, _ := readCached(t, cache, "/A.epub", "v2"); data != "newer" {
+		t.Fatalf("unexpected content %q", data)
+	}
+}
+
+func TestContentCacheDiscardsIncompleteDownloads(t *testing.T) {
+	cache, _ := NewContentCache(t.TempDir(), CacheOptions{})
+	cache.claim("conn-1", "/A.epub", "v1")
+	meta := cacheMeta{ConnectionID: "conn-1", Path: "/A.epub", Version: "v1"}
+	content := cache.fill(meta, Content{Status: http.StatusOK, Body: io.NopCloser(strings.NewReader("abcdef")), Length: 6})
+	buf := make([]byte, 2)
+	_, _ = content.Body.Read(buf)
+	content.Body.Close()
+	if cache.Has("conn-1", "/A.epub", "v1") {
+		t.Fatalf("expected partial download discarded")
+	}
+
+	cache.claim("conn-1", "/A.epub", "v1")
+	content = cache.fill(meta, Content{Status: http.StatusOK, Body: io.NopCloser(strings.NewReader("abc")), Length: 6})
+	_, _ = io.ReadAll(content.Body)
+	content.Body.Close()
+	if cache.Has("conn-1", "/A.epub", "v1") {
+		t.Fatalf("expected truncated download discarded")
+	}
+}
+
+func TestContentCacheSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	cache, _ := NewContentCache(dir, CacheOptions{})
+	storeContent(t, cache, "/A.epub", "v1", "aaaa")
+	_ = cache.SetPinned("conn-1", "/A.epub", true)
+
+	reopened, err := NewContentCache(dir, CacheOptions{})
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	if data, ok := readCached(t, reopened, "/A.epub", "v1"); !ok || data != "aaaa" {
+		t.Fatalf("expected cached content after restart, got %q", data)
+	}
+	if !reopened.Pinned("conn-1", "/A.epub") {
+		t.Fatalf("expected pin after restart")
+	}
+	if err := reopened.RemoveConnection("conn-1"); err != nil || reopened.Size() != 0 || reopened.Pinned("conn-1", "/A.epub") {
+		t.Fatalf("expected connection removed (%v)", err)
+	}
+}
+
+// shareClient serves file contents and counts downloads; offline makes
+// every request fail.
+type shareClient struct {
+	mu      sync.Mutex
+	entries []Entry
+	files   map[string]string
+	offline bool
+	fetches int
+}
+
+func (c *shareClient) List(_ context.Context, _, _, _ string, _ func(string) bool) ([]Entry, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.offline {
+		return nil, errors.New("offline")
+	}
+	return c.entries, nil
+}
+
+func (c *shareClient) Fetch(_ context.Context, _, _, _, path string, req ContentRequest) (Content, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.offline {
+		return Content{}, errors.New("offline")
+	}
+	c.fetches++
+	data := c.files[path]
+	if req.Range != "" {
+		return Content{Status: http.StatusPartialContent, Body: io.NopCloser(strings.NewReader(data[:1])), Length: 1}, nil
+	}
+	return Content{Status: http.StatusOK, Body: io.NopCloser(strings.NewReader(data)), Length: int64(len(data)), ETag: `"` + data + `"`}, nil
+}
+
+func (*shareClient) Put(_ context.Context, _, _, _, _ string, _ io.ReadSeeker, _ int64, _ string) (string, error) {
+	return "", nil
+}
+
+func (*shareClient) MakeCollection(_ context.Context, _, _, _, _ string) error {
+	return nil
+}
+
+func (*shareClient) Stat(_ context.Context, _, _, _, filePath string) (Entry, error) {
+	return Entry{Path: filePath}, nil
+}
+
+func (c *shareClient) set(path, data string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.files[path] = data
+	c.entries = []Entry{{Path: path, ETag: `"` + data + `"`, Size: int64(len(data))}}
+}
+
+func fetchAll(t *testing.T, svc *Service, bookID string) string {
+	t.Helper()
+	content, err := svc.FetchContent(context.Background(), "user-1", bookID, ContentRequest{})
+	if err != nil {
+		t.Fatalf("fetch: %v", err)
+	}
+	defer content.Body.Close()
+	data, _ := io.ReadAll(content.Body)
+	return string(data)
+}
+
+func TestServiceServesCachedContentOffline(t *testing.T) {
+	booksStore := books.NewMemoryStore()
+	key, _ := ParseKey("00112233445566778899aabbccddeeff00112233445566778899aabbccddeeff")
+	client := &shareClient{files: map[string]string{}}
+	client.set("/A.epub", "first")
+	cache, _ := NewContentCache(t.TempDir(), CacheOptions{Prefetch: time.Hour})
+	svc := NewService(NewMemoryStore(), client, key, booksStore, nil, cache)
+	conn, _ := svc.Create(context.Background(), "user-1", "https://dav.example.com", "reader", "secret", Filter{}, "", "")
+	if err := svc.Sync(context.Background(), "user-1", conn.ID); err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+	book, _ := booksStore.GetBySourcePath("user-1", "/A.epub")
+
+	// A range request is answered by the server and fills the cache in
+	// the background.
+	partial, err := svc.FetchContent(context.Background(), "user-1", book.ID, ContentRequest{Range: "bytes=0-0"})
+	if err != nil || partial.Status != http.StatusPartialContent {
+		t.Fatalf("expected partial content, got %d (%v)", partial.Status, err)
+	}
+	partial.Body.Close()
+	svc.prefetching.Wait()
+	client.offline = true
+	if data := fetchAll(t, svc, book.ID); data != "first" {
+		t.Fatalf("expected cached content while offline, got %q", data)
+	}
+
+	// A changed file that was read recently is prefetched by the sync.
+	client.offline = false
+	client.set("/A.epub", "second")
+	if err := svc.Sync(context.Background(), "user-1", conn.ID); err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+	svc.prefetching.Wait()
+	fetches := client.fetches
+	if data := fetchAll(t, svc, book.ID); data != "second" || client.fetches != fetches {
+		t.Fatalf("expected prefetched content, got %q after %d extra fetches", data, client.fetches-fetches)
+	}
+}
+
+func TestServiceBackgroundContentDoesNotFillCache(t *testing.T) {
+	booksStore := books.NewMemoryStore()
+	key, _ := ParseKey("00112233445566778899aabbccddeeff00112233445566778899aabbccddeeff")
+	client := &shareClient{files: map[string]string{}}
+	client.set("/A.epub", "first")
+	cache, _ := NewContentCache(t.TempDir(), CacheOptions{})
+	svc := NewService(NewMemoryStore(), client, key, booksStore, nil, cache)
+	conn, _ := svc.Create(context.Background(), "user-1", "https://dav.example.com", "reader", "secret", Filter{}, "", "")
+	_ = svc.Sync(context.Background(), "user-1", conn.ID)
+	book, _ := booksStore.GetBySourcePath("user-1", "/A.epub")
+
+	reader, _, err := svc.Background().OpenContent(context.Background(), "user-1", book.ID)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	_, _ = io.ReadAll(reader)
+	reader.Close()
+	if cache.Size() != 0 {
+		t.Fatalf("expected background reads to bypass the cache")
+	}
+	if err := svc.PinBook(context.Background(), "user-1", book.ID, true); err != nil {
+		t.Fatalf("pin: %v", err)
+	}
+	svc.prefetching.Wait()
+	if !cache.Has(conn.ID, "/A.epub", `etag:"first"`) {
+		t.Fatalf("expected pinned book downloaded")
+	}
+}
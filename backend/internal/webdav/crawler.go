@@ -0,0 +1,206 @@
+package webdav
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// List returns every file below baseURL. It first asks for the whole tree
+// with one Depth: infinity PROPFIND and falls back to crawling folder by
+// folder when the server refuses; servers that refused are remembered.
+// Folders rejected by skipDir are never crawled.
+func (c *HTTPClient) List(ctx context.Context, baseURL, username, secret string, skipDir func(dir string) bool) ([]Entry, error) {
+	startURL := strings.TrimRight(baseURL, "/") + "/"
+	parsed, err := url.Parse(startURL)
+	if err != nil {
+		return nil, err
+	}
+	host := parsed.Host
+	var files []Entry
+	pending := []string{startURL}
+	if c.allowsInfinity(host) {
+		result, err := c.propfind(ctx, startURL, "infinity", username, secret)
+		var status statusError
+		switch {
+		case err == nil:
+			files = result.files
+			pending = unlistedDirs(result)
+		case errors.As(err, &status) && refusesInfinity(status.code):
+			c.refuseInfinity(host)
+		default:
+			return nil, err
+		}
+	}
+	crawled, err := c.crawl(ctx, startURL, pending, username, secret, skipDir)
+	if err != nil {
+		return nil, err
+	}
+	files = append(files, crawled...)
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	return files, nil
+}
+
+// refusesInfinity reports whether a status answers a Depth: infinity
+// PROPFIND with "not here" rather than a real failure.
+func refusesInfinity(code int) bool {
+	switch code {
+	case http.StatusBadRequest, http.StatusForbidden, http.StatusMethodNotAllowed, http.StatusNotImplemented:
+		return true
+	}
+	return false
+}
+
+// unlistedDirs returns the folders of a Depth: infinity response with no
+// listed children. They are empty, or the server quietly answered with
+// Depth: 1, so they are crawled to be sure.
+func unlistedDirs(result listResult) []string {
+	parents := make(map[string]struct{})
+	for _, file := range result.files {
+		parents[path.Dir(file.Path)+"/"] = struct{}{}
+	}
+	for _, dir := range result.dirs {
+		if parsed, err := url.Parse(dir); err == nil {
+			parents[path.Dir(strings.TrimSuffix(parsed.Path, "/"))+"/"] = struct{}{}
+		}
+	}
+	var out []string
+	for _, dir := range result.dirs {
+		parsed, err := url.Parse(dir)
+		if err != nil {
+			continue
+		}
+		if _, ok := parents[ensureTrailingSlash(parsed.Path)]; !ok {
+			out = append(out, dir)
+		}
+	}
+	return out
+}
+
+// crawl lists the given folders and everything below them with Depth: 1
+// requests spread over a bounded pool of workers. The first error cancels
+// the remaining requests.
+func (c *HTTPClient) crawl(ctx context.Context, startURL string, dirs []string, username, secret string, skipDir func(string) bool) ([]Entry, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		mu       sync.Mutex
+		cond     = sync.NewCond(&mu)
+		queue    []string
+		seen     = make(map[string]struct{})
+		active   int
+		out      []Entry
+		firstErr error
+	)
+	// enqueue adds a folder once, unless skipDir rejects it.
+	enqueue := func(dir string) {
+		if _, ok := seen[dir]; ok {
+			return
+		}
+		seen[dir] = struct{}{}
+		if skipDir != nil && dir != startURL {
+			if parsed, err := url.Parse(dir); err == nil && skipDir(parsed.Path) {
+				return
+			}
+		}
+		queue = append(queue, dir)
+	}
+	for _, dir := range dirs {
+		enqueue(dir)
+	}
+	// The start folder is listed already when it is not among dirs.
+	seen[startURL] = struct{}{}
+	worker := func() {
+		mu.Lock()
+		defer mu.Unlock()
+		for {
+			for len(queue) == 0 && active > 0 && firstErr == nil {
+				cond.Wait()
+			}
+			if len(queue) == 0 || firstErr != nil {
+				cond.Broadcast()
+				return
+			}
+			dir := queue[0]
+			queue = queue[1:]
+			active++
+			mu.Unlock()
+			result, err := c.propfind(ctx, dir, "1", username, secret)
+			mu.Lock()
+			active--
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+					cancel()
+				}
+				cond.Broadcast()
+				continue
+			}
+			out = append(out, result.files...)
+			for _, sub := range result.dirs {
+				enqueue(sub)
+			}
+			cond.Broadcast()
+		}
+	}
+	var wg sync.WaitGroup
+	for range max(c.options.Workers, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			worker()
+		}()
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return out, nil
+}
+
+func (c *HTTPClient) allowsInfinity(host string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, refused := c.finiteHosts[host]
+	return !refused
+}
+
+func (c *HTTPClient) refuseInfinity(host string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.finiteHosts[host] = struct{}{}
+}
+
+// wait blocks until the rate limit of host allows another request.
+func (c *HTTPClient) wait(ctx context.Context, host string) error {
+	if c.options.RequestsPerSecond <= 0 {
+		return ctx.Err()
+	}
+	interval := time.Duration(float64(time.Second) / c.options.RequestsPerSecond)
+	c.mu.Lock()
+	now := time.Now()
+	slot := c.nextSlot[host]
+	if slot.Before(now) {
+		slot = now
+	}
+	c.nextSlot[host] = slot.Add(interval)
+	c.mu.Unlock()
+	delay := time.Until(slot)
+	if delay <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,177 @@
+package webdav
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// davTree serves PROPFIND for a fixed tree of folders (ending in "/") and
+// files, optionally refusing Depth: infinity.
+type davTree struct {
+	paths    []string
+	infinity bool
+	delay    time.Duration
+
+	mu       sync.Mutex
+	requests []string
+	inFlight int
+	peak     int
+}
+
+func (d *davTree) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	depth := r.Header.Get("Depth")
+	d.mu.Lock()
+	d.requests = append(d.requests, depth+" "+r.URL.Path)
+	d.inFlight++
+	d.peak = max(d.peak, d.inFlight)
+	d.mu.Unlock()
+	defer func() {
+		d.mu.Lock()
+		d.inFlight--
+		d.mu.Unlock()
+	}()
+	time.Sleep(d.delay)
+	if depth == "infinity" && !d.infinity {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	var body strings.Builder
+	body.WriteString(`<?xml version="1.0" encoding="utf-8"?><d:multistatus xmlns:d="DAV:">`)
+	for _, p := range append([]string{"/"}, d.paths...) {
+		if !strings.HasPrefix(p, r.URL.Path) {
+			continue
+		}
+		rest := strings.TrimSuffix(strings.TrimPrefix(p, r.URL.Path), "/")
+		if depth == "1" && strings.Contains(rest, "/") {
+			continue
+		}
+		prop := `<d:getcontentlength>1</d:getcontentlength>`
+		if strings.HasSuffix(p, "/") {
+			prop = `<d:resourcetype><d:collection/></d:resourcetype>`
+		}
+		fmt.Fprintf(&body, `<d:response><d:href>%s</d:href><d:propstat><d:prop>%s</d:prop></d:propstat></d:response>`, p, prop)
+	}
+	body.WriteString(`</d:multistatus>`)
+	w.WriteHeader(http.StatusMultiStatus)
+	_, _ = w.Write([]byte(body.String()))
+}
+
+func (d *davTree) calls() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	out := append([]string(nil), d.requests...)
+	sort.Strings(out)
+	return out
+}
+
+var testTree = []string{
+	"/a/", "/a/1.epub", "/a/deep/", "/a/deep/2.epub",
+	"/b/", "/b/3.epub", "/empty/", "/4.epub",
+}
+
+func TestHTTPClientListUsesDepthInfinity(t *testing.T) {
+	tree := &davTree{paths: testTree, infinity: true}
+	srv := httptest.NewServer(tree)
+	defer srv.Close()
+
+	entries, err := NewHTTPClient(nil, ClientOptions{}).List(context.Background(), srv.URL, "reader", "secret", nil)
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if len(entries) != 4 || entries[0].Path != "/4.epub" || entries[3].Path != "/b/3.epub" {
+		t.Fatalf("unexpected entries %+v", entries)
+	}
+	// Only the empty folder has to be confirmed with a Depth: 1 request.
+	calls := tree.calls()
+	if len(calls) != 2 || calls[0] != "1 /empty/" || calls[1] != "infinity /" {
+		t.Fatalf("unexpected requests %v", calls)
+	}
+}
+
+func TestHTTPClientListFallsBackToCrawling(t *testing.T) {
+	tree := &davTree{paths: testTree, delay: 20 * time.Millisecond}
+	srv := httptest.NewServer(tree)
+	defer srv.Close()
+
+	client := NewHTTPClient(nil, ClientOptions{Workers: 2})
+	for range 2 {
+		entries, err := client.List(context.Background(), srv.URL, "reader", "secret", nil)
+		if err != nil {
+			t.Fatalf("list: %v", err)
+		}
+		if len(entries) != 4 {
+			t.Fatalf("expected 4 entries, got %+v", entries)
+		}
+	}
+	infinity := 0
+	for _, call := range tree.calls() {
+		if strings.HasPrefix(call, "infinity") {
+			infinity++
+		}
+	}
+	if infinity != 1 {
+		t.Fatalf("expected the refusal to be remembered, got %v", tree.calls())
+	}
+	if tree.peak > 2 {
+		t.Fatalf("expected at most 2 concurrent requests, got %d", tree.peak)
+	}
+}
+
+func TestHTTPClientListSkipsFolders(t *testing.T) {
+	tree := &davTree{paths: append([]string{"/.Trash/", "/.Trash/old/", "/.Trash/5.epub"}, testTree...)}
+	srv := httptest.NewServer(tree)
+	defer srv.Close()
+
+	filter, _ := Filter{Exclude: []string{".Trash"}, MaxDepth: 2}.Normalize()
+	skip := func(dir string) bool { return filter.SkipDir("/", dir) }
+	entries, err := NewHTTPClient(nil, ClientOptions{}).List(context.Background(), srv.URL, "reader", "secret", skip)
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("unexpected entries %+v", entries)
+	}
+	for _, call := range tree.calls() {
+		if strings.Contains(call, ".Trash") || strings.Contains(call, "/deep/") {
+			t.Fatalf("expected skipped folders not to be listed, got %v", tree.calls())
+		}
+	}
+}
+
+func TestHTTPClientListHonoursContext(t *testing.T) {
+	tree := &davTree{paths: testTree, delay: 50 * time.Millisecond}
+	srv := httptest.NewServer(tree)
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := NewHTTPClient(nil, ClientOptions{}).List(ctx, srv.URL, "reader", "secret", nil)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline error, got %v", err)
+	}
+}
+
+func TestHTTPClientRateLimitsPerHost(t *testing.T) {
+	client := NewHTTPClient(nil, ClientOptions{RequestsPerSecond: 50})
+	start := time.Now()
+	for range 4 {
+		if err := client.wait(context.Background(), "dav.example.com"); err != nil {
+			t.Fatalf("wait: %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 55*time.Millisecond {
+		t.Fatalf("expected requests to be spaced out, took %v", elapsed)
+	}
+	start = time.Now()
+	if err := client.wait(context.Background(), "other.example.com"); err != nil || time.Since(start) > 10*time.Millisecond {
+		t.Fatalf("expected other hosts to be unaffected")
+	}
+}
@@ -0,0 +1,181 @@
+package webdav
+
+import (
+	"errors"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/EROQIN/relite-reader/backend/internal/formats"
+)
+
+var ErrInvalidFilter = errors.New("invalid webdav filter")
+
+// Filter limits which files of a connection are indexed. Patterns are
+// matched against the path relative to RootPath: a pattern without a slash
+// matches any single path segment ("*.epub", ".Trash"), otherwise it is
+// matched segment by segment, where "**" spans any number of folders.
+type Filter struct {
+	// RootPath is the library folder below the base URL; empty means the
+	// base URL itself.
+	RootPath string
+	Include  []string
+	Exclude  []string
+	// MaxDepth counts the folders below the root a file may sit in: 0 is
+	// unlimited and 1 keeps only files directly in the root.
+	MaxDepth      int
+	SupportedOnly bool
+}
+
+// Normalize cleans the root path and rejects malformed patterns.
+func (f Filter) Normalize() (Filter, error) {
+	if f.MaxDepth < 0 {
+		return Filter{}, ErrInvalidFilter
+	}
+	root := strings.TrimSpace(f.RootPath)
+	if root != "" {
+		root = strings.TrimSuffix(path.Clean("/"+root), "/")
+	}
+	f.RootPath = root
+	var err error
+	if f.Include, err = cleanPatterns(f.Include); err != nil {
+		return Filter{}, err
+	}
+	if f.Exclude, err = cleanPatterns(f.Exclude); err != nil {
+		return Filter{}, err
+	}
+	return f, nil
+}
+
+func cleanPatterns(patterns []string) ([]string, error) {
+	var out []string
+	for _, pattern := range patterns {
+		pattern = strings.Trim(strings.TrimSpace(pattern), "/")
+		if pattern == "" {
+			continue
+		}
+		for _, segment := range strings.Split(pattern, "/") {
+			if _, err := path.Match(segment, ""); err != nil {
+				return nil, ErrInvalidFilter
+			}
+		}
+		out = append(out, pattern)
+	}
+	return out, nil
+}
+
+// listURL returns the URL of the library root.
+func (f Filter) listURL(baseURL string) (string, error) {
+	if f.RootPath == "" {
+		return baseURL, nil
+	}
+	return url.JoinPath(baseURL, strings.Split(strings.TrimPrefix(f.RootPath, "/"), "/")...)
+}
+
+// Match reports whether a file listed below root should be indexed. root
+// is the decoded server path of the library root.
+func (f Filter) Match(root, filePath string) bool {
+	prefix := strings.TrimSuffix(root, "/") + "/"
+	if !strings.HasPrefix(filePath, prefix) {
+		return false
+	}
+	rel := strings.TrimPrefix(filePath, prefix)
+	if rel == "" {
+		return false
+	}
+	segments := strings.Split(rel, "/")
+	if f.MaxDepth > 0 && len(segments) > f.MaxDepth {
+		return false
+	}
+	if f.SupportedOnly {
+		if _, ok := formats.Detect(filePath); !ok {
+			return false
+		}
+	}
+	if len(f.Include) > 0 && !matchAny(f.Include, segments) {
+		return false
+	}
+	return !matchAny(f.Exclude, segments)
+}
+
+// rootPath returns the decoded server path of the library root.
+func rootPath(listURL string) string {
+	if parsed, err := url.Parse(listURL); err == nil {
+		return parsed.Path
+	}
+	return "/"
+}
+
+// dirSkipper returns the SkipDir predicate for listing listURL.
+func (f Filter) dirSkipper(listURL string) func(string) bool {
+	root := rootPath(listURL)
+	return func(dir string) bool {
+		return f.SkipDir(root, dir)
+	}
+}
+
+// SkipDir reports whether no file below a folder can pass Match, so the
+// folder need not be listed at all: it sits at MaxDepth or deeper, or it
+// is excluded by a segment pattern or by a pattern ending in "**". dirPath
+// is the decoded server path of the folder.
+func (f Filter) SkipDir(root, dirPath string) bool {
+	prefix := strings.TrimSuffix(root, "/") + "/"
+	rel := strings.Trim(strings.TrimPrefix(dirPath, prefix), "/")
+	if !strings.HasPrefix(dirPath, prefix) || rel == "" {
+		return false
+	}
+	segments := strings.Split(rel, "/")
+	if f.MaxDepth > 0 && len(segments) >= f.MaxDepth {
+		return true
+	}
+	for _, pattern := range f.Exclude {
+		if !strings.Contains(pattern, "/") || strings.HasSuffix(pattern, "/**") {
+			if matchPattern(pattern, segments) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func matchAny(patterns, segments []string) bool {
+	for _, pattern := range patterns {
+		if matchPattern(pattern, segments) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchPattern(pattern string, segments []string) bool {
+	if !strings.Contains(pattern, "/") {
+		for _, segment := range segments {
+			if ok, _ := path.Match(pattern, segment); ok {
+				return true
+			}
+		}
+		return false
+	}
+	return matchSegments(strings.Split(pattern, "/"), segments)
+}
+
+func matchSegments(pattern, segments []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(segments); i++ {
+				if matchSegments(pattern[1:], segments[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(segments) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], segments[0]); !ok {
+			return false
+		}
+		pattern, segments = pattern[1:], segments[1:]
+	}
+	return len(segments) == 0
+}
@@ -0,0 +1,75 @@
+package webdav
+
+import "testing"
+
+func TestFilterMatch(t *testing.T) {
+	filter, err := Filter{
+		RootPath:      "Books/",
+		Include:       []string{"Fiction/**", "*.pdf"},
+		Exclude:       []string{".Trash", "**/drafts/*"},
+		MaxDepth:      3,
+		SupportedOnly: true,
+	}.Normalize()
+	if err != nil {
+		t.Fatalf("normalize: %v", err)
+	}
+	if filter.RootPath != "/Books" {
+		t.Fatalf("expected cleaned root, got %q", filter.RootPath)
+	}
+	cases := map[string]bool{
+		"/dav/Books/Fiction/A.epub":          true,
+		"/dav/Books/Fiction/Tolkien/B.epub":  true,
+		"/dav/Books/manual.pdf":              true,
+		"/dav/Books/Notes/C.epub":            false,
+		"/dav/Books/Fiction/.Trash/D.epub":   false,
+		"/dav/Books/Fiction/x/drafts/E.epub": false,
+		"/dav/Books/Fiction/a/b/F.epub":      false,
+		"/dav/Books/Fiction/photo.jpg":       false,
+		"/dav/Other/G.epub":                  false,
+	}
+	for name, want := range cases {
+		if got := filter.Match("/dav/Books/", name); got != want {
+			t.Fatalf("match %s: expected %v, got %v", name, want, got)
+		}
+	}
+}
+
+func TestFilterSkipDir(t *testing.T) {
+	filter, _ := Filter{Exclude: []string{".Trash", "Backups/**", "**/drafts/*"}, MaxDepth: 3}.Normalize()
+	cases := map[string]bool{
+		"/dav/Books/":                false,
+		"/dav/Books/Fiction/":        false,
+		"/dav/Books/Fiction/.Trash/": true,
+		"/dav/Books/Backups/":        true,
+		"/dav/Books/Backups/2024/":   true,
+		"/dav/Books/x/drafts/":       false,
+		"/dav/Books/a/b/":            false,
+		"/dav/Books/a/b/c/":          true,
+		"/dav/Other/.Trash/":         false,
+	}
+	for dir, want := range cases {
+		if got := filter.SkipDir("/dav/Books", dir); got != want {
+			t.Fatalf("skip %s: expected %v, got %v", dir, want, got)
+		}
+	}
+}
+
+func TestFilterRejectsBadPatterns(t *testing.T) {
+	if _, err := (Filter{Include: []string{"[a-"}}).Normalize(); err != ErrInvalidFilter {
+		t.Fatalf("expected invalid filter, got %v", err)
+	}
+	if _, err := (Filter{MaxDepth: -1}).Normalize(); err != ErrInvalidFilter {
+		t.Fatalf("expected invalid filter, got %v", err)
+	}
+}
+
+func TestFilterListURL(t *testing.T) {
+	filter, _ := Filter{RootPath: "My Books"}.Normalize()
+	got, err := filter.listURL("https://dav.example.com/remote.php/dav/files/reader/")
+	if err != nil {
+		t.Fatalf("list url: %v", err)
+	}
+	if got != "https://dav.example.com/remote.php/dav/files/reader/My%20Books" {
+		t.Fatalf("unexpected list url %q", got)
+	}
+}
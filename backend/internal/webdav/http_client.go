@@ -2,18 +2,50 @@ package webdav
 
 import (
 	"bytes"
+	"context"
 	"encoding/xml"
-	"errors"
 	"io"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
+// defaultWorkers is the number of concurrent PROPFIND requests of one
+// listing when ClientOptions leaves it unset.
+const defaultWorkers = 4
+
+// ClientOptions tunes how HTTPClient talks to a server.
+type ClientOptions struct {
+	// Workers bounds the concurrent PROPFIND requests of one listing.
+	Workers int
+	// RequestsPerSecond limits the requests sent to each host; 0 disables
+	// the limit.
+	RequestsPerSecond float64
+	// ConnectTimeout bounds dialing and the TLS handshake of the client
+	// built by NewHTTPClient when none is given.
+	ConnectTimeout time.Duration
+	// ReadTimeout bounds the wait for response headers and any stall
+	// while reading a response body.
+	ReadTimeout time.Duration
+	// Retries is the number of extra attempts of a failed PROPFIND or GET;
+	// negative disables retries.
+	Retries int
+	// RetryDelay is the first backoff delay, doubled on each retry.
+	RetryDelay time.Duration
+}
+
 type HTTPClient struct {
 	httpClient *http.Client
+	options    ClientOptions
+
+	mu sync.Mutex
+	// finiteHosts are the hosts that refused a Depth: infinity PROPFIND.
+	finiteHosts map[string]struct{}
+	// nextSlot is the earliest time of the next request to each host.
+	nextSlot map[string]time.Time
 }
 
 type listResult struct {
@@ -21,72 +53,94 @@ type listResult struct {
 	dirs  []string
 }
 
-func NewHTTPClient(client *http.Client) *HTTPClient {
+// NewHTTPClient fills unset options with defaults. When client is nil, one
+// with the connect and read timeouts of the options is built.
+func NewHTTPClient(client *http.Client, options ClientOptions) *HTTPClient {
+	if options.Workers <= 0 {
+		options.Workers = defaultWorkers
+	}
+	if options.ConnectTimeout <= 0 {
+		options.ConnectTimeout = defaultConnectTimeout
+	}
+	if options.ReadTimeout <= 0 {
+		options.ReadTimeout = defaultReadTimeout
+	}
+	if options.Retries == 0 {
+		options.Retries = defaultRetries
+	}
+	if options.RetryDelay <= 0 {
+		options.RetryDelay = defaultRetryDelay
+	}
 	if client == nil {
-		client = http.DefaultClient
+		client = &http.Client{Transport: newTransport(options)}
+	}
+	return &HTTPClient{
+		httpClient:  client,
+		options:     options,
+		finiteHosts: make(map[string]struct{}),
+		nextSlot:    make(map[string]time.Time),
 	}
-	return &HTTPClient{httpClient: client}
 }
 
-func (c *HTTPClient) List(baseURL, username, secret string) ([]Entry, error) {
-	startURL := strings.TrimRight(baseURL, "/") + "/"
-	queue := []string{startURL}
-	seen := map[string]struct{}{startURL: {}}
-	var out []Entry
-	for len(queue) > 0 {
-		current := queue[0]
-		queue = queue[1:]
-		result, err := c.listDepthOne(current, username, secret)
-		if err != nil {
-			return nil, err
-		}
-		out = append(out, result.files...)
-		for _, dir := range result.dirs {
-			if _, ok := seen[dir]; ok {
-				continue
-			}
-			seen[dir] = struct{}{}
-			queue = append(queue, dir)
+func (c *HTTPClient) propfind(ctx context.Context, targetURL, depth, username, secret string) (listResult, error) {
+	parsed, err := c.propfindEntries(ctx, targetURL, depth, username, secret)
+	if err != nil {
+		return listResult{}, err
+	}
+	return classifyEntries(targetURL, parsed), nil
+}
+
+// Stat reads the size, modification time and ETag of one file with a
+// Depth: 0 PROPFIND.
+func (c *HTTPClient) Stat(ctx context.Context, baseURL, username, secret, filePath string) (Entry, error) {
+	target, err := resourceURL(baseURL, filePath)
+	if err != nil {
+		return Entry{}, err
+	}
+	parsed, err := c.propfindEntries(ctx, target, "0", username, secret)
+	if err != nil {
+		return Entry{}, err
+	}
+	for _, entry := range parsed {
+		if !entry.IsDir {
+			return Entry{Path: filePath, Size: entry.Size, ModTime: entry.Modified, ETag: entry.ETag}, nil
 		}
 	}
-	return out, nil
+	return Entry{}, statusError{code: http.StatusNotFound}
 }
 
-func (c *HTTPClient) listDepthOne(targetURL, username, secret string) (listResult, error) {
+func (c *HTTPClient) propfindEntries(ctx context.Context, targetURL, depth, username, secret string) ([]parsedEntry, error) {
 	body := `<?xml version="1.0" encoding="utf-8"?>
 <d:propfind xmlns:d="DAV:">
   <d:prop>
     <d:getcontentlength />
     <d:getlastmodified />
+    <d:getetag />
     <d:resourcetype />
   </d:prop>
 </d:propfind>`
-	req, err := http.NewRequest("PROPFIND", targetURL, bytes.NewBufferString(body))
+	req, err := http.NewRequestWithContext(ctx, "PROPFIND", targetURL, bytes.NewBufferString(body))
 	if err != nil {
-		return listResult{}, err
+		return nil, err
 	}
 	req.SetBasicAuth(username, secret)
-	req.Header.Set("Depth", "1")
+	req.Header.Set("Depth", depth)
 	req.Header.Set("Content-Type", "application/xml")
 
-	resp, err := c.httpClient.Do(req)
+	resp, err := c.do(ctx, req)
 	if err != nil {
-		return listResult{}, err
+		return nil, err
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusMultiStatus && resp.StatusCode != http.StatusOK {
-		return listResult{}, errors.New("unexpected status")
+		return nil, statusError{code: resp.StatusCode}
 	}
 	data, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return listResult{}, err
+		return nil, err
 	}
 
-	parsed, err := parseMultiStatus(data)
-	if err != nil {
-		return listResult{}, err
-	}
-	return classifyEntries(targetURL, parsed), nil
+	return parseMultiStatus(data)
 }
 
 type multistatus struct {
@@ -106,6 +160,7 @@ type prop struct {
 	ResourceType resourcetype `xml:"resourcetype"`
 	ContentLen   string       `xml:"getcontentlength"`
 	Modified     string       `xml:"getlastmodified"`
+	ETag         string       `xml:"getetag"`
 }
 
 type resourcetype struct {
@@ -117,6 +172,7 @@ type parsedEntry struct {
 	IsDir    bool
 	Size     int64
 	Modified time.Time
+	ETag     string
 }
 
 func parseMultiStatus(raw []byte) ([]parsedEntry, error) {
@@ -141,6 +197,9 @@ func parseMultiStatus(raw []byte) ([]parsedEntry, error) {
 					entry.Modified = ts
 				}
 			}
+			if propstat.Prop.ETag != "" {
+				entry.ETag = strings.TrimSpace(propstat.Prop.ETag)
+			}
 		}
 		out = append(out, entry)
 	}
@@ -174,6 +233,7 @@ func classifyEntries(baseURL string, entries []parsedEntry) listResult {
 			Path:    resolvedPath,
 			Size:    entry.Size,
 			ModTime: entry.Modified,
+			ETag:    entry.ETag,
 		})
 	}
 	return result
@@ -199,24 +259,133 @@ func ensureTrailingSlash(p string) string {
 	return p + "/"
 }
 
-func (c *HTTPClient) Fetch(baseURL, username, secret, sourcePath string) (io.ReadCloser, string, error) {
-	parsed, err := url.Parse(baseURL)
+// Fetch downloads a file. Range and conditional headers of req are passed
+// through, so the result may be a part of the file or a 304.
+func (c *HTTPClient) Fetch(ctx context.Context, baseURL, username, secret, sourcePath string, req ContentRequest) (Content, error) {
+	target, err := resourceURL(baseURL, sourcePath)
 	if err != nil {
-		return nil, "", err
+		return Content{}, err
 	}
-	target := parsed.Scheme + "://" + parsed.Host + sourcePath
-	req, err := http.NewRequest(http.MethodGet, target, nil)
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
 	if err != nil {
-		return nil, "", err
+		return Content{}, err
 	}
-	req.SetBasicAuth(username, secret)
-	resp, err := c.httpClient.Do(req)
+	httpReq.SetBasicAuth(username, secret)
+	for name, value := range map[string]string{
+		"Range":             req.Range,
+		"If-Range":          req.IfRange,
+		"If-None-Match":     req.IfNoneMatch,
+		"If-Modified-Since": req.IfModifiedSince,
+	} {
+		if value != "" {
+			httpReq.Header.Set(name, value)
+		}
+	}
+	resp, err := c.do(ctx, httpReq)
 	if err != nil {
-		return nil, "", err
+		return Content{}, err
+	}
+	content := Content{
+		Status:       resp.StatusCode,
+		ContentType:  resp.Header.Get("Content-Type"),
+		Length:       resp.ContentLength,
+		ContentRange: resp.Header.Get("Content-Range"),
+		AcceptRanges: resp.Header.Get("Accept-Ranges") == "bytes" || resp.StatusCode == http.StatusPartialContent,
+		ETag:         resp.Header.Get("ETag"),
 	}
-	if resp.StatusCode != http.StatusOK {
+	if modified, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		content.LastModified = modified
+	}
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusPartialContent:
+		content.Body = resp.Body
+		return content, nil
+	case http.StatusNotModified, http.StatusRequestedRangeNotSatisfiable:
 		resp.Body.Close()
-		return nil, "", errors.New("unexpected status")
+		content.Length = 0
+		return content, nil
+	}
+	resp.Body.Close()
+	return Content{}, statusError{code: resp.StatusCode}
+}
+
+// Put uploads a file, streaming size bytes of body. A non-empty ifMatch
+// only replaces that version, "*" any existing one; an empty ifMatch only
+// creates the file. A version mismatch returns ErrPreconditionFailed.
+func (c *HTTPClient) Put(ctx context.Context, baseURL, username, secret, filePath string, body io.ReadSeeker, size int64, ifMatch string) (string, error) {
+	target, err := resourceURL(baseURL, filePath)
+	if err != nil {
+		return "", err
+	}
+	start, err := body.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, target, io.NopCloser(body))
+	if err != nil {
+		return "", err
+	}
+	req.ContentLength = size
+	// A redirect sends the body again from where it started.
+	req.GetBody = func() (io.ReadCloser, error) {
+		if _, err := body.Seek(start, io.SeekStart); err != nil {
+			return nil, err
+		}
+		return io.NopCloser(body), nil
+	}
+	req.SetBasicAuth(username, secret)
+	if ifMatch != "" {
+		req.Header.Set("If-Match", ifMatch)
+	} else {
+		req.Header.Set("If-None-Match", "*")
+	}
+	resp, err := c.send(ctx, req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated, http.StatusNoContent:
+		return resp.Header.Get("ETag"), nil
+	case http.StatusPreconditionFailed:
+		return "", ErrPreconditionFailed
+	}
+	return "", statusError{code: resp.StatusCode}
+}
+
+// MakeCollection creates a folder. A folder that already exists is not an
+// error.
+func (c *HTTPClient) MakeCollection(ctx context.Context, baseURL, username, secret, dirPath string) error {
+	target, err := resourceURL(baseURL, ensureTrailingSlash(dirPath))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "MKCOL", target, nil)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(username, secret)
+	resp, err := c.send(ctx, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	switch resp.StatusCode {
+	case http.StatusCreated, http.StatusOK, http.StatusNoContent, http.StatusMethodNotAllowed:
+		// 405 is the answer for an existing collection.
+		return nil
+	}
+	return statusError{code: resp.StatusCode}
+}
+
+// resourceURL points a server path at the host of baseURL.
+func resourceURL(baseURL, resourcePath string) (string, error) {
+	parsed, err := url.Parse(baseURL)
+	if err != nil {
+		return "", err
 	}
-	return resp.Body, resp.Header.Get("Content-Type"), nil
+	target := url.URL{Scheme: parsed.Scheme, Host: parsed.Host, Path: resourcePath}
+	return target.String(), nil
 }
@@ -1,8 +1,12 @@
 package webdav
 
 import (
+	"context"
+	"errors"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 )
 
@@ -26,6 +30,7 @@ func TestHTTPClientParsesEntries(t *testing.T) {
       <d:prop>
         <d:getcontentlength>123</d:getcontentlength>
         <d:getlastmodified>Mon, 02 Jan 2006 15:04:05 GMT</d:getlastmodified>
+        <d:getetag>"a1b2"</d:getetag>
       </d:prop>
     </d:propstat>
   </d:response>
@@ -33,8 +38,8 @@ func TestHTTPClientParsesEntries(t *testing.T) {
 	}))
 	defer srv.Close()
 
-	client := NewHTTPClient(http.DefaultClient)
-	entries, err := client.listDepthOne(srv.URL, "user", "pass")
+	client := NewHTTPClient(http.DefaultClient, ClientOptions{})
+	entries, err := client.propfind(context.Background(), srv.URL, "1", "user", "pass")
 	if err != nil {
 		t.Fatalf("list: %v", err)
 	}
@@ -44,6 +49,9 @@ func TestHTTPClientParsesEntries(t *testing.T) {
 	if len(entries.dirs) != 1 {
 		t.Fatalf("expected 1 dir, got %d", len(entries.dirs))
 	}
+	if file := entries.files[0]; file.Size != 123 || file.ETag != `"a1b2"` || file.ModTime.IsZero() {
+		t.Fatalf("unexpected file entry %+v", file)
+	}
 }
 
 func TestHTTPClientListsRecursively(t *testing.T) {
@@ -103,8 +111,8 @@ func TestHTTPClientListsRecursively(t *testing.T) {
 	}))
 	defer srv.Close()
 
-	client := NewHTTPClient(http.DefaultClient)
-	entries, err := client.List(srv.URL, "reader", "secret")
+	client := NewHTTPClient(http.DefaultClient, ClientOptions{})
+	entries, err := client.List(context.Background(), srv.URL, "reader", "secret", nil)
 	if err != nil {
 		t.Fatalf("list: %v", err)
 	}
@@ -115,3 +123,84 @@ func TestHTTPClientListsRecursively(t *testing.T) {
 		t.Fatalf("expected recursive calls")
 	}
 }
+
+func TestHTTPClientPutIsConditional(t *testing.T) {
+	var ifMatch, ifNoneMatch string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Method != http.MethodPut || r.URL.Path != "/library/A book.epub.relite.json" || r.ContentLength != 2 || string(body) != "{}" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		ifMatch = r.Header.Get("If-Match")
+		ifNoneMatch = r.Header.Get("If-None-Match")
+		if ifMatch == `"old"` {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		w.Header().Set("ETag", `"new"`)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer srv.Close()
+
+	client := NewHTTPClient(http.DefaultClient, ClientOptions{})
+	etag, err := client.Put(context.Background(), srv.URL, "reader", "secret", "/library/A book.epub.relite.json", strings.NewReader("{}"), 2, "")
+	if err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	if etag != `"new"` || ifNoneMatch != "*" || ifMatch != "" {
+		t.Fatalf("unexpected put: etag %q if-match %q if-none-match %q", etag, ifMatch, ifNoneMatch)
+	}
+	_, err = client.Put(context.Background(), srv.URL, "reader", "secret", "/library/A book.epub.relite.json", strings.NewReader("{}"), 2, `"old"`)
+	if !errors.Is(err, ErrPreconditionFailed) {
+		t.Fatalf("expected precondition failed, got %v", err)
+	}
+}
+
+func TestHTTPClientStatReadsOneFile(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "PROPFIND" || r.Header.Get("Depth") != "0" || r.URL.Path != "/library/A.epub" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusMultiStatus)
+		_, _ = w.Write([]byte(`<?xml version="1.0"?>
+<d:multistatus xmlns:d="DAV:">
+  <d:response>
+    <d:href>/library/A.epub</d:href>
+    <d:propstat><d:prop>
+      <d:getcontentlength>42</d:getcontentlength>
+      <d:getlastmodified>Wed, 01 May 2024 10:00:00 GMT</d:getlastmodified>
+      <d:getetag>"v1"</d:getetag>
+      <d:resourcetype/>
+    </d:prop></d:propstat>
+  </d:response>
+</d:multistatus>`))
+	}))
+	defer srv.Close()
+
+	client := NewHTTPClient(http.DefaultClient, ClientOptions{})
+	entry, err := client.Stat(context.Background(), srv.URL, "reader", "secret", "/library/A.epub")
+	if err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+	if entry.Path != "/library/A.epub" || entry.Size != 42 || entry.ETag != `"v1"` || entry.ModTime.IsZero() {
+		t.Fatalf("unexpected entry %+v", entry)
+	}
+}
+
+func TestHTTPClientMakeCollectionAcceptsExisting(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "MKCOL" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}))
+	defer srv.Close()
+
+	client := NewHTTPClient(http.DefaultClient, ClientOptions{})
+	if err := client.MakeCollection(context.Background(), srv.URL, "reader", "secret", "/library/A.sdr"); err != nil {
+		t.Fatalf("mkcol: %v", err)
+	}
+}
@@ -10,10 +10,14 @@ import (
 type MemoryStore struct {
 	mu    sync.Mutex
 	items map[string]map[string]Connection
+	files map[string][]FileState
 }
 
 func NewMemoryStore() *MemoryStore {
-	return &MemoryStore{items: make(map[string]map[string]Connection)}
+	return &MemoryStore{
+		items: make(map[string]map[string]Connection),
+		files: make(map[string][]FileState),
+	}
 }
 
 func (s *MemoryStore) Create(userID string, conn Connection) (Connection, error) {
@@ -77,10 +81,11 @@ func (s *MemoryStore) Delete(userID, id string) error {
 		return ErrNotFound
 	}
 	delete(s.items[userID], id)
+	delete(s.files, id)
 	return nil
 }
 
-func (s *MemoryStore) UpdateSyncStatus(userID, id, status, lastError string) (Connection, error) {
+func (s *MemoryStore) UpdateSyncStatus(userID, id, status, lastError string, result SyncResult) (Connection, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	conn, ok := s.items[userID][id]
@@ -90,10 +95,61 @@ func (s *MemoryStore) UpdateSyncStatus(userID, id, status, lastError string) (Co
 	conn.LastSyncStatus = status
 	conn.LastError = lastError
 	conn.LastSyncAt = time.Now()
+	conn.LastSyncResult = result
 	s.items[userID][id] = conn
 	return conn, nil
 }
 
+func (s *MemoryStore) SetNextSync(userID, id string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	conn, ok := s.items[userID][id]
+	if !ok {
+		return ErrNotFound
+	}
+	conn.NextSyncAt = at
+	s.items[userID][id] = conn
+	return nil
+}
+
+func (s *MemoryStore) ListFiles(connectionID string) ([]FileState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]FileState(nil), s.files[connectionID]...), nil
+}
+
+func (s *MemoryStore) GetFile(connectionID, path string) (FileState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, file := range s.files[connectionID] {
+		if file.Path == path {
+			return file, nil
+		}
+	}
+	return FileState{}, ErrFileNotFound
+}
+
+func (s *MemoryStore) ReplaceFiles(connectionID string, files []FileState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.files[connectionID] = append([]FileState(nil), files...)
+	return nil
+}
+
+func (s *MemoryStore) PutFile(connectionID string, file FileState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	files := s.files[connectionID]
+	for i := range files {
+		if files[i].Path == file.Path {
+			files[i] = file
+			return nil
+		}
+	}
+	s.files[connectionID] = append(files, file)
+	return nil
+}
+
 func newID() string {
 	buf := make([]byte, 16)
 	_, _ = rand.Read(buf)
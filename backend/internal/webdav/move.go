@@ -0,0 +1,129 @@
+package webdav
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"sort"
+
+	"github.com/EROQIN/relite-reader/backend/internal/books"
+)
+
+// detectMoves pairs new paths with removed files of the same size that
+// still have a book, so the book can follow the file. A pair is made by
+// equal ETags first, then by equal modification times, and last by
+// downloading the new file and comparing its hash with the one recorded by
+// the format task. Ambiguous matches are left alone.
+func (s *Service) detectMoves(ctx context.Context, conn Connection, secret, userID string, added []Entry, removed map[string]FileState) map[string]books.Book {
+	if s.books == nil || len(added) == 0 || len(removed) == 0 {
+		return nil
+	}
+	var candidates []movedFile
+	for filePath, state := range removed {
+		book, err := s.books.GetBySourcePath(userID, filePath)
+		if err != nil || book.ConnectionID != conn.ID {
+			continue
+		}
+		candidates = append(candidates, movedFile{state: state, book: book})
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].state.Path < candidates[j].state.Path })
+	moves := make(map[string]books.Book)
+	taken := make(map[string]bool)
+	pair := func(entry Entry, same func(movedFile) bool) {
+		if _, ok := moves[entry.Path]; ok {
+			return
+		}
+		var found *movedFile
+		for i := range candidates {
+			candidate := &candidates[i]
+			if taken[candidate.state.Path] || candidate.state.Size != entry.Size || !same(*candidate) {
+				continue
+			}
+			if found != nil {
+				return
+			}
+			found = candidate
+		}
+		if found != nil {
+			moves[entry.Path] = found.book
+			taken[found.state.Path] = true
+		}
+	}
+	for _, entry := range added {
+		if entry.ETag == "" {
+			continue
+		}
+		pair(entry, func(c movedFile) bool { return c.state.ETag == entry.ETag })
+	}
+	for _, entry := range added {
+		if entry.ModTime.IsZero() {
+			continue
+		}
+		pair(entry, func(c movedFile) bool { return c.state.ModTime.Equal(entry.ModTime) })
+	}
+	for _, entry := range added {
+		if _, ok := moves[entry.Path]; ok || !hashCandidate(candidates, taken, entry) {
+			continue
+		}
+		hash, err := s.hashFile(ctx, conn, secret, entry.Path)
+		if err != nil {
+			continue
+		}
+		pair(entry, func(c movedFile) bool { return c.book.ContentHash == hash })
+	}
+	return moves
+}
+
+type movedFile struct {
+	state FileState
+	book  books.Book
+}
+
+// hashCandidate reports whether a removed file could match the entry by
+// hash, which is worth a download.
+func hashCandidate(candidates []movedFile, taken map[string]bool, entry Entry) bool {
+	for _, candidate := range candidates {
+		if !taken[candidate.state.Path] && candidate.state.Size == entry.Size && candidate.book.ContentHash != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Service) hashFile(ctx context.Context, conn Connection, secret, filePath string) (string, error) {
+	content, err := s.client.Fetch(ctx, conn.BaseURL, conn.Username, secret, filePath, ContentRequest{})
+	if err != nil {
+		return "", err
+	}
+	if content.Body == nil {
+		return "", errors.New("empty webdav response")
+	}
+	defer content.Body.Close()
+	if content.Status != http.StatusOK {
+		return "", errors.New("unexpected webdav response")
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(hash, content.Body); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// moveBook points a book at the new path of its file and carries its pin
+// along.
+func (s *Service) moveBook(userID, connectionID string, book books.Book, newPath string) error {
+	if _, err := s.books.Move(userID, book.ID, newPath); err != nil {
+		return err
+	}
+	if s.cache != nil && s.cache.Pinned(connectionID, book.SourcePath) {
+		_ = s.cache.SetPinned(connectionID, book.SourcePath, false)
+		_ = s.cache.SetPinned(connectionID, newPath, true)
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package webdav
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/EROQIN/relite-reader/backend/internal/books"
+	"github.com/EROQIN/relite-reader/backend/internal/tasks"
+)
+
+type moveClient struct {
+	fakeClient
+	data    map[string][]byte
+	fetched []string
+}
+
+func (c *moveClient) Fetch(_ context.Context, _, _, _, filePath string, _ ContentRequest) (Content, error) {
+	c.fetched = append(c.fetched, filePath)
+	return Content{Status: http.StatusOK, Body: io.NopCloser(bytes.NewReader(c.data[filePath]))}, nil
+}
+
+func TestServiceSyncFollowsMovedFiles(t *testing.T) {
+	store := NewMemoryStore()
+	booksStore := books.NewMemoryStore()
+	taskStore := tasks.NewMemoryStore()
+	queue := newTestQueue(taskStore)
+	key, _ := ParseKey("00112233445566778899aabbccddeeff00112233445566778899aabbccddeeff")
+	modified := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	client := &moveClient{data: map[string][]byte{"/library/Sorted/C.txt": []byte("plain text")}}
+	client.entries = []Entry{
+		{Path: "/library/A.epub", Size: 10, ETag: `"a1"`},
+		{Path: "/library/B.pdf", Size: 20, ModTime: modified},
+		{Path: "/library/C.txt", Size: 10},
+		{Path: "/library/D.epub", Size: 30},
+	}
+	svc := NewService(store, client, key, booksStore, queue, nil)
+	conn, _ := svc.Create(context.Background(), "user-1", "https://dav.example.com", "reader", "secret", Filter{}, "", "")
+	if err := svc.Sync(context.Background(), "user-1", conn.ID); err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+	before := make(map[string]books.Book)
+	for _, filePath := range []string{"/library/A.epub", "/library/B.pdf", "/library/C.txt", "/library/D.epub"} {
+		book, _ := booksStore.GetBySourcePath("user-1", filePath)
+		before[filePath] = book
+	}
+	// The format task records the hash of C.txt.
+	sum := sha256.Sum256([]byte("plain text"))
+	hashed := before["/library/C.txt"]
+	hashed.ContentHash = hex.EncodeToString(sum[:])
+	_, _ = booksStore.Upsert("user-1", hashed)
+	tasksBefore, _ := taskStore.ListByUser("user-1")
+
+	client.entries = []Entry{
+		{Path: "/library/Sorted/A.epub", Size: 10, ETag: `"a1"`},
+		{Path: "/library/Sorted/Bee.pdf", Size: 20, ModTime: modified},
+		{Path: "/library/Sorted/C.txt", Size: 10},
+		{Path: "/library/Sorted/E.epub", Size: 30},
+	}
+	if err := svc.Sync(context.Background(), "user-1", conn.ID); err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+	synced, _ := store.GetByID("user-1", conn.ID)
+	if synced.LastSyncResult != (SyncResult{Added: 1, Removed: 1, Moved: 3}) {
+		t.Fatalf("unexpected result %+v", synced.LastSyncResult)
+	}
+	for from, to := range map[string]string{
+		"/library/A.epub": "/library/Sorted/A.epub",
+		"/library/B.pdf":  "/library/Sorted/Bee.pdf",
+		"/library/C.txt":  "/library/Sorted/C.txt",
+	} {
+		moved, err := booksStore.GetBySourcePath("user-1", to)
+		if err != nil || moved.ID != before[from].ID || moved.Missing {
+			t.Fatalf("expected %s to follow to %s, got %+v (%v)", from, to, moved, err)
+		}
+	}
+	// D.epub has no ETag, modification time or hash to go by.
+	removed, _ := booksStore.GetBySourcePath("user-1", "/library/D.epub")
+	if !removed.Missing {
+		t.Fatalf("expected unmatched file marked missing")
+	}
+	if len(client.fetched) != 1 || client.fetched[0] != "/library/Sorted/C.txt" {
+		t.Fatalf("expected only the hash candidate downloaded, got %v", client.fetched)
+	}
+	tasksAfter, _ := taskStore.ListByUser("user-1")
+	if len(tasksAfter) != len(tasksBefore)+1 {
+		t.Fatalf("expected a format task for the new file only, got %d", len(tasksAfter)-len(tasksBefore))
+	}
+}
+
+func TestServiceSyncLeavesAmbiguousMovesAlone(t *testing.T) {
+	store := NewMemoryStore()
+	booksStore := books.NewMemoryStore()
+	key, _ := ParseKey("00112233445566778899aabbccddeeff00112233445566778899aabbccddeeff")
+	modified := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	client := &moveClient{}
+	client.entries = []Entry{
+		{Path: "/library/A.epub", Size: 10, ModTime: modified},
+		{Path: "/library/B.epub", Size: 10, ModTime: modified},
+	}
+	svc := NewService(store, client, key, booksStore, nil, nil)
+	conn, _ := svc.Create(context.Background(), "user-1", "https://dav.example.com", "reader", "secret", Filter{}, "", "")
+	if err := svc.Sync(context.Background(), "user-1", conn.ID); err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+	client.entries = []Entry{{Path: "/library/Sorted/A.epub", Size: 10, ModTime: modified}}
+	if err := svc.Sync(context.Background(), "user-1", conn.ID); err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+	synced, _ := store.GetByID("user-1", conn.ID)
+	if synced.LastSyncResult != (SyncResult{Added: 1, Removed: 2}) {
+		t.Fatalf("unexpected result %+v", synced.LastSyncResult)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"errors"
 	"time"
 
+	"github.com/EROQIN/relite-reader/backend/internal/pgutil"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
@@ -31,7 +32,28 @@ CREATE TABLE IF NOT EXISTS webdav_connections (
   last_error TEXT NOT NULL DEFAULT '',
   last_sync_at TIMESTAMPTZ
 );
+ALTER TABLE webdav_connections ADD COLUMN IF NOT EXISTS root_path TEXT NOT NULL DEFAULT '';
+ALTER TABLE webdav_connections ADD COLUMN IF NOT EXISTS include_patterns TEXT[];
+ALTER TABLE webdav_connections ADD COLUMN IF NOT EXISTS exclude_patterns TEXT[];
+ALTER TABLE webdav_connections ADD COLUMN IF NOT EXISTS max_depth INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE webdav_connections ADD COLUMN IF NOT EXISTS supported_only BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE webdav_connections ADD COLUMN IF NOT EXISTS last_sync_added INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE webdav_connections ADD COLUMN IF NOT EXISTS last_sync_changed INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE webdav_connections ADD COLUMN IF NOT EXISTS last_sync_removed INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE webdav_connections ADD COLUMN IF NOT EXISTS last_sync_unchanged INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE webdav_connections ADD COLUMN IF NOT EXISTS last_sync_moved INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE webdav_connections ADD COLUMN IF NOT EXISTS write_back TEXT NOT NULL DEFAULT '';
+ALTER TABLE webdav_connections ADD COLUMN IF NOT EXISTS schedule TEXT NOT NULL DEFAULT '';
+ALTER TABLE webdav_connections ADD COLUMN IF NOT EXISTS next_sync_at TIMESTAMPTZ;
 CREATE INDEX IF NOT EXISTS idx_webdav_user_id ON webdav_connections (user_id);
+CREATE TABLE IF NOT EXISTS webdav_files (
+  connection_id TEXT NOT NULL REFERENCES webdav_connections (id) ON DELETE CASCADE,
+  path TEXT NOT NULL,
+  etag TEXT NOT NULL DEFAULT '',
+  size BIGINT NOT NULL DEFAULT 0,
+  modified_at TIMESTAMPTZ,
+  PRIMARY KEY (connection_id, path)
+);
 `)
 	return err
 }
@@ -42,22 +64,20 @@ func (s *PostgresStore) Create(userID string, conn Connection) (Connection, erro
 		conn.ID = newConnectionID()
 	}
 	conn.UserID = userID
-	err := s.pool.QueryRow(ctx, `
-INSERT INTO webdav_connections (id, user_id, base_url, username, encrypted_secret, last_sync_status, last_error, last_sync_at)
-VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
-RETURNING id, user_id, base_url, username, encrypted_secret, last_sync_status, last_error, last_sync_at;`,
-		conn.ID, conn.UserID, conn.BaseURL, conn.Username, conn.EncryptedSecret, conn.LastSyncStatus, conn.LastError, conn.LastSyncAt,
-	).Scan(&conn.ID, &conn.UserID, &conn.BaseURL, &conn.Username, &conn.EncryptedSecret, &conn.LastSyncStatus, &conn.LastError, &conn.LastSyncAt)
-	if err != nil {
-		return Connection{}, err
-	}
-	return conn, nil
+	return scanConnection(s.pool.QueryRow(ctx, `
+INSERT INTO webdav_connections (id, user_id, base_url, username, encrypted_secret, root_path, include_patterns, exclude_patterns, max_depth, supported_only, write_back, schedule, next_sync_at, last_sync_status, last_error, last_sync_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)
+RETURNING `+connectionColumns+`;`,
+		conn.ID, conn.UserID, conn.BaseURL, conn.Username, conn.EncryptedSecret,
+		conn.Filter.RootPath, conn.Filter.Include, conn.Filter.Exclude, conn.Filter.MaxDepth, conn.Filter.SupportedOnly,
+		string(conn.WriteBack), conn.Schedule, pgutil.NullTime(conn.NextSyncAt), conn.LastSyncStatus, conn.LastError, conn.LastSyncAt,
+	))
 }
 
 func (s *PostgresStore) ListByUser(userID string) ([]Connection, error) {
 	ctx := context.Background()
 	rows, err := s.pool.Query(ctx, `
-SELECT id, user_id, base_url, username, encrypted_secret, last_sync_status, last_error, last_sync_at
+SELECT `+connectionColumns+`
 FROM webdav_connections
 WHERE user_id = $1
 ORDER BY base_url;`,
@@ -69,8 +89,8 @@ ORDER BY base_url;`,
 	defer rows.Close()
 	var out []Connection
 	for rows.Next() {
-		var conn Connection
-		if err := rows.Scan(&conn.ID, &conn.UserID, &conn.BaseURL, &conn.Username, &conn.EncryptedSecret, &conn.LastSyncStatus, &conn.LastError, &conn.LastSyncAt); err != nil {
+		conn, err := scanConnection(rows)
+		if err != nil {
 			return nil, err
 		}
 		out = append(out, conn)
@@ -84,7 +104,7 @@ ORDER BY base_url;`,
 func (s *PostgresStore) ListAll() ([]Connection, error) {
 	ctx := context.Background()
 	rows, err := s.pool.Query(ctx, `
-SELECT id, user_id, base_url, username, encrypted_secret, last_sync_status, last_error, last_sync_at
+SELECT `+connectionColumns+`
 FROM webdav_connections;`)
 	if err != nil {
 		return nil, err
@@ -92,8 +112,8 @@ FROM webdav_connections;`)
 	defer rows.Close()
 	var out []Connection
 	for rows.Next() {
-		var conn Connection
-		if err := rows.Scan(&conn.ID, &conn.UserID, &conn.BaseURL, &conn.Username, &conn.EncryptedSecret, &conn.LastSyncStatus, &conn.LastError, &conn.LastSyncAt); err != nil {
+		conn, err := scanConnection(rows)
+		if err != nil {
 			return nil, err
 		}
 		out = append(out, conn)
@@ -106,13 +126,12 @@ FROM webdav_connections;`)
 
 func (s *PostgresStore) GetByID(userID, id string) (Connection, error) {
 	ctx := context.Background()
-	var conn Connection
-	err := s.pool.QueryRow(ctx, `
-SELECT id, user_id, base_url, username, encrypted_secret, last_sync_status, last_error, last_sync_at
+	conn, err := scanConnection(s.pool.QueryRow(ctx, `
+SELECT `+connectionColumns+`
 FROM webdav_connections
 WHERE user_id = $1 AND id = $2;`,
 		userID, id,
-	).Scan(&conn.ID, &conn.UserID, &conn.BaseURL, &conn.Username, &conn.EncryptedSecret, &conn.LastSyncStatus, &conn.LastError, &conn.LastSyncAt)
+	))
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return Connection{}, ErrNotFound
@@ -129,11 +148,21 @@ UPDATE webdav_connections
 SET base_url = $1,
     username = $2,
     encrypted_secret = $3,
-    last_sync_status = $4,
-    last_error = $5,
-    last_sync_at = $6
-WHERE user_id = $7 AND id = $8;`,
-		conn.BaseURL, conn.Username, conn.EncryptedSecret, conn.LastSyncStatus, conn.LastError, conn.LastSyncAt, userID, conn.ID,
+    root_path = $4,
+    include_patterns = $5,
+    exclude_patterns = $6,
+    max_depth = $7,
+    supported_only = $8,
+    write_back = $9,
+    schedule = $10,
+    next_sync_at = $11,
+    last_sync_status = $12,
+    last_error = $13,
+    last_sync_at = $14
+WHERE user_id = $15 AND id = $16;`,
+		conn.BaseURL, conn.Username, conn.EncryptedSecret,
+		conn.Filter.RootPath, conn.Filter.Include, conn.Filter.Exclude, conn.Filter.MaxDepth, conn.Filter.SupportedOnly,
+		string(conn.WriteBack), conn.Schedule, pgutil.NullTime(conn.NextSyncAt), conn.LastSyncStatus, conn.LastError, conn.LastSyncAt, userID, conn.ID,
 	)
 	if err != nil {
 		return Connection{}, err
@@ -156,18 +185,22 @@ func (s *PostgresStore) Delete(userID, id string) error {
 	return nil
 }
 
-func (s *PostgresStore) UpdateSyncStatus(userID, id, status, lastError string) (Connection, error) {
+func (s *PostgresStore) UpdateSyncStatus(userID, id, status, lastError string, result SyncResult) (Connection, error) {
 	ctx := context.Background()
-	var conn Connection
-	err := s.pool.QueryRow(ctx, `
+	conn, err := scanConnection(s.pool.QueryRow(ctx, `
 UPDATE webdav_connections
 SET last_sync_status = $1,
     last_error = $2,
-    last_sync_at = $3
-WHERE user_id = $4 AND id = $5
-RETURNING id, user_id, base_url, username, encrypted_secret, last_sync_status, last_error, last_sync_at;`,
-		status, lastError, time.Now().UTC(), userID, id,
-	).Scan(&conn.ID, &conn.UserID, &conn.BaseURL, &conn.Username, &conn.EncryptedSecret, &conn.LastSyncStatus, &conn.LastError, &conn.LastSyncAt)
+    last_sync_at = $3,
+    last_sync_added = $4,
+    last_sync_changed = $5,
+    last_sync_removed = $6,
+    last_sync_unchanged = $7,
+    last_sync_moved = $8
+WHERE user_id = $9 AND id = $10
+RETURNING `+connectionColumns+`;`,
+		status, lastError, time.Now().UTC(), result.Added, result.Changed, result.Removed, result.Unchanged, result.Moved, userID, id,
+	))
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return Connection{}, ErrNotFound
@@ -177,6 +210,126 @@ RETURNING id, user_id, base_url, username, encrypted_secret, last_sync_status, l
 	return conn, nil
 }
 
+func (s *PostgresStore) SetNextSync(userID, id string, at time.Time) error {
+	ctx := context.Background()
+	ct, err := s.pool.Exec(ctx, `UPDATE webdav_connections SET next_sync_at = $1 WHERE user_id = $2 AND id = $3;`, pgutil.NullTime(at), userID, id)
+	if err != nil {
+		return err
+	}
+	if ct.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *PostgresStore) ListFiles(connectionID string) ([]FileState, error) {
+	ctx := context.Background()
+	rows, err := s.pool.Query(ctx, `
+SELECT path, etag, size, modified_at
+FROM webdav_files
+WHERE connection_id = $1;`,
+		connectionID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []FileState
+	for rows.Next() {
+		var file FileState
+		var modified *time.Time
+		if err := rows.Scan(&file.Path, &file.ETag, &file.Size, &modified); err != nil {
+			return nil, err
+		}
+		if modified != nil {
+			file.ModTime = *modified
+		}
+		out = append(out, file)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (s *PostgresStore) GetFile(connectionID, path string) (FileState, error) {
+	file := FileState{Path: path}
+	var modified *time.Time
+	err := s.pool.QueryRow(context.Background(), `
+SELECT etag, size, modified_at
+FROM webdav_files
+WHERE connection_id = $1 AND path = $2;`,
+		connectionID, path,
+	).Scan(&file.ETag, &file.Size, &modified)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return FileState{}, ErrFileNotFound
+		}
+		return FileState{}, err
+	}
+	if modified != nil {
+		file.ModTime = *modified
+	}
+	return file, nil
+}
+
+// ReplaceFiles swaps the stored listing of a connection in one
+// transaction, so a failed sync leaves the previous listing in place.
+func (s *PostgresStore) ReplaceFiles(connectionID string, files []FileState) error {
+	ctx := context.Background()
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	if _, err := tx.Exec(ctx, `DELETE FROM webdav_files WHERE connection_id = $1;`, connectionID); err != nil {
+		return err
+	}
+	batch := &pgx.Batch{}
+	for _, file := range files {
+		batch.Queue(`
+INSERT INTO webdav_files (connection_id, path, etag, size, modified_at)
+VALUES ($1, $2, $3, $4, $5)
+ON CONFLICT (connection_id, path) DO NOTHING;`,
+			connectionID, file.Path, file.ETag, file.Size, pgutil.NullTime(file.ModTime),
+		)
+	}
+	if err := tx.SendBatch(ctx, batch).Close(); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func (s *PostgresStore) PutFile(connectionID string, file FileState) error {
+	_, err := s.pool.Exec(context.Background(), `
+INSERT INTO webdav_files (connection_id, path, etag, size, modified_at)
+VALUES ($1, $2, $3, $4, $5)
+ON CONFLICT (connection_id, path) DO UPDATE
+SET etag = EXCLUDED.etag, size = EXCLUDED.size, modified_at = EXCLUDED.modified_at;`,
+		connectionID, file.Path, file.ETag, file.Size, pgutil.NullTime(file.ModTime),
+	)
+	return err
+}
+
+const connectionColumns = `id, user_id, base_url, username, encrypted_secret,
+  root_path, include_patterns, exclude_patterns, max_depth, supported_only, write_back, schedule, next_sync_at,
+  last_sync_status, last_error, last_sync_at, last_sync_added, last_sync_changed, last_sync_removed, last_sync_unchanged, last_sync_moved`
+
+func scanConnection(row pgx.Row) (Connection, error) {
+	var conn Connection
+	var nextSync *time.Time
+	err := row.Scan(
+		&conn.ID, &conn.UserID, &conn.BaseURL, &conn.Username, &conn.EncryptedSecret,
+		&conn.Filter.RootPath, &conn.Filter.Include, &conn.Filter.Exclude, &conn.Filter.MaxDepth, &conn.Filter.SupportedOnly, &conn.WriteBack,
+		&conn.Schedule, &nextSync,
+		&conn.LastSyncStatus, &conn.LastError, &conn.LastSyncAt, &conn.LastSyncResult.Added, &conn.LastSyncResult.Changed, &conn.LastSyncResult.Removed, &conn.LastSyncResult.Unchanged, &conn.LastSyncResult.Moved,
+	)
+	if nextSync != nil {
+		conn.NextSyncAt = *nextSync
+	}
+	return conn, err
+}
+
 func newConnectionID() string {
 	buf := make([]byte, 16)
 	_, _ = rand.Read(buf)
@@ -2,6 +2,7 @@ package webdav
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"testing"
 	"time"
@@ -31,9 +32,27 @@ func TestPostgresStoreCRUD(t *testing.T) {
 	if len(list) != 1 {
 		t.Fatalf("expected 1 connection, got %d", len(list))
 	}
-	if _, err := store.UpdateSyncStatus(userID, created.ID, "success", ""); err != nil {
+	synced, err := store.UpdateSyncStatus(userID, created.ID, "success", "", SyncResult{Added: 2, Unchanged: 1})
+	if err != nil {
 		t.Fatalf("update sync: %v", err)
 	}
+	if synced.LastSyncResult.Added != 2 || synced.LastSyncResult.Unchanged != 1 {
+		t.Fatalf("unexpected sync result %+v", synced.LastSyncResult)
+	}
+	files := []FileState{{Path: "/library/A.epub", ETag: `"1"`, Size: 10}, {Path: "/library/B.pdf", ModTime: time.Now().UTC()}}
+	if err := store.ReplaceFiles(created.ID, files); err != nil {
+		t.Fatalf("replace files: %v", err)
+	}
+	stored, err := store.ListFiles(created.ID)
+	if err != nil || len(stored) != 2 {
+		t.Fatalf("expected 2 files, got %d (%v)", len(stored), err)
+	}
+	if file, err := store.GetFile(created.ID, "/library/A.epub"); err != nil || file.ETag != `"1"` {
+		t.Fatalf("expected stored file, got %+v (%v)", file, err)
+	}
+	if _, err := store.GetFile(created.ID, "/library/C.cbz"); !errors.Is(err, ErrFileNotFound) {
+		t.Fatalf("expected file not found, got %v", err)
+	}
 	if err := store.Delete(userID, created.ID); err != nil {
 		t.Fatalf("delete: %v", err)
 	}
@@ -0,0 +1,247 @@
+package webdav
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	defaultConnectTimeout = 10 * time.Second
+	defaultReadTimeout    = 60 * time.Second
+	defaultRetries        = 3
+	defaultRetryDelay     = 500 * time.Millisecond
+	// maxRetryDelay caps the exponential backoff.
+	maxRetryDelay = 30 * time.Second
+	// maxRetryAfter is the longest Retry-After a request waits for before
+	// giving up.
+	maxRetryAfter = 2 * time.Minute
+)
+
+// errReadTimeout is returned by a response body that stalled for longer
+// than the read timeout.
+var errReadTimeout = errors.New("webdav read timed out")
+
+// statusError is an unexpected HTTP status from the server.
+type statusError struct {
+	code int
+}
+
+func (e statusError) Error() string {
+	return fmt.Sprintf("unexpected status %d %s", e.code, http.StatusText(e.code))
+}
+
+// newTransport returns a transport whose dial, TLS handshake and response
+// header waits are bounded by the client options.
+func newTransport(options ClientOptions) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	dialer := &net.Dialer{Timeout: options.ConnectTimeout, KeepAlive: 30 * time.Second}
+	transport.DialContext = dialer.DialContext
+	transport.TLSHandshakeTimeout = options.ConnectTimeout
+	transport.ResponseHeaderTimeout = options.ReadTimeout
+	return transport
+}
+
+// do sends an idempotent request, retrying network errors, 5xx and 429
+// responses with exponential backoff and jitter. A 429 or 503 with
+// Retry-After waits as long as the server asks. The body of the returned
+// response fails with errReadTimeout when it stalls.
+func (c *HTTPClient) do(ctx context.Context, req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		if err := c.wait(ctx, req.URL.Host); err != nil {
+			return nil, err
+		}
+		try := req.Clone(ctx)
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			try.Body = body
+		}
+		attemptCtx, cancel := context.WithCancel(ctx)
+		resp, err := c.httpClient.Do(try.WithContext(attemptCtx))
+		var delay time.Duration
+		switch {
+		case err != nil:
+			cancel()
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+		case retryableStatus(resp.StatusCode):
+			delay = retryAfter(resp.Header.Get("Retry-After"), time.Now())
+			err = statusError{code: resp.StatusCode}
+			_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			resp.Body.Close()
+			cancel()
+		default:
+			resp.Body = newIdleBody(resp.Body, c.options.ReadTimeout, cancel)
+			return resp, nil
+		}
+		if attempt >= c.options.Retries || delay > maxRetryAfter {
+			return nil, err
+		}
+		if delay <= 0 {
+			delay = c.backoff(attempt)
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// send sends a request that is not safe to repeat, such as a PUT or MKCOL
+// the server may have carried out before its response was lost. It keeps
+// to the host's rate limit like do but makes a single attempt.
+func (c *HTTPClient) send(ctx context.Context, req *http.Request) (*http.Response, error) {
+	if err := c.wait(ctx, req.URL.Host); err != nil {
+		return nil, err
+	}
+	attemptCtx, cancel := context.WithCancel(ctx)
+	resp, err := c.httpClient.Do(req.WithContext(attemptCtx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = newIdleBody(resp.Body, c.options.ReadTimeout, cancel)
+	return resp, nil
+}
+
+func retryableStatus(code int) bool {
+	return code == http.StatusTooManyRequests || (code >= 500 && code != http.StatusNotImplemented)
+}
+
+// retryAfter parses a Retry-After header given in seconds or as an HTTP
+// date. It returns 0 when the header is absent or malformed.
+func retryAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return max(time.Duration(seconds)*time.Second, 0)
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return max(at.Sub(now), 0)
+	}
+	return 0
+}
+
+// backoff returns the delay before retry attempt+1: the retry delay
+// doubled per attempt, capped, with the upper half randomized.
+func (c *HTTPClient) backoff(attempt int) time.Duration {
+	delay := c.options.RetryDelay << min(attempt, 16)
+	if delay <= 0 || delay > maxRetryDelay {
+		delay = maxRetryDelay
+	}
+	half := delay / 2
+	return half + rand.N(half+1)
+}
+
+// idleBody cancels its request when no bytes arrive for a whole timeout.
+type idleBody struct {
+	body    io.ReadCloser
+	timeout time.Duration
+	timer   *time.Timer
+	cancel  context.CancelFunc
+
+	mu      sync.Mutex
+	expired bool
+}
+
+func newIdleBody(body io.ReadCloser, timeout time.Duration, cancel context.CancelFunc) io.ReadCloser {
+	b := &idleBody{body: body, timeout: timeout, cancel: cancel}
+	if timeout > 0 {
+		b.timer = time.AfterFunc(timeout, func() {
+			b.mu.Lock()
+			b.expired = true
+			b.mu.Unlock()
+			cancel()
+		})
+	}
+	return b
+}
+
+func (b *idleBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	b.mu.Lock()
+	expired := b.expired
+	b.mu.Unlock()
+	if expired {
+		return n, errReadTimeout
+	}
+	if b.timer != nil && n > 0 {
+		b.timer.Reset(b.timeout)
+	}
+	return n, err
+}
+
+func (b *idleBody) Close() error {
+	if b.timer != nil {
+		b.timer.Stop()
+	}
+	err := b.body.Close()
+	b.cancel()
+	return err
+}
+
+// describeError turns a client error into the short explanation stored as
+// the LastError of a connection.
+func describeError(err error) string {
+	var status statusError
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	var certErr *tls.CertificateVerificationError
+	var authorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var syntaxErr *xml.SyntaxError
+	switch {
+	case err == nil:
+		return ""
+	case errors.As(err, &status):
+		switch {
+		case status.code == http.StatusUnauthorized || status.code == http.StatusForbidden:
+			return fmt.Sprintf("authentication failed (HTTP %d)", status.code)
+		case status.code == http.StatusNotFound:
+			return "not found (HTTP 404)"
+		case status.code == http.StatusTooManyRequests:
+			return "rate limited by server (HTTP 429)"
+		case status.code >= 500:
+			return fmt.Sprintf("server error (HTTP %d)", status.code)
+		}
+		return fmt.Sprintf("unexpected response (HTTP %d)", status.code)
+	case errors.Is(err, context.Canceled):
+		return "sync canceled"
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, errReadTimeout):
+		return "timed out"
+	case errors.As(err, &dnsErr):
+		return "host not found"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "connection refused"
+	case errors.As(err, &certErr), errors.As(err, &authorityErr), errors.As(err, &hostnameErr):
+		return "TLS certificate rejected"
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "timed out"
+	case errors.As(err, &syntaxErr):
+		return "invalid WebDAV response"
+	case errors.As(err, &netErr):
+		return "connection failed"
+	}
+	return "sync failed"
+}
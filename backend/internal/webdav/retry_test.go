@@ -0,0 +1,144 @@
+package webdav
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHTTPClientRetriesServerErrors(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch calls.Add(1) {
+		case 1:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case 2:
+			w.WriteHeader(http.StatusTooManyRequests)
+		default:
+			_, _ = w.Write([]byte("book"))
+		}
+	}))
+	defer srv.Close()
+
+	client := NewHTTPClient(nil, ClientOptions{RetryDelay: time.Millisecond})
+	content, err := client.Fetch(context.Background(), srv.URL, "reader", "secret", "/A.epub", ContentRequest{})
+	if err != nil {
+		t.Fatalf("fetch: %v", err)
+	}
+	defer content.Body.Close()
+	data, _ := io.ReadAll(content.Body)
+	if string(data) != "book" || calls.Load() != 3 {
+		t.Fatalf("expected success on third attempt, got %q after %d calls", data, calls.Load())
+	}
+}
+
+func TestHTTPClientDoesNotRetryClientErrors(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+
+	client := NewHTTPClient(nil, ClientOptions{RetryDelay: time.Millisecond})
+	_, err := client.Fetch(context.Background(), srv.URL, "reader", "secret", "/A.epub", ContentRequest{})
+	if describeError(err) != "authentication failed (HTTP 401)" || calls.Load() != 1 {
+		t.Fatalf("expected one unauthorized call, got %v after %d calls", err, calls.Load())
+	}
+}
+
+func TestHTTPClientGivesUpAfterRetries(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	client := NewHTTPClient(nil, ClientOptions{Retries: 2, RetryDelay: time.Millisecond})
+	_, err := client.List(context.Background(), srv.URL, "reader", "secret", nil)
+	if describeError(err) != "server error (HTTP 502)" || calls.Load() != 3 {
+		t.Fatalf("expected 3 attempts, got %v after %d calls", err, calls.Load())
+	}
+}
+
+func TestHTTPClientDoesNotRetryWrites(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	client := NewHTTPClient(nil, ClientOptions{RetryDelay: time.Millisecond})
+	if _, err := client.Put(context.Background(), srv.URL, "reader", "secret", "/A.epub", strings.NewReader("book"), 4, ""); describeError(err) != "server error (HTTP 503)" {
+		t.Fatalf("expected put to fail, got %v", err)
+	}
+	if err := client.MakeCollection(context.Background(), srv.URL, "reader", "secret", "/Books"); describeError(err) != "server error (HTTP 503)" {
+		t.Fatalf("expected mkcol to fail, got %v", err)
+	}
+	if calls.Load() != 2 {
+		t.Fatalf("expected one attempt each, got %d calls", calls.Load())
+	}
+}
+
+func TestHTTPClientTimesOutStalledBodies(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("start"))
+		w.(http.Flusher).Flush()
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	client := NewHTTPClient(nil, ClientOptions{ReadTimeout: 50 * time.Millisecond})
+	content, err := client.Fetch(context.Background(), srv.URL, "reader", "secret", "/A.epub", ContentRequest{})
+	if err != nil {
+		t.Fatalf("fetch: %v", err)
+	}
+	defer content.Body.Close()
+	_, err = io.ReadAll(content.Body)
+	if !errors.Is(err, errReadTimeout) {
+		t.Fatalf("expected read timeout, got %v", err)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	cases := map[string]time.Duration{
+		"":                              0,
+		"7":                             7 * time.Second,
+		"soon":                          0,
+		"Wed, 01 May 2024 12:00:30 GMT": 30 * time.Second,
+		"Wed, 01 May 2024 11:00:00 GMT": 0,
+	}
+	for value, want := range cases {
+		if got := retryAfter(value, now); got != want {
+			t.Fatalf("retry after %q: expected %v, got %v", value, want, got)
+		}
+	}
+}
+
+func TestDescribeError(t *testing.T) {
+	cases := map[string]error{
+		"server error (HTTP 503)": statusError{code: http.StatusServiceUnavailable},
+		"not found (HTTP 404)":    statusError{code: http.StatusNotFound},
+		"sync canceled":           context.Canceled,
+		"timed out":               context.DeadlineExceeded,
+		"host not found":          &net.DNSError{Err: "no such host", Name: "dav.invalid"},
+		"sync failed":             errors.New("boom"),
+	}
+	for want, err := range cases {
+		if got := describeError(err); got != want {
+			t.Fatalf("describe %v: expected %q, got %q", err, want, got)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package webdav
+
+import (
+	"errors"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidSchedule = errors.New("invalid sync schedule")
+
+// ScheduleOff disables scheduled syncs of a connection; it still syncs on
+// request.
+const ScheduleOff = "off"
+
+// maxJitter caps the random delay added to each scheduled run.
+const maxJitter = 5 * time.Minute
+
+// Schedule says when a connection syncs: every fixed interval, or at the
+// times matched by a cron expression. The zero Schedule never runs.
+type Schedule struct {
+	every time.Duration
+	cron  *cronSpec
+}
+
+// ParseSchedule reads a Go duration ("30m", "6h") or a five-field cron
+// expression in local time ("0 3 * * *", "*/15 8-20 * * 1-5"). The
+// @hourly, @daily, @weekly and @monthly shorthands are accepted too.
+// "off" returns the zero Schedule.
+func ParseSchedule(spec string) (Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == ScheduleOff {
+		return Schedule{}, nil
+	}
+	if every, err := time.ParseDuration(spec); err == nil {
+		if every < time.Minute {
+			return Schedule{}, ErrInvalidSchedule
+		}
+		return Schedule{every: every}, nil
+	}
+	cron, err := parseCron(spec)
+	if err != nil {
+		return Schedule{}, err
+	}
+	if cron.next(time.Now()).IsZero() {
+		return Schedule{}, ErrInvalidSchedule
+	}
+	return Schedule{cron: cron}, nil
+}
+
+func (s Schedule) IsZero() bool {
+	return s.every == 0 && s.cron == nil
+}
+
+// Next returns the first run after the given time, or the zero time when
+// the schedule never runs.
+func (s Schedule) Next(after time.Time) time.Time {
+	switch {
+	case s.every > 0:
+		return after.Add(s.every)
+	case s.cron != nil:
+		return s.cron.next(after)
+	}
+	return time.Time{}
+}
+
+// NextJittered is Next delayed by up to a tenth of the wait, at most five
+// minutes, so connections sharing a schedule do not all hit their servers
+// at once.
+func (s Schedule) NextJittered(after time.Time) time.Time {
+	next := s.Next(after)
+	if next.IsZero() {
+		return next
+	}
+	spread := min(next.Sub(after)/10, maxJitter)
+	if spread <= 0 {
+		return next
+	}
+	return next.Add(time.Duration(rand.Int63n(int64(spread))))
+}
+
+// cronSpec holds the allowed values of each field as bit sets.
+type cronSpec struct {
+	minute, hour, dom, month, dow uint64
+	// anyDay is set when day of month or day of week is "*"; cron then
+	// requires both to match instead of either.
+	anyDay bool
+}
+
+var cronShorthands = map[string]string{
+	"@hourly":   "0 * * * *",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@weekly":   "0 0 * * 0",
+	"@monthly":  "0 0 1 * *",
+}
+
+func parseCron(spec string) (*cronSpec, error) {
+	if expanded, ok := cronShorthands[spec]; ok {
+		spec = expanded
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, ErrInvalidSchedule
+	}
+	var out cronSpec
+	var err error
+	if out.minute, err = parseCronField(fields[0], 0, 59); err != nil {
+		return nil, err
+	}
+	if out.hour, err = parseCronField(fields[1], 0, 23); err != nil {
+		return nil, err
+	}
+	if out.dom, err = parseCronField(fields[2], 1, 31); err != nil {
+		return nil, err
+	}
+	if out.month, err = parseCronField(fields[3], 1, 12); err != nil {
+		return nil, err
+	}
+	if out.dow, err = parseCronField(fields[4], 0, 7); err != nil {
+		return nil, err
+	}
+	// Sunday is both 0 and 7.
+	if out.dow&(1<<7) != 0 {
+		out.dow |= 1
+	}
+	out.anyDay = fields[2] == "*" || fields[4] == "*"
+	return &out, nil
+}
+
+// parseCronField reads a comma-separated list of "*", "n", "a-b", each
+// optionally followed by "/step".
+func parseCronField(field string, lo, hi int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepPart)
+			if err != nil || n <= 0 {
+				return 0, ErrInvalidSchedule
+			}
+			step = n
+		}
+		start, end := lo, hi
+		if rangePart != "*" {
+			first, last, isRange := strings.Cut(rangePart, "-")
+			var err error
+			if start, err = strconv.Atoi(first); err != nil {
+				return 0, ErrInvalidSchedule
+			}
+			end = start
+			if isRange {
+				if end, err = strconv.Atoi(last); err != nil {
+					return 0, ErrInvalidSchedule
+				}
+			} else if hasStep {
+				end = hi
+			}
+		}
+		if start < lo || end > hi || start > end {
+			return 0, ErrInvalidSchedule
+		}
+		for v := start; v <= end; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+// next walks forward minute by minute, skipping whole days and hours that
+// cannot match. Expressions that never match, such as "0 0 31 2 *", give
+// up after five years.
+func (c *cronSpec) next(after time.Time) time.Time {
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if c.month&(1<<uint(t.Month())) == 0 || !c.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if c.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if c.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+func (c *cronSpec) dayMatches(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.anyDay {
+		return dom && dow
+	}
+	return dom || dow
+}
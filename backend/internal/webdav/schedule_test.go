@@ -0,0 +1,56 @@
+package webdav
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseScheduleRejectsInvalidSpecs(t *testing.T) {
+	for _, spec := range []string{"", "10s", "* * * *", "60 * * * *", "0 0 31 2 *", "*/0 * * * *", "5-1 * * * *", "daily"} {
+		if _, err := ParseSchedule(spec); err != ErrInvalidSchedule {
+			t.Fatalf("expected %q rejected, got %v", spec, err)
+		}
+	}
+	off, err := ParseSchedule(ScheduleOff)
+	if err != nil || !off.IsZero() || !off.Next(time.Now()).IsZero() {
+		t.Fatalf("expected off to never run")
+	}
+}
+
+func TestScheduleNext(t *testing.T) {
+	// Wednesday.
+	from := time.Date(2024, 5, 1, 12, 7, 30, 0, time.UTC)
+	cases := []struct {
+		spec string
+		want time.Time
+	}{
+		{"45m", from.Add(45 * time.Minute)},
+		{"*/15 * * * *", time.Date(2024, 5, 1, 12, 15, 0, 0, time.UTC)},
+		{"0 3 * * *", time.Date(2024, 5, 2, 3, 0, 0, 0, time.UTC)},
+		{"30 8-10 * * 1-5", time.Date(2024, 5, 2, 8, 30, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2024, 5, 5, 0, 0, 0, 0, time.UTC)},
+		{"0 0 15 * 1", time.Date(2024, 5, 6, 0, 0, 0, 0, time.UTC)},
+		{"@monthly", time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 12 29 2 *", time.Date(2028, 2, 29, 12, 0, 0, 0, time.UTC)},
+	}
+	for _, tc := range cases {
+		schedule, err := ParseSchedule(tc.spec)
+		if err != nil {
+			t.Fatalf("parse %q: %v", tc.spec, err)
+		}
+		if got := schedule.Next(from); !got.Equal(tc.want) {
+			t.Fatalf("%q: expected %v, got %v", tc.spec, tc.want, got)
+		}
+	}
+}
+
+func TestScheduleJitterIsBounded(t *testing.T) {
+	schedule, _ := ParseSchedule("10m")
+	from := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	for range 50 {
+		got := schedule.NextJittered(from)
+		if got.Before(from.Add(10*time.Minute)) || !got.Before(from.Add(11*time.Minute)) {
+			t.Fatalf("jitter out of range: %v", got)
+		}
+	}
+}
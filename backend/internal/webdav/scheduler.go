@@ -2,20 +2,30 @@ package webdav
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"time"
+
+	"github.com/EROQIN/relite-reader/backend/internal/tasks"
 )
 
+// SyncTask is the task type of a connection sync.
+const SyncTask = "sync"
+
 type Syncer interface {
-	SyncAll() error
+	QueueDue(now time.Time, fallback Schedule) error
 }
 
+// Scheduler queues the syncs that are due on every tick. Connections
+// without a schedule of their own follow fallback.
 type Scheduler struct {
-	syncer Syncer
-	tick   <-chan time.Time
+	syncer   Syncer
+	tick     <-chan time.Time
+	fallback Schedule
 }
 
-func NewScheduler(syncer Syncer, tick <-chan time.Time) *Scheduler {
-	return &Scheduler{syncer: syncer, tick: tick}
+func NewScheduler(syncer Syncer, tick <-chan time.Time, fallback Schedule) *Scheduler {
+	return &Scheduler{syncer: syncer, tick: tick, fallback: fallback}
 }
 
 func (s *Scheduler) Start(ctx context.Context) {
@@ -23,8 +33,85 @@ func (s *Scheduler) Start(ctx context.Context) {
 		select {
 		case <-ctx.Done():
 			return
-		case <-s.tick:
-			_ = s.syncer.SyncAll()
+		case now := <-s.tick:
+			_ = s.syncer.QueueDue(now, s.fallback)
 		}
 	}
 }
+
+// QueueDue queues a sync for every connection whose next run has come and
+// moves that run forward on its schedule. A stored schedule that no longer
+// parses is reported and replaced by fallback, so the connection keeps
+// syncing.
+func (s *Service) QueueDue(now time.Time, fallback Schedule) error {
+	conns, err := s.store.ListAll()
+	if err != nil {
+		return err
+	}
+	var lastErr error
+	for _, conn := range conns {
+		schedule := fallback
+		if conn.Schedule != "" {
+			parsed, err := ParseSchedule(conn.Schedule)
+			if err != nil {
+				lastErr = fmt.Errorf("connection %s: schedule %q: %w", conn.ID, conn.Schedule, err)
+			} else {
+				schedule = parsed
+			}
+		}
+		if schedule.IsZero() || conn.NextSyncAt.After(now) {
+			continue
+		}
+		if err := s.store.SetNextSync(conn.UserID, conn.ID, schedule.NextJittered(now)); err != nil {
+			lastErr = err
+			continue
+		}
+		if _, err := s.QueueSync(conn.UserID, conn.ID); err != nil {
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+// QueueSync queues a sync of a connection. While one is queued or running,
+// that task is returned instead of queueing another.
+func (s *Service) QueueSync(userID, id string) (tasks.Task, error) {
+	if _, err := s.store.GetByID(userID, id); err != nil {
+		return tasks.Task{}, err
+	}
+	if s.queue == nil {
+		return tasks.Task{}, errors.New("missing task queue")
+	}
+	payload := map[string]string{"connection_id": id}
+	// Holding mu keeps two callers from both finding no pending sync.
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if task, ok, err := s.queue.Pending(userID, SyncTask, payload); err != nil || ok {
+		return task, err
+	}
+	return s.queue.Enqueue(userID, SyncTask, payload)
+}
+
+// Register adds the sync task handler to registry, running at most
+// parallel syncs at once. Large libraries get an hour per sync.
+func (s *Service) Register(registry *tasks.Registry, parallel int) {
+	registry.Register(SyncTask, s.HandleSync, tasks.TypeOptions{Concurrency: parallel, Timeout: time.Hour})
+}
+
+// HandleSync runs a sync task. When the connection is already being synced
+// the task has nothing left to do and succeeds.
+func (s *Service) HandleSync(ctx context.Context, task tasks.Task) error {
+	id := task.Payload["connection_id"]
+	if id == "" {
+		return tasks.Permanent(errors.New("missing connection_id"))
+	}
+	err := s.Sync(ctx, task.UserID, id)
+	switch {
+	case errors.Is(err, ErrSyncInProgress):
+		return nil
+	case errors.Is(err, ErrNotFound):
+		// The connection was deleted.
+		return tasks.Permanent(err)
+	}
+	return err
+}
@@ -2,28 +2,162 @@ package webdav
 
 import (
 	"context"
+	"errors"
 	"testing"
 	"time"
+
+	"github.com/EROQIN/relite-reader/backend/internal/books"
+	"github.com/EROQIN/relite-reader/backend/internal/tasks"
 )
 
-type fakeSyncer struct{ ch chan struct{} }
+type fakeSyncer struct{ ch chan time.Time }
 
-func (f *fakeSyncer) SyncAll() error {
-	f.ch <- struct{}{}
+func (f *fakeSyncer) QueueDue(now time.Time, _ Schedule) error {
+	f.ch <- now
 	return nil
 }
 
-func TestSchedulerCallsSyncAll(t *testing.T) {
+func TestSchedulerQueuesDueSyncs(t *testing.T) {
 	ch := make(chan time.Time, 1)
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
-	fs := &fakeSyncer{ch: make(chan struct{}, 1)}
-	s := NewScheduler(fs, ch)
+	fs := &fakeSyncer{ch: make(chan time.Time, 1)}
+	s := NewScheduler(fs, ch, Schedule{})
 	go s.Start(ctx)
-	ch <- time.Now()
+	now := time.Now()
+	ch <- now
 	select {
-	case <-fs.ch:
+	case got := <-fs.ch:
+		if !got.Equal(now) {
+			t.Fatalf("expected tick time, got %v", got)
+		}
 	case <-time.After(100 * time.Millisecond):
 		t.Fatalf("expected sync call")
 	}
 }
+
+func TestServiceQueueDueFollowsSchedules(t *testing.T) {
+	store := NewMemoryStore()
+	taskStore := tasks.NewMemoryStore()
+	queue := newTestQueue(taskStore)
+	key, _ := ParseKey("00112233445566778899aabbccddeeff00112233445566778899aabbccddeeff")
+	svc := NewService(store, &fakeClient{}, key, books.NewMemoryStore(), queue, nil)
+	hourly, _ := svc.Create(context.Background(), "user-1", "https://dav.example.com", "reader", "secret", Filter{}, "", "1h")
+	fallback, _ := svc.Create(context.Background(), "user-1", "https://dav.example.com/b", "reader", "secret", Filter{}, "", "")
+	off, _ := svc.Create(context.Background(), "user-1", "https://dav.example.com/c", "reader", "secret", Filter{}, "", ScheduleOff)
+	if _, err := svc.Create(context.Background(), "user-1", "https://dav.example.com/d", "reader", "secret", Filter{}, "", "every day"); err != ErrInvalidSchedule {
+		t.Fatalf("expected invalid schedule rejected, got %v", err)
+	}
+
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	daily, _ := ParseSchedule("24h")
+	if err := svc.QueueDue(now, daily); err != nil {
+		t.Fatalf("queue due: %v", err)
+	}
+	queued := func() map[string]int {
+		list, _ := taskStore.ListByUser("user-1")
+		out := map[string]int{}
+		for _, task := range list {
+			if task.Type == SyncTask {
+				out[task.Payload["connection_id"]]++
+			}
+		}
+		return out
+	}
+	if got := queued(); got[hourly.ID] != 1 || got[fallback.ID] != 1 || got[off.ID] != 0 {
+		t.Fatalf("unexpected sync tasks %v", got)
+	}
+	next, _ := store.GetByID("user-1", hourly.ID)
+	if next.NextSyncAt.Before(now.Add(time.Hour)) || next.NextSyncAt.After(now.Add(time.Hour+6*time.Minute)) {
+		t.Fatalf("expected next run within the jitter of an hour, got %v", next.NextSyncAt)
+	}
+	next, _ = store.GetByID("user-1", fallback.ID)
+	if next.NextSyncAt.Before(now.Add(24 * time.Hour)) {
+		t.Fatalf("expected fallback schedule, got %v", next.NextSyncAt)
+	}
+
+	// Not due yet, and the queued syncs have not run.
+	if err := svc.QueueDue(now.Add(30*time.Minute), daily); err != nil {
+		t.Fatalf("queue due: %v", err)
+	}
+	if got := queued(); got[hourly.ID] != 1 {
+		t.Fatalf("expected no new sync, got %v", got)
+	}
+}
+
+func TestServiceQueueDueFallsBackOnBadSchedule(t *testing.T) {
+	store := NewMemoryStore()
+	taskStore := tasks.NewMemoryStore()
+	queue := newTestQueue(taskStore)
+	key, _ := ParseKey("00112233445566778899aabbccddeeff00112233445566778899aabbccddeeff")
+	svc := NewService(store, &fakeClient{}, key, books.NewMemoryStore(), queue, nil)
+	conn, _ := svc.Create(context.Background(), "user-1", "https://dav.example.com", "reader", "secret", Filter{}, "", "1h")
+	// A row written by an older version, say, that no longer parses.
+	conn.Schedule = "every day"
+	_, _ = store.Update("user-1", conn)
+
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	daily, _ := ParseSchedule("24h")
+	if err := svc.QueueDue(now, daily); !errors.Is(err, ErrInvalidSchedule) {
+		t.Fatalf("expected the bad schedule reported, got %v", err)
+	}
+	if _, ok, _ := queue.Pending("user-1", SyncTask, map[string]string{"connection_id": conn.ID}); !ok {
+		t.Fatalf("expected a sync queued on the fallback schedule")
+	}
+	next, _ := store.GetByID("user-1", conn.ID)
+	if next.NextSyncAt.Before(now.Add(24 * time.Hour)) {
+		t.Fatalf("expected fallback schedule, got %v", next.NextSyncAt)
+	}
+}
+
+func TestServiceQueueSyncDoesNotOverlap(t *testing.T) {
+	store := NewMemoryStore()
+	taskStore := tasks.NewMemoryStore()
+	queue := newTestQueue(taskStore)
+	key, _ := ParseKey("00112233445566778899aabbccddeeff00112233445566778899aabbccddeeff")
+	svc := NewService(store, &fakeClient{}, key, books.NewMemoryStore(), queue, nil)
+	conn, _ := svc.Create(context.Background(), "user-1", "https://dav.example.com", "reader", "secret", Filter{}, "", "")
+
+	first, err := svc.QueueSync("user-1", conn.ID)
+	if err != nil {
+		t.Fatalf("queue sync: %v", err)
+	}
+	second, _ := svc.QueueSync("user-1", conn.ID)
+	if second.ID != first.ID {
+		t.Fatalf("expected the queued sync returned, got %q and %q", first.ID, second.ID)
+	}
+	if _, err := svc.QueueSync("user-1", "missing"); err != ErrNotFound {
+		t.Fatalf("expected not found, got %v", err)
+	}
+
+	// A service started after a restart finds the sync in the task store.
+	restarted := NewService(store, &fakeClient{}, key, books.NewMemoryStore(), queue, nil)
+	if again, _ := restarted.QueueSync("user-1", conn.ID); again.ID != first.ID {
+		t.Fatalf("expected the stored sync returned, got %q and %q", first.ID, again.ID)
+	}
+
+	// A sync already running for the connection is not run again, and the
+	// duplicate task succeeds without doing anything.
+	svc.syncing[conn.ID] = struct{}{}
+	if err := svc.HandleSync(context.Background(), first); err != nil {
+		t.Fatalf("expected duplicate sync to succeed, got %v", err)
+	}
+	if synced, _ := store.GetByID("user-1", conn.ID); synced.LastSyncStatus != "never" {
+		t.Fatalf("expected no second sync, got %q", synced.LastSyncStatus)
+	}
+	delete(svc.syncing, conn.ID)
+	first.Status = tasks.StatusSuccess
+	_ = taskStore.Update(first)
+
+	third, _ := svc.QueueSync("user-1", conn.ID)
+	if third.ID == first.ID {
+		t.Fatalf("expected a new sync once the previous one finished")
+	}
+	if err := svc.HandleSync(context.Background(), third); err != nil {
+		t.Fatalf("handle sync: %v", err)
+	}
+	synced, _ := store.GetByID("user-1", conn.ID)
+	if synced.LastSyncStatus != "success" {
+		t.Fatalf("expected sync to run, got %q", synced.LastSyncStatus)
+	}
+}
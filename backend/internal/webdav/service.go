@@ -1,33 +1,63 @@
 package webdav
 
 import (
+	"context"
 	"errors"
 	"io"
+	"net/http"
 	"path"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/EROQIN/relite-reader/backend/internal/books"
 	"github.com/EROQIN/relite-reader/backend/internal/formats"
+	"github.com/EROQIN/relite-reader/backend/internal/library"
 	"github.com/EROQIN/relite-reader/backend/internal/tasks"
 )
 
+var ErrSyncInProgress = errors.New("webdav sync already running")
+
 type Service struct {
 	store  Store
 	client Client
 	key    []byte
 	books  books.Store
 	queue  *tasks.Queue
+	cache  *ContentCache
+
+	prefetching sync.WaitGroup
+
+	mu sync.Mutex
+	// syncing holds the connections being synced.
+	syncing map[string]struct{}
 }
 
-func NewService(store Store, client Client, key []byte, booksStore books.Store, queue *tasks.Queue) *Service {
-	return &Service{store: store, client: client, key: key, books: booksStore, queue: queue}
+// NewService wires the WebDAV service. The content cache is optional.
+func NewService(store Store, client Client, key []byte, booksStore books.Store, queue *tasks.Queue, cache *ContentCache) *Service {
+	return &Service{
+		store:   store,
+		client:  client,
+		key:     key,
+		books:   booksStore,
+		queue:   queue,
+		cache:   cache,
+		syncing: make(map[string]struct{}),
+	}
 }
 
-func (s *Service) Create(userID, baseURL, username, secret string) (Connection, error) {
+func (s *Service) Create(ctx context.Context, userID, baseURL, username, secret string, filter Filter, writeBack WriteBack, schedule string) (Connection, error) {
 	if baseURL == "" || username == "" || secret == "" {
 		return Connection{}, errors.New("invalid payload")
 	}
-	if _, err := s.client.List(baseURL, username, secret); err != nil {
+	if !writeBack.Valid() {
+		return Connection{}, ErrInvalidWriteBack
+	}
+	if err := validateSchedule(schedule); err != nil {
+		return Connection{}, err
+	}
+	filter, err := s.validate(ctx, baseURL, username, secret, filter)
+	if err != nil {
 		return Connection{}, err
 	}
 	encrypted, err := EncryptSecret(s.key, secret)
@@ -38,6 +68,9 @@ func (s *Service) Create(userID, baseURL, username, secret string) (Connection,
 		BaseURL:         baseURL,
 		Username:        username,
 		EncryptedSecret: encrypted,
+		Filter:          filter,
+		WriteBack:       writeBack,
+		Schedule:        schedule,
 		LastSyncStatus:  "never",
 	})
 }
@@ -46,11 +79,24 @@ func (s *Service) List(userID string) ([]Connection, error) {
 	return s.store.ListByUser(userID)
 }
 
-func (s *Service) Update(userID, id, baseURL, username, secret string) (Connection, error) {
+func (s *Service) Get(userID, id string) (Connection, error) {
+	return s.store.GetByID(userID, id)
+}
+
+// Update replaces the settings of a connection. A new schedule takes
+// effect with a sync at the next scheduler tick.
+func (s *Service) Update(ctx context.Context, userID, id, baseURL, username, secret string, filter Filter, writeBack WriteBack, schedule string) (Connection, error) {
 	if baseURL == "" || username == "" || secret == "" {
 		return Connection{}, errors.New("invalid payload")
 	}
-	if _, err := s.client.List(baseURL, username, secret); err != nil {
+	if !writeBack.Valid() {
+		return Connection{}, ErrInvalidWriteBack
+	}
+	if err := validateSchedule(schedule); err != nil {
+		return Connection{}, err
+	}
+	filter, err := s.validate(ctx, baseURL, username, secret, filter)
+	if err != nil {
 		return Connection{}, err
 	}
 	encrypted, err := EncryptSecret(s.key, secret)
@@ -64,58 +110,236 @@ func (s *Service) Update(userID, id, baseURL, username, secret string) (Connecti
 	conn.BaseURL = baseURL
 	conn.Username = username
 	conn.EncryptedSecret = encrypted
+	conn.Filter = filter
+	conn.WriteBack = writeBack
+	if conn.Schedule != schedule {
+		conn.Schedule = schedule
+		conn.NextSyncAt = time.Time{}
+	}
 	return s.store.Update(userID, conn)
 }
 
+// validateSchedule accepts an empty schedule, meaning the server default.
+func validateSchedule(schedule string) error {
+	if schedule == "" {
+		return nil
+	}
+	_, err := ParseSchedule(schedule)
+	return err
+}
+
+// validate normalizes the filter and checks that the library root can be
+// listed with the given credentials.
+func (s *Service) validate(ctx context.Context, baseURL, username, secret string, filter Filter) (Filter, error) {
+	filter, err := filter.Normalize()
+	if err != nil {
+		return Filter{}, err
+	}
+	listURL, err := filter.listURL(baseURL)
+	if err != nil {
+		return Filter{}, err
+	}
+	if _, err := s.client.List(ctx, listURL, username, secret, filter.dirSkipper(listURL)); err != nil {
+		return Filter{}, err
+	}
+	return filter, nil
+}
+
 func (s *Service) Delete(userID, id string) error {
-	return s.store.Delete(userID, id)
+	if err := s.store.Delete(userID, id); err != nil {
+		return err
+	}
+	if s.cache != nil {
+		_ = s.cache.RemoveConnection(id)
+	}
+	return nil
 }
 
-func (s *Service) Sync(userID, id string) error {
+// Sync lists the library root of the connection, drops sidecars and files
+// rejected by its filter and compares the rest with the listing stored by
+// the previous sync. Only new and changed files are indexed and get a
+// format task; unchanged files are left alone. New files that turn out to
+// be removed ones under another path take their book along. Connections
+// with write-back get a writeback task afterwards.
+func (s *Service) Sync(ctx context.Context, userID, id string) error {
 	conn, err := s.store.GetByID(userID, id)
 	if err != nil {
 		return err
 	}
+	s.mu.Lock()
+	if _, ok := s.syncing[id]; ok {
+		s.mu.Unlock()
+		return ErrSyncInProgress
+	}
+	s.syncing[id] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.syncing, id)
+		s.mu.Unlock()
+	}()
 	secret, err := DecryptSecret(s.key, conn.EncryptedSecret)
 	if err != nil {
-		_, _ = s.store.UpdateSyncStatus(userID, id, "error", "decrypt failed")
+		_, _ = s.store.UpdateSyncStatus(userID, id, "error", "decrypt failed", SyncResult{})
 		return err
 	}
-	entries, err := s.client.List(conn.BaseURL, conn.Username, secret)
+	listURL, err := conn.Filter.listURL(conn.BaseURL)
 	if err != nil {
-		_, _ = s.store.UpdateSyncStatus(userID, id, "error", "sync failed")
+		_, _ = s.store.UpdateSyncStatus(userID, id, "error", "invalid root path", SyncResult{})
 		return err
 	}
-	if s.books != nil {
-		present := make(map[string]struct{})
-		for _, entry := range entries {
-			present[entry.Path] = struct{}{}
-			_ = s.upsertBookFromEntry(userID, id, entry)
+	entries, err := s.client.List(ctx, listURL, conn.Username, secret, conn.Filter.dirSkipper(listURL))
+	if err != nil {
+		_, _ = s.store.UpdateSyncStatus(userID, id, "error", describeError(err), SyncResult{})
+		return err
+	}
+	root := rootPath(listURL)
+	previous, err := s.store.ListFiles(id)
+	if err != nil {
+		_, _ = s.store.UpdateSyncStatus(userID, id, "error", "sync failed", SyncResult{})
+		return err
+	}
+	known := make(map[string]FileState, len(previous))
+	for _, file := range previous {
+		known[file.Path] = file
+	}
+	var result SyncResult
+	present := make(map[string]struct{}, len(entries))
+	files := make([]FileState, 0, len(entries))
+	var added []Entry
+	for i, entry := range entries {
+		tasks.ReportProgress(ctx, i, len(entries), "Checking files")
+		if _, ok := present[entry.Path]; ok || isSidecar(entry.Path) || !conn.Filter.Match(root, entry.Path) {
+			continue
 		}
-		missing := s.computeMissing(userID, present)
+		present[entry.Path] = struct{}{}
+		state := FileState{Path: entry.Path, ETag: entry.ETag, Size: entry.Size, ModTime: entry.ModTime}
+		old, seen := known[entry.Path]
+		delete(known, entry.Path)
+		switch {
+		case !seen:
+			// New paths are handled once the removed files are known.
+			added = append(added, entry)
+			continue
+		case fileChanged(old, state):
+			result.Changed++
+			_, err = s.upsertBookFromEntry(userID, id, entry)
+		default:
+			result.Unchanged++
+			err = s.keepBook(userID, id, entry)
+		}
+		if err != nil {
+			// Remember the old state so the next sync retries.
+			state = old
+		}
+		files = append(files, state)
+	}
+	tasks.ReportProgress(ctx, len(entries), len(entries), "Checking files")
+	moves := s.detectMoves(ctx, conn, secret, userID, added, known)
+	for i, entry := range added {
+		tasks.ReportProgress(ctx, i, len(added), "Adding new files")
+		state := FileState{Path: entry.Path, ETag: entry.ETag, Size: entry.Size, ModTime: entry.ModTime}
+		if book, ok := moves[entry.Path]; ok {
+			if err := s.moveBook(userID, id, book, entry.Path); err == nil {
+				result.Moved++
+				delete(known, book.SourcePath)
+				files = append(files, state)
+				continue
+			}
+		}
+		if _, err := s.upsertBookFromEntry(userID, id, entry); err != nil {
+			continue
+		}
+		result.Added++
+		files = append(files, state)
+	}
+	if len(added) > 0 {
+		tasks.ReportProgress(ctx, len(added), len(added), "Adding new files")
+	}
+	result.Removed = len(known)
+	if err := s.store.ReplaceFiles(id, files); err != nil {
+		_, _ = s.store.UpdateSyncStatus(userID, id, "error", "sync failed", result)
+		return err
+	}
+	if s.books != nil {
+		missing := s.computeMissing(userID, id, present)
 		_ = s.books.MarkMissing(userID, missing)
 	}
-	_, err = s.store.UpdateSyncStatus(userID, id, "success", "")
+	s.prefetchWanted(ctx, conn, secret, files)
+	if conn.WriteBack != WriteBackOff && s.queue != nil {
+		// An export still waiting covers this sync too. One already
+		// running may have read the data before this sync, so it does not.
+		payload := map[string]string{"connection_id": id}
+		task, ok, err := s.queue.Pending(userID, WriteBackTask, payload)
+		if err == nil && (!ok || task.Status != tasks.StatusQueued) {
+			_, _ = s.queue.Enqueue(userID, WriteBackTask, payload)
+		}
+	}
+	_, err = s.store.UpdateSyncStatus(userID, id, "success", "", result)
 	return err
 }
 
-func (s *Service) SyncAll() error {
-	conns, err := s.store.ListAll()
-	if err != nil {
-		return err
+// prefetchWanted downloads the current versions of pinned and recently
+// read files that are not cached yet, when prefetching is enabled.
+func (s *Service) prefetchWanted(ctx context.Context, conn Connection, secret string, files []FileState) {
+	if s.cache == nil || s.cache.options.Prefetch <= 0 {
+		return
+	}
+	wanted := s.cache.Wanted(conn.ID, time.Now().Add(-s.cache.options.Prefetch))
+	var missing []cacheMeta
+	for _, file := range files {
+		if _, ok := wanted[file.Path]; !ok {
+			continue
+		}
+		version := fileVersion(file)
+		if !s.cache.Has(conn.ID, file.Path, version) {
+			missing = append(missing, cacheMeta{ConnectionID: conn.ID, Path: file.Path, Version: version})
+		}
+	}
+	s.prefetch(ctx, conn, secret, missing)
+}
+
+// prefetch downloads file versions into the content cache one at a time in
+// the background. It outlives the request that started it.
+func (s *Service) prefetch(ctx context.Context, conn Connection, secret string, files []cacheMeta) {
+	if len(files) == 0 {
+		return
 	}
-	var lastErr error
-	for _, conn := range conns {
-		if err := s.Sync(conn.UserID, conn.ID); err != nil {
-			lastErr = err
+	ctx = context.WithoutCancel(ctx)
+	s.prefetching.Add(1)
+	go func() {
+		defer s.prefetching.Done()
+		for _, meta := range files {
+			if !s.cache.claim(meta.ConnectionID, meta.Path, meta.Version) {
+				continue
+			}
+			content, err := s.client.Fetch(ctx, conn.BaseURL, conn.Username, secret, meta.Path, ContentRequest{})
+			if err != nil || content.Status != http.StatusOK {
+				if err == nil && content.Body != nil {
+					content.Body.Close()
+				}
+				s.cache.release(meta.ConnectionID, meta.Path, meta.Version)
+				continue
+			}
+			body := s.cache.fill(meta, content).Body
+			_, _ = io.Copy(io.Discard, body)
+			body.Close()
 		}
+	}()
+}
+
+// fileChanged compares ETags when the server reported one both times, and
+// falls back to size and modification time otherwise.
+func fileChanged(old, current FileState) bool {
+	if old.ETag != "" && current.ETag != "" {
+		return old.ETag != current.ETag
 	}
-	return lastErr
+	return old.Size != current.Size || !old.ModTime.Equal(current.ModTime)
 }
 
-func (s *Service) upsertBookFromEntry(userID, connectionID string, entry Entry) error {
+func (s *Service) upsertBookFromEntry(userID, connectionID string, entry Entry) (books.Book, error) {
 	if s.books == nil {
-		return nil
+		return books.Book{}, nil
 	}
 	base := path.Base(entry.Path)
 	ext := strings.ToLower(path.Ext(base))
@@ -124,26 +348,60 @@ func (s *Service) upsertBookFromEntry(userID, connectionID string, entry Entry)
 	if !ok {
 		format = strings.TrimPrefix(ext, ".")
 	}
-	book, err := s.books.Upsert(userID, books.Book{
+	book := books.Book{
 		Title:        title,
 		Format:       format,
 		SourcePath:   entry.Path,
 		ConnectionID: connectionID,
-	})
+	}
+	if existing, err := s.books.GetBySourcePath(userID, entry.Path); err == nil {
+		// Keep the format and metadata filled in by background tasks.
+		book = existing
+		book.ConnectionID = connectionID
+		book.Missing = false
+		format = existing.Format
+	}
+	book, err := s.books.Upsert(userID, book)
 	if err != nil {
-		return err
+		return books.Book{}, err
 	}
 	if s.queue != nil {
-		_, _ = s.queue.Enqueue(userID, "format", map[string]string{
+		_, _ = s.queue.Enqueue(userID, library.FormatTask, map[string]string{
 			"book_id":    book.ID,
 			"format":     format,
 			"sourcePath": entry.Path,
 		})
 	}
-	return nil
+	return book, nil
+}
+
+// keepBook handles a file that did not change since the last sync. Its
+// book only needs attention when it was deleted or marked missing in the
+// meantime.
+func (s *Service) keepBook(userID, connectionID string, entry Entry) error {
+	if s.books == nil {
+		return nil
+	}
+	book, err := s.books.GetBySourcePath(userID, entry.Path)
+	if errors.Is(err, books.ErrNotFound) {
+		_, err := s.upsertBookFromEntry(userID, connectionID, entry)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	if !book.Missing && book.ConnectionID == connectionID {
+		return nil
+	}
+	book.ConnectionID = connectionID
+	book.Missing = false
+	_, err = s.books.Upsert(userID, book)
+	return err
 }
 
-func (s *Service) computeMissing(userID string, present map[string]struct{}) []string {
+// computeMissing returns the books of a connection whose files were not in
+// its listing. Books of the user's other connections are left alone.
+func (s *Service) computeMissing(userID, connectionID string, present map[string]struct{}) []string {
 	if s.books == nil {
 		return nil
 	}
@@ -153,6 +411,9 @@ func (s *Service) computeMissing(userID string, present map[string]struct{}) []s
 	}
 	var missing []string
 	for _, book := range list {
+		if book.ConnectionID != connectionID {
+			continue
+		}
 		if _, ok := present[book.SourcePath]; !ok {
 			missing = append(missing, book.SourcePath)
 		}
@@ -160,24 +421,123 @@ func (s *Service) computeMissing(userID string, present map[string]struct{}) []s
 	return missing
 }
 
-func (s *Service) OpenContent(userID, bookID string) (io.ReadCloser, string, error) {
+// OpenContent downloads the whole content of a book.
+func (s *Service) OpenContent(ctx context.Context, userID, bookID string) (io.ReadCloser, string, error) {
+	content, err := s.FetchContent(ctx, userID, bookID, ContentRequest{})
+	if err != nil {
+		return nil, "", err
+	}
+	return content.Body, content.ContentType, nil
+}
+
+// FetchContent returns the content of a book. A cached copy of the version
+// seen by the last sync is served without asking the server; the caller
+// then answers Range and conditional headers itself, see Content.Seeker.
+// Otherwise the headers are passed through to the server, and complete
+// downloads are kept in the cache while they are read. Partial downloads
+// start a complete one in the background.
+func (s *Service) FetchContent(ctx context.Context, userID, bookID string, req ContentRequest) (Content, error) {
+	return s.fetchContent(ctx, userID, bookID, req, true)
+}
+
+func (s *Service) fetchContent(ctx context.Context, userID, bookID string, req ContentRequest, fill bool) (Content, error) {
+	book, conn, err := s.bookSource(userID, bookID)
+	if err != nil {
+		return Content{}, err
+	}
+	var meta cacheMeta
+	cacheable := false
+	if s.cache != nil {
+		if file, err := s.store.GetFile(conn.ID, book.SourcePath); err == nil {
+			meta = cacheMeta{ConnectionID: conn.ID, Path: book.SourcePath, Version: fileVersion(file)}
+			if content, ok := s.cache.Open(meta.ConnectionID, meta.Path, meta.Version); ok {
+				return content, nil
+			}
+			cacheable = fill
+		}
+	}
+	secret, err := DecryptSecret(s.key, conn.EncryptedSecret)
+	if err != nil {
+		return Content{}, err
+	}
+	if cacheable && req != (ContentRequest{}) {
+		s.prefetch(ctx, conn, secret, []cacheMeta{meta})
+		cacheable = false
+	}
+	claimed := cacheable && s.cache.claim(meta.ConnectionID, meta.Path, meta.Version)
+	content, err := s.client.Fetch(ctx, conn.BaseURL, conn.Username, secret, book.SourcePath, req)
+	if err != nil || content.Status != http.StatusOK {
+		if claimed {
+			s.cache.release(meta.ConnectionID, meta.Path, meta.Version)
+		}
+		return content, err
+	}
+	if claimed {
+		content = s.cache.fill(meta, content)
+	}
+	return content, nil
+}
+
+// PinBook keeps the content of a book in the cache whatever its size
+// limit, downloading it in the background when it is not cached yet.
+// Unpinning makes it evictable again.
+func (s *Service) PinBook(ctx context.Context, userID, bookID string, pinned bool) error {
+	if s.cache == nil {
+		return ErrCacheDisabled
+	}
+	book, conn, err := s.bookSource(userID, bookID)
+	if err != nil {
+		return err
+	}
+	if err := s.cache.SetPinned(conn.ID, book.SourcePath, pinned); err != nil || !pinned {
+		return err
+	}
+	file, err := s.store.GetFile(conn.ID, book.SourcePath)
+	if err != nil {
+		// Not synced yet; the next sync downloads it when prefetching.
+		return nil
+	}
+	secret, err := DecryptSecret(s.key, conn.EncryptedSecret)
+	if err != nil {
+		return err
+	}
+	s.prefetch(ctx, conn, secret, []cacheMeta{{ConnectionID: conn.ID, Path: file.Path, Version: fileVersion(file)}})
+	return nil
+}
+
+func (s *Service) bookSource(userID, bookID string) (books.Book, Connection, error) {
 	if s.books == nil {
-		return nil, "", errors.New("missing books store")
+		return books.Book{}, Connection{}, errors.New("missing books store")
 	}
 	book, err := s.books.GetByID(userID, bookID)
 	if err != nil {
-		return nil, "", err
+		return books.Book{}, Connection{}, err
 	}
 	if book.ConnectionID == "" {
-		return nil, "", errors.New("missing connection")
+		return books.Book{}, Connection{}, errors.New("missing connection")
 	}
 	conn, err := s.store.GetByID(userID, book.ConnectionID)
 	if err != nil {
-		return nil, "", err
+		return books.Book{}, Connection{}, err
 	}
-	secret, err := DecryptSecret(s.key, conn.EncryptedSecret)
+	return book, conn, nil
+}
+
+// Background returns a content source for background tasks. It reads
+// cached copies but never adds to the cache, so indexing a large share
+// does not push out the books being read.
+func (s *Service) Background() BackgroundContent {
+	return BackgroundContent{service: s}
+}
+
+type BackgroundContent struct {
+	service *Service
+}
+
+func (b BackgroundContent) OpenContent(ctx context.Context, userID, bookID string) (io.ReadCloser, string, error) {
+	content, err := b.service.fetchContent(ctx, userID, bookID, ContentRequest{}, false)
 	if err != nil {
 		return nil, "", err
 	}
-	return s.client.Fetch(conn.BaseURL, conn.Username, secret, book.SourcePath)
+	return content.Body, content.ContentType, nil
 }
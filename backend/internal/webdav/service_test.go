@@ -1,30 +1,58 @@
 package webdav
 
 import (
+	"context"
 	"io"
 	"testing"
+	"time"
 
 	"github.com/EROQIN/relite-reader/backend/internal/books"
+	"github.com/EROQIN/relite-reader/backend/internal/library"
+	"github.com/EROQIN/relite-reader/backend/internal/tasks"
 )
 
 type fakeClient struct {
 	err     error
 	entries []Entry
+	listed  string
 }
 
-func (f fakeClient) List(_ string, _ string, _ string) ([]Entry, error) {
+func (f *fakeClient) List(_ context.Context, baseURL, _, _ string, _ func(string) bool) ([]Entry, error) {
+	f.listed = baseURL
 	return f.entries, f.err
 }
 
-func (f fakeClient) Fetch(_, _, _, _ string) (io.ReadCloser, string, error) {
-	return nil, "", f.err
+func (f *fakeClient) Fetch(_ context.Context, _, _, _, _ string, _ ContentRequest) (Content, error) {
+	return Content{}, f.err
+}
+
+func (f *fakeClient) Put(_ context.Context, _, _, _, _ string, _ io.ReadSeeker, _ int64, _ string) (string, error) {
+	return "", f.err
+}
+
+func (f *fakeClient) MakeCollection(_ context.Context, _, _, _, _ string) error {
+	return f.err
+}
+
+func (f *fakeClient) Stat(_ context.Context, _, _, _, _ string) (Entry, error) {
+	return Entry{}, f.err
+}
+
+// newTestQueue returns a queue that accepts every task type the service
+// queues, without running them.
+func newTestQueue(store tasks.Store) *tasks.Queue {
+	registry := tasks.NewRegistry()
+	for _, taskType := range []string{library.FormatTask, WriteBackTask, SyncTask} {
+		registry.Register(taskType, func(context.Context, tasks.Task) error { return nil }, tasks.TypeOptions{})
+	}
+	return tasks.NewQueue(store, registry, tasks.QueueOptions{})
 }
 
 func TestServiceCreateValidatesClient(t *testing.T) {
 	store := NewMemoryStore()
 	key, _ := ParseKey("00112233445566778899aabbccddeeff00112233445566778899aabbccddeeff")
-	svc := NewService(store, fakeClient{err: nil}, key, nil, nil)
-	conn, err := svc.Create("user-1", "https://dav.example.com", "reader", "secret")
+	svc := NewService(store, &fakeClient{}, key, nil, nil, nil)
+	conn, err := svc.Create(context.Background(), "user-1", "https://dav.example.com", "reader", "secret", Filter{}, "", "")
 	if err != nil {
 		t.Fatalf("create: %v", err)
 	}
@@ -36,9 +64,9 @@ func TestServiceCreateValidatesClient(t *testing.T) {
 func TestServiceSyncUpdatesStatus(t *testing.T) {
 	store := NewMemoryStore()
 	key, _ := ParseKey("00112233445566778899aabbccddeeff00112233445566778899aabbccddeeff")
-	svc := NewService(store, fakeClient{err: nil}, key, nil, nil)
-	conn, _ := svc.Create("user-1", "https://dav.example.com", "reader", "secret")
-	if err := svc.Sync("user-1", conn.ID); err != nil {
+	svc := NewService(store, &fakeClient{}, key, nil, nil, nil)
+	conn, _ := svc.Create(context.Background(), "user-1", "https://dav.example.com", "reader", "secret", Filter{}, "", "")
+	if err := svc.Sync(context.Background(), "user-1", conn.ID); err != nil {
 		t.Fatalf("sync: %v", err)
 	}
 	updated, _ := store.GetByID("user-1", conn.ID)
@@ -51,19 +79,204 @@ func TestServiceSyncIndexesBooks(t *testing.T) {
 	store := NewMemoryStore()
 	booksStore := books.NewMemoryStore()
 	key, _ := ParseKey("00112233445566778899aabbccddeeff00112233445566778899aabbccddeeff")
-	client := fakeClient{entries: []Entry{{Path: "/library/A.epub"}, {Path: "/library/B.pdf"}}}
-	svc := NewService(store, client, key, booksStore, nil)
-	conn, _ := svc.Create("user-1", "https://dav.example.com", "reader", "secret")
-	_, _ = booksStore.Upsert("user-1", books.Book{SourcePath: "/library/OLD.txt", Title: "OLD"})
-	if err := svc.Sync("user-1", conn.ID); err != nil {
+	client := &fakeClient{entries: []Entry{{Path: "/library/A.epub"}, {Path: "/library/B.pdf"}}}
+	svc := NewService(store, client, key, booksStore, nil, nil)
+	conn, _ := svc.Create(context.Background(), "user-1", "https://dav.example.com", "reader", "secret", Filter{}, "", "")
+	_, _ = booksStore.Upsert("user-1", books.Book{SourcePath: "/library/OLD.txt", Title: "OLD", ConnectionID: conn.ID})
+	_, _ = booksStore.Upsert("user-1", books.Book{SourcePath: "/other/C.epub", Title: "C", ConnectionID: "other"})
+	if err := svc.Sync(context.Background(), "user-1", conn.ID); err != nil {
 		t.Fatalf("sync: %v", err)
 	}
 	list, _ := booksStore.ListByUser("user-1")
-	if len(list) != 3 {
-		t.Fatalf("expected 3 books, got %d", len(list))
+	if len(list) != 4 {
+		t.Fatalf("expected 4 books, got %d", len(list))
 	}
 	missing, _ := booksStore.GetBySourcePath("user-1", "/library/OLD.txt")
 	if !missing.Missing {
 		t.Fatalf("expected old entry marked missing")
 	}
+	other, _ := booksStore.GetBySourcePath("user-1", "/other/C.epub")
+	if other.Missing {
+		t.Fatalf("expected books of other connections left alone")
+	}
+}
+
+func TestServiceSyncKeepsExtractedMetadata(t *testing.T) {
+	store := NewMemoryStore()
+	booksStore := books.NewMemoryStore()
+	key, _ := ParseKey("00112233445566778899aabbccddeeff00112233445566778899aabbccddeeff")
+	client := &fakeClient{entries: []Entry{{Path: "/library/the_hobbit_v2_final.epub"}}}
+	svc := NewService(store, client, key, booksStore, nil, nil)
+	conn, _ := svc.Create(context.Background(), "user-1", "https://dav.example.com", "reader", "secret", Filter{}, "", "")
+	_ = svc.Sync(context.Background(), "user-1", conn.ID)
+	book, _ := booksStore.GetBySourcePath("user-1", "/library/the_hobbit_v2_final.epub")
+	book.Title = "The Hobbit"
+	book.Author = "J. R. R. Tolkien"
+	_, _ = booksStore.Upsert("user-1", book)
+	if err := svc.Sync(context.Background(), "user-1", conn.ID); err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+	synced, _ := booksStore.GetBySourcePath("user-1", "/library/the_hobbit_v2_final.epub")
+	if synced.Title != "The Hobbit" || synced.Author != "J. R. R. Tolkien" {
+		t.Fatalf("expected metadata to survive sync, got %+v", synced)
+	}
+}
+
+func TestServiceSyncSkipsUnchangedFiles(t *testing.T) {
+	store := NewMemoryStore()
+	booksStore := books.NewMemoryStore()
+	taskStore := tasks.NewMemoryStore()
+	queue := newTestQueue(taskStore)
+	key, _ := ParseKey("00112233445566778899aabbccddeeff00112233445566778899aabbccddeeff")
+	modified := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	client := &fakeClient{entries: []Entry{
+		{Path: "/library/A.epub", Size: 10, ETag: `"a1"`},
+		{Path: "/library/B.pdf", Size: 20, ModTime: modified},
+		{Path: "/library/C.txt", Size: 30, ETag: `"c1"`},
+	}}
+	svc := NewService(store, client, key, booksStore, queue, nil)
+	conn, _ := svc.Create(context.Background(), "user-1", "https://dav.example.com", "reader", "secret", Filter{}, "", "")
+	if err := svc.Sync(context.Background(), "user-1", conn.ID); err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+	first, _ := store.GetByID("user-1", conn.ID)
+	if first.LastSyncResult != (SyncResult{Added: 3}) {
+		t.Fatalf("unexpected first result %+v", first.LastSyncResult)
+	}
+
+	client.entries = []Entry{
+		{Path: "/library/A.epub", Size: 10, ETag: `"a2"`},
+		{Path: "/library/B.pdf", Size: 20, ModTime: modified},
+		{Path: "/library/D.epub", Size: 40},
+	}
+	if err := svc.Sync(context.Background(), "user-1", conn.ID); err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+	second, _ := store.GetByID("user-1", conn.ID)
+	if second.LastSyncResult != (SyncResult{Added: 1, Changed: 1, Removed: 1, Unchanged: 1}) {
+		t.Fatalf("unexpected second result %+v", second.LastSyncResult)
+	}
+	list, _ := taskStore.ListByUser("user-1")
+	enqueued := map[string]int{}
+	for _, task := range list {
+		enqueued[task.Payload["sourcePath"]]++
+	}
+	if enqueued["/library/A.epub"] != 2 || enqueued["/library/B.pdf"] != 1 || enqueued["/library/D.epub"] != 1 {
+		t.Fatalf("unexpected format tasks %v", enqueued)
+	}
+	removed, _ := booksStore.GetBySourcePath("user-1", "/library/C.txt")
+	if !removed.Missing {
+		t.Fatalf("expected removed file marked missing")
+	}
+}
+
+func TestServiceSyncSkipsSidecarsAndQueuesWriteBack(t *testing.T) {
+	store := NewMemoryStore()
+	booksStore := books.NewMemoryStore()
+	taskStore := tasks.NewMemoryStore()
+	queue := newTestQueue(taskStore)
+	key, _ := ParseKey("00112233445566778899aabbccddeeff00112233445566778899aabbccddeeff")
+	client := &fakeClient{entries: []Entry{
+		{Path: "/library/A.epub", Size: 10},
+		{Path: "/library/A.epub.relite.json", Size: 2},
+		{Path: "/library/A.sdr/metadata.epub.lua", Size: 3},
+	}}
+	svc := NewService(store, client, key, booksStore, queue, nil)
+	conn, _ := svc.Create(context.Background(), "user-1", "https://dav.example.com", "reader", "secret", Filter{}, WriteBackKOReader, "")
+	if err := svc.Sync(context.Background(), "user-1", conn.ID); err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+	synced, _ := store.GetByID("user-1", conn.ID)
+	if synced.LastSyncResult != (SyncResult{Added: 1}) {
+		t.Fatalf("expected sidecars skipped, got %+v", synced.LastSyncResult)
+	}
+	// The export queued by the first sync covers the second one.
+	if err := svc.Sync(context.Background(), "user-1", conn.ID); err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+	list, _ := taskStore.ListByUser("user-1")
+	writeBacks := 0
+	for _, task := range list {
+		if task.Type == WriteBackTask && task.Payload["connection_id"] == conn.ID {
+			writeBacks++
+		}
+	}
+	if writeBacks != 1 {
+		t.Fatalf("expected one writeback task, got %d", writeBacks)
+	}
+}
+
+func TestServiceSyncQueuesWriteBackBehindRunningOne(t *testing.T) {
+	store := NewMemoryStore()
+	taskStore := tasks.NewMemoryStore()
+	queue := newTestQueue(taskStore)
+	key, _ := ParseKey("00112233445566778899aabbccddeeff00112233445566778899aabbccddeeff")
+	client := &fakeClient{entries: []Entry{{Path: "/library/A.epub", Size: 10}}}
+	svc := NewService(store, client, key, books.NewMemoryStore(), queue, nil)
+	conn, _ := svc.Create(context.Background(), "user-1", "https://dav.example.com", "reader", "secret", Filter{}, WriteBackRelite, "")
+	running, _ := taskStore.Create(tasks.Task{UserID: "user-1", Type: WriteBackTask, Status: tasks.StatusRunning, Payload: map[string]string{"connection_id": conn.ID}})
+
+	// The running export may have read the data already, so each sync
+	// needs one queued behind it, but only one.
+	for range 2 {
+		if err := svc.Sync(context.Background(), "user-1", conn.ID); err != nil {
+			t.Fatalf("sync: %v", err)
+		}
+	}
+	list, _ := taskStore.ListByUser("user-1")
+	queued := 0
+	for _, task := range list {
+		if task.Type == WriteBackTask && task.ID != running.ID && task.Status == tasks.StatusQueued {
+			queued++
+		}
+	}
+	if queued != 1 {
+		t.Fatalf("expected one writeback queued behind the running one, got %d", queued)
+	}
+}
+
+func TestServiceSyncAppliesFilter(t *testing.T) {
+	store := NewMemoryStore()
+	booksStore := books.NewMemoryStore()
+	key, _ := ParseKey("00112233445566778899aabbccddeeff00112233445566778899aabbccddeeff")
+	client := &fakeClient{entries: []Entry{
+		{Path: "/dav/Books/A.epub"},
+		{Path: "/dav/Books/.Trash/B.epub"},
+		{Path: "/dav/Books/cover.jpg"},
+	}}
+	svc := NewService(store, client, key, booksStore, nil, nil)
+	conn, err := svc.Create(context.Background(), "user-1", "https://dav.example.com/dav", "reader", "secret", Filter{
+		RootPath:      "Books",
+		Exclude:       []string{".Trash"},
+		SupportedOnly: true,
+	}, WriteBackOff, "")
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if err := svc.Sync(context.Background(), "user-1", conn.ID); err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+	if client.listed != "https://dav.example.com/dav/Books" {
+		t.Fatalf("expected root to be listed, got %q", client.listed)
+	}
+	list, _ := booksStore.ListByUser("user-1")
+	if len(list) != 1 || list[0].SourcePath != "/dav/Books/A.epub" {
+		t.Fatalf("expected only A.epub, got %+v", list)
+	}
+}
+
+func TestServiceSyncRecordsErrorKind(t *testing.T) {
+	store := NewMemoryStore()
+	key, _ := ParseKey("00112233445566778899aabbccddeeff00112233445566778899aabbccddeeff")
+	client := &fakeClient{}
+	svc := NewService(store, client, key, nil, nil, nil)
+	conn, _ := svc.Create(context.Background(), "user-1", "https://dav.example.com", "reader", "secret", Filter{}, "", "")
+	client.err = statusError{code: 401}
+	if err := svc.Sync(context.Background(), "user-1", conn.ID); err == nil {
+		t.Fatalf("expected sync error")
+	}
+	updated, _ := store.GetByID("user-1", conn.ID)
+	if updated.LastSyncStatus != "error" || updated.LastError != "authentication failed (HTTP 401)" {
+		t.Fatalf("unexpected status %q / %q", updated.LastSyncStatus, updated.LastError)
+	}
 }
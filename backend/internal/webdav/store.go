@@ -7,15 +7,46 @@ import (
 
 var ErrNotFound = errors.New("webdav connection not found")
 
+var ErrFileNotFound = errors.New("webdav file not found")
+
 type Connection struct {
 	ID              string
 	UserID          string
 	BaseURL         string
 	Username        string
 	EncryptedSecret []byte
-	LastSyncStatus  string
-	LastError       string
-	LastSyncAt      time.Time
+	Filter          Filter
+	WriteBack       WriteBack
+	// Schedule is a duration, a cron expression or "off"; empty uses the
+	// server default. NextSyncAt is when the scheduler queues the next
+	// sync, zero meaning as soon as possible.
+	Schedule       string
+	NextSyncAt     time.Time
+	LastSyncStatus string
+	LastError      string
+	LastSyncAt     time.Time
+	LastSyncResult SyncResult
+}
+
+// SyncResult counts the files seen by the last sync, compared with the
+// listing stored by the sync before it.
+type SyncResult struct {
+	Added     int
+	Changed   int
+	Removed   int
+	Unchanged int
+	// Moved counts files found under a new path; their books followed
+	// them.
+	Moved int
+}
+
+// FileState is what a sync remembers about one remote file, to tell
+// whether it changed since.
+type FileState struct {
+	Path    string
+	ETag    string
+	Size    int64
+	ModTime time.Time
 }
 
 type Store interface {
@@ -25,5 +56,11 @@ type Store interface {
 	GetByID(userID, id string) (Connection, error)
 	Update(userID string, conn Connection) (Connection, error)
 	Delete(userID, id string) error
-	UpdateSyncStatus(userID, id, status, lastError string) (Connection, error)
+	UpdateSyncStatus(userID, id, status, lastError string, result SyncResult) (Connection, error)
+	SetNextSync(userID, id string, at time.Time) error
+	ListFiles(connectionID string) ([]FileState, error)
+	GetFile(connectionID, path string) (FileState, error)
+	ReplaceFiles(connectionID string, files []FileState) error
+	// PutFile adds or replaces one file of the stored listing.
+	PutFile(connectionID string, file FileState) error
 }
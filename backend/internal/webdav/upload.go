@@ -0,0 +1,99 @@
+package webdav
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/EROQIN/relite-reader/backend/internal/books"
+	"github.com/EROQIN/relite-reader/backend/internal/formats"
+)
+
+var (
+	ErrUnsupportedFormat = errors.New("unsupported book format")
+	ErrInvalidUpload     = errors.New("invalid upload")
+	ErrFileExists        = errors.New("file already exists")
+)
+
+// Upload stores a book in a folder below the library root of a connection,
+// creating missing folders, and indexes it right away instead of waiting
+// for the next sync. The file is added to the stored listing too, so that
+// sync sees it unchanged. An existing file is never replaced. Size bytes of
+// body are streamed to the server.
+func (s *Service) Upload(ctx context.Context, userID, connectionID, folder, name string, body io.ReadSeeker, size int64) (books.Book, error) {
+	if s.books == nil {
+		return books.Book{}, errors.New("missing books store")
+	}
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return books.Book{}, ErrInvalidUpload
+	}
+	if _, ok := formats.Detect(name); !ok {
+		return books.Book{}, ErrUnsupportedFormat
+	}
+	conn, secret, err := s.credentials(userID, connectionID)
+	if err != nil {
+		return books.Book{}, err
+	}
+	listURL, err := conn.Filter.listURL(conn.BaseURL)
+	if err != nil {
+		return books.Book{}, err
+	}
+	base, err := url.Parse(conn.BaseURL)
+	if err != nil {
+		return books.Book{}, err
+	}
+	root, err := url.Parse(listURL)
+	if err != nil {
+		return books.Book{}, err
+	}
+	// Cleaning below "/" keeps ".." from leaving the library root.
+	dir := path.Join(root.Path, path.Clean("/"+folder))
+	target := path.Join(dir, name)
+	if isSidecar(target) || !conn.Filter.Match(root.Path, target) {
+		return books.Book{}, ErrInvalidUpload
+	}
+	if err := s.makeDirs(ctx, conn, secret, base.Path, dir); err != nil {
+		return books.Book{}, err
+	}
+	// Put is sent once, so a 412 means the file was there before.
+	etag, err := s.client.Put(ctx, conn.BaseURL, conn.Username, secret, target, body, size, "")
+	if errors.Is(err, ErrPreconditionFailed) {
+		return books.Book{}, ErrFileExists
+	}
+	if err != nil {
+		return books.Book{}, err
+	}
+	// Servers rarely return the modification time, and not all return the
+	// ETag, that the next listing compares against.
+	entry, err := s.client.Stat(ctx, conn.BaseURL, conn.Username, secret, target)
+	if err != nil {
+		entry = Entry{Path: target, Size: size, ETag: etag}
+	}
+	book, err := s.upsertBookFromEntry(userID, connectionID, entry)
+	if err != nil {
+		return books.Book{}, err
+	}
+	_ = s.store.PutFile(connectionID, FileState{Path: entry.Path, ETag: entry.ETag, Size: entry.Size, ModTime: entry.ModTime})
+	return book, nil
+}
+
+// makeDirs creates each folder from below the base URL down to dir. MKCOL
+// on a folder that exists is harmless.
+func (s *Service) makeDirs(ctx context.Context, conn Connection, secret, basePath, dir string) error {
+	basePath = strings.TrimSuffix(basePath, "/")
+	rel, ok := strings.CutPrefix(dir, basePath+"/")
+	if !ok || rel == "" {
+		return nil
+	}
+	current := basePath
+	for _, segment := range strings.Split(rel, "/") {
+		current += "/" + segment
+		if err := s.client.MakeCollection(ctx, conn.BaseURL, conn.Username, secret, current); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package webdav
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/EROQIN/relite-reader/backend/internal/books"
+	"github.com/EROQIN/relite-reader/backend/internal/library"
+	"github.com/EROQIN/relite-reader/backend/internal/tasks"
+)
+
+type uploadClient struct {
+	fakeClient
+	dirs  []string
+	files map[string][]byte
+}
+
+func (c *uploadClient) Put(_ context.Context, _, _, _, filePath string, body io.ReadSeeker, size int64, ifMatch string) (string, error) {
+	if _, ok := c.files[filePath]; ok && ifMatch == "" {
+		return "", ErrPreconditionFailed
+	}
+	data, err := io.ReadAll(body)
+	if err != nil || int64(len(data)) != size {
+		return "", errors.New("short body")
+	}
+	c.files[filePath] = data
+	return `"v1"`, nil
+}
+
+func (c *uploadClient) Stat(_ context.Context, _, _, _, filePath string) (Entry, error) {
+	return Entry{Path: filePath, Size: int64(len(c.files[filePath])), ETag: `"v1"`, ModTime: time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)}, nil
+}
+
+func (c *uploadClient) MakeCollection(_ context.Context, _, _, _, dirPath string) error {
+	c.dirs = append(c.dirs, dirPath)
+	return nil
+}
+
+func TestServiceUploadStoresAndIndexesBook(t *testing.T) {
+	booksStore := books.NewMemoryStore()
+	taskStore := tasks.NewMemoryStore()
+	queue := newTestQueue(taskStore)
+	key, _ := ParseKey("00112233445566778899aabbccddeeff00112233445566778899aabbccddeeff")
+	client := &uploadClient{files: map[string][]byte{}}
+	svc := NewService(NewMemoryStore(), client, key, booksStore, queue, nil)
+	conn, err := svc.Create(context.Background(), "user-1", "https://dav.example.com/dav/", "reader", "secret", Filter{RootPath: "Books"}, "", "")
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+
+	book, err := svc.Upload(context.Background(), "user-1", conn.ID, "Fiction/../Sci-Fi", "Dune.epub", strings.NewReader("epub"), 4)
+	if err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+	if book.SourcePath != "/dav/Books/Sci-Fi/Dune.epub" || book.Format != "epub" || book.Title != "Dune" || book.ConnectionID != conn.ID {
+		t.Fatalf("unexpected book %+v", book)
+	}
+	if string(client.files["/dav/Books/Sci-Fi/Dune.epub"]) != "epub" {
+		t.Fatalf("expected file uploaded, got %v", client.files)
+	}
+	if len(client.dirs) != 2 || client.dirs[0] != "/dav/Books" || client.dirs[1] != "/dav/Books/Sci-Fi" {
+		t.Fatalf("unexpected folders %v", client.dirs)
+	}
+	list, _ := taskStore.ListByUser("user-1")
+	if len(list) != 1 || list[0].Type != library.FormatTask || list[0].Payload["book_id"] != book.ID {
+		t.Fatalf("expected format task, got %+v", list)
+	}
+
+	file, err := svc.store.GetFile(conn.ID, "/dav/Books/Sci-Fi/Dune.epub")
+	if err != nil || file.ETag != `"v1"` || file.Size != 4 || file.ModTime.IsZero() {
+		t.Fatalf("expected uploaded file in the listing, got %+v (%v)", file, err)
+	}
+	// The next sync finds the file unchanged and queues nothing new.
+	client.entries = []Entry{{Path: file.Path, Size: file.Size, ETag: file.ETag, ModTime: file.ModTime}}
+	if err := svc.Sync(context.Background(), "user-1", conn.ID); err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+	if list, _ := taskStore.ListByUser("user-1"); len(list) != 1 {
+		t.Fatalf("expected no second format task, got %+v", list)
+	}
+
+	if _, err := svc.Upload(context.Background(), "user-1", conn.ID, "Sci-Fi", "Dune.epub", strings.NewReader("again"), 5); !errors.Is(err, ErrFileExists) {
+		t.Fatalf("expected existing file kept, got %v", err)
+	}
+	if _, err := svc.Upload(context.Background(), "user-1", conn.ID, "", "notes.exe", strings.NewReader("x"), 1); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Fatalf("expected unsupported format, got %v", err)
+	}
+	if _, err := svc.Upload(context.Background(), "user-1", conn.ID, "", "../Dune.epub", strings.NewReader("x"), 1); !errors.Is(err, ErrInvalidUpload) {
+		t.Fatalf("expected invalid name, got %v", err)
+	}
+}
@@ -0,0 +1,126 @@
+package webdav
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+var ErrInvalidWriteBack = errors.New("invalid write-back mode")
+
+// WriteBackTask is the task type of a sidecar export.
+const WriteBackTask = "writeback"
+
+// maxSidecarSize bounds the files read back by ReadFile.
+const maxSidecarSize = 8 << 20
+
+// WriteBack selects the sidecar file that annotations, bookmarks and
+// progress are exported to next to each book on the share.
+type WriteBack string
+
+const (
+	WriteBackOff WriteBack = ""
+	// WriteBackRelite writes book.epub.relite.json.
+	WriteBackRelite WriteBack = "relite"
+	// WriteBackKOReader writes book.sdr/metadata.epub.lua, as KOReader does.
+	WriteBackKOReader WriteBack = "koreader"
+)
+
+func (w WriteBack) Valid() bool {
+	switch w {
+	case WriteBackOff, WriteBackRelite, WriteBackKOReader:
+		return true
+	}
+	return false
+}
+
+// SidecarPath returns where the sidecar of a book goes, or "" when write-back
+// is off.
+func SidecarPath(mode WriteBack, bookPath string) string {
+	switch mode {
+	case WriteBackRelite:
+		return bookPath + ".relite.json"
+	case WriteBackKOReader:
+		dir, name := path.Split(bookPath)
+		ext := path.Ext(name)
+		return dir + strings.TrimSuffix(name, ext) + ".sdr/metadata" + strings.ToLower(ext) + ".lua"
+	}
+	return ""
+}
+
+// isSidecar reports whether a listed file is a sidecar rather than a book.
+func isSidecar(filePath string) bool {
+	return strings.HasSuffix(filePath, ".relite.json") || strings.Contains(filePath, ".sdr/")
+}
+
+// RemoteFile is a small file read from a share.
+type RemoteFile struct {
+	Data    []byte
+	ETag    string
+	ModTime time.Time
+}
+
+// ReadFile downloads a small file, such as a sidecar, from the share of a
+// connection. A missing file returns ErrFileNotFound.
+func (s *Service) ReadFile(ctx context.Context, userID, connectionID, filePath string) (RemoteFile, error) {
+	conn, secret, err := s.credentials(userID, connectionID)
+	if err != nil {
+		return RemoteFile{}, err
+	}
+	content, err := s.client.Fetch(ctx, conn.BaseURL, conn.Username, secret, filePath, ContentRequest{})
+	var status statusError
+	if errors.As(err, &status) && status.code == http.StatusNotFound {
+		return RemoteFile{}, ErrFileNotFound
+	}
+	if err != nil {
+		return RemoteFile{}, err
+	}
+	if content.Body == nil {
+		return RemoteFile{}, errors.New("empty webdav response")
+	}
+	defer content.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(content.Body, maxSidecarSize+1))
+	if err != nil {
+		return RemoteFile{}, err
+	}
+	if len(data) > maxSidecarSize {
+		return RemoteFile{}, errors.New("file too large")
+	}
+	return RemoteFile{Data: data, ETag: content.ETag, ModTime: content.LastModified}, nil
+}
+
+// WriteFile uploads a file to the share of a connection. See Client.Put
+// for ifMatch.
+func (s *Service) WriteFile(ctx context.Context, userID, connectionID, filePath string, data []byte, ifMatch string) (string, error) {
+	conn, secret, err := s.credentials(userID, connectionID)
+	if err != nil {
+		return "", err
+	}
+	return s.client.Put(ctx, conn.BaseURL, conn.Username, secret, filePath, bytes.NewReader(data), int64(len(data)), ifMatch)
+}
+
+// MakeDir creates a folder on the share of a connection unless it exists.
+func (s *Service) MakeDir(ctx context.Context, userID, connectionID, dirPath string) error {
+	conn, secret, err := s.credentials(userID, connectionID)
+	if err != nil {
+		return err
+	}
+	return s.client.MakeCollection(ctx, conn.BaseURL, conn.Username, secret, dirPath)
+}
+
+func (s *Service) credentials(userID, connectionID string) (Connection, string, error) {
+	conn, err := s.store.GetByID(userID, connectionID)
+	if err != nil {
+		return Connection{}, "", err
+	}
+	secret, err := DecryptSecret(s.key, conn.EncryptedSecret)
+	if err != nil {
+		return Connection{}, "", err
+	}
+	return conn, secret, nil
+}
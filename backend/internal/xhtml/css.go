@@ -0,0 +1,50 @@
+package xhtml
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	cssURL    = regexp.MustCompile(`(?i)url\(\s*(?:"([^"]*)"|'([^']*)'|([^)"'\s]*))\s*\)`)
+	cssImport = regexp.MustCompile(`(?i)@import\s+(?:"([^"]*)"|'([^']*)')`)
+	// cssUnsafe matches constructs that can run script in old browsers.
+	cssUnsafe = regexp.MustCompile(`(?i)expression\s*\(|javascript:|vbscript:|behavior\s*:|-moz-binding`)
+)
+
+// RewriteCSS maps every url() and @import reference through resolve.
+// References that resolve rejects are replaced by an empty url().
+func RewriteCSS(css string, resolve func(ref string) (string, bool)) string {
+	css = cssUnsafe.ReplaceAllString(css, "/* removed */")
+	css = cssImport.ReplaceAllStringFunc(css, func(match string) string {
+		parts := cssImport.FindStringSubmatch(match)
+		// Emit a quoted string so the url() pass below leaves it alone.
+		ref := strings.TrimPrefix(cssRef(firstNonEmpty(parts[1:]), resolve), "url(")
+		return "@import " + strings.TrimSuffix(ref, ")")
+	})
+	return cssURL.ReplaceAllStringFunc(css, func(match string) string {
+		parts := cssURL.FindStringSubmatch(match)
+		return cssRef(firstNonEmpty(parts[1:]), resolve)
+	})
+}
+
+func cssRef(ref string, resolve func(string) (string, bool)) string {
+	ref = strings.TrimSpace(ref)
+	if strings.HasPrefix(ref, "#") {
+		return `url("` + ref + `")`
+	}
+	mapped, ok := resolve(ref)
+	if !ok {
+		return `url("")`
+	}
+	return `url("` + strings.NewReplacer(`"`, `%22`, `\`, `%5C`, "\n", "").Replace(mapped) + `")`
+}
+
+func firstNonEmpty(values []string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
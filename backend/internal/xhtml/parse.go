@@ -0,0 +1,103 @@
+package xhtml
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+const (
+	nsXHTML = "http://www.w3.org/1999/xhtml"
+	nsSVG   = "http://www.w3.org/2000/svg"
+	nsXLink = "http://www.w3.org/1999/xlink"
+	nsXML   = "http://www.w3.org/XML/1998/namespace"
+	nsOPS   = "http://www.idpf.org/2007/ops"
+)
+
+// Parse builds a node tree from a content document. Well-formed XHTML is
+// read as XML so self-closing elements such as <a id="p5"/> keep their
+// meaning; anything else goes through the HTML5 parser.
+func Parse(data []byte) (*html.Node, error) {
+	if root, err := parseXML(data); err == nil {
+		return root, nil
+	}
+	return html.Parse(bytes.NewReader(data))
+}
+
+func parseXML(data []byte) (*html.Node, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	decoder.Strict = true
+	decoder.Entity = xml.HTMLEntity
+	decoder.CharsetReader = func(label string, input io.Reader) (io.Reader, error) {
+		enc, err := htmlindex.Get(label)
+		if err != nil {
+			return nil, err
+		}
+		return enc.NewDecoder().Reader(input), nil
+	}
+	root := &html.Node{Type: html.DocumentNode}
+	current := root
+	depth := 0
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			if err == io.EOF && depth == 0 && root.FirstChild != nil {
+				return root, nil
+			}
+			return nil, err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			node := &html.Node{Type: html.ElementNode}
+			switch t.Name.Space {
+			case nsSVG:
+				node.Namespace = "svg"
+				node.Data = t.Name.Local
+			default:
+				node.Data = strings.ToLower(t.Name.Local)
+			}
+			node.DataAtom = atom.Lookup([]byte(node.Data))
+			for _, attr := range t.Attr {
+				if attr.Name.Space == "xmlns" || (attr.Name.Space == "" && attr.Name.Local == "xmlns") {
+					continue
+				}
+				node.Attr = append(node.Attr, html.Attribute{
+					Namespace: attrNamespace(attr.Name.Space),
+					Key:       attr.Name.Local,
+					Val:       attr.Value,
+				})
+			}
+			current.AppendChild(node)
+			current = node
+			depth++
+		case xml.EndElement:
+			current = current.Parent
+			depth--
+		case xml.CharData:
+			if depth > 0 {
+				current.AppendChild(&html.Node{Type: html.TextNode, Data: string(t)})
+			}
+		}
+	}
+}
+
+func attrNamespace(space string) string {
+	switch space {
+	case "":
+		return ""
+	case nsXLink, "xlink":
+		return "xlink"
+	case nsXML, "xml":
+		return "xml"
+	case nsOPS, "epub":
+		return "epub"
+	case nsXHTML:
+		return ""
+	}
+	return space
+}
@@ -0,0 +1,272 @@
+package xhtml
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+var errNoDocument = errors.New("empty content document")
+
+// Kind tells a Resolver what a reference is used for.
+type Kind int
+
+const (
+	// Link is the target of an <a href>.
+	Link Kind = iota
+	// Resource is an image, stylesheet or other embedded file.
+	Resource
+)
+
+// Resolver maps a reference found in the source document to the URL the
+// reader should use. Returning false drops the reference.
+type Resolver func(kind Kind, ref string) (string, bool)
+
+// allowedElements lists the elements kept in the output. Anything else is
+// unwrapped so its text survives.
+var allowedElements = map[string]bool{
+	"a": true, "abbr": true, "article": true, "aside": true, "b": true, "bdi": true,
+	"bdo": true, "blockquote": true, "br": true, "caption": true, "cite": true,
+	"code": true, "col": true, "colgroup": true, "dd": true, "del": true,
+	"details": true, "dfn": true, "div": true, "dl": true, "dt": true, "em": true,
+	"figcaption": true, "figure": true, "footer": true, "h1": true, "h2": true,
+	"h3": true, "h4": true, "h5": true, "h6": true, "header": true, "hr": true,
+	"i": true, "img": true, "ins": true, "kbd": true, "li": true, "main": true,
+	"mark": true, "nav": true, "ol": true, "p": true, "pre": true, "q": true,
+	"rp": true, "rt": true, "ruby": true, "s": true, "samp": true, "section": true,
+	"small": true, "span": true, "strong": true, "sub": true, "summary": true,
+	"sup": true, "table": true, "tbody": true, "td": true, "tfoot": true, "th": true,
+	"thead": true, "time": true, "tr": true, "u": true, "ul": true, "var": true,
+	"wbr": true,
+}
+
+// droppedElements are removed together with their content.
+var droppedElements = map[string]bool{
+	"script": true, "style": true, "iframe": true, "object": true, "embed": true,
+	"applet": true, "frame": true, "frameset": true, "template": true,
+	"noscript": true, "head": true, "title": true, "meta": true, "link": true,
+	"base": true, "audio": true, "video": true, "canvas": true, "math": true,
+	"form": true, "input": true, "button": true, "select": true, "textarea": true,
+}
+
+var svgElements = map[string]bool{"svg": true, "g": true, "image": true}
+
+var globalAttrs = map[string]bool{"id": true, "class": true, "title": true, "lang": true, "dir": true}
+
+var elementAttrs = map[string]map[string]bool{
+	"img":      {"alt": true, "width": true, "height": true},
+	"td":       {"colspan": true, "rowspan": true, "headers": true},
+	"th":       {"colspan": true, "rowspan": true, "headers": true, "scope": true},
+	"ol":       {"start": true, "type": true, "reversed": true},
+	"li":       {"value": true},
+	"col":      {"span": true},
+	"colgroup": {"span": true},
+	"time":     {"datetime": true},
+	"svg":      {"viewBox": true, "width": true, "height": true, "preserveAspectRatio": true, "version": true},
+	"image":    {"width": true, "height": true, "x": true, "y": true, "preserveAspectRatio": true},
+	"g":        {"transform": true},
+}
+
+// Sanitize parses a content document and returns a standalone XHTML
+// document that keeps only presentational markup. Scripts, forms, event
+// handlers and external embeds are removed; links, images and stylesheets
+// are passed through resolve.
+func Sanitize(data []byte, resolve Resolver) ([]byte, error) {
+	root, err := Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	if root.FirstChild == nil {
+		return nil, errNoDocument
+	}
+	s := &sanitizer{resolve: resolve}
+	if head := find(root, "head"); head != nil {
+		s.collectHead(head)
+	}
+	body := find(root, "body")
+	if body == nil {
+		body = root
+	}
+	out := &html.Node{Type: html.ElementNode, Data: "body"}
+	if body.Type == html.ElementNode {
+		out.Attr = s.attrs(body)
+	}
+	for child := body.FirstChild; child != nil; child = child.NextSibling {
+		s.clean(child, out)
+	}
+	return s.render(out)
+}
+
+type sanitizer struct {
+	resolve     Resolver
+	title       string
+	stylesheets []string
+	styles      []string
+}
+
+func (s *sanitizer) collectHead(head *html.Node) {
+	for node := head.FirstChild; node != nil; node = node.NextSibling {
+		if node.Type != html.ElementNode {
+			continue
+		}
+		switch node.Data {
+		case "title":
+			s.title = strings.TrimSpace(textContent(node))
+		case "link":
+			if !strings.Contains(strings.ToLower(attr(node, "rel")), "stylesheet") {
+				continue
+			}
+			if href, ok := s.resolve(Resource, attr(node, "href")); ok {
+				s.stylesheets = append(s.stylesheets, href)
+			}
+		case "style":
+			s.styles = append(s.styles, RewriteCSS(textContent(node), s.resource))
+		}
+	}
+}
+
+func (s *sanitizer) resource(ref string) (string, bool) {
+	return s.resolve(Resource, ref)
+}
+
+func (s *sanitizer) clean(node, parent *html.Node) {
+	switch node.Type {
+	case html.TextNode:
+		parent.AppendChild(&html.Node{Type: html.TextNode, Data: node.Data})
+		return
+	case html.ElementNode:
+	default:
+		return
+	}
+	name := node.Data
+	if droppedElements[name] && node.Namespace == "" {
+		return
+	}
+	var out *html.Node
+	switch {
+	case node.Namespace == "svg" && svgElements[name]:
+		out = &html.Node{Type: html.ElementNode, Namespace: "svg", Data: name, Attr: s.attrs(node)}
+		if name == "svg" {
+			out.Attr = append(out.Attr, html.Attribute{Key: "xmlns", Val: nsSVG})
+		}
+	case node.Namespace == "" && allowedElements[name]:
+		out = &html.Node{Type: html.ElementNode, Data: name, DataAtom: node.DataAtom, Attr: s.attrs(node)}
+	case node.Namespace == "svg":
+		// Unknown SVG content cannot be unwrapped into XHTML meaningfully.
+		return
+	default:
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			s.clean(child, parent)
+		}
+		return
+	}
+	if (name == "img" || name == "image") && attr(out, "src") == "" && attr(out, "href") == "" {
+		return
+	}
+	parent.AppendChild(out)
+	if name == "img" || name == "br" || name == "hr" || name == "wbr" || name == "col" {
+		return
+	}
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		s.clean(child, out)
+	}
+}
+
+func (s *sanitizer) attrs(node *html.Node) []html.Attribute {
+	var out []html.Attribute
+	allowed := elementAttrs[node.Data]
+	for _, a := range node.Attr {
+		key := a.Key
+		if a.Namespace != "" {
+			key = a.Namespace + ":" + a.Key
+		}
+		switch {
+		case key == "xml:lang":
+			out = append(out, html.Attribute{Key: "lang", Val: a.Val})
+		case key == "epub:type":
+			out = append(out, html.Attribute{Key: "data-epub-type", Val: a.Val})
+		case key == "style":
+			if cssUnsafe.MatchString(a.Val) {
+				continue
+			}
+			out = append(out, html.Attribute{Key: "style", Val: RewriteCSS(a.Val, s.resource)})
+		case key == "href" && node.Data == "a":
+			if href, ok := s.resolve(Link, a.Val); ok {
+				out = append(out, html.Attribute{Key: "href", Val: href})
+			}
+		case key == "src" && node.Data == "img",
+			(key == "href" || key == "xlink:href") && node.Data == "image":
+			if src, ok := s.resolve(Resource, a.Val); ok {
+				name := "src"
+				if node.Data == "image" {
+					name = "href"
+				}
+				out = append(out, html.Attribute{Key: name, Val: src})
+			}
+		case globalAttrs[key] || allowed[key]:
+			out = append(out, html.Attribute{Key: key, Val: a.Val})
+		}
+	}
+	return out
+}
+
+func (s *sanitizer) render(body *html.Node) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString("<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<!DOCTYPE html>\n")
+	buf.WriteString(`<html xmlns="http://www.w3.org/1999/xhtml"><head><meta charset="utf-8"/>`)
+	buf.WriteString("<title>" + html.EscapeString(s.title) + "</title>")
+	for _, href := range s.stylesheets {
+		buf.WriteString(`<link rel="stylesheet" type="text/css" href="` + html.EscapeString(href) + `"/>`)
+	}
+	for _, css := range s.styles {
+		// Keep the text from ending the CDATA section or, read as HTML,
+		// the style element; "\/" is a CSS escape for "/".
+		css = strings.ReplaceAll(css, "]]>", "]] >")
+		css = strings.ReplaceAll(css, "</", `<\/`)
+		buf.WriteString("<style>/*<![CDATA[*/" + css + "/*]]>*/</style>")
+	}
+	buf.WriteString("</head>")
+	if err := html.Render(&buf, body); err != nil {
+		return nil, err
+	}
+	buf.WriteString("</html>")
+	return buf.Bytes(), nil
+}
+
+func find(node *html.Node, name string) *html.Node {
+	if node.Type == html.ElementNode && node.Data == name && node.Namespace == "" {
+		return node
+	}
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		if found := find(child, name); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+func attr(node *html.Node, key string) string {
+	for _, a := range node.Attr {
+		if a.Key == key && a.Namespace == "" {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func textContent(node *html.Node) string {
+	var buf strings.Builder
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			buf.WriteString(n.Data)
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(node)
+	return buf.String()
+}
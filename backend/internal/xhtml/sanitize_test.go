@@ -0,0 +1,129 @@
+package xhtml
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+func testResolver(kind Kind, ref string) (string, bool) {
+	if strings.HasPrefix(ref, "http") {
+		return ref, kind == Link
+	}
+	if kind == Link {
+		return "/chapters/" + ref, true
+	}
+	return "/resources/" + ref, true
+}
+
+func TestSanitizeKeepsSelfClosingAnchors(t *testing.T) {
+	doc := `<?xml version="1.0" encoding="UTF-8"?>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops" xml:lang="en">
+<head><title>One</title><link rel="stylesheet" href="../styles/book.css"/>
+<style>p { background: url('bg.png') }</style></head>
+<body class="chapter"><p>Before<a id="page5"/> after&nbsp;anchor.</p>
+<a epub:type="noteref" href="notes.xhtml#n1">1</a></body></html>`
+	out, err := Sanitize([]byte(doc), testResolver)
+	if err != nil {
+		t.Fatalf("sanitize: %v", err)
+	}
+	text := string(out)
+	for _, want := range []string{
+		`<a id="page5"></a> after` + " " + `anchor.`,
+		`href="/resources/../styles/book.css"`,
+		`url("/resources/bg.png")`,
+		`data-epub-type="noteref" href="/chapters/notes.xhtml#n1"`,
+		`<body class="chapter">`,
+		`<title>One</title>`,
+	} {
+		if !strings.Contains(text, want) {
+			t.Fatalf("expected %q in %s", want, text)
+		}
+	}
+	if err := xml.Unmarshal(out, new(struct{})); err != nil {
+		t.Fatalf("output is not well-formed: %v\n%s", err, text)
+	}
+}
+
+func TestSanitizeStripsActiveContent(t *testing.T) {
+	doc := `<html><body onload="x()"><p onclick="steal()">Hi<script>alert(1)</script></p>
+<img src="http://tracker.example/p.gif"><img src="img/fig.png" alt="Fig">
+<a href="javascript:alert(1)">bad</a><iframe src="http://example.com"></iframe>
+<font color="red">kept text</font><p style="width: expression(alert(1))">x</p>`
+	out, err := Sanitize([]byte(doc), func(kind Kind, ref string) (string, bool) {
+		if strings.Contains(ref, ":") {
+			return "", false
+		}
+		return testResolver(kind, ref)
+	})
+	if err != nil {
+		t.Fatalf("sanitize: %v", err)
+	}
+	text := string(out)
+	for _, banned := range []string{"onload", "onclick", "script", "alert(1)", "tracker", "iframe", "<font", "expression"} {
+		if strings.Contains(text, banned) {
+			t.Fatalf("unexpected %q in %s", banned, text)
+		}
+	}
+	for _, want := range []string{`<img src="/resources/img/fig.png" alt="Fig"/>`, "kept text", "<a>bad</a>"} {
+		if !strings.Contains(text, want) {
+			t.Fatalf("expected %q in %s", want, text)
+		}
+	}
+}
+
+func TestSanitizeKeepsStyleTextInside(t *testing.T) {
+	doc := `<?xml version="1.0" encoding="UTF-8"?>
+<html xmlns="http://www.w3.org/1999/xhtml"><head><title>One</title>
+<style><![CDATA[p { color: red } </style><p>x</p>]]></style></head>
+<body><p>Body</p></body></html>`
+	out, err := Sanitize([]byte(doc), testResolver)
+	if err != nil {
+		t.Fatalf("sanitize: %v", err)
+	}
+	// Browsers read the chapter as HTML, where only "</style" ends the
+	// element.
+	page, err := html.Parse(bytes.NewReader(out))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	counts := map[string]int{}
+	var walk func(*html.Node)
+	walk = func(node *html.Node) {
+		if node.Type == html.ElementNode {
+			counts[node.Data]++
+		}
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(page)
+	if counts["style"] != 1 || counts["p"] != 1 {
+		t.Fatalf("expected one style and one p element, got %v in %s", counts, out)
+	}
+}
+
+func TestSanitizeKeepsSVGCover(t *testing.T) {
+	doc := `<html xmlns="http://www.w3.org/1999/xhtml" xmlns:xlink="http://www.w3.org/1999/xlink"><body>
+<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 600 800"><image width="600" height="800" xlink:href="cover.jpg"/></svg>
+</body></html>`
+	out, err := Sanitize([]byte(doc), testResolver)
+	if err != nil {
+		t.Fatalf("sanitize: %v", err)
+	}
+	if !strings.Contains(string(out), `<svg viewBox="0 0 600 800" xmlns="http://www.w3.org/2000/svg"><image width="600" height="800" href="/resources/cover.jpg">`) {
+		t.Fatalf("unexpected svg output %s", out)
+	}
+}
+
+func TestRewriteCSS(t *testing.T) {
+	css := `@import "fonts.css"; body { src: url(fonts/a.woff) } .x { background: url("#g") }`
+	got := RewriteCSS(css, func(ref string) (string, bool) { return "/r/" + ref, true })
+	want := `@import "/r/fonts.css"; body { src: url("/r/fonts/a.woff") } .x { background: url("#g") }`
+	if got != want {
+		t.Fatalf("unexpected css %q", got)
+	}
+}
@@ -64,6 +64,8 @@ const translations: Record<Locale, Record<string, string>> = {
     'tasks.refreshing': 'Refreshing...',
     'tasks.retry': 'Retry',
     'tasks.retrying': 'Retrying...',
+    'tasks.cancel': 'Cancel',
+    'tasks.canceling': 'Canceling...',
     'tasks.auth': 'Sign in to see queued conversions.',
     'tasks.empty': 'No queued tasks right now.',
     'tasks.error': 'Unable to load the task queue.',
@@ -75,11 +77,15 @@ const translations: Record<Locale, Record<string, string>> = {
     'tasks.filter.running': 'Processing',
     'tasks.filter.success': 'Ready',
     'tasks.filter.error': 'Failed',
+    'tasks.filter.canceled': 'Canceled',
     'tasks.status.queued': 'Queued',
     'tasks.status.running': 'Processing',
     'tasks.status.success': 'Ready',
     'tasks.status.error': 'Failed',
+    'tasks.status.dead': 'Gave up',
+    'tasks.status.canceled': 'Canceled',
     'tasks.item.format': '{format} conversion',
+    'tasks.progress': '{current} of {total}',
     'tasks.item.generic': 'Background task',
     'tasks.item.source': 'Source: {source}',
     'tasks.item.book': 'Book ID: {id}',
@@ -297,6 +303,8 @@ const translations: Record<Locale, Record<string, string>> = {
     'tasks.refreshing': '刷新中...',
     'tasks.retry': '重试',
     'tasks.retrying': '重试中...',
+    'tasks.cancel': '取消',
+    'tasks.canceling': '取消中...',
     'tasks.auth': '登录后可查看转换队列。',
     'tasks.empty': '当前没有排队任务。',
     'tasks.error': '无法加载任务队列。',
@@ -308,11 +316,15 @@ const translations: Record<Locale, Record<string, string>> = {
     'tasks.filter.running': '处理中',
     'tasks.filter.success': '已就绪',
     'tasks.filter.error': '失败',
+    'tasks.filter.canceled': '已取消',
     'tasks.status.queued': '排队中',
     'tasks.status.running': '处理中',
     'tasks.status.success': '已就绪',
     'tasks.status.error': '失败',
+    'tasks.status.dead': '已放弃',
+    'tasks.status.canceled': '已取消',
     'tasks.item.format': '{format} 转换',
+    'tasks.progress': '{current} / {total}',
     'tasks.item.generic': '后台任务',
     'tasks.item.source': '来源：{source}',
     'tasks.item.book': '书籍 ID：{id}',
@@ -2,7 +2,7 @@ import { useEffect, useMemo, useState } from 'react'
 import { Link } from 'react-router-dom'
 import { getToken } from '../lib/authApi'
 import { loadLibrary } from '../lib/library'
-import { TaskResponse, fetchTasks, retryTask } from '../lib/tasksApi'
+import { TaskResponse, cancelTask, fetchTasks, retryTask, streamTasks } from '../lib/tasksApi'
 import { useI18n } from './I18nProvider'
 
 const statusClassMap: Record<string, string> = {
@@ -10,6 +10,8 @@ const statusClassMap: Record<string, string> = {
   running: 'status-running',
   success: 'status-success',
   error: 'status-error',
+  dead: 'status-error',
+  canceled: 'status-canceled',
 }
 
 const statusLabelMap: Record<string, string> = {
@@ -17,6 +19,8 @@ const statusLabelMap: Record<string, string> = {
   running: 'tasks.status.running',
   success: 'tasks.status.success',
   error: 'tasks.status.error',
+  dead: 'tasks.status.dead',
+  canceled: 'tasks.status.canceled',
 }
 
 export default function TasksPanel() {
@@ -25,6 +29,7 @@ export default function TasksPanel() {
   const [loading, setLoading] = useState(false)
   const [hasError, setHasError] = useState(false)
   const [retryingId, setRetryingId] = useState<string | null>(null)
+  const [cancelingId, setCancelingId] = useState<string | null>(null)
   const { t } = useI18n()
 
   const titleMap = useMemo(() => {
@@ -64,6 +69,36 @@ export default function TasksPanel() {
     void refresh()
   }, [token])
 
+  // Follow task changes live instead of polling; reconnect after a pause
+  // when the stream drops.
+  useEffect(() => {
+    if (!token) return
+    const controller = new AbortController()
+    let timer: number | undefined
+    const connect = async () => {
+      await streamTasks(
+        (task) =>
+          setTasks((current) => {
+            const index = current.findIndex((item) => item.id === task.id)
+            if (index < 0) return [task, ...current]
+            const next = current.slice()
+            next[index] = task
+            return next
+          }),
+        controller.signal,
+        token
+      )
+      if (!controller.signal.aborted) {
+        timer = window.setTimeout(() => void connect(), 5000)
+      }
+    }
+    void connect()
+    return () => {
+      controller.abort()
+      window.clearTimeout(timer)
+    }
+  }, [token])
+
   const handleRetry = async (id: string) => {
     if (!token) return
     setRetryingId(id)
@@ -72,6 +107,14 @@ export default function TasksPanel() {
     await refresh()
   }
 
+  const handleCancel = async (id: string) => {
+    if (!token) return
+    setCancelingId(id)
+    await cancelTask(id, token)
+    setCancelingId(null)
+    await refresh()
+  }
+
   const visible = tasks.slice(0, 6)
 
   return (
@@ -120,12 +163,32 @@ export default function TasksPanel() {
                   <div className="task-meta">
                     <strong>{headline}</strong>
                     {detail ? <span className="muted">{detail}</span> : null}
+                    {task.status === 'running' && task.progress?.total ? (
+                      <span className="muted">
+                        {t('tasks.progress', {
+                          current: task.progress.current,
+                          total: task.progress.total,
+                        })}
+                        {task.progress.message ? ` · ${task.progress.message}` : ''}
+                      </span>
+                    ) : null}
                     {task.error ? (
                       <span className="muted">{task.error}</span>
                     ) : null}
                   </div>
                   <div className="task-actions">
-                    {task.status === 'error' ? (
+                    {task.status === 'queued' || task.status === 'running' ? (
+                      <button
+                        className="button"
+                        onClick={() => handleCancel(task.id)}
+                        disabled={cancelingId === task.id}
+                      >
+                        {cancelingId === task.id
+                          ? t('tasks.canceling')
+                          : t('tasks.cancel')}
+                      </button>
+                    ) : null}
+                    {task.status === 'error' || task.status === 'dead' || task.status === 'canceled' ? (
                       <button
                         className="button"
                         onClick={() => handleRetry(task.id)}
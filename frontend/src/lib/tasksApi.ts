@@ -1,5 +1,11 @@
 import { getToken } from './authApi'
 
+export type TaskProgress = {
+  current: number
+  total: number
+  message?: string
+}
+
 export type TaskResponse = {
   id: string
   user_id: string
@@ -7,6 +13,9 @@ export type TaskResponse = {
   status: string
   error: string
   payload: Record<string, string>
+  attempts: number
+  max_attempts: number
+  progress?: TaskProgress
   created_at: string
   updated_at: string
 }
@@ -43,3 +52,65 @@ export async function retryTask(id: string, token?: string) {
     return null
   }
 }
+
+export async function cancelTask(id: string, token?: string) {
+  const authToken = token ?? getToken()
+  if (!authToken) return null
+  try {
+    const resp = await fetch(`/api/tasks/${id}/cancel`, {
+      method: 'POST',
+      headers: {
+        Authorization: `Bearer ${authToken}`,
+      },
+    })
+    if (!resp.ok) return null
+    return (await resp.json()) as TaskResponse
+  } catch {
+    return null
+  }
+}
+
+// streamTasks reads the server-sent task stream with fetch, since
+// EventSource cannot send the Authorization header. It calls onTask for the
+// current tasks and then every change until signal aborts. It resolves when
+// the stream ends.
+export async function streamTasks(
+  onTask: (task: TaskResponse) => void,
+  signal: AbortSignal,
+  token?: string
+) {
+  const authToken = token ?? getToken()
+  if (!authToken) return false
+  try {
+    const resp = await fetch('/api/tasks/stream', {
+      headers: {
+        Authorization: `Bearer ${authToken}`,
+        Accept: 'text/event-stream',
+      },
+      signal,
+    })
+    if (!resp.ok || !resp.body) return false
+    const reader = resp.body.getReader()
+    const decoder = new TextDecoder()
+    let buffer = ''
+    for (;;) {
+      const { value, done } = await reader.read()
+      if (done) return true
+      buffer += decoder.decode(value, { stream: true })
+      let end = buffer.indexOf('\n\n')
+      while (end >= 0) {
+        const block = buffer.slice(0, end)
+        buffer = buffer.slice(end + 2)
+        const data = block
+          .split('\n')
+          .filter((line) => line.startsWith('data: '))
+          .map((line) => line.slice(6))
+          .join('\n')
+        if (data) onTask(JSON.parse(data) as TaskResponse)
+        end = buffer.indexOf('\n\n')
+      }
+    }
+  } catch {
+    return false
+  }
+}
@@ -1,7 +1,7 @@
 import { useEffect, useMemo, useState } from 'react'
 import { getToken } from '../lib/authApi'
 import { loadLibrary } from '../lib/library'
-import { TaskResponse, fetchTasks, retryTask } from '../lib/tasksApi'
+import { TaskResponse, cancelTask, fetchTasks, retryTask } from '../lib/tasksApi'
 import { useI18n } from '../components/I18nProvider'
 
 const statusClassMap: Record<string, string> = {
@@ -9,6 +9,8 @@ const statusClassMap: Record<string, string> = {
   running: 'status-running',
   success: 'status-success',
   error: 'status-error',
+  dead: 'status-error',
+  canceled: 'status-canceled',
 }
 
 const statusLabelMap: Record<string, string> = {
@@ -16,9 +18,11 @@ const statusLabelMap: Record<string, string> = {
   running: 'tasks.status.running',
   success: 'tasks.status.success',
   error: 'tasks.status.error',
+  dead: 'tasks.status.dead',
+  canceled: 'tasks.status.canceled',
 }
 
-type FilterKey = 'all' | 'queued' | 'running' | 'success' | 'error'
+type FilterKey = 'all' | 'queued' | 'running' | 'success' | 'error' | 'canceled'
 
 export default function TasksPage() {
   const [token, setToken] = useState(() => getToken())
@@ -27,6 +31,7 @@ export default function TasksPage() {
   const [hasError, setHasError] = useState(false)
   const [filter, setFilter] = useState<FilterKey>('all')
   const [retryingId, setRetryingId] = useState<string | null>(null)
+  const [cancelingId, setCancelingId] = useState<string | null>(null)
   const { t } = useI18n()
 
   const titleMap = useMemo(() => {
@@ -74,7 +79,20 @@ export default function TasksPage() {
     await refresh()
   }
 
-  const visible = filter === 'all' ? tasks : tasks.filter((task) => task.status === filter)
+  const handleCancel = async (id: string) => {
+    if (!token) return
+    setCancelingId(id)
+    await cancelTask(id, token)
+    setCancelingId(null)
+    await refresh()
+  }
+
+  const visible =
+    filter === 'all'
+      ? tasks
+      : tasks.filter(
+          (task) => task.status === filter || (filter === 'error' && task.status === 'dead')
+        )
 
   return (
     <section className="tasks-page">
@@ -90,7 +108,7 @@ export default function TasksPage() {
           </button>
         </div>
         <div className="tasks-filter">
-          {(['all', 'queued', 'running', 'success', 'error'] as FilterKey[]).map(
+          {(['all', 'queued', 'running', 'success', 'error', 'canceled'] as FilterKey[]).map(
             (key) => (
               <button
                 key={key}
@@ -141,7 +159,18 @@ export default function TasksPage() {
                     ) : null}
                   </div>
                   <div className="task-actions">
-                    {task.status === 'error' ? (
+                    {task.status === 'queued' || task.status === 'running' ? (
+                      <button
+                        className="button"
+                        onClick={() => handleCancel(task.id)}
+                        disabled={cancelingId === task.id}
+                      >
+                        {cancelingId === task.id
+                          ? t('tasks.canceling')
+                          : t('tasks.cancel')}
+                      </button>
+                    ) : null}
+                    {task.status === 'error' || task.status === 'dead' || task.status === 'canceled' ? (
                       <button
                         className="button"
                         onClick={() => handleRetry(task.id)}